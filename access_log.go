@@ -0,0 +1,52 @@
+/**
+ * Author: Vanya Usalko <ivict@rambler.ru>
+ * File: access_log.go
+ */
+
+package s2d3
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/usalko/s2d3/services"
+)
+
+// StartAccessLogWorker delivers the access logs into the target buckets of
+// the bucket logging in background until the context is done. The worker is
+// disabled for a non-positive interval.
+func StartAccessLogWorker(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	worker := &services.AccessLogWorker{
+		Interval: interval,
+	}
+	go worker.Run(ctx)
+}
+
+// AccessLogSettingsFromEnv reads ACCESS_LOG (json, s3 or off) and
+// ACCESS_LOG_DELIVERY_INTERVAL.
+func AccessLogSettingsFromEnv() (string, time.Duration) {
+	format := services.ACCESS_LOG_JSON
+	if os.Getenv("ACCESS_LOG") != "" {
+		if slices.Contains(services.ACCESS_LOG_FORMATS, os.Getenv("ACCESS_LOG")) {
+			format = os.Getenv("ACCESS_LOG")
+		} else {
+			fmt.Printf("invalid ACCESS_LOG: %s is not one of %v\n", os.Getenv("ACCESS_LOG"), services.ACCESS_LOG_FORMATS)
+		}
+	}
+	interval := services.DEFAULT_ACCESS_LOG_DELIVERY_INTERVAL
+	if os.Getenv("ACCESS_LOG_DELIVERY_INTERVAL") != "" {
+		parsedInterval, err := time.ParseDuration(os.Getenv("ACCESS_LOG_DELIVERY_INTERVAL"))
+		if err != nil {
+			fmt.Printf("invalid ACCESS_LOG_DELIVERY_INTERVAL: %s\n", err)
+		} else {
+			interval = parsedInterval
+		}
+	}
+	return format, interval
+}
@@ -12,15 +12,36 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"strings"
 
 	"github.com/usalko/s2d3/services"
 )
 
 func InitStorage(localFolder string) {
-	storage := services.Storage{
+	backend := services.FileSystemBackend{
 		RootFolder: localFolder,
 	}
-	storage.Init()
+	backend.Init()
+}
+
+// AccessKeysOf splits the comma separated list of access keys.
+func AccessKeysOf(accessKeys string) []string {
+	result := make([]string, 0)
+	for _, accessKey := range strings.Split(accessKeys, ",") {
+		if strings.TrimSpace(accessKey) != "" {
+			result = append(result, strings.TrimSpace(accessKey))
+		}
+	}
+	return result
+}
+
+// notStarted reports the error of the configuration, the returned context is
+// done as the one of the server which is terminated.
+func notStarted(err error) (context.Context, context.CancelFunc) {
+	fmt.Printf("the server is not started: %s\n", err)
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	cancelFunc()
+	return ctx, cancelFunc
 }
 
 func AsyncServe(localFolder string, addr string, port int) (context.Context, context.CancelFunc) {
@@ -28,10 +49,41 @@ func AsyncServe(localFolder string, addr string, port int) (context.Context, con
 	fmt.Printf("Host: %s Port: %d \n", addr, port)
 
 	multiplexer := http.NewServeMux()
-	multiplexer.HandleFunc(".*", services.ApiRouter)
+	multiplexer.HandleFunc("/", services.ApiRouter)
+	multiplexer.HandleFunc("/metrics", services.Metrics)
+	multiplexer.HandleFunc(services.ADMIN_PATH, services.AdminRouter)
 	// multiplexer.HandleFunc("/hello", services.GetHello)
 
+	mounts := make([]services.Mount, 0)
+	if os.Getenv("MOUNTS_FILE") != "" {
+		var err error
+		mounts, err = ReadMountsFile(os.Getenv("MOUNTS_FILE"))
+		if err != nil {
+			return notStarted(fmt.Errorf("invalid MOUNTS_FILE: %w", err))
+		}
+	}
+	backend, err := NewBackend(localFolder, mounts, DiskSettingsFromEnv())
+	if err != nil {
+		return notStarted(err)
+	}
+	if IndexSettingsFromEnv() {
+		backend = NewIndexedBackend(localFolder, backend)
+	}
+
+	masterKeyFile, generateMasterKey := EncryptionSettingsFromEnv()
+
 	ctx, cancelFunc := context.WithCancel(context.Background())
+	lifecycleInterval, lifecycleDryRun := LifecycleSettingsFromEnv()
+	StartLifecycleWorker(ctx, backend, lifecycleInterval, lifecycleDryRun)
+	notificationSignal := StartNotificationWorker(ctx, backend, NotificationSettingsFromEnv())
+	packInterval, packGarbageRatio := PackSettingsFromEnv()
+	StartPackWorker(ctx, backend, packInterval, packGarbageRatio)
+	StartDedupWorker(ctx, backend, DedupSettingsFromEnv())
+	StartFolderWatcher(ctx, backend, localFolder, WatchSettingsFromEnv())
+	accessLogFormat, accessLogDeliveryInterval := AccessLogSettingsFromEnv()
+	StartAccessLogWorker(ctx, accessLogDeliveryInterval)
+	accessKeys := &services.AccessKeyCache{}
+
 	server := &http.Server{
 		Addr:    fmt.Sprintf("%s:%d", addr, port),
 		Handler: multiplexer,
@@ -39,6 +91,14 @@ func AsyncServe(localFolder string, addr string, port int) (context.Context, con
 			ctx = context.WithValue(ctx, services.KeyServerAddr, listener.Addr().String())
 			ctx = context.WithValue(ctx, services.KeyDataFolder, localFolder)
 			ctx = context.WithValue(ctx, services.KeyStatisticsApplicationFolder, os.Getenv("STATISTICS_APPLICATION_FOLDER"))
+			ctx = context.WithValue(ctx, services.KeyGovernanceBypassAccessKeys, AccessKeysOf(os.Getenv("GOVERNANCE_BYPASS_ACCESS_KEYS")))
+			ctx = context.WithValue(ctx, services.KeyMasterKeyFile, masterKeyFile)
+			ctx = context.WithValue(ctx, services.KeyGenerateMasterKey, generateMasterKey)
+			ctx = context.WithValue(ctx, services.KeyBackend, backend)
+			ctx = context.WithValue(ctx, services.KeyAccessLogFormat, accessLogFormat)
+			ctx = context.WithValue(ctx, services.KeyAdminToken, os.Getenv("ADMIN_TOKEN"))
+			ctx = context.WithValue(ctx, services.KeyNotificationSignal, notificationSignal)
+			ctx = context.WithValue(ctx, services.KeyAccessKeyCache, accessKeys)
 			return ctx
 		},
 	}
@@ -0,0 +1,224 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/usalko/s2d3/models"
+)
+
+// ADMIN_API_PATH is the path of the admin api of the s2d3 service.
+const ADMIN_API_PATH = "/_s2d3/admin/"
+
+// AdminClient calls the admin api of the s2d3 service, the requests are
+// authorized by the admin token of the service.
+type AdminClient struct {
+	// Endpoint is the url of the admin listener, e.g. http://127.0.0.1:3333
+	Endpoint string
+	Token    string
+	// HTTPClient is http.DefaultClient unless it is set
+	HTTPClient *http.Client
+}
+
+// AdminError is the error response of the admin api.
+type AdminError struct {
+	StatusCode int
+	Message    string
+}
+
+func (err *AdminError) Error() string {
+	return fmt.Sprintf("%s (%d)", err.Message, err.StatusCode)
+}
+
+func NewAdminClient(endpoint string, token string) *AdminClient {
+	return &AdminClient{
+		Endpoint: strings.TrimSuffix(endpoint, "/"),
+		Token:    token,
+	}
+}
+
+// do sends the request with the json body (unless it is nil) and decodes the
+// json response into result (unless it is nil).
+func (client *AdminClient) do(method string, path string, body any, result any) error {
+	var payload io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		payload = bytes.NewReader(data)
+	}
+	request, err := http.NewRequest(method, client.Endpoint+ADMIN_API_PATH+path, payload)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Authorization", "Bearer "+client.Token)
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	httpClient := client.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= http.StatusBadRequest {
+		payload := struct {
+			Error string `json:"error"`
+		}{}
+		if err := json.NewDecoder(response.Body).Decode(&payload); err != nil || payload.Error == "" {
+			payload.Error = http.StatusText(response.StatusCode)
+		}
+		return &AdminError{StatusCode: response.StatusCode, Message: payload.Error}
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(response.Body).Decode(result)
+}
+
+func (client *AdminClient) Info() (*models.ServerInfo, error) {
+	info := &models.ServerInfo{}
+	if err := client.do("GET", "info", nil, info); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// ListAccessKeys returns the access keys without their secrets.
+func (client *AdminClient) ListAccessKeys() ([]models.AccessKey, error) {
+	accessKeys := []models.AccessKey{}
+	if err := client.do("GET", "access-keys", nil, &accessKeys); err != nil {
+		return nil, err
+	}
+	return accessKeys, nil
+}
+
+// CreateAccessKey returns the new access key with its secret, the secret is
+// not reported later.
+func (client *AdminClient) CreateAccessKey(description string) (*models.AccessKey, error) {
+	accessKey := &models.AccessKey{}
+	if err := client.do("POST", "access-keys", &models.AccessKey{Description: description}, accessKey); err != nil {
+		return nil, err
+	}
+	return accessKey, nil
+}
+
+func (client *AdminClient) RevokeAccessKey(accessKeyId string) error {
+	return client.do("DELETE", "access-keys/"+url.PathEscape(accessKeyId), nil, nil)
+}
+
+// ListBuckets returns the buckets with their usage and traffic.
+func (client *AdminClient) ListBuckets() ([]models.BucketStatistics, error) {
+	buckets := []models.BucketStatistics{}
+	if err := client.do("GET", "buckets", nil, &buckets); err != nil {
+		return nil, err
+	}
+	return buckets, nil
+}
+
+func (client *AdminClient) CreateBucket(name string) error {
+	return client.do("PUT", "buckets/"+url.PathEscape(name), nil, nil)
+}
+
+// DeleteBucket deletes the bucket without objects and multipart uploads.
+func (client *AdminClient) DeleteBucket(name string) error {
+	return client.do("DELETE", "buckets/"+url.PathEscape(name), nil, nil)
+}
+
+// GetQuota returns the quota of the bucket with the bucket usage.
+func (client *AdminClient) GetQuota(bucketName string) (*models.QuotaConfiguration, error) {
+	config := &models.QuotaConfiguration{}
+	if err := client.do("GET", "buckets/"+url.PathEscape(bucketName)+"/quota", nil, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func (client *AdminClient) PutQuota(bucketName string, config *models.QuotaConfiguration) error {
+	return client.do("PUT", "buckets/"+url.PathEscape(bucketName)+"/quota", config, nil)
+}
+
+func (client *AdminClient) DeleteQuota(bucketName string) error {
+	return client.do("DELETE", "buckets/"+url.PathEscape(bucketName)+"/quota", nil, nil)
+}
+
+func (client *AdminClient) GetPolicy(bucketName string) (*models.BucketPolicy, error) {
+	policy := &models.BucketPolicy{}
+	if err := client.do("GET", "buckets/"+url.PathEscape(bucketName)+"/policy", nil, policy); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+func (client *AdminClient) PutPolicy(bucketName string, policy *models.BucketPolicy) error {
+	return client.do("PUT", "buckets/"+url.PathEscape(bucketName)+"/policy", policy, nil)
+}
+
+func (client *AdminClient) DeletePolicy(bucketName string) error {
+	return client.do("DELETE", "buckets/"+url.PathEscape(bucketName)+"/policy", nil, nil)
+}
+
+// RunLifecycle applies the lifecycle rules of all buckets now, the dry run
+// only logs what would be deleted.
+func (client *AdminClient) RunLifecycle(dryRun bool) error {
+	path := "lifecycle"
+	if dryRun {
+		path += "?dry-run"
+	}
+	return client.do("POST", path, nil, nil)
+}
+
+// SCRUB_POLL_INTERVAL is the interval of the checks of the scrub job waited
+// by Scrub.
+const SCRUB_POLL_INTERVAL = 100 * time.Millisecond
+
+// StartScrub starts the verification of the objects of the bucket, of all
+// buckets for the empty name, the job is checked by ScrubJob.
+func (client *AdminClient) StartScrub(bucketName string) (*models.ScrubJob, error) {
+	path := "scrub"
+	if bucketName != "" {
+		path += "?bucket=" + url.QueryEscape(bucketName)
+	}
+	job := &models.ScrubJob{}
+	if err := client.do("POST", path, nil, job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+func (client *AdminClient) ScrubJob(id string) (*models.ScrubJob, error) {
+	job := &models.ScrubJob{}
+	if err := client.do("GET", "scrub/"+url.PathEscape(id), nil, job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// Scrub verifies the objects of the bucket, of all buckets for the empty
+// name, and waits for the result of the job.
+func (client *AdminClient) Scrub(bucketName string) (*models.ScrubResult, error) {
+	job, err := client.StartScrub(bucketName)
+	for err == nil && job.Status == models.ScrubStatusRunning {
+		time.Sleep(SCRUB_POLL_INTERVAL)
+		job, err = client.ScrubJob(job.Id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if job.Status == models.ScrubStatusFailed {
+		return nil, fmt.Errorf("the scrub %s is failed: %s", job.Id, job.Error)
+	}
+	return job.Result, nil
+}
@@ -19,6 +19,7 @@ import (
 	"net/url"
 	"os"
 	"regexp"
+	"sort"
 	"strings"
 	"time"
 
@@ -228,7 +229,7 @@ func (client *Client) v4signature(request *http.Request, raw []byte) string {
 	canon := sha256.New()
 	canon.Write([]byte(request.Method))
 	canon.Write([]byte("\n"))
-	canon.Write([]byte(url.PathEscape(request.URL.Path)))
+	canon.Write([]byte(utils.UriEncode(request.URL.Path, false)))
 	canon.Write([]byte("\n"))
 	canon.Write(utils.V4QueryString(request.URL.RawQuery))
 	canon.Write([]byte("\n"))
@@ -365,7 +366,7 @@ func (client *Client) List() ([]models.Object, error) {
 		}
 
 		if response.StatusCode != 200 {
-			return nil, ResponseErrorFrom(body)
+			return nil, responseError(response, body)
 		}
 
 		err = xml.Unmarshal(body, &parsedBody)
@@ -394,6 +395,103 @@ func (client *Client) List() ([]models.Object, error) {
 	}
 }
 
+// ListVersions returns the versions of the objects of the client bucket.
+func (client *Client) ListVersions() ([]models.ObjectVersion, error) {
+	if client.Bucket == "" {
+		return nil, fmt.Errorf("the bucket of the versions listing is not set")
+	}
+	versions := make([]models.ObjectVersion, 0)
+	markers := ""
+	for {
+		response, err := client.get(fmt.Sprintf("/?versions%s", markers), nil)
+		if err != nil {
+			return nil, err
+		}
+
+		type versionEntry struct {
+			Key          string `xml:"Key"`
+			VersionId    string `xml:"VersionId"`
+			IsLatest     bool   `xml:"IsLatest"`
+			LastModified string `xml:"LastModified"`
+			ETag         string `xml:"ETag"`
+			Size         int64  `xml:"Size"`
+			StorageClass string `xml:"StorageClass"`
+			Owner        struct {
+				ID          string `xml:"ID"`
+				DisplayName string `xml:"DisplayName"`
+			} `xml:"Owner"`
+		}
+		var parsedBody struct {
+			XMLName             xml.Name       `xml:"ListVersionsResult"`
+			IsTruncated         bool           `xml:"IsTruncated"`
+			NextKeyMarker       string         `xml:"NextKeyMarker"`
+			NextVersionIdMarker string         `xml:"NextVersionIdMarker"`
+			Versions            []versionEntry `xml:"Version"`
+			DeleteMarkers       []versionEntry `xml:"DeleteMarker"`
+		}
+		body, err := io.ReadAll(response.Body)
+		if err != nil {
+			return nil, err
+		}
+
+		if response.StatusCode != 200 {
+			return nil, responseError(response, body)
+		}
+
+		err = xml.Unmarshal(body, &parsedBody)
+		if err != nil {
+			return nil, err
+		}
+
+		page := make([]models.ObjectVersion, 0, len(parsedBody.Versions)+len(parsedBody.DeleteMarkers))
+		for _, f := range parsedBody.Versions {
+			mod, _ := time.Parse("2006-01-02T15:04:05.000Z", f.LastModified)
+			page = append(page, models.ObjectVersion{
+				Object: models.Object{
+					Key:          f.Key,
+					LastModified: mod,
+					ETag:         strings.Trim(f.ETag, "\""),
+					Size:         utils.SizeInBytes(f.Size),
+					StorageClass: f.StorageClass,
+					OwnerID:      f.Owner.ID,
+					OwnerName:    f.Owner.DisplayName,
+				},
+				VersionId: f.VersionId,
+				IsLatest:  f.IsLatest,
+			})
+		}
+		for _, f := range parsedBody.DeleteMarkers {
+			mod, _ := time.Parse("2006-01-02T15:04:05.000Z", f.LastModified)
+			page = append(page, models.ObjectVersion{
+				Object: models.Object{
+					Key:          f.Key,
+					LastModified: mod,
+					OwnerID:      f.Owner.ID,
+					OwnerName:    f.Owner.DisplayName,
+				},
+				VersionId:      f.VersionId,
+				IsLatest:       f.IsLatest,
+				IsDeleteMarker: true,
+			})
+		}
+		// versions and delete markers come back as separate lists
+		sort.SliceStable(page, func(i, j int) bool {
+			if page[i].Key != page[j].Key {
+				return page[i].Key < page[j].Key
+			}
+			return page[i].LastModified.After(page[j].LastModified)
+		})
+		versions = append(versions, page...)
+
+		if !parsedBody.IsTruncated {
+			return versions, nil
+		}
+
+		markers = fmt.Sprintf("&key-marker=%s&version-id-marker=%s",
+			url.QueryEscape(parsedBody.NextKeyMarker), url.QueryEscape(parsedBody.NextVersionIdMarker))
+	}
+}
+
 // --------------------------------------------------------------------------------------------
 
 func (client *Client) GetACL(key string) ([]models.Grant, error) {
@@ -553,7 +651,7 @@ func (client *Client) ListBuckets() ([]models.Bucket, error) {
 	}
 
 	if response.StatusCode != 200 {
-		return nil, ResponseErrorFrom(body)
+		return nil, responseError(response, body)
 	}
 
 	err = xml.Unmarshal(body, &request)
@@ -617,7 +715,7 @@ func (client *Client) NewUpload(path string, headers *http.Header) (*Upload, err
 	}
 
 	if res.StatusCode != 200 {
-		return nil, ResponseErrorFrom(body)
+		return nil, responseError(res, body)
 	}
 
 	var payload struct {
@@ -9,19 +9,59 @@ import (
 	"github.com/usalko/s2d3/models"
 )
 
+// RequestError is the error response of the service, RequestId and HostId
+// identify the failed request in the service logs.
+type RequestError struct {
+	StatusCode int
+	Code       string
+	Message    string
+	RequestId  string
+	HostId     string
+	Raw        []byte
+}
+
+func (err *RequestError) Error() string {
+	return fmt.Sprintf("%s (%s) [request id %s, host id %s] [raw %s]", err.Message, err.Code, err.RequestId, err.HostId, string(err.Raw))
+}
+
 func ResponseError(response *http.Response) error {
 	b, err := io.ReadAll(response.Body)
 	if err != nil {
 		return err
 	}
-	return ResponseErrorFrom(b)
+	return responseError(response, b)
 }
 
 func ResponseErrorFrom(body []byte) error {
+	return responseError(nil, body)
+}
+
+// responseError parses the error of the response, the request id is taken
+// from the headers when the body doesn't have it (e.g. the HEAD response).
+func responseError(response *http.Response, body []byte) error {
 	payload := models.Error{}
-	if err := xml.Unmarshal(body, &payload); err != nil {
+	if err := xml.Unmarshal(body, &payload); err != nil && response == nil {
 		return fmt.Errorf("unable to parse response xml: %s", err)
 	}
 
-	return fmt.Errorf("%s (%s) [raw %s]", payload.Message, payload.Code, string(body))
+	requestError := &RequestError{
+		Code:      payload.Code,
+		Message:   payload.Message,
+		RequestId: payload.RequestId,
+		HostId:    payload.HostId,
+		Raw:       body,
+	}
+	if response != nil {
+		requestError.StatusCode = response.StatusCode
+		if requestError.Code == "" {
+			requestError.Code = http.StatusText(response.StatusCode)
+		}
+		if requestError.RequestId == "" {
+			requestError.RequestId = response.Header.Get("x-amz-request-id")
+		}
+		if requestError.HostId == "" {
+			requestError.HostId = response.Header.Get("x-amz-id-2")
+		}
+	}
+	return requestError
 }
@@ -1,13 +1,17 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"slices"
+	"strings"
 
 	"github.com/usalko/s2d3"
+	"github.com/usalko/s2d3/services"
 )
 
 const LOGO_ASCII_GRAPHIC = "\n" +
@@ -19,12 +23,50 @@ const LOGO_ASCII_GRAPHIC = "\n" +
 	" `----'      \n" +
 	"             \n"
 
+// mountFlags collects the repeated -mount flags
+type mountFlags []string
+
+func (mounts *mountFlags) String() string {
+	return strings.Join(*mounts, ",")
+}
+
+func (mounts *mountFlags) Set(mount string) error {
+	*mounts = append(*mounts, mount)
+	return nil
+}
+
 func main() {
 	fmt.Println("s2d3 utility for running simple s3 compatible service")
 	ipAddr := flag.String("a", "127.0.0.1", "ip address ")
 	ipPort := flag.Int("p", 3333, "ip port")
 	localFolder := flag.String("d", "/tmp", "local folder")
 	urlContext := flag.String("u", "/", "url context")
+	defaultLifecycleInterval, defaultLifecycleDryRun := s2d3.LifecycleSettingsFromEnv()
+	lifecycleInterval := flag.Duration("lifecycle-interval", defaultLifecycleInterval, "interval of applying the bucket lifecycle rules, 0 disables them")
+	lifecycleDryRun := flag.Bool("lifecycle-dry-run", defaultLifecycleDryRun, "only log the objects which lifecycle rules would delete")
+	notificationMaxAttempts := flag.Int("notification-max-attempts", s2d3.NotificationSettingsFromEnv(), "attempts to deliver the bucket event to the webhook before it is moved to the failed events")
+	defaultPackInterval, defaultPackGarbageRatio := s2d3.PackSettingsFromEnv()
+	packCompactionInterval := flag.Duration("pack-compaction-interval", defaultPackInterval, "interval of compacting the packs of small objects, 0 disables it")
+	packGarbageRatio := flag.Float64("pack-garbage-ratio", defaultPackGarbageRatio, "part of the pack taken by the deleted and overwritten objects which triggers the compaction")
+	dedupCollectionInterval := flag.Duration("dedup-collection-interval", s2d3.DedupSettingsFromEnv(), "interval of removing the segments which are not referenced by the deduplicated objects, 0 disables it")
+	governanceBypassKeys := flag.String("governance-bypass-keys", os.Getenv("GOVERNANCE_BYPASS_ACCESS_KEYS"), "comma separated access keys (managed by the admin api) allowed to bypass the governance mode retention by the signed requests")
+	defaultMasterKeyFile, defaultGenerateMasterKey := s2d3.EncryptionSettingsFromEnv()
+	masterKeyFile := flag.String("sse-master-key-file", defaultMasterKeyFile, "file with the hex encoded master key of the server side encryption, the SSE-S3 is refused without it")
+	generateMasterKey := flag.Bool("sse-generate-master-key", defaultGenerateMasterKey, "generate the missing master key of the server side encryption in the local folder, next to the encrypted data")
+	var mountSpecs mountFlags
+	flag.Var(&mountSpecs, "mount", "bucket served from its own folder as bucket=/path[:ro][:quota=10g], repeatable")
+	mountsFile := flag.String("mounts-file", os.Getenv("MOUNTS_FILE"), "file with one bucket mount per line")
+	index := flag.Bool("index", s2d3.IndexSettingsFromEnv(), "list the buckets from the persistent indexes of their keys, the buckets without the index are listed walking their folders. The changes made out of the service are watched and notified with the index only")
+	rescanInterval := flag.Duration("rescan-interval", s2d3.WatchSettingsFromEnv(), "interval of rescanning the local folder for the changes made out of the service when the index is used, the changes are watched by inotify on Linux meanwhile, 0 disables both")
+	diskReserve := flag.String("disk-reserve", os.Getenv("DISK_RESERVE"), "free space kept on the disk of every data folder, e.g. 1g, the objects are refused below it")
+	metricsPath := flag.String("metrics-path", "/metrics", "path of the metrics in the Prometheus text format, the bucket of the same name is not served, empty disables the metrics")
+	adminAddr := flag.String("admin-addr", os.Getenv("ADMIN_ADDR"), "address (e.g. 127.0.0.1:3334) of the listener of the dashboard, the admin apis and the metrics, by default they are served by the S3 listener under "+services.ADMIN_PATH+" and the metrics path")
+	adminToken := flag.String("admin-token", os.Getenv("ADMIN_TOKEN"), "bearer token of the admin api (access keys, buckets, quotas, policies, maintenance runs) under "+services.ADMIN_API_PATH+", empty disables the api")
+	defaultAccessLogFormat, defaultAccessLogDeliveryInterval := s2d3.AccessLogSettingsFromEnv()
+	accessLogFormat := flag.String("access-log", defaultAccessLogFormat, "format of the access log of the requests: json, s3 (the S3 server access log lines) or off")
+	accessLogDeliveryInterval := flag.Duration("access-log-delivery-interval", defaultAccessLogDeliveryInterval, "interval of delivering the access logs into the target buckets of the bucket logging, 0 disables it")
+	rebuildIndex := flag.Bool("rebuild-index", false, "rebuild the indexes of all buckets from the local folder and exit")
+	healthcheck := flag.Bool("healthcheck", false, "probe the health and the readiness of the running service (at the admin address if it is set) and exit, the exit code is 0 when it is ready")
 	// Folder for the statistics application
 	statisticsApplicationFolder := "/statistics/app"
 	if os.Getenv("STATISTICS_APPLICATION_FOLDER") != "" {
@@ -33,15 +75,98 @@ func main() {
 
 	flag.Parse()
 
-	http.Handle(*urlContext, &s2d3.ServeLocalFolder{
+	if *healthcheck {
+		address := fmt.Sprintf("%s:%d", *ipAddr, *ipPort)
+		if *adminAddr != "" {
+			address = *adminAddr
+		}
+		if err := s2d3.HealthCheck(address, s2d3.DEFAULT_HEALTHCHECK_TIMEOUT); err != nil {
+			fmt.Printf("Service is not ready: %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Service is ready\n")
+		return
+	}
+
+	mounts := make([]services.Mount, 0)
+	if *mountsFile != "" {
+		fileMounts, err := s2d3.ReadMountsFile(*mountsFile)
+		if err != nil {
+			log.Fatal("Invalid mounts file ", err)
+		}
+		mounts = append(mounts, fileMounts...)
+	}
+	for _, mountSpec := range mountSpecs {
+		mount, err := s2d3.ParseMount(mountSpec)
+		if err != nil {
+			log.Fatal("Invalid mount ", err)
+		}
+		mounts = append(mounts, mount)
+	}
+	if !slices.Contains(services.ACCESS_LOG_FORMATS, *accessLogFormat) {
+		log.Fatal("Invalid access log format ", *accessLogFormat)
+	}
+	reserve, err := s2d3.ParseDiskReserve(*diskReserve)
+	if err != nil {
+		log.Fatal("Invalid disk reserve ", err)
+	}
+	backend, err := s2d3.NewBackend(*localFolder, mounts, reserve)
+	if err != nil {
+		log.Fatal("Invalid mounts ", err)
+	}
+	if *index || *rebuildIndex {
+		indexedBackend := s2d3.NewIndexedBackend(*localFolder, backend)
+		if *rebuildIndex {
+			if err := indexedBackend.RebuildAll(); err != nil {
+				log.Fatal("Index is not rebuilt ", err)
+			}
+			fmt.Printf("Indexes of the buckets of '%s' are rebuilt \n", *localFolder)
+			return
+		}
+		backend = indexedBackend
+	}
+
+	notificationSignal := s2d3.StartNotificationWorker(context.Background(), backend, *notificationMaxAttempts)
+	serveLocalFolder := &s2d3.ServeLocalFolder{
 		RootFolder:                  *localFolder,
 		UrlContext:                  *urlContext,
 		ServerAddr:                  fmt.Sprintf("%s:%d", *ipAddr, *ipPort),
 		StatisticsApplicationFolder: statisticsApplicationFolder,
-	})
+		GovernanceBypassAccessKeys:  s2d3.AccessKeysOf(*governanceBypassKeys),
+		MasterKeyFile:               *masterKeyFile,
+		GenerateMasterKey:           *generateMasterKey,
+		Backend:                     backend,
+		AccessLogFormat:             *accessLogFormat,
+		AdminToken:                  *adminToken,
+		NotificationSignal:          notificationSignal,
+	}
+	http.Handle(*urlContext, serveLocalFolder)
+	// The admin namespace is out of the S3 key space, the S3 listener answers
+	// it by the S3 errors when it is served by its own listener
+	adminMultiplexer := http.DefaultServeMux
+	if *adminAddr != "" {
+		adminMultiplexer = http.NewServeMux()
+	}
+	if *metricsPath != "" {
+		adminMultiplexer.Handle(*metricsPath, serveLocalFolder.MetricsHandler())
+	}
+	adminMultiplexer.Handle(services.ADMIN_PATH, serveLocalFolder.AdminHandler())
 	fmt.Print(LOGO_ASCII_GRAPHIC)
 	fmt.Printf("Serve local folder '%s' \n", *localFolder)
+	s2d3.StartLifecycleWorker(context.Background(), backend, *lifecycleInterval, *lifecycleDryRun)
+	s2d3.StartPackWorker(context.Background(), backend, *packCompactionInterval, *packGarbageRatio)
+	s2d3.StartDedupWorker(context.Background(), backend, *dedupCollectionInterval)
+	s2d3.StartFolderWatcher(context.Background(), backend, *localFolder, *rescanInterval)
+	s2d3.StartAccessLogWorker(context.Background(), *accessLogDeliveryInterval)
 	fmt.Printf("Please check url: http://%s:%d%s\n", *ipAddr, *ipPort, *urlContext)
+	if *adminAddr != "" {
+		fmt.Printf("Dashboard url: http://%s%s\n", *adminAddr, services.ADMIN_PATH)
+		go func() {
+			if err := http.ListenAndServe(*adminAddr, adminMultiplexer); err != nil {
+				log.Fatal("Admin server terminated", err)
+			}
+		}()
+	}
 
 	if err := http.ListenAndServe(fmt.Sprintf("%s:%d", *ipAddr, *ipPort), nil); err != nil {
 		log.Fatal("S3 server terminated", err)
@@ -0,0 +1,46 @@
+/**
+ * Author: Vanya Usalko <ivict@rambler.ru>
+ * File: dedup_worker.go
+ */
+
+package s2d3
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/usalko/s2d3/services"
+)
+
+// StartDedupWorker collects the unreferenced segments in background until
+// the context is done. The worker is disabled for a non-positive interval.
+func StartDedupWorker(ctx context.Context, backend services.Backend, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	fmt.Printf("Unreferenced segments are collected every %s \n", interval)
+
+	worker := &services.DedupWorker{
+		Storage: services.Storage{
+			Backend: backend,
+		},
+		Interval: interval,
+	}
+	go worker.Run(ctx)
+}
+
+// DedupSettingsFromEnv reads DEDUP_COLLECTION_INTERVAL.
+func DedupSettingsFromEnv() time.Duration {
+	interval := services.DEFAULT_DEDUP_COLLECTION_INTERVAL
+	if os.Getenv("DEDUP_COLLECTION_INTERVAL") != "" {
+		parsedInterval, err := time.ParseDuration(os.Getenv("DEDUP_COLLECTION_INTERVAL"))
+		if err != nil {
+			fmt.Printf("invalid DEDUP_COLLECTION_INTERVAL: %s\n", err)
+		} else {
+			interval = parsedInterval
+		}
+	}
+	return interval
+}
@@ -0,0 +1,35 @@
+/**
+ * Author: Vanya Usalko <ivict@rambler.ru>
+ * File: disk_space.go
+ */
+
+package s2d3
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/usalko/s2d3/utils"
+)
+
+// ParseDiskReserve parses the free disk space kept by the service, e.g. 1g,
+// the empty reserve is 0.
+func ParseDiskReserve(reserve string) (int64, error) {
+	if reserve == "" {
+		return 0, nil
+	}
+	size, err := utils.ParseSizeInBytes(reserve)
+	if err != nil {
+		return 0, err
+	}
+	return int64(size), nil
+}
+
+// DiskSettingsFromEnv reads DISK_RESERVE.
+func DiskSettingsFromEnv() int64 {
+	reserve, err := ParseDiskReserve(os.Getenv("DISK_RESERVE"))
+	if err != nil {
+		fmt.Printf("invalid DISK_RESERVE: %s\n", err)
+	}
+	return reserve
+}
@@ -0,0 +1,18 @@
+/**
+ * Author: Vanya Usalko <ivict@rambler.ru>
+ * File: encryption.go
+ */
+
+package s2d3
+
+import (
+	"os"
+	"strconv"
+)
+
+// EncryptionSettingsFromEnv reads SSE_MASTER_KEY_FILE and
+// SSE_GENERATE_MASTER_KEY, the master key is not generated by default.
+func EncryptionSettingsFromEnv() (string, bool) {
+	generateMasterKey, _ := strconv.ParseBool(os.Getenv("SSE_GENERATE_MASTER_KEY"))
+	return os.Getenv("SSE_MASTER_KEY_FILE"), generateMasterKey
+}
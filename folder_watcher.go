@@ -0,0 +1,78 @@
+/**
+ * Author: Vanya Usalko <ivict@rambler.ru>
+ * File: folder_watcher.go
+ */
+
+package s2d3
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/usalko/s2d3/services"
+)
+
+// StartFolderWatcher detects the changes made to the local folder out of the
+// service in background until the context is done. Only the indexed backend
+// is watched: the index tells the changes from the writes of the service, so
+// the external events of the notifications are queued with the index only.
+// The watcher is disabled for a non-positive rescan interval.
+func StartFolderWatcher(ctx context.Context, backend services.Backend, localFolder string, rescanInterval time.Duration) {
+	indexedBackend, indexed := backend.(*services.IndexedBackend)
+	if !indexed {
+		if bucketNames := UnwatchedNotifications(backend); len(bucketNames) > 0 {
+			fmt.Printf("Changes of '%s' made out of the service are not notified for the buckets %s, the folder is watched with the index only \n", localFolder, strings.Join(bucketNames, ", "))
+		}
+		return
+	}
+	if rescanInterval <= 0 {
+		return
+	}
+	fmt.Printf("Changes of '%s' are watched, the folder is rescanned every %s \n", localFolder, rescanInterval)
+
+	watcher := &services.FolderWatcher{
+		Backend:    indexedBackend,
+		RootFolder: localFolder,
+		Interval:   rescanInterval,
+	}
+	go watcher.Run(ctx)
+}
+
+// UnwatchedNotifications returns the buckets with the notifications which
+// are not told the changes made out of the service, the backend is not
+// indexed.
+func UnwatchedNotifications(backend services.Backend) []string {
+	bucketNames := make([]string, 0)
+	if _, indexed := backend.(*services.IndexedBackend); indexed {
+		return bucketNames
+	}
+	storage := services.Storage{Backend: backend}
+	buckets, err := storage.ListBuckets()
+	if err != nil {
+		return bucketNames
+	}
+	for _, bucket := range buckets {
+		config, err := storage.GetNotificationConfiguration(bucket.Name)
+		if err == nil && config != nil && len(config.Targets()) > 0 {
+			bucketNames = append(bucketNames, bucket.Name)
+		}
+	}
+	return bucketNames
+}
+
+// WatchSettingsFromEnv reads RESCAN_INTERVAL.
+func WatchSettingsFromEnv() time.Duration {
+	interval := services.DEFAULT_RESCAN_INTERVAL
+	if os.Getenv("RESCAN_INTERVAL") != "" {
+		parsedInterval, err := time.ParseDuration(os.Getenv("RESCAN_INTERVAL"))
+		if err != nil {
+			fmt.Printf("invalid RESCAN_INTERVAL: %s\n", err)
+		} else {
+			interval = parsedInterval
+		}
+	}
+	return interval
+}
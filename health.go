@@ -0,0 +1,55 @@
+/**
+ * Author: Vanya Usalko <ivict@rambler.ru>
+ * File: health.go
+ */
+
+package s2d3
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/usalko/s2d3/models"
+	"github.com/usalko/s2d3/services"
+)
+
+const DEFAULT_HEALTHCHECK_TIMEOUT = 5 * time.Second
+
+// HealthCheck probes the health and then the readiness of the service
+// listening at the address (host:port), the unspecified host (e.g. 0.0.0.0)
+// is probed at the loopback.
+func HealthCheck(address string, timeout time.Duration) error {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "127.0.0.1"
+	}
+	httpClient := &http.Client{Timeout: timeout}
+	for _, path := range []string{services.HEALTHZ_PATH, services.READYZ_PATH} {
+		url := "http://" + net.JoinHostPort(host, port) + path
+		response, err := httpClient.Get(url)
+		if err != nil {
+			return err
+		}
+		status := models.HealthStatus{}
+		err = json.NewDecoder(response.Body).Decode(&status)
+		response.Body.Close()
+		if response.StatusCode != http.StatusOK {
+			for _, check := range status.Checks {
+				if check.Status != models.HealthStatusOk {
+					return fmt.Errorf("%s: %s %s", url, check.Name, check.Error)
+				}
+			}
+			return fmt.Errorf("%s: %s", url, response.Status)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", url, err)
+		}
+	}
+	return nil
+}
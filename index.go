@@ -0,0 +1,30 @@
+/**
+ * Author: Vanya Usalko <ivict@rambler.ru>
+ * File: index.go
+ */
+
+package s2d3
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/usalko/s2d3/services"
+)
+
+// NewIndexedBackend wraps the backend with the persistent indexes of the
+// bucket keys, the indexes are kept in the system folder of the local folder.
+func NewIndexedBackend(localFolder string, backend services.Backend) *services.IndexedBackend {
+	return services.NewIndexedBackend(backend, strings.Join([]string{
+		localFolder,
+		services.SYSTEM_FOLDER,
+		services.INDEX_FOLDER,
+	}, "/"))
+}
+
+// IndexSettingsFromEnv reads METADATA_INDEX.
+func IndexSettingsFromEnv() bool {
+	enabled, _ := strconv.ParseBool(os.Getenv("METADATA_INDEX"))
+	return enabled
+}
@@ -0,0 +1,49 @@
+/**
+ * Author: Vanya Usalko <ivict@rambler.ru>
+ * File: lifecycle_worker.go
+ */
+
+package s2d3
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/usalko/s2d3/services"
+)
+
+// StartLifecycleWorker applies the bucket lifecycle rules in background until
+// the context is done. The worker is disabled for a non-positive interval.
+func StartLifecycleWorker(ctx context.Context, backend services.Backend, interval time.Duration, dryRun bool) {
+	if interval <= 0 {
+		return
+	}
+	fmt.Printf("Lifecycle rules are applied every %s (dry-run: %t) \n", interval, dryRun)
+
+	worker := &services.LifecycleWorker{
+		Storage: services.Storage{
+			Backend: backend,
+		},
+		Interval: interval,
+		DryRun:   dryRun,
+	}
+	go worker.Run(ctx)
+}
+
+// LifecycleSettingsFromEnv reads LIFECYCLE_INTERVAL and LIFECYCLE_DRY_RUN.
+func LifecycleSettingsFromEnv() (time.Duration, bool) {
+	interval := services.DEFAULT_LIFECYCLE_INTERVAL
+	if os.Getenv("LIFECYCLE_INTERVAL") != "" {
+		parsedInterval, err := time.ParseDuration(os.Getenv("LIFECYCLE_INTERVAL"))
+		if err != nil {
+			fmt.Printf("invalid LIFECYCLE_INTERVAL: %s\n", err)
+		} else {
+			interval = parsedInterval
+		}
+	}
+	dryRun, _ := strconv.ParseBool(os.Getenv("LIFECYCLE_DRY_RUN"))
+	return interval, dryRun
+}
@@ -0,0 +1,28 @@
+/**
+ * Copyright (C) 2024 Vanya Usalko <ivict@rambler.ru>
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * File: access_key.go
+ */
+package models
+
+import "time"
+
+// AccessKey is the access key managed by the admin api, the secret is
+// reported only when the key is created.
+type AccessKey struct {
+	AccessKeyId     string     `json:"accessKeyId"`
+	SecretAccessKey string     `json:"secretAccessKey,omitempty"`
+	Description     string     `json:"description,omitempty"`
+	CreationDate    time.Time  `json:"creationDate"`
+	RevocationDate  *time.Time `json:"revocationDate,omitempty"`
+}
@@ -0,0 +1,69 @@
+/**
+ * Copyright (C) 2024 Vanya Usalko <ivict@rambler.ru>
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * File: browser.go
+ */
+package models
+
+import "time"
+
+// The json documents of the object browser of the statistics application.
+
+type BrowserBucket struct {
+	Name         string    `json:"name"`
+	CreationDate time.Time `json:"creationDate"`
+}
+
+// BrowserListing is one page of the objects and the common prefixes of the
+// bucket, the next page starts after NextMarker.
+type BrowserListing struct {
+	Bucket      string          `json:"bucket"`
+	Prefix      string          `json:"prefix"`
+	Delimiter   string          `json:"delimiter"`
+	Prefixes    []string        `json:"prefixes"`
+	Objects     []BrowserObject `json:"objects"`
+	IsTruncated bool            `json:"isTruncated"`
+	NextMarker  string          `json:"nextMarker,omitempty"`
+}
+
+// BrowserObject describes the object, the metadata is given only for the
+// single object.
+type BrowserObject struct {
+	Key          string            `json:"key"`
+	Size         int64             `json:"size"`
+	ETag         string            `json:"etag"`
+	LastModified time.Time         `json:"lastModified"`
+	ContentType  string            `json:"contentType,omitempty"`
+	Tags         map[string]string `json:"tags,omitempty"`
+	Retention    *ObjectRetention  `json:"retention,omitempty"`
+	LegalHold    bool              `json:"legalHold,omitempty"`
+	Encryption   string            `json:"encryption,omitempty"`
+	Compression  string            `json:"compression,omitempty"`
+}
+
+type BrowserUpload struct {
+	Bucket   string `json:"bucket"`
+	Key      string `json:"key"`
+	UploadId string `json:"uploadId"`
+}
+
+type BrowserPart struct {
+	PartNumber int    `json:"partNumber"`
+	ETag       string `json:"etag"`
+}
+
+// BrowserCompletion lists the parts of the completed upload, all staged parts
+// are used when none is listed.
+type BrowserCompletion struct {
+	Parts []BrowserPart `json:"parts"`
+}
@@ -0,0 +1,38 @@
+/**
+ * Copyright (C) 2024 Vanya Usalko <ivict@rambler.ru>
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * File: dedup.go
+ */
+package models
+
+import "encoding/xml"
+
+// DedupConfiguration turns on the deduplication of the bucket objects, the
+// objects are split into the segments of SegmentSize and every distinct
+// segment is stored once.
+type DedupConfiguration struct {
+	XMLName     xml.Name `xml:"DedupConfiguration"`
+	SegmentSize int64    `xml:"SegmentSize,omitempty"`
+}
+
+// DedupStatistics describes the segments of the deduplicated objects, the
+// ratio is the size of the referenced data per byte stored.
+type DedupStatistics struct {
+	XMLName           xml.Name `xml:"DedupStatistics"`
+	Segments          int64    `xml:"Segments"`
+	References        int64    `xml:"References"`
+	StoredBytes       int64    `xml:"StoredBytes"`
+	LogicalBytes      int64    `xml:"LogicalBytes"`
+	UnreferencedBytes int64    `xml:"UnreferencedBytes"`
+	DedupRatio        float64  `xml:"DedupRatio"`
+}
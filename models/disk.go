@@ -0,0 +1,33 @@
+/**
+ * Copyright (C) 2024 Vanya Usalko <ivict@rambler.ru>
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * File: disk.go
+ */
+package models
+
+import "encoding/xml"
+
+// DiskStatus describes the disk of the data folder, the writes are refused
+// when the free space is below the reserve.
+type DiskStatus struct {
+	Folder        string `xml:"Folder"`
+	TotalBytes    int64  `xml:"TotalBytes"`
+	FreeBytes     int64  `xml:"FreeBytes"`
+	ReserveBytes  int64  `xml:"ReserveBytes"`
+	WritesRefused bool   `xml:"WritesRefused"`
+}
+
+type DiskStatusResult struct {
+	XMLName xml.Name     `xml:"DiskStatusResult"`
+	Disks   []DiskStatus `xml:"Disk"`
+}
@@ -0,0 +1,44 @@
+/**
+ * Copyright (C) 2024 Vanya Usalko <ivict@rambler.ru>
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * File: encryption.go
+ */
+package models
+
+import "encoding/xml"
+
+const SSEAlgorithmAES256 = "AES256"
+
+// ObjectEncryption describes the server side encryption of the stored object.
+// The data key is wrapped by the master key of the service or, for SSE-C, by
+// the customer key which is never stored.
+type ObjectEncryption struct {
+	Algorithm      string `json:"algorithm"`
+	CustomerKeyMD5 string `json:"customerKeyMd5,omitempty"`
+	DataKey        string `json:"dataKey"`
+}
+
+type ServerSideEncryptionConfiguration struct {
+	XMLName xml.Name                   `xml:"ServerSideEncryptionConfiguration"`
+	Rules   []ServerSideEncryptionRule `xml:"Rule"`
+}
+
+type ServerSideEncryptionRule struct {
+	ApplyServerSideEncryptionByDefault *ServerSideEncryptionByDefault `xml:"ApplyServerSideEncryptionByDefault"`
+	BucketKeyEnabled                   *bool                          `xml:"BucketKeyEnabled"`
+}
+
+type ServerSideEncryptionByDefault struct {
+	SSEAlgorithm   string `xml:"SSEAlgorithm"`
+	KMSMasterKeyID string `xml:"KMSMasterKeyID,omitempty"`
+}
@@ -21,4 +21,7 @@ type Error struct {
 	XMLName xml.Name `xml:"Error"`
 	Code    string   `xml:"Code"`
 	Message string   `xml:"Message"`
+	// RequestId and HostId identify the failed request in the server logs
+	RequestId string `xml:"RequestId,omitempty"`
+	HostId    string `xml:"HostId,omitempty"`
 }
@@ -0,0 +1,38 @@
+/**
+ * Copyright (C) 2024 Vanya Usalko <ivict@rambler.ru>
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * File: health.go
+ */
+package models
+
+import "time"
+
+const HealthStatusOk = "ok"
+const HealthStatusFailed = "failed"
+
+// HealthStatus is the json document of the health and the readiness probes,
+// the service is ready when all checks are ok.
+type HealthStatus struct {
+	Status string        `json:"status"`
+	Checks []HealthCheck `json:"checks,omitempty"`
+}
+
+type HealthCheck struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	// Error is the reason of the failed check
+	Error string `json:"error,omitempty"`
+	// LastRun and LastError describe the last run of the background worker
+	LastRun   *time.Time `json:"lastRun,omitempty"`
+	LastError string     `json:"lastError,omitempty"`
+}
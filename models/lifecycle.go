@@ -0,0 +1,67 @@
+/**
+ * Copyright (C) 2024 Vanya Usalko <ivict@rambler.ru>
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * File: lifecycle.go
+ */
+package models
+
+import "encoding/xml"
+
+const (
+	LifecycleEnabled  = "Enabled"
+	LifecycleDisabled = "Disabled"
+)
+
+type LifecycleConfiguration struct {
+	XMLName xml.Name        `xml:"LifecycleConfiguration"`
+	Rules   []LifecycleRule `xml:"Rule"`
+}
+
+type LifecycleRule struct {
+	ID                             string                          `xml:"ID,omitempty"`
+	Status                         string                          `xml:"Status"`
+	Prefix                         *string                         `xml:"Prefix"`
+	Filter                         *LifecycleFilter                `xml:"Filter"`
+	Expiration                     *LifecycleExpiration            `xml:"Expiration"`
+	NoncurrentVersionExpiration    *NoncurrentVersionExpiration    `xml:"NoncurrentVersionExpiration"`
+	AbortIncompleteMultipartUpload *AbortIncompleteMultipartUpload `xml:"AbortIncompleteMultipartUpload"`
+}
+
+type LifecycleFilter struct {
+	Prefix *string             `xml:"Prefix"`
+	Tag    *Tag                `xml:"Tag"`
+	And    *LifecycleFilterAnd `xml:"And"`
+}
+
+type LifecycleFilterAnd struct {
+	Prefix string `xml:"Prefix,omitempty"`
+	Tags   []Tag  `xml:"Tag"`
+}
+
+type LifecycleExpiration struct {
+	Days int    `xml:"Days,omitempty"`
+	Date string `xml:"Date,omitempty"`
+}
+
+type NoncurrentVersionExpiration struct {
+	NoncurrentDays int `xml:"NoncurrentDays"`
+}
+
+type AbortIncompleteMultipartUpload struct {
+	DaysAfterInitiation int `xml:"DaysAfterInitiation"`
+}
+
+type Tag struct {
+	Key   string `xml:"Key"`
+	Value string `xml:"Value"`
+}
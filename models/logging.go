@@ -0,0 +1,32 @@
+/**
+ * Copyright (C) 2024 Vanya Usalko <ivict@rambler.ru>
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * File: logging.go
+ */
+package models
+
+import "encoding/xml"
+
+// BucketLoggingStatus turns on the delivery of the server access logs of the
+// bucket into the target bucket, the status without LoggingEnabled turns it
+// off.
+type BucketLoggingStatus struct {
+	XMLName        xml.Name        `xml:"BucketLoggingStatus"`
+	LoggingEnabled *LoggingEnabled `xml:"LoggingEnabled,omitempty"`
+}
+
+type LoggingEnabled struct {
+	TargetBucket string `xml:"TargetBucket"`
+	// TargetPrefix is prepended to the names of the log objects
+	TargetPrefix string `xml:"TargetPrefix"`
+}
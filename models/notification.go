@@ -0,0 +1,123 @@
+/**
+ * Copyright (C) 2024 Vanya Usalko <ivict@rambler.ru>
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * File: notification.go
+ */
+package models
+
+import (
+	"encoding/xml"
+	"time"
+)
+
+const (
+	FilterRulePrefix = "prefix"
+	FilterRuleSuffix = "suffix"
+)
+
+// NotificationConfiguration sends the bucket events to the webhooks, the
+// topic, queue and cloud function configurations are handled alike and their
+// Topic, Queue or CloudFunction element is the http(s) url of the endpoint.
+type NotificationConfiguration struct {
+	XMLName                     xml.Name             `xml:"NotificationConfiguration"`
+	TopicConfigurations         []NotificationTarget `xml:"TopicConfiguration"`
+	QueueConfigurations         []NotificationTarget `xml:"QueueConfiguration"`
+	CloudFunctionConfigurations []NotificationTarget `xml:"CloudFunctionConfiguration"`
+}
+
+type NotificationTarget struct {
+	Id            string              `xml:"Id,omitempty"`
+	Topic         string              `xml:"Topic,omitempty"`
+	Queue         string              `xml:"Queue,omitempty"`
+	CloudFunction string              `xml:"CloudFunction,omitempty"`
+	Events        []string            `xml:"Event"`
+	Filter        *NotificationFilter `xml:"Filter"`
+}
+
+type NotificationFilter struct {
+	FilterRules []FilterRule `xml:"S3Key>FilterRule"`
+}
+
+type FilterRule struct {
+	Name  string `xml:"Name"`
+	Value string `xml:"Value"`
+}
+
+// Endpoint returns the url of the webhook.
+func (target *NotificationTarget) Endpoint() string {
+	if target.Topic != "" {
+		return target.Topic
+	}
+	if target.Queue != "" {
+		return target.Queue
+	}
+	return target.CloudFunction
+}
+
+// Targets returns the targets of all the configuration kinds.
+func (config *NotificationConfiguration) Targets() []NotificationTarget {
+	targets := make([]NotificationTarget, 0, len(config.TopicConfigurations)+len(config.QueueConfigurations)+len(config.CloudFunctionConfigurations))
+	targets = append(targets, config.TopicConfigurations...)
+	targets = append(targets, config.QueueConfigurations...)
+	return append(targets, config.CloudFunctionConfigurations...)
+}
+
+// Event is the json document in the S3 event message format.
+type Event struct {
+	Records []EventRecord `json:"Records"`
+}
+
+type EventRecord struct {
+	EventVersion      string            `json:"eventVersion"`
+	EventSource       string            `json:"eventSource"`
+	AwsRegion         string            `json:"awsRegion"`
+	EventTime         string            `json:"eventTime"`
+	EventName         string            `json:"eventName"`
+	UserIdentity      EventIdentity     `json:"userIdentity"`
+	RequestParameters map[string]string `json:"requestParameters"`
+	ResponseElements  map[string]string `json:"responseElements"`
+	S3                EventS3           `json:"s3"`
+}
+
+type EventIdentity struct {
+	PrincipalId string `json:"principalId"`
+}
+
+type EventS3 struct {
+	SchemaVersion   string      `json:"s3SchemaVersion"`
+	ConfigurationId string      `json:"configurationId"`
+	Bucket          EventBucket `json:"bucket"`
+	Object          EventObject `json:"object"`
+}
+
+type EventBucket struct {
+	Name          string        `json:"name"`
+	OwnerIdentity EventIdentity `json:"ownerIdentity"`
+	Arn           string        `json:"arn"`
+}
+
+type EventObject struct {
+	Key       string `json:"key"`
+	Size      int64  `json:"size,omitempty"`
+	ETag      string `json:"eTag,omitempty"`
+	Sequencer string `json:"sequencer"`
+}
+
+// QueuedEvent is the event waiting in the on-disk queue for the delivery to
+// the endpoint.
+type QueuedEvent struct {
+	Endpoint    string    `json:"endpoint"`
+	Event       Event     `json:"event"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"nextAttempt"`
+}
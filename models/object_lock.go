@@ -0,0 +1,56 @@
+/**
+ * Copyright (C) 2024 Vanya Usalko <ivict@rambler.ru>
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * File: object_lock.go
+ */
+package models
+
+import (
+	"encoding/xml"
+	"time"
+)
+
+const (
+	ObjectLockEnabled    = "Enabled"
+	ObjectLockGovernance = "GOVERNANCE"
+	ObjectLockCompliance = "COMPLIANCE"
+	LegalHoldOn          = "ON"
+	LegalHoldOff         = "OFF"
+)
+
+type ObjectLockConfiguration struct {
+	XMLName           xml.Name        `xml:"ObjectLockConfiguration"`
+	ObjectLockEnabled string          `xml:"ObjectLockEnabled,omitempty"`
+	Rule              *ObjectLockRule `xml:"Rule"`
+}
+
+type ObjectLockRule struct {
+	DefaultRetention DefaultRetention `xml:"DefaultRetention"`
+}
+
+type DefaultRetention struct {
+	Mode  string `xml:"Mode"`
+	Days  int    `xml:"Days,omitempty"`
+	Years int    `xml:"Years,omitempty"`
+}
+
+type ObjectRetention struct {
+	XMLName         xml.Name  `xml:"Retention" json:"-"`
+	Mode            string    `xml:"Mode,omitempty" json:"mode"`
+	RetainUntilDate time.Time `xml:"RetainUntilDate,omitempty" json:"retainUntilDate"`
+}
+
+type LegalHold struct {
+	XMLName xml.Name `xml:"LegalHold"`
+	Status  string   `xml:"Status"`
+}
@@ -0,0 +1,32 @@
+/**
+ * Copyright (C) 2024 Vanya Usalko <ivict@rambler.ru>
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * File: object_metadata.go
+ */
+package models
+
+// ObjectMetadata is kept by the service next to the object data.
+type ObjectMetadata struct {
+	Tags      map[string]string `json:"tags,omitempty"`
+	Retention *ObjectRetention  `json:"retention,omitempty"`
+	LegalHold bool              `json:"legalHold,omitempty"`
+
+	ContentType string `json:"contentType,omitempty"`
+
+	// Size and ETag of the object content for objects stored transformed
+	// (compressed or encrypted), the ones of the data file are used otherwise
+	Size        *int64            `json:"size,omitempty"`
+	ETag        string            `json:"etag,omitempty"`
+	Compression string            `json:"compression,omitempty"`
+	Encryption  *ObjectEncryption `json:"encryption,omitempty"`
+}
@@ -0,0 +1,25 @@
+/**
+ * Copyright (C) 2024 Vanya Usalko <ivict@rambler.ru>
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * File: object_version.go
+ */
+package models
+
+// ObjectVersion is one entry of an object history. Objects stored without
+// versioning are reported with the "null" version id.
+type ObjectVersion struct {
+	Object
+	VersionId      string
+	IsLatest       bool
+	IsDeleteMarker bool
+}
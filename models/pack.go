@@ -0,0 +1,26 @@
+/**
+ * Copyright (C) 2024 Vanya Usalko <ivict@rambler.ru>
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * File: pack.go
+ */
+package models
+
+import "encoding/xml"
+
+// PackConfiguration turns on the pack storage of the bucket, the objects
+// smaller than MaxObjectSize are appended into the pack files instead of
+// being stored as separate files.
+type PackConfiguration struct {
+	XMLName       xml.Name `xml:"PackConfiguration"`
+	MaxObjectSize int64    `xml:"MaxObjectSize,omitempty"`
+}
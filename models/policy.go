@@ -0,0 +1,85 @@
+/**
+ * Copyright (C) 2024 Vanya Usalko <ivict@rambler.ru>
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * File: policy.go
+ */
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+const PolicyEffectAllow = "Allow"
+const PolicyEffectDeny = "Deny"
+
+// BucketPolicy is the json document of the bucket policy as the S3 api has
+// it, the statements name the access keys as the principals.
+type BucketPolicy struct {
+	Version   string            `json:"Version,omitempty"`
+	Id        string            `json:"Id,omitempty"`
+	Statement []PolicyStatement `json:"Statement"`
+}
+
+type PolicyStatement struct {
+	Sid       string          `json:"Sid,omitempty"`
+	Effect    string          `json:"Effect"`
+	Principal PolicyPrincipal `json:"Principal"`
+	Action    PolicyValues    `json:"Action"`
+	Resource  PolicyValues    `json:"Resource"`
+}
+
+// PolicyValues is the value of the policy element given as the string or as
+// the list of strings.
+type PolicyValues []string
+
+func (values *PolicyValues) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err == nil {
+		*values = PolicyValues{value}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("the policy value must be the string or the list of strings")
+	}
+	*values = list
+	return nil
+}
+
+// PolicyPrincipal is "*" (everyone, the anonymous requests too) or
+// {"AWS": [access key ids]}.
+type PolicyPrincipal struct {
+	AWS PolicyValues `json:"AWS,omitempty"`
+}
+
+func (principal *PolicyPrincipal) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err == nil {
+		if value != "*" {
+			return fmt.Errorf("the principal must be \"*\" or {\"AWS\": ...}")
+		}
+		principal.AWS = PolicyValues{"*"}
+		return nil
+	}
+	type plain PolicyPrincipal
+	return json.Unmarshal(data, (*plain)(principal))
+}
+
+func (principal PolicyPrincipal) MarshalJSON() ([]byte, error) {
+	if len(principal.AWS) == 1 && principal.AWS[0] == "*" {
+		return json.Marshal("*")
+	}
+	type plain PolicyPrincipal
+	return json.Marshal(plain(principal))
+}
@@ -0,0 +1,39 @@
+/**
+ * Copyright (C) 2024 Vanya Usalko <ivict@rambler.ru>
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * File: quota.go
+ */
+package models
+
+import "encoding/xml"
+
+// QuotaConfiguration limits the total size and the count of the bucket
+// objects, 0 is no limit. The writes over the hard limits are rejected, the
+// ones over the soft limits are accepted and reported.
+type QuotaConfiguration struct {
+	XMLName        xml.Name `xml:"QuotaConfiguration" json:"-"`
+	MaxBytes       int64    `xml:"MaxBytes,omitempty" json:"maxBytes,omitempty"`
+	SoftMaxBytes   int64    `xml:"SoftMaxBytes,omitempty" json:"softMaxBytes,omitempty"`
+	MaxObjects     int64    `xml:"MaxObjects,omitempty" json:"maxObjects,omitempty"`
+	SoftMaxObjects int64    `xml:"SoftMaxObjects,omitempty" json:"softMaxObjects,omitempty"`
+	// Usage is reported by GET and is ignored by PUT
+	Usage *QuotaUsage `xml:"Usage,omitempty" json:"usage,omitempty"`
+}
+
+// QuotaUsage is the total size and the count of the latest versions of the
+// bucket objects.
+type QuotaUsage struct {
+	Bytes             int64 `xml:"Bytes" json:"bytes"`
+	Objects           int64 `xml:"Objects" json:"objects"`
+	SoftLimitExceeded bool  `xml:"SoftLimitExceeded" json:"softLimitExceeded"`
+}
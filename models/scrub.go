@@ -0,0 +1,52 @@
+/**
+ * Copyright (C) 2024 Vanya Usalko <ivict@rambler.ru>
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * File: scrub.go
+ */
+package models
+
+import "time"
+
+const ScrubStatusRunning = "running"
+const ScrubStatusDone = "done"
+const ScrubStatusFailed = "failed"
+
+// ScrubResult is the report of the verification of the stored objects, the
+// objects are read and their content is checked against their ETags. The
+// objects whose ETag is not the md5 of the content (the multipart uploads)
+// are read only and counted as skipped.
+type ScrubResult struct {
+	Buckets   int            `json:"buckets"`
+	Objects   int64          `json:"objects"`
+	Bytes     int64          `json:"bytes"`
+	Skipped   int64          `json:"skipped"`
+	Corrupted []ScrubFailure `json:"corrupted"`
+}
+
+type ScrubFailure struct {
+	Bucket string `json:"bucket"`
+	Key    string `json:"key"`
+	Error  string `json:"error"`
+}
+
+// ScrubJob is the scrub run in the background by the admin api, the result
+// is set once the job is done.
+type ScrubJob struct {
+	Id        string       `json:"id"`
+	Bucket    string       `json:"bucket,omitempty"`
+	Status    string       `json:"status"`
+	StartTime time.Time    `json:"startTime"`
+	EndTime   *time.Time   `json:"endTime,omitempty"`
+	Result    *ScrubResult `json:"result,omitempty"`
+	Error     string       `json:"error,omitempty"`
+}
@@ -0,0 +1,41 @@
+/**
+ * Copyright (C) 2024 Vanya Usalko <ivict@rambler.ru>
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * File: server_info.go
+ */
+package models
+
+import "time"
+
+// ServerInfo describes the running service for the admin api.
+type ServerInfo struct {
+	Version       string    `json:"version"`
+	StartTime     time.Time `json:"startTime"`
+	UptimeSeconds int64     `json:"uptimeSeconds"`
+	ServerAddr    string    `json:"serverAddr"`
+	DataFolder    string    `json:"dataFolder"`
+	Buckets       int       `json:"buckets"`
+	AccessKeys    int       `json:"accessKeys"`
+	Mounts        []Mount   `json:"mounts"`
+	// Features are the enabled features (index, admin-api, ...) and the
+	// running background workers (lifecycle, notifications, ...)
+	Features []string `json:"features"`
+}
+
+// Mount is the bucket served by its own backend.
+type Mount struct {
+	Bucket   string `json:"bucket"`
+	ReadOnly bool   `json:"readOnly"`
+	// Quota is the limit of the total size of the bucket objects, 0 is no limit
+	Quota int64 `json:"quota,omitempty"`
+}
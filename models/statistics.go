@@ -0,0 +1,68 @@
+/**
+ * Copyright (C) 2024 Vanya Usalko <ivict@rambler.ru>
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * File: statistics.go
+ */
+package models
+
+import "time"
+
+// Statistics is the json document of the statistics api, the dashboard
+// renders it.
+type Statistics struct {
+	Buckets    []BucketStatistics    `json:"buckets"`
+	Requests   RequestRates          `json:"requests"`
+	TopKeys    []KeyStatistics       `json:"topKeys"`
+	AccessKeys []AccessKeyStatistics `json:"accessKeys"`
+}
+
+type BucketStatistics struct {
+	Name          string    `json:"name"`
+	CreationDate  time.Time `json:"creationDate"`
+	Objects       int64     `json:"objects"`
+	Bytes         int64     `json:"bytes"`
+	ReceivedBytes int64     `json:"receivedBytes"`
+	SentBytes     int64     `json:"sentBytes"`
+	Uploads       int       `json:"uploads"`
+}
+
+// RequestRates are the counts of the requests per interval, the samples are
+// ordered by time and the intervals without requests are kept.
+type RequestRates struct {
+	IntervalSeconds int64               `json:"intervalSeconds"`
+	Samples         []RequestRateSample `json:"samples"`
+}
+
+type RequestRateSample struct {
+	Time          time.Time `json:"time"`
+	Requests      int64     `json:"requests"`
+	Errors        int64     `json:"errors"`
+	ReceivedBytes int64     `json:"receivedBytes"`
+	SentBytes     int64     `json:"sentBytes"`
+}
+
+type KeyStatistics struct {
+	Bucket     string    `json:"bucket"`
+	Key        string    `json:"key"`
+	Requests   int64     `json:"requests"`
+	SentBytes  int64     `json:"sentBytes"`
+	LastAccess time.Time `json:"lastAccess"`
+}
+
+type AccessKeyStatistics struct {
+	AccessKey string    `json:"accessKey"`
+	Requests  int64     `json:"requests"`
+	Errors    int64     `json:"errors"`
+	FirstSeen time.Time `json:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen"`
+}
@@ -0,0 +1,29 @@
+/**
+ * Copyright (C) 2024 Vanya Usalko <ivict@rambler.ru>
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *       http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * File: upload.go
+ */
+package models
+
+import "time"
+
+// Upload is a multipart upload that was initiated but not completed yet.
+type Upload struct {
+	Bucket    string    `json:"bucket"`
+	Key       string    `json:"key"`
+	UploadId  string    `json:"uploadId"`
+	Initiated time.Time `json:"initiated"`
+
+	// Metadata is given to the object when the upload is completed
+	Metadata ObjectMetadata `json:"metadata"`
+}
@@ -0,0 +1,106 @@
+/**
+ * Author: Vanya Usalko <ivict@rambler.ru>
+ * File: mount.go
+ */
+
+package s2d3
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/usalko/s2d3/services"
+	"github.com/usalko/s2d3/utils"
+)
+
+// ParseMount parses the mount of the folder as bucket=/path[:ro][:quota=10g],
+// the folder must exist.
+func ParseMount(spec string) (services.Mount, error) {
+	bucketName, options, found := strings.Cut(strings.TrimSpace(spec), "=")
+	if !found || bucketName == "" {
+		return services.Mount{}, fmt.Errorf("invalid mount %q, expected bucket=/path[:ro][:quota=size]", spec)
+	}
+	if !services.ValidBucketName(bucketName) {
+		return services.Mount{}, fmt.Errorf("invalid mount %q, the bucket name %q is not valid", spec, bucketName)
+	}
+	parts := strings.Split(options, ":")
+	mount := services.Mount{
+		Bucket: bucketName,
+		Backend: &services.FileSystemBackend{
+			RootFolder: parts[0],
+			Bucket:     bucketName,
+		},
+	}
+	for _, option := range parts[1:] {
+		switch {
+		case option == "ro":
+			mount.ReadOnly = true
+		case option == "rw":
+			mount.ReadOnly = false
+		case strings.HasPrefix(option, "quota="):
+			quota, err := utils.ParseSizeInBytes(strings.TrimPrefix(option, "quota="))
+			if err != nil {
+				return services.Mount{}, fmt.Errorf("invalid mount %q: %w", spec, err)
+			}
+			mount.Quota = int64(quota)
+		default:
+			return services.Mount{}, fmt.Errorf("invalid mount %q, unknown option %s", spec, option)
+		}
+	}
+
+	fileInfo, err := os.Stat(parts[0])
+	if err != nil {
+		return services.Mount{}, fmt.Errorf("invalid mount %q: %w", spec, err)
+	}
+	if !fileInfo.IsDir() {
+		return services.Mount{}, fmt.Errorf("invalid mount %q, %s is not a folder", spec, parts[0])
+	}
+	return mount, nil
+}
+
+// ReadMountsFile reads the mounts of the file, one mount per line. The empty
+// lines and the lines starting with # are skipped.
+func ReadMountsFile(path string) ([]services.Mount, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	mounts := make([]services.Mount, 0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		mount, err := ParseMount(line)
+		if err != nil {
+			return nil, err
+		}
+		mounts = append(mounts, mount)
+	}
+	return mounts, scanner.Err()
+}
+
+// NewBackend returns the backend of the local folder, the mounted buckets are
+// served by the backends of their mounts. The writes are refused when the
+// free space of the folder disk is below the reserve.
+func NewBackend(localFolder string, mounts []services.Mount, reserve int64) (services.Backend, error) {
+	backend := &services.FileSystemBackend{
+		RootFolder: localFolder,
+		Reserve:    reserve,
+	}
+	if len(mounts) == 0 {
+		return backend, nil
+	}
+	for _, mount := range mounts {
+		if mountBackend, exists := mount.Backend.(*services.FileSystemBackend); exists && mountBackend.Reserve == 0 {
+			mountBackend.Reserve = reserve
+		}
+		fmt.Printf("Mount bucket '%s' (read-only: %t, quota: %d) \n", mount.Bucket, mount.ReadOnly, mount.Quota)
+	}
+	return services.NewMountBackend(backend, mounts)
+}
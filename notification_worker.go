@@ -0,0 +1,46 @@
+/**
+ * Author: Vanya Usalko <ivict@rambler.ru>
+ * File: notification_worker.go
+ */
+
+package s2d3
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/usalko/s2d3/services"
+)
+
+// StartNotificationWorker delivers the queued bucket events in background
+// until the context is done, the events left in the queue by the previous
+// run are delivered first. The returned signal wakes up the worker, it is
+// the NotificationSignal of the served folder.
+func StartNotificationWorker(ctx context.Context, backend services.Backend, maxAttempts int) chan struct{} {
+	signal := make(chan struct{}, 1)
+	worker := &services.NotificationWorker{
+		Storage: services.Storage{
+			Backend: backend,
+		},
+		MaxAttempts: maxAttempts,
+		Signal:      signal,
+	}
+	go worker.Run(ctx)
+	return signal
+}
+
+// NotificationSettingsFromEnv reads NOTIFICATION_MAX_ATTEMPTS.
+func NotificationSettingsFromEnv() int {
+	maxAttempts := services.DEFAULT_NOTIFICATION_MAX_ATTEMPTS
+	if os.Getenv("NOTIFICATION_MAX_ATTEMPTS") != "" {
+		parsedMaxAttempts, err := strconv.Atoi(os.Getenv("NOTIFICATION_MAX_ATTEMPTS"))
+		if err != nil {
+			fmt.Printf("invalid NOTIFICATION_MAX_ATTEMPTS: %s\n", err)
+		} else {
+			maxAttempts = parsedMaxAttempts
+		}
+	}
+	return maxAttempts
+}
@@ -1,18 +1,110 @@
 // file: models/index.ts
+const API = '/_s2d3/api/'
+const BROWSER = '/_s2d3/browser/'
+
+// the parts of the uploaded files
+const PART_SIZE = 8 * 1024 * 1024
+
+// the browser requests carry the admin token, or the access key the S3 api
+// identifies them by while the access keys are not managed
+function headers(): HeadersInit {
+  const accessKey = localStorage.getItem('accessKey')
+  if (localStorage.getItem('adminToken') || !accessKey) return adminHeaders()
+  return { Authorization: `AWS ${accessKey}:` }
+}
+
+function objectPath(bucket: string, key: string): string {
+  return BROWSER + encodeURIComponent(bucket) + '/' + key.split('/').map(encodeURIComponent).join('/')
+}
+
+async function browse(method: string, path: string, body?: BodyInit): Promise<any> {
+  const resp = await fetch(path, { method, body, headers: headers() })
+  if (!resp.ok) {
+    const error = await resp.json().catch(() => ({ error: resp.statusText }))
+    throw new Error(error.error)
+  }
+  return resp.status == 204 ? null : await resp.json()
+}
+
+// the admin token of the server, the statistics are served to the admin only
+function adminHeaders(): HeadersInit {
+  const adminToken = localStorage.getItem('adminToken')
+  return adminToken ? { Authorization: `Bearer ${adminToken}` } : {}
+}
+
+async function get(name: string): Promise<any> {
+  const resp = await fetch(API + name, { headers: adminHeaders() })
+  if (!resp.ok) throw new Error(`statistics ${name}: ${resp.status}`)
+  return await resp.json()
+}
+
 export default {
 
-    async getFeedback(): Promise<any> {
-      const resp = await fetch('/models/feedback.json')
-      return await resp.json()
+    async listBuckets(): Promise<any> {
+      return { buckets: await browse('GET', BROWSER) }
+    },
+
+    async listObjects(bucket: string, prefix = '', marker = ''): Promise<any> {
+      const query = new URLSearchParams({ prefix, marker })
+      return await browse('GET', BROWSER + encodeURIComponent(bucket) + '?' + query)
     },
-  
-    async getUsers(): Promise<any> {
-      const resp = await fetch('/models/users.json')
-      return await resp.json()
+
+    async getMetadata(bucket: string, key: string): Promise<any> {
+      return await browse('GET', objectPath(bucket, key) + '?metadata')
+    },
+
+    objectUrl(bucket: string, key: string, download = false): string {
+      return objectPath(bucket, key) + (download ? '?download' : '')
     },
-  
+
+    async putTags(bucket: string, key: string, tags: Record<string, string>): Promise<any> {
+      return await browse('PUT', objectPath(bucket, key) + '?tags', JSON.stringify(tags))
+    },
+
+    async deleteObject(bucket: string, key: string): Promise<any> {
+      return await browse('DELETE', objectPath(bucket, key))
+    },
+
+    // uploads the file by the multipart upload, the upload is aborted
+    // when a part fails
+    async upload(bucket: string, key: string, file: File, progress?: (sent: number) => void): Promise<any> {
+      const path = objectPath(bucket, key)
+      const resp = await fetch(path + '?uploads', {
+        method: 'POST',
+        headers: { ...headers(), 'Content-Type': file.type || 'application/octet-stream' }
+      })
+      if (!resp.ok) throw new Error((await resp.json()).error)
+      const { uploadId } = await resp.json()
+      try {
+        const parts = []
+        for (let offset = 0, partNumber = 1; offset < file.size || partNumber == 1; offset += PART_SIZE, partNumber++) {
+          const query = new URLSearchParams({ uploadId, partNumber: String(partNumber) })
+          parts.push(await browse('PUT', path + '?' + query, file.slice(offset, offset + PART_SIZE)))
+          if (progress) progress(Math.min(offset + PART_SIZE, file.size))
+        }
+        return await browse('POST', path + '?' + new URLSearchParams({ uploadId }), JSON.stringify({ parts }))
+      } catch (error) {
+        await browse('DELETE', path + '?' + new URLSearchParams({ uploadId })).catch(() => null)
+        throw error
+      }
+    },
+
+    async getBuckets(): Promise<any> {
+      return { buckets: await get('buckets') }
+    },
+
+    async getAccessKeys(): Promise<any> {
+      return { accessKeys: await get('access-keys') }
+    },
+
     async getAnalytics(): Promise<any> {
-      const resp = await fetch('/models/analytics.json')
-      return await resp.json()
+      const requests = await get('requests')
+      const samples = requests.samples
+      return {
+        requests: samples.map(sample => sample.requests),
+        errors: samples.map(sample => sample.errors),
+        traffic: samples.map(sample => sample.receivedBytes + sample.sentBytes),
+        topKeys: await get('keys?top=10')
+      }
     }
-  }
\ No newline at end of file
+  }
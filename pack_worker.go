@@ -0,0 +1,57 @@
+/**
+ * Author: Vanya Usalko <ivict@rambler.ru>
+ * File: pack_worker.go
+ */
+
+package s2d3
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/usalko/s2d3/services"
+)
+
+// StartPackWorker compacts the bucket packs in background until the context
+// is done. The worker is disabled for a non-positive interval.
+func StartPackWorker(ctx context.Context, backend services.Backend, interval time.Duration, garbageRatio float64) {
+	if interval <= 0 {
+		return
+	}
+	fmt.Printf("Packs are compacted every %s (garbage ratio: %.2f) \n", interval, garbageRatio)
+
+	worker := &services.PackWorker{
+		Storage: services.Storage{
+			Backend: backend,
+		},
+		Interval:     interval,
+		GarbageRatio: garbageRatio,
+	}
+	go worker.Run(ctx)
+}
+
+// PackSettingsFromEnv reads PACK_COMPACTION_INTERVAL and PACK_GARBAGE_RATIO.
+func PackSettingsFromEnv() (time.Duration, float64) {
+	interval := services.DEFAULT_PACK_COMPACTION_INTERVAL
+	if os.Getenv("PACK_COMPACTION_INTERVAL") != "" {
+		parsedInterval, err := time.ParseDuration(os.Getenv("PACK_COMPACTION_INTERVAL"))
+		if err != nil {
+			fmt.Printf("invalid PACK_COMPACTION_INTERVAL: %s\n", err)
+		} else {
+			interval = parsedInterval
+		}
+	}
+	garbageRatio := services.DEFAULT_PACK_GARBAGE_RATIO
+	if os.Getenv("PACK_GARBAGE_RATIO") != "" {
+		parsedRatio, err := strconv.ParseFloat(os.Getenv("PACK_GARBAGE_RATIO"), 64)
+		if err != nil {
+			fmt.Printf("invalid PACK_GARBAGE_RATIO: %s\n", err)
+		} else {
+			garbageRatio = parsedRatio
+		}
+	}
+	return interval, garbageRatio
+}
@@ -6,17 +6,36 @@
 package s2d3
 
 import (
+	"archive/zip"
 	"bytes"
 	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"runtime"
+	"slices"
+	"sort"
+	"strings"
 	"testing"
+	"testing/iotest"
+	"time"
 
 	"github.com/usalko/s2d3/client"
+	"github.com/usalko/s2d3/models"
 	"github.com/usalko/s2d3/services"
+	"github.com/usalko/s2d3/utils"
 )
 
 var serverAddr = ""
@@ -24,6 +43,7 @@ var serverAddr = ""
 const TEST_SERVED_LOCAL_FOLDER = "./.s3data"
 const TEST_OBJECT_CONTENT = "Test"
 const TEST_OBJECT_PATH = "test123/test456"
+const TEST_ADMIN_TOKEN = "test-admin-token"
 
 func WithContextDecorator(handler http.HandlerFunc, dataFolder string, urlContext string) http.HandlerFunc {
 	return func(writer http.ResponseWriter, request *http.Request) {
@@ -31,10 +51,69 @@ func WithContextDecorator(handler http.HandlerFunc, dataFolder string, urlContex
 		ctx = context.WithValue(ctx, services.KeyServerAddr, serverAddr)
 		ctx = context.WithValue(ctx, services.KeyDataFolder, dataFolder)
 		ctx = context.WithValue(ctx, services.KeyUrlContext, urlContext)
+		ctx = context.WithValue(ctx, services.KeyGenerateMasterKey, true)
+		ctx = context.WithValue(ctx, services.KeyAdminToken, TEST_ADMIN_TOKEN)
 		handler(writer, request.WithContext(ctx))
 	}
 }
 
+// signRequest signs the request by the signature version 2 of the access key,
+// the subresources of the query are signed with the path.
+func signRequest(request *http.Request, accessKey *models.AccessKey) {
+	request.Header.Set("x-amz-date", time.Now().UTC().Format(http.TimeFormat))
+	names := make([]string, 0)
+	for name := range request.Header {
+		if strings.HasPrefix(strings.ToLower(name), "x-amz-") {
+			names = append(names, strings.ToLower(name))
+		}
+	}
+	sort.Strings(names)
+	stringToSign := request.Method + "\n" + request.Header.Get("Content-MD5") + "\n" + request.Header.Get("Content-Type") + "\n\n"
+	for _, name := range names {
+		stringToSign += name + ":" + request.Header.Get(name) + "\n"
+	}
+	subresources := make([]string, 0)
+	for _, name := range services.SIGNATURE_V2_SUBRESOURCES {
+		if value := request.URL.Query().Get(name); value != "" {
+			subresources = append(subresources, name+"="+value)
+		} else if request.URL.Query().Has(name) {
+			subresources = append(subresources, name)
+		}
+	}
+	stringToSign += request.URL.EscapedPath()
+	if len(subresources) > 0 {
+		stringToSign += "?" + strings.Join(subresources, "&")
+	}
+	mac := hmac.New(sha1.New, []byte(accessKey.SecretAccessKey))
+	mac.Write([]byte(stringToSign))
+	request.Header.Set("Authorization", "AWS "+accessKey.AccessKeyId+":"+base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+}
+
+// signRequestV4 signs the request by the signature version 4 of the access
+// key, the signed headers are the host and the x-amz- headers.
+func signRequestV4(request *http.Request, accessKey *models.AccessKey, payloadHash string) {
+	now := time.Now().UTC()
+	request.Header.Set("x-amz-date", now.Format(services.SIGNATURE_V4_DATE_FORMAT))
+	request.Header.Set("x-amz-content-sha256", payloadHash)
+	names := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	canonicalHeaders := "host:" + request.URL.Host + "\n"
+	for _, name := range names[1:] {
+		canonicalHeaders += name + ":" + request.Header.Get(name) + "\n"
+	}
+	canonicalRequest := strings.Join([]string{request.Method, utils.UriEncode(request.URL.Path, false), request.URL.RawQuery,
+		canonicalHeaders, strings.Join(names, ";"), payloadHash}, "\n")
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+	scope := []string{now.Format("20060102"), "us-east-1", "s3", "aws4_request"}
+	stringToSign := strings.Join([]string{services.SIGNATURE_V4_ALGORITHM, now.Format(services.SIGNATURE_V4_DATE_FORMAT),
+		strings.Join(scope, "/"), fmt.Sprintf("%x", canonicalHash)}, "\n")
+	signingKey := []byte("AWS4" + accessKey.SecretAccessKey)
+	for _, part := range scope {
+		signingKey = utils.Mac256(signingKey, []byte(part))
+	}
+	request.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%x", services.SIGNATURE_V4_ALGORITHM,
+		accessKey.AccessKeyId, strings.Join(scope, "/"), strings.Join(names, ";"), utils.Mac256(signingKey, []byte(stringToSign))))
+}
+
 func TestList(t *testing.T) {
 	InitStorage(TEST_SERVED_LOCAL_FOLDER)
 	server := httptest.NewServer(WithContextDecorator(services.ApiRouter, TEST_SERVED_LOCAL_FOLDER, ""))
@@ -60,6 +139,56 @@ func TestList(t *testing.T) {
 	println(result)
 }
 
+func TestListV1(t *testing.T) {
+	InitStorage(TEST_SERVED_LOCAL_FOLDER)
+	os.RemoveAll(TEST_SERVED_LOCAL_FOLDER + "/listed-v1")
+	server := httptest.NewServer(WithContextDecorator(services.ApiRouter, TEST_SERVED_LOCAL_FOLDER, ""))
+	// Close the server when test finishes
+	defer server.Close()
+
+	for _, key := range []string{"a/1", "a/2", "a/3", "b/1"} {
+		request, _ := http.NewRequest("PUT", server.URL+"/listed-v1/"+key, strings.NewReader(TEST_OBJECT_CONTENT))
+		if response, err := http.DefaultClient.Do(request); err != nil || response.StatusCode != http.StatusOK {
+			t.Fatalf("Error in attempt to put object %s %v", key, err)
+		}
+	}
+	list := func(query string) *services.ListResponse {
+		response, err := http.Get(server.URL + "/listed-v1" + query)
+		if err != nil || response.StatusCode != http.StatusOK {
+			t.Fatalf("Error in attempt to list objects %s %v", query, err)
+		}
+		result := &services.ListResponse{}
+		data, _ := io.ReadAll(response.Body)
+		if err := xml.Unmarshal(data, result); err != nil {
+			t.Fatalf("Error in attempt to parse listing %s %v", data, err)
+		}
+		return result
+	}
+	keysOf := func(result *services.ListResponse) string {
+		keys := make([]string, len(result.Contents))
+		for i, entry := range result.Contents {
+			keys[i] = entry.Key
+		}
+		return strings.Join(keys, ",")
+	}
+
+	// The bucket without list-type is listed by ListObjects V1
+	if result := list(""); keysOf(result) != "a/1,a/2,a/3,b/1" || result.IsTruncated {
+		t.Errorf("Wrong listing of the bucket %v", keysOf(result))
+	}
+	result := list("?prefix=a/&max-keys=2")
+	if keysOf(result) != "a/1,a/2" || !result.IsTruncated || result.NextMarker != "a/2" || result.Prefix != "a/" {
+		t.Errorf("Wrong first page of the prefix %v %v", keysOf(result), result.NextMarker)
+	}
+	result = list("?prefix=a/&marker=" + result.NextMarker)
+	if keysOf(result) != "a/3" || result.IsTruncated || result.Marker != "a/2" {
+		t.Errorf("Wrong page after the marker %v %v", keysOf(result), result.Marker)
+	}
+	if result = list("?delimiter=/"); len(result.CommonPrefixes) != 2 || len(result.Contents) != 0 {
+		t.Errorf("Wrong common prefixes %v", result.CommonPrefixes)
+	}
+}
+
 func TestUpload(t *testing.T) {
 	InitStorage(TEST_SERVED_LOCAL_FOLDER)
 	server := httptest.NewServer(WithContextDecorator(services.ApiRouter, TEST_SERVED_LOCAL_FOLDER, ""))
@@ -133,6 +262,20 @@ func TestGet(t *testing.T) {
 		t.Errorf("Wrong content in object %d", err)
 	}
 
+	// The plain objects have the ETag of their content as in the listings
+	etag := fmt.Sprintf("\"%x\"", md5.Sum([]byte(TEST_OBJECT_CONTENT)))
+	for _, method := range []string{"GET", "HEAD"} {
+		request, _ := http.NewRequest(method, server.URL+"/"+TEST_OBJECT_PATH, nil)
+		response, err := http.DefaultClient.Do(request)
+		if err != nil || response.Header.Get("ETag") != etag {
+			t.Errorf("Wrong ETag of %s %s %v", method, response.Header.Get("ETag"), err)
+		}
+	}
+	request, _ := http.NewRequest("GET", server.URL+"/"+TEST_OBJECT_PATH, nil)
+	request.Header.Set("If-None-Match", etag)
+	if response, err := http.DefaultClient.Do(request); err != nil || response.StatusCode != http.StatusNotModified {
+		t.Errorf("Object of the matched ETag is served again %v", err)
+	}
 }
 
 func TestUrlContext(t *testing.T) {
@@ -172,3 +315,2563 @@ func TestUrlContext(t *testing.T) {
 	}
 
 }
+
+func TestListVersions(t *testing.T) {
+	InitStorage(TEST_SERVED_LOCAL_FOLDER)
+	server := httptest.NewServer(WithContextDecorator(services.ApiRouter, TEST_SERVED_LOCAL_FOLDER, ""))
+	// Close the server when test finishes
+	defer server.Close()
+	parsedUrl, _ := url.Parse(server.URL)
+	serverAddr = parsedUrl.Host
+
+	bucketName, objectKey, _ := strings.Cut(TEST_OBJECT_PATH, "/")
+	s3Client, err := client.NewClient(&client.Client{
+		AccessKeyId:    "",
+		Domain:         parsedUrl.Host, //"localhost:3333",
+		Protocol:       "http",
+		Bucket:         bucketName,
+		UsePathBuckets: true,
+	})
+	if err != nil {
+		t.Errorf("Error in attempt to create new client %d", err)
+	}
+
+	versions, err := s3Client.ListVersions()
+	if err != nil {
+		t.Errorf("Error in attempt to list object versions %d", err)
+	}
+
+	found := false
+	for _, version := range versions {
+		if version.Key == objectKey {
+			found = true
+			if !version.IsLatest || version.VersionId != "null" {
+				t.Errorf("Wrong version of object %s: %s", version.Key, version.VersionId)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("Object %s not found in versions list", TEST_OBJECT_PATH)
+	}
+}
+
+func TestLifecycle(t *testing.T) {
+	InitStorage(TEST_SERVED_LOCAL_FOLDER)
+	server := httptest.NewServer(WithContextDecorator(services.ApiRouter, TEST_SERVED_LOCAL_FOLDER, ""))
+	// Close the server when test finishes
+	defer server.Close()
+	parsedUrl, _ := url.Parse(server.URL)
+	serverAddr = parsedUrl.Host
+
+	s3Client, err := client.NewClient(&client.Client{
+		AccessKeyId: "",
+		Domain:      parsedUrl.Host, //"localhost:3333",
+		Protocol:    "http",
+	})
+	if err != nil {
+		t.Errorf("Error in attempt to create new client %d", err)
+	}
+
+	upload, err := s3Client.NewUpload("lifecycle/expired/object", nil)
+	if err != nil {
+		t.Fatalf("Error in attempt to upload object %s", err)
+	}
+	upload.Stream(bytes.NewReader([]byte(TEST_OBJECT_CONTENT)), 5*1024*1024)
+	if err = upload.Done(); err != nil {
+		t.Fatalf("Error in attempt to finish upload %s", err)
+	}
+	_, err = s3Client.NewUpload("lifecycle/expired/incomplete", nil)
+	if err != nil {
+		t.Fatalf("Error in attempt to upload object %s", err)
+	}
+
+	configuration := "<LifecycleConfiguration><Rule><ID>expire</ID><Status>Enabled</Status>" +
+		"<Filter><Prefix>expired/</Prefix></Filter><Expiration><Days>1</Days></Expiration>" +
+		"<AbortIncompleteMultipartUpload><DaysAfterInitiation>1</DaysAfterInitiation></AbortIncompleteMultipartUpload>" +
+		"</Rule></LifecycleConfiguration>"
+	request, _ := http.NewRequest("PUT", server.URL+"/lifecycle?lifecycle", bytes.NewReader([]byte(configuration)))
+	response, err := http.DefaultClient.Do(request)
+	if err != nil || response.StatusCode != http.StatusOK {
+		t.Fatalf("Error in attempt to put lifecycle configuration %v", err)
+	}
+
+	response, err = http.Get(server.URL + "/lifecycle?lifecycle")
+	if err != nil || response.StatusCode != http.StatusOK {
+		t.Fatalf("Error in attempt to get lifecycle configuration %v", err)
+	}
+
+	storage := services.NewStorage(TEST_SERVED_LOCAL_FOLDER)
+	worker := services.LifecycleWorker{Storage: *storage, DryRun: true}
+	if err = worker.Apply(time.Now().Add(72 * time.Hour)); err != nil {
+		t.Errorf("Error in attempt to apply lifecycle rules %s", err)
+	}
+	if _, err = storage.GetData("lifecycle", "expired/object", ""); err != nil {
+		t.Errorf("Object was deleted in the dry-run mode %s", err)
+	}
+
+	worker.DryRun = false
+	if err = worker.Apply(time.Now()); err != nil {
+		t.Errorf("Error in attempt to apply lifecycle rules %s", err)
+	}
+	if _, err = storage.GetData("lifecycle", "expired/object", ""); err != nil {
+		t.Errorf("Object was deleted before expiration %s", err)
+	}
+
+	if err = worker.Apply(time.Now().Add(72 * time.Hour)); err != nil {
+		t.Errorf("Error in attempt to apply lifecycle rules %s", err)
+	}
+	if _, err = storage.GetData("lifecycle", "expired/object", ""); err == nil {
+		t.Errorf("Expired object was not deleted")
+	}
+	uploads, _ := storage.ListUploads("lifecycle")
+	if len(uploads) != 0 {
+		t.Errorf("Incomplete multipart upload was not aborted")
+	}
+}
+
+func TestTagging(t *testing.T) {
+	InitStorage(TEST_SERVED_LOCAL_FOLDER)
+	server := httptest.NewServer(WithContextDecorator(services.ApiRouter, TEST_SERVED_LOCAL_FOLDER, ""))
+	// Close the server when test finishes
+	defer server.Close()
+
+	request, _ := http.NewRequest("PUT", server.URL+"/tagging/run/object", bytes.NewReader([]byte(TEST_OBJECT_CONTENT)))
+	request.Header.Set("x-amz-tagging", "run=42&retention=short")
+	response, err := http.DefaultClient.Do(request)
+	if err != nil || response.StatusCode != http.StatusOK {
+		t.Fatalf("Error in attempt to put object %v", err)
+	}
+
+	response, err = http.Get(server.URL + "/tagging/run/object")
+	if err != nil || response.Header.Get("x-amz-tagging-count") != "2" {
+		t.Errorf("Wrong tagging count of object %v", response.Header)
+	}
+
+	tagging := "<Tagging><TagSet><Tag><Key>retention</Key><Value>long</Value></Tag></TagSet></Tagging>"
+	request, _ = http.NewRequest("PUT", server.URL+"/tagging/run/object?tagging", bytes.NewReader([]byte(tagging)))
+	response, err = http.DefaultClient.Do(request)
+	if err != nil || response.StatusCode != http.StatusOK {
+		t.Fatalf("Error in attempt to put object tagging %v", err)
+	}
+
+	response, err = http.Get(server.URL + "/tagging/run/object?tagging")
+	if err != nil {
+		t.Fatalf("Error in attempt to get object tagging %s", err)
+	}
+	body, _ := io.ReadAll(response.Body)
+	if string(body) != tagging {
+		t.Errorf("Wrong object tagging %s", body)
+	}
+
+	request, _ = http.NewRequest("PUT", server.URL+"/tagging?tagging", bytes.NewReader([]byte(tagging)))
+	response, err = http.DefaultClient.Do(request)
+	if err != nil || response.StatusCode != http.StatusNoContent {
+		t.Fatalf("Error in attempt to put bucket tagging %v", err)
+	}
+
+	configuration := "<LifecycleConfiguration><Rule><Status>Enabled</Status>" +
+		"<Filter><Tag><Key>retention</Key><Value>short</Value></Tag></Filter><Expiration><Days>1</Days></Expiration>" +
+		"</Rule></LifecycleConfiguration>"
+	request, _ = http.NewRequest("PUT", server.URL+"/tagging?lifecycle", bytes.NewReader([]byte(configuration)))
+	response, err = http.DefaultClient.Do(request)
+	if err != nil || response.StatusCode != http.StatusOK {
+		t.Fatalf("Error in attempt to put lifecycle configuration %v", err)
+	}
+
+	storage := services.NewStorage(TEST_SERVED_LOCAL_FOLDER)
+	worker := services.LifecycleWorker{Storage: *storage}
+	worker.Apply(time.Now().Add(72 * time.Hour))
+	if _, err = storage.GetData("tagging", "run/object", ""); err != nil {
+		t.Errorf("Object with other tags was expired %s", err)
+	}
+}
+
+func TestObjectLock(t *testing.T) {
+	InitStorage(TEST_SERVED_LOCAL_FOLDER)
+	os.RemoveAll(TEST_SERVED_LOCAL_FOLDER + "/locked")
+	os.RemoveAll(TEST_SERVED_LOCAL_FOLDER + "/unlocked")
+	accessKeysRecord := TEST_SERVED_LOCAL_FOLDER + "/" + services.SYSTEM_FOLDER + "/" + services.ACCESS_KEYS_RECORD
+	os.Remove(accessKeysRecord)
+	// The other tests use the access keys which are not managed
+	defer os.Remove(accessKeysRecord)
+	storage := services.NewStorage(TEST_SERVED_LOCAL_FOLDER)
+	writer, err := storage.CreateAccessKey("writer")
+	if err != nil {
+		t.Fatalf("Error in attempt to create access key %v", err)
+	}
+	auditor, err := storage.CreateAccessKey("auditor")
+	if err != nil {
+		t.Fatalf("Error in attempt to create access key %v", err)
+	}
+	server := httptest.NewServer(&ServeLocalFolder{
+		RootFolder:                 TEST_SERVED_LOCAL_FOLDER,
+		GovernanceBypassAccessKeys: []string{auditor.AccessKeyId},
+	})
+	// Close the server when test finishes
+	defer server.Close()
+
+	request, _ := http.NewRequest("PUT", server.URL+"/locked", nil)
+	request.Header.Set("x-amz-bucket-object-lock-enabled", "true")
+	signRequest(request, writer)
+	response, err := http.DefaultClient.Do(request)
+	if err != nil || response.StatusCode != http.StatusOK {
+		t.Fatalf("Error in attempt to create bucket %v", err)
+	}
+
+	// The default retention is set on the bucket created with object lock only
+	configuration := "<ObjectLockConfiguration><ObjectLockEnabled>Enabled</ObjectLockEnabled>" +
+		"<Rule><DefaultRetention><Mode>COMPLIANCE</Mode><Years>10</Years></DefaultRetention></Rule></ObjectLockConfiguration>"
+	request, _ = http.NewRequest("PUT", server.URL+"/unlocked", nil)
+	signRequest(request, writer)
+	if _, err := http.DefaultClient.Do(request); err != nil {
+		t.Fatalf("Error in attempt to create bucket %v", err)
+	}
+	request, _ = http.NewRequest("PUT", server.URL+"/unlocked?object-lock", strings.NewReader(configuration))
+	signRequest(request, writer)
+	response, err = http.DefaultClient.Do(request)
+	if err != nil || response.StatusCode != http.StatusConflict {
+		t.Errorf("Object lock was enabled on the existing bucket %v", err)
+	}
+	configuration = "<ObjectLockConfiguration><ObjectLockEnabled>Enabled</ObjectLockEnabled>" +
+		"<Rule><DefaultRetention><Mode>GOVERNANCE</Mode><Days>1</Days></DefaultRetention></Rule></ObjectLockConfiguration>"
+	request, _ = http.NewRequest("PUT", server.URL+"/locked?object-lock", strings.NewReader(configuration))
+	signRequest(request, writer)
+	response, err = http.DefaultClient.Do(request)
+	if err != nil || response.StatusCode != http.StatusOK {
+		t.Errorf("Error in attempt to put object lock configuration %v", err)
+	}
+
+	request, _ = http.NewRequest("PUT", server.URL+"/locked/audit", bytes.NewReader([]byte(TEST_OBJECT_CONTENT)))
+	request.Header.Set("x-amz-object-lock-mode", "GOVERNANCE")
+	request.Header.Set("x-amz-object-lock-retain-until-date", time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+	signRequest(request, writer)
+	response, err = http.DefaultClient.Do(request)
+	if err != nil || response.StatusCode != http.StatusOK {
+		t.Fatalf("Error in attempt to put locked object %v", err)
+	}
+
+	request, _ = http.NewRequest("PUT", server.URL+"/locked/audit", bytes.NewReader([]byte(TEST_OBJECT_CONTENT)))
+	signRequest(request, writer)
+	response, err = http.DefaultClient.Do(request)
+	if err != nil || response.StatusCode != http.StatusForbidden {
+		t.Errorf("Locked object was overwritten %v", err)
+	}
+
+	request, _ = http.NewRequest("DELETE", server.URL+"/locked/audit", nil)
+	request.Header.Set("x-amz-bypass-governance-retention", "true")
+	signRequest(request, writer)
+	response, err = http.DefaultClient.Do(request)
+	if err != nil || response.StatusCode != http.StatusForbidden {
+		t.Errorf("Locked object was deleted without permission %v", err)
+	}
+
+	// The access key allowed to bypass the governance mode must sign the request
+	request, _ = http.NewRequest("DELETE", server.URL+"/locked/audit", nil)
+	request.Header.Set("x-amz-bypass-governance-retention", "true")
+	request.Header.Set("x-amz-date", time.Now().UTC().Format(http.TimeFormat))
+	request.Header.Set("Authorization", "AWS "+auditor.AccessKeyId+":forged")
+	response, err = http.DefaultClient.Do(request)
+	if err != nil || response.StatusCode != http.StatusForbidden {
+		t.Errorf("Locked object was deleted by the forged signature %v", err)
+	}
+
+	request, _ = http.NewRequest("PUT", server.URL+"/locked/audit?legal-hold", bytes.NewReader([]byte("<LegalHold><Status>ON</Status></LegalHold>")))
+	signRequest(request, writer)
+	response, err = http.DefaultClient.Do(request)
+	if err != nil || response.StatusCode != http.StatusOK {
+		t.Fatalf("Error in attempt to put legal hold %v", err)
+	}
+
+	request, _ = http.NewRequest("DELETE", server.URL+"/locked/audit", nil)
+	request.Header.Set("x-amz-bypass-governance-retention", "true")
+	signRequest(request, auditor)
+	response, err = http.DefaultClient.Do(request)
+	if err != nil || response.StatusCode != http.StatusForbidden {
+		t.Errorf("Object under legal hold was deleted %v", err)
+	}
+
+	request, _ = http.NewRequest("PUT", server.URL+"/locked/audit?legal-hold", bytes.NewReader([]byte("<LegalHold><Status>OFF</Status></LegalHold>")))
+	signRequest(request, writer)
+	response, err = http.DefaultClient.Do(request)
+	if err != nil || response.StatusCode != http.StatusOK {
+		t.Fatalf("Error in attempt to put legal hold %v", err)
+	}
+
+	request, _ = http.NewRequest("DELETE", server.URL+"/locked/audit", nil)
+	request.Header.Set("x-amz-bypass-governance-retention", "true")
+	signRequest(request, auditor)
+	response, err = http.DefaultClient.Do(request)
+	if err != nil || response.StatusCode != http.StatusNoContent {
+		t.Errorf("Governance mode was not bypassed %v", err)
+	}
+}
+
+func TestCors(t *testing.T) {
+	InitStorage(TEST_SERVED_LOCAL_FOLDER)
+	server := httptest.NewServer(WithContextDecorator(services.ApiRouter, TEST_SERVED_LOCAL_FOLDER, ""))
+	// Close the server when test finishes
+	defer server.Close()
+
+	request, _ := http.NewRequest("PUT", server.URL+"/cors/page", bytes.NewReader([]byte(TEST_OBJECT_CONTENT)))
+	response, err := http.DefaultClient.Do(request)
+	if err != nil || response.StatusCode != http.StatusOK {
+		t.Fatalf("Error in attempt to put object %v", err)
+	}
+
+	configuration := "<CORSConfiguration><CORSRule><AllowedOrigin>https://*.example.com</AllowedOrigin>" +
+		"<AllowedMethod>GET</AllowedMethod><AllowedMethod>PUT</AllowedMethod><AllowedHeader>x-amz-*</AllowedHeader>" +
+		"<ExposeHeader>ETag</ExposeHeader><MaxAgeSeconds>600</MaxAgeSeconds></CORSRule></CORSConfiguration>"
+	request, _ = http.NewRequest("PUT", server.URL+"/cors?cors", bytes.NewReader([]byte(configuration)))
+	response, err = http.DefaultClient.Do(request)
+	if err != nil || response.StatusCode != http.StatusOK {
+		t.Fatalf("Error in attempt to put CORS configuration %v", err)
+	}
+
+	request, _ = http.NewRequest("OPTIONS", server.URL+"/cors/page", nil)
+	request.Header.Set("Origin", "https://app.example.com")
+	request.Header.Set("Access-Control-Request-Method", "PUT")
+	request.Header.Set("Access-Control-Request-Headers", "x-amz-tagging")
+	response, err = http.DefaultClient.Do(request)
+	if err != nil || response.StatusCode != http.StatusOK {
+		t.Fatalf("Error in attempt to send preflight request %v", err)
+	}
+	if response.Header.Get("Access-Control-Allow-Origin") != "https://app.example.com" ||
+		response.Header.Get("Access-Control-Max-Age") != "600" {
+		t.Errorf("Wrong preflight response headers %v", response.Header)
+	}
+
+	request, _ = http.NewRequest("OPTIONS", server.URL+"/cors/page", nil)
+	request.Header.Set("Origin", "https://evil.org")
+	request.Header.Set("Access-Control-Request-Method", "PUT")
+	response, err = http.DefaultClient.Do(request)
+	if err != nil || response.StatusCode != http.StatusForbidden {
+		t.Errorf("Preflight request from other origin was allowed %v", err)
+	}
+
+	request, _ = http.NewRequest("GET", server.URL+"/cors/page", nil)
+	request.Header.Set("Origin", "https://app.example.com")
+	response, err = http.DefaultClient.Do(request)
+	if err != nil || response.Header.Get("Access-Control-Expose-Headers") != "ETag" {
+		t.Errorf("Wrong CORS headers of response %v", response.Header)
+	}
+}
+
+func TestNotification(t *testing.T) {
+	InitStorage(TEST_SERVED_LOCAL_FOLDER)
+	os.RemoveAll(TEST_SERVED_LOCAL_FOLDER + "/.s2d3/notifications")
+	server := httptest.NewServer(WithContextDecorator(services.ApiRouter, TEST_SERVED_LOCAL_FOLDER, ""))
+	// Close the server when test finishes
+	defer server.Close()
+
+	events := make([]models.Event, 0)
+	failures := 1
+	endpoint := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if failures > 0 {
+			failures--
+			writer.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		event := models.Event{}
+		json.NewDecoder(request.Body).Decode(&event)
+		events = append(events, event)
+	}))
+	defer endpoint.Close()
+
+	request, _ := http.NewRequest("PUT", server.URL+"/notification/incoming/first.csv", bytes.NewReader([]byte(TEST_OBJECT_CONTENT)))
+	response, err := http.DefaultClient.Do(request)
+	if err != nil || response.StatusCode != http.StatusOK {
+		t.Fatalf("Error in attempt to put object %v", err)
+	}
+
+	configuration := "<NotificationConfiguration><QueueConfiguration><Id>ingestion</Id><Queue>" + endpoint.URL + "</Queue>" +
+		"<Event>s3:ObjectCreated:*</Event><Event>s3:ObjectRemoved:*</Event><Filter><S3Key>" +
+		"<FilterRule><Name>prefix</Name><Value>incoming/</Value></FilterRule>" +
+		"<FilterRule><Name>suffix</Name><Value>.csv</Value></FilterRule>" +
+		"</S3Key></Filter></QueueConfiguration></NotificationConfiguration>"
+	putConfiguration := func(configuration string, token string) int {
+		request, _ := http.NewRequest("PUT", server.URL+"/notification?notification", bytes.NewReader([]byte(configuration)))
+		if token != "" {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatalf("Error in attempt to put notification configuration %v", err)
+		}
+		return response.StatusCode
+	}
+	// The internal addresses are the destinations of the admin only
+	if status := putConfiguration(configuration, ""); status != http.StatusForbidden {
+		t.Errorf("Internal destination is configured without the admin token %d", status)
+	}
+	if status := putConfiguration(configuration, TEST_ADMIN_TOKEN); status != http.StatusOK {
+		t.Fatalf("Error in attempt to put notification configuration %d", status)
+	}
+
+	requestIds := make(map[string]string)
+	for _, objectKey := range []string{"incoming/second.csv", "incoming/skipped.txt", "other/skipped.csv"} {
+		request, _ = http.NewRequest("PUT", server.URL+"/notification/"+objectKey, bytes.NewReader([]byte(TEST_OBJECT_CONTENT)))
+		if response, err = http.DefaultClient.Do(request); err != nil {
+			t.Fatalf("Error in attempt to put object %v", err)
+		}
+		requestIds[objectKey] = response.Header.Get("x-amz-request-id")
+	}
+	request, _ = http.NewRequest("DELETE", server.URL+"/notification/incoming/first.csv", nil)
+	if _, err = http.DefaultClient.Do(request); err != nil {
+		t.Fatalf("Error in attempt to delete object %v", err)
+	}
+
+	// The queue survives the restart, so the new worker delivers the events
+	worker := services.NotificationWorker{Storage: services.Storage{Backend: &services.FileSystemBackend{RootFolder: TEST_SERVED_LOCAL_FOLDER}}}
+	now := time.Now()
+	if err = worker.Deliver(now); err != nil {
+		t.Errorf("Error in attempt to deliver events %s", err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("Wrong count of events delivered after the failure %d", len(events))
+	}
+	if err = worker.Deliver(now.Add(time.Minute)); err != nil {
+		t.Errorf("Error in attempt to deliver events %s", err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("Wrong count of events delivered after the backoff %d", len(events))
+	}
+	if events[0].Records[0].EventName != "ObjectRemoved:Delete" || events[1].Records[0].EventName != "ObjectCreated:Put" ||
+		events[1].Records[0].S3.Object.Key != url.QueryEscape("incoming/second.csv") || events[1].Records[0].S3.Object.Size != int64(len(TEST_OBJECT_CONTENT)) ||
+		events[1].Records[0].ResponseElements["x-amz-request-id"] != requestIds["incoming/second.csv"] {
+		t.Errorf("Wrong events delivered %v", events)
+	}
+
+	// The endpoint which doesn't respond delays its own events only, every
+	// endpoint gets at most MaxDeliveries events at once
+	unreachable := httptest.NewServer(http.NotFoundHandler())
+	unreachable.Close()
+	configuration = "<NotificationConfiguration><QueueConfiguration><Id>live</Id><Queue>" + endpoint.URL + "</Queue>" +
+		"<Event>s3:ObjectCreated:*</Event></QueueConfiguration><QueueConfiguration><Id>dead</Id><Queue>" + unreachable.URL + "</Queue>" +
+		"<Event>s3:ObjectCreated:*</Event></QueueConfiguration></NotificationConfiguration>"
+	if status := putConfiguration(configuration, TEST_ADMIN_TOKEN); status != http.StatusOK {
+		t.Fatalf("Error in attempt to put notification configuration %d", status)
+	}
+	for _, objectKey := range []string{"third.csv", "fourth.csv", "fifth.csv"} {
+		request, _ = http.NewRequest("PUT", server.URL+"/notification/"+objectKey, bytes.NewReader([]byte(TEST_OBJECT_CONTENT)))
+		if _, err = http.DefaultClient.Do(request); err != nil {
+			t.Fatalf("Error in attempt to put object %v", err)
+		}
+	}
+	events = events[:0]
+	worker.MaxDeliveries = 2
+	now = time.Now()
+	worker.Deliver(now)
+	if len(events) != 2 || events[0].Records[0].S3.Object.Key != "third.csv" || events[1].Records[0].S3.Object.Key != "fourth.csv" {
+		t.Fatalf("Wrong events delivered to the live endpoint %v", events)
+	}
+	attempts := make([]int, 0)
+	names, _ := worker.Storage.ListRecords(services.NOTIFICATIONS_FOLDER)
+	for _, name := range names {
+		data, _ := worker.Storage.GetRecord(services.NOTIFICATIONS_FOLDER + "/" + name)
+		queuedEvent := models.QueuedEvent{}
+		json.Unmarshal(data, &queuedEvent)
+		if queuedEvent.Endpoint == unreachable.URL {
+			attempts = append(attempts, queuedEvent.Attempts)
+		}
+	}
+	if !slices.Equal(attempts, []int{1, 0, 0}) {
+		t.Errorf("Wrong attempts of the unreachable endpoint %v", attempts)
+	}
+	worker.Deliver(now)
+	if len(events) != 3 || events[2].Records[0].S3.Object.Key != "fifth.csv" {
+		t.Errorf("Wrong events delivered after the limit %v", events)
+	}
+	os.RemoveAll(TEST_SERVED_LOCAL_FOLDER + "/.s2d3/notifications")
+}
+
+func TestEncryption(t *testing.T) {
+	InitStorage(TEST_SERVED_LOCAL_FOLDER)
+	os.RemoveAll(TEST_SERVED_LOCAL_FOLDER + "/encryption")
+	server := httptest.NewServer(WithContextDecorator(services.ApiRouter, TEST_SERVED_LOCAL_FOLDER, ""))
+	// Close the server when test finishes
+	defer server.Close()
+	parsedUrl, _ := url.Parse(server.URL)
+	serverAddr = parsedUrl.Host
+
+	// The content of several encryption chunks
+	content := bytes.Repeat([]byte("0123456789abcdef"), 10000)
+	request, _ := http.NewRequest("PUT", server.URL+"/encryption/sse-s3", bytes.NewReader(content))
+	request.Header.Set("x-amz-server-side-encryption", "AES256")
+	response, err := http.DefaultClient.Do(request)
+	if err != nil || response.StatusCode != http.StatusOK || response.Header.Get("x-amz-server-side-encryption") != "AES256" {
+		t.Fatalf("Error in attempt to put encrypted object %v", err)
+	}
+	data, _ := os.ReadFile(TEST_SERVED_LOCAL_FOLDER + "/encryption/sse-s3")
+	if bytes.Contains(data, content[:32]) {
+		t.Errorf("Object is stored as plaintext")
+	}
+
+	request, _ = http.NewRequest("GET", server.URL+"/encryption/sse-s3", nil)
+	request.Header.Set("Range", "bytes=65530-65545")
+	response, err = http.DefaultClient.Do(request)
+	if err != nil || response.StatusCode != http.StatusPartialContent {
+		t.Fatalf("Error in attempt to get range of encrypted object %v", err)
+	}
+	data, _ = io.ReadAll(response.Body)
+	if !bytes.Equal(data, content[65530:65546]) {
+		t.Errorf("Wrong range of encrypted object %s", data)
+	}
+	// The master key is not generated next to the data without the opt-in
+	unkeyedServer := httptest.NewServer(&ServeLocalFolder{Backend: services.NewMemoryBackend()})
+	defer unkeyedServer.Close()
+	request, _ = http.NewRequest("PUT", unkeyedServer.URL+"/encryption/sse-s3", bytes.NewReader(content))
+	request.Header.Set("x-amz-server-side-encryption", "AES256")
+	response, err = http.DefaultClient.Do(request)
+	if err != nil || response.StatusCode != http.StatusBadRequest {
+		t.Errorf("Encrypted object is put without the master key %v", response.StatusCode)
+	}
+
+	versions, _ := services.NewStorage(TEST_SERVED_LOCAL_FOLDER).List("encryption")
+	if len(versions) != 1 || int(versions[0].Size) != len(content) {
+		t.Errorf("Wrong size of encrypted object in listing %v", versions)
+	}
+
+	customerKey := bytes.Repeat([]byte{7}, 32)
+	customerKeyMD5 := md5.Sum(customerKey)
+	setCustomerKey := func(request *http.Request, customerKey []byte) {
+		hash := md5.Sum(customerKey)
+		request.Header.Set("x-amz-server-side-encryption-customer-algorithm", "AES256")
+		request.Header.Set("x-amz-server-side-encryption-customer-key", base64.StdEncoding.EncodeToString(customerKey))
+		request.Header.Set("x-amz-server-side-encryption-customer-key-MD5", base64.StdEncoding.EncodeToString(hash[:]))
+	}
+	request, _ = http.NewRequest("PUT", server.URL+"/encryption/sse-c", bytes.NewReader([]byte(TEST_OBJECT_CONTENT)))
+	setCustomerKey(request, customerKey)
+	response, err = http.DefaultClient.Do(request)
+	if err != nil || response.Header.Get("x-amz-server-side-encryption-customer-key-MD5") != base64.StdEncoding.EncodeToString(customerKeyMD5[:]) {
+		t.Fatalf("Error in attempt to put object encrypted with customer key %v", err)
+	}
+
+	response, err = http.Get(server.URL + "/encryption/sse-c")
+	if err != nil || response.StatusCode != http.StatusBadRequest {
+		t.Errorf("Object encrypted with customer key was read without the key %v", err)
+	}
+	request, _ = http.NewRequest("GET", server.URL+"/encryption/sse-c", nil)
+	setCustomerKey(request, bytes.Repeat([]byte{8}, 32))
+	response, err = http.DefaultClient.Do(request)
+	if err != nil || response.StatusCode != http.StatusForbidden {
+		t.Errorf("Object encrypted with customer key was read with other key %v", err)
+	}
+	request, _ = http.NewRequest("GET", server.URL+"/encryption/sse-c", nil)
+	setCustomerKey(request, customerKey)
+	response, err = http.DefaultClient.Do(request)
+	if err != nil || response.StatusCode != http.StatusOK {
+		t.Fatalf("Error in attempt to get object encrypted with customer key %v", err)
+	}
+	data, _ = io.ReadAll(response.Body)
+	if string(data) != TEST_OBJECT_CONTENT {
+		t.Errorf("Wrong content of object encrypted with customer key %s", data)
+	}
+
+	configuration := "<ServerSideEncryptionConfiguration><Rule><ApplyServerSideEncryptionByDefault>" +
+		"<SSEAlgorithm>AES256</SSEAlgorithm></ApplyServerSideEncryptionByDefault></Rule></ServerSideEncryptionConfiguration>"
+	request, _ = http.NewRequest("PUT", server.URL+"/encryption?encryption", bytes.NewReader([]byte(configuration)))
+	response, err = http.DefaultClient.Do(request)
+	if err != nil || response.StatusCode != http.StatusOK {
+		t.Fatalf("Error in attempt to put bucket encryption %v", err)
+	}
+
+	s3Client, err := client.NewClient(&client.Client{
+		AccessKeyId: "",
+		Domain:      parsedUrl.Host,
+		Protocol:    "http",
+	})
+	if err != nil {
+		t.Errorf("Error in attempt to create new client %d", err)
+	}
+	upload, err := s3Client.NewUpload("encryption/default", nil)
+	if err != nil {
+		t.Fatalf("Error in attempt to upload object %s", err)
+	}
+	upload.Stream(bytes.NewReader([]byte(TEST_OBJECT_CONTENT)), 5*1024*1024)
+	if err = upload.Done(); err != nil {
+		t.Fatalf("Error in attempt to finish upload %s", err)
+	}
+	response, err = http.Get(server.URL + "/encryption/default")
+	if err != nil || response.Header.Get("x-amz-server-side-encryption") != "AES256" {
+		t.Fatalf("Object is not encrypted with bucket default encryption %v", err)
+	}
+	data, _ = io.ReadAll(response.Body)
+	if string(data) != TEST_OBJECT_CONTENT {
+		t.Errorf("Wrong content of object encrypted by default %s", data)
+	}
+}
+
+func TestCompression(t *testing.T) {
+	InitStorage(TEST_SERVED_LOCAL_FOLDER)
+	os.RemoveAll(TEST_SERVED_LOCAL_FOLDER + "/compression")
+	server := httptest.NewServer(WithContextDecorator(services.ApiRouter, TEST_SERVED_LOCAL_FOLDER, ""))
+	// Close the server when test finishes
+	defer server.Close()
+
+	request, _ := http.NewRequest("PUT", server.URL+"/compression", nil)
+	if _, err := http.DefaultClient.Do(request); err != nil {
+		t.Fatalf("Error in attempt to create bucket %v", err)
+	}
+	configuration := "<CompressionConfiguration><ContentType>application/json</ContentType>" +
+		"<Extension>.csv</Extension></CompressionConfiguration>"
+	request, _ = http.NewRequest("PUT", server.URL+"/compression?compression", bytes.NewReader([]byte(configuration)))
+	response, err := http.DefaultClient.Do(request)
+	if err != nil || response.StatusCode != http.StatusOK {
+		t.Fatalf("Error in attempt to put compression configuration %v", err)
+	}
+
+	content := make([]byte, 0)
+	for i := 0; len(content) < 300000; i++ {
+		content = append(content, []byte(fmt.Sprintf("{\"line\":%d,\"message\":\"request processed\"}\n", i))...)
+	}
+	contentMD5 := md5.Sum(content)
+	for _, objectKey := range []string{"log.json", "encrypted.csv"} {
+		request, _ = http.NewRequest("PUT", server.URL+"/compression/"+objectKey, bytes.NewReader(content))
+		request.Header.Set("Content-Type", "application/json")
+		if objectKey == "encrypted.csv" {
+			request.Header.Set("x-amz-server-side-encryption", "AES256")
+		}
+		response, err = http.DefaultClient.Do(request)
+		if err != nil || response.Header.Get("ETag") != fmt.Sprintf("\"%x\"", contentMD5) {
+			t.Fatalf("Error in attempt to put compressed object %v", err)
+		}
+		fileInfo, err := os.Stat(TEST_SERVED_LOCAL_FOLDER + "/compression/" + objectKey)
+		if err != nil || fileInfo.Size() >= int64(len(content))/2 {
+			t.Errorf("Object %s is not compressed %v", objectKey, err)
+		}
+
+		request, _ = http.NewRequest("GET", server.URL+"/compression/"+objectKey, nil)
+		request.Header.Set("Range", "bytes=131000-131100")
+		response, err = http.DefaultClient.Do(request)
+		if err != nil || response.StatusCode != http.StatusPartialContent || response.Header.Get("Content-Type") != "application/json" {
+			t.Fatalf("Error in attempt to get range of compressed object %v", err)
+		}
+		data, _ := io.ReadAll(response.Body)
+		if !bytes.Equal(data, content[131000:131101]) {
+			t.Errorf("Wrong range of compressed object %s", data)
+		}
+
+		response, err = http.Get(server.URL + "/compression/" + objectKey)
+		if err != nil {
+			t.Fatalf("Error in attempt to get compressed object %v", err)
+		}
+		data, _ = io.ReadAll(response.Body)
+		if !bytes.Equal(data, content) {
+			t.Errorf("Wrong content of compressed object %s", objectKey)
+		}
+	}
+
+	versions, _ := services.NewStorage(TEST_SERVED_LOCAL_FOLDER).List("compression")
+	if len(versions) != 2 || int(versions[0].Size) != len(content) || versions[0].ETag != fmt.Sprintf("%x", contentMD5) {
+		t.Errorf("Wrong size of compressed object in listing %v", versions)
+	}
+}
+
+func TestMemoryBackend(t *testing.T) {
+	backend := services.NewMemoryBackend()
+	server := httptest.NewServer(&ServeLocalFolder{
+		Backend:           backend,
+		GenerateMasterKey: true,
+	})
+	// Close the server when test finishes
+	defer server.Close()
+	parsedUrl, _ := url.Parse(server.URL)
+
+	request, _ := http.NewRequest("PUT", server.URL+"/memory", nil)
+	response, err := http.DefaultClient.Do(request)
+	if err != nil || response.StatusCode != http.StatusOK {
+		t.Fatalf("Error in attempt to create bucket %v", err)
+	}
+	request, _ = http.NewRequest("PUT", server.URL+"/memory/put/object", bytes.NewReader([]byte(TEST_OBJECT_CONTENT)))
+	request.Header.Set("x-amz-server-side-encryption", "AES256")
+	response, err = http.DefaultClient.Do(request)
+	if err != nil || response.StatusCode != http.StatusOK {
+		t.Fatalf("Error in attempt to put object %v", err)
+	}
+
+	s3Client, err := client.NewClient(&client.Client{
+		AccessKeyId: "",
+		Domain:      parsedUrl.Host,
+		Protocol:    "http",
+	})
+	if err != nil {
+		t.Errorf("Error in attempt to create new client %d", err)
+	}
+	upload, err := s3Client.NewUpload("memory/upload/object", nil)
+	if err != nil {
+		t.Fatalf("Error in attempt to upload object %s", err)
+	}
+	upload.Stream(bytes.NewReader([]byte(TEST_OBJECT_CONTENT)), 5*1024*1024)
+	if err = upload.Done(); err != nil {
+		t.Fatalf("Error in attempt to finish upload %s", err)
+	}
+
+	request, _ = http.NewRequest("GET", server.URL+"/memory/put/object", nil)
+	request.Header.Set("Range", "bytes=1-2")
+	response, err = http.DefaultClient.Do(request)
+	if err != nil || response.StatusCode != http.StatusPartialContent {
+		t.Fatalf("Error in attempt to get range of object %v", err)
+	}
+	data, _ := io.ReadAll(response.Body)
+	if string(data) != TEST_OBJECT_CONTENT[1:3] {
+		t.Errorf("Wrong range of object %s", data)
+	}
+
+	response, err = http.Get(server.URL + "/memory?list-type=2")
+	if err != nil || response.StatusCode != http.StatusOK {
+		t.Fatalf("Error in attempt to list objects %v", err)
+	}
+	data, _ = io.ReadAll(response.Body)
+	if !bytes.Contains(data, []byte("<Key>put/object</Key>")) || !bytes.Contains(data, []byte("<Key>upload/object</Key>")) {
+		t.Errorf("Wrong listing of objects %s", data)
+	}
+
+	request, _ = http.NewRequest("DELETE", server.URL+"/memory/upload/object", nil)
+	response, err = http.DefaultClient.Do(request)
+	if err != nil || response.StatusCode != http.StatusNoContent {
+		t.Fatalf("Error in attempt to delete object %v", err)
+	}
+	versions, _ := backend.List("memory")
+	if len(versions) != 1 || versions[0].Key != "put/object" || int(versions[0].Size) != len(TEST_OBJECT_CONTENT) {
+		t.Errorf("Wrong objects of memory backend %v", versions)
+	}
+}
+
+func TestFSBackend(t *testing.T) {
+	var archive bytes.Buffer
+	archiveWriter := zip.NewWriter(&archive)
+	for name, content := range map[string]string{"v1/notes.txt": "Release notes", "v1/bin/tool": TEST_OBJECT_CONTENT} {
+		file, _ := archiveWriter.Create(name)
+		file.Write([]byte(content))
+	}
+	archiveWriter.Close()
+	archiveReader, err := zip.NewReader(bytes.NewReader(archive.Bytes()), int64(archive.Len()))
+	if err != nil {
+		t.Fatalf("Error in attempt to read zip archive %v", err)
+	}
+
+	server := httptest.NewServer(&ServeLocalFolder{
+		Backend: &services.FSBackend{FS: archiveReader, Bucket: "release"},
+	})
+	// Close the server when test finishes
+	defer server.Close()
+
+	response, err := http.Get(server.URL + "/release?list-type=2&prefix=v1/&delimiter=/")
+	if err != nil || response.StatusCode != http.StatusOK {
+		t.Fatalf("Error in attempt to list objects %v", err)
+	}
+	data, _ := io.ReadAll(response.Body)
+	if !bytes.Contains(data, []byte("<Key>v1/notes.txt</Key>")) || !bytes.Contains(data, []byte("<Prefix>v1/bin/</Prefix>")) {
+		t.Errorf("Wrong listing of zip archive %s", data)
+	}
+
+	request, _ := http.NewRequest("GET", server.URL+"/release/v1/notes.txt", nil)
+	request.Header.Set("Range", "bytes=8-12")
+	response, err = http.DefaultClient.Do(request)
+	if err != nil || response.StatusCode != http.StatusPartialContent {
+		t.Fatalf("Error in attempt to get range of object %v", err)
+	}
+	data, _ = io.ReadAll(response.Body)
+	if string(data) != "notes" {
+		t.Errorf("Wrong range of object %s", data)
+	}
+
+	request, _ = http.NewRequest("HEAD", server.URL+"/release/v1/bin/tool", nil)
+	response, err = http.DefaultClient.Do(request)
+	if err != nil || response.StatusCode != http.StatusOK || response.ContentLength != int64(len(TEST_OBJECT_CONTENT)) {
+		t.Errorf("Wrong HEAD response of object %v", err)
+	}
+
+	// The ETag is kept until the file is changed
+	backend := &services.FSBackend{FS: archiveReader, Bucket: "release"}
+	for i := 0; i < 2; i++ {
+		info, err := backend.Stat("release", "v1/bin/tool")
+		if err != nil || info.ETag != fmt.Sprintf("%x", md5.Sum([]byte(TEST_OBJECT_CONTENT))) {
+			t.Errorf("Wrong ETag of object %v", err)
+		}
+	}
+
+	// The compressed member is streamed, the seek back reads it again
+	reader, _, err := backend.Get("release", "v1/notes.txt")
+	if err != nil {
+		t.Fatalf("Error in attempt to get object %v", err)
+	}
+	defer reader.Close()
+	data, _ = io.ReadAll(reader)
+	reader.Seek(8, io.SeekStart)
+	tail, _ := io.ReadAll(reader)
+	if string(data) != "Release notes" || string(tail) != "notes" {
+		t.Errorf("Wrong content of streamed object %s %s", data, tail)
+	}
+
+	for _, method := range []string{"PUT", "DELETE"} {
+		request, _ = http.NewRequest(method, server.URL+"/release/v1/notes.txt", bytes.NewReader([]byte(TEST_OBJECT_CONTENT)))
+		response, err = http.DefaultClient.Do(request)
+		if err != nil || response.StatusCode != http.StatusForbidden {
+			t.Errorf("%s of read-only object was not refused %v", method, err)
+		}
+	}
+}
+
+func TestPathTraversal(t *testing.T) {
+	outside := t.TempDir()
+	rootFolder := outside + "/data"
+	os.WriteFile(outside+"/secret.txt", []byte("secret"), 0644)
+	backend := &services.FileSystemBackend{RootFolder: rootFolder}
+	backend.Init()
+	server := httptest.NewServer(&ServeLocalFolder{RootFolder: rootFolder, Backend: backend})
+	// Close the server when test finishes
+	defer server.Close()
+
+	request, _ := http.NewRequest("PUT", server.URL+"/contained", nil)
+	if _, err := http.DefaultClient.Do(request); err != nil {
+		t.Fatalf("Error in attempt to create bucket %v", err)
+	}
+	send := func(method string, path string, body string) (int, string) {
+		request, _ := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatalf("Error in attempt to %s %s %v", method, path, err)
+		}
+		data, _ := io.ReadAll(response.Body)
+		return response.StatusCode, string(data)
+	}
+
+	// The keys escaping the bucket folder are refused
+	if status, _ := send("PUT", "/contained/../../pwned.txt", TEST_OBJECT_CONTENT); status != http.StatusBadRequest {
+		t.Errorf("Wrong status of put out of the bucket %d", status)
+	}
+	if _, err := os.Stat(outside + "/pwned.txt"); !os.IsNotExist(err) {
+		t.Errorf("Object is written out of the bucket %v", err)
+	}
+	for _, path := range []string{"/contained/../../secret.txt", "/contained/%2e%2e/%2e%2e/secret.txt", "/contained//../../secret.txt", "/../secret.txt"} {
+		if status, body := send("GET", path, ""); status == http.StatusOK || strings.Contains(body, "secret") {
+			t.Errorf("File out of the bucket is read by %s %d", path, status)
+		}
+	}
+	send("DELETE", "/contained/../../secret.txt", "")
+	if _, err := os.Stat(outside + "/secret.txt"); err != nil {
+		t.Errorf("File out of the bucket is deleted %v", err)
+	}
+	if _, _, err := backend.Get("contained", "../../secret.txt"); err == nil {
+		t.Errorf("Backend reads the file out of the bucket")
+	}
+	if err := backend.Put("..", "secret.txt", strings.NewReader(TEST_OBJECT_CONTENT), &models.ObjectMetadata{}); err == nil {
+		t.Errorf("Backend writes the file out of the data folder")
+	}
+	if _, err := ParseMount("../outside=" + outside); err == nil {
+		t.Errorf("Mount of the invalid bucket name is accepted")
+	}
+}
+
+func TestSystemFolder(t *testing.T) {
+	rootFolder := t.TempDir()
+	backend := &services.FileSystemBackend{RootFolder: rootFolder}
+	backend.Init()
+	server := httptest.NewServer(&ServeLocalFolder{RootFolder: rootFolder, Backend: backend, GenerateMasterKey: true})
+	// Close the server when test finishes
+	defer server.Close()
+
+	request, _ := http.NewRequest("PUT", server.URL+"/keyed/sse-s3", strings.NewReader(TEST_OBJECT_CONTENT))
+	request.Header.Set("x-amz-server-side-encryption", "AES256")
+	response, err := http.DefaultClient.Do(request)
+	if err != nil || response.StatusCode != http.StatusOK {
+		t.Fatalf("Error in attempt to put encrypted object %v", err)
+	}
+	masterKey, _ := os.ReadFile(rootFolder + "/" + services.SYSTEM_FOLDER + "/" + services.MASTER_KEY_FILE)
+	if len(masterKey) == 0 {
+		t.Fatalf("Master key is not generated")
+	}
+	for _, path := range []string{
+		services.SYSTEM_FOLDER + "/" + services.MASTER_KEY_FILE,
+		services.SYSTEM_FOLDER + "/metadata/keyed/sse-s3.json",
+	} {
+		if info, err := os.Stat(rootFolder + "/" + path); err != nil || info.Mode().Perm() != services.RECORD_FILE_MODE {
+			t.Errorf("Wrong mode of the key record %s %v", path, err)
+		}
+	}
+
+	// The system folder is never served whatever the path is
+	for _, path := range []string{
+		"/keyed/../.s2d3/master.key",
+		"/keyed/%2e%2e/.s2d3/master.key",
+		"/.s2d3/master.key",
+		"/keyed/.s2d3/master.key",
+		"/keyed/../.s2d3/metadata/keyed/sse-s3.json",
+	} {
+		request, _ := http.NewRequest("GET", server.URL+path, nil)
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatalf("Error in attempt to get %s %v", path, err)
+		}
+		data, _ := io.ReadAll(response.Body)
+		if response.StatusCode == http.StatusOK || bytes.Contains(data, masterKey) || strings.Contains(string(data), "dataKey") {
+			t.Errorf("System folder is served by %s %d", path, response.StatusCode)
+		}
+	}
+	request, _ = http.NewRequest("PUT", server.URL+"/keyed/.s2d3/master.key", strings.NewReader(TEST_OBJECT_CONTENT))
+	if response, err := http.DefaultClient.Do(request); err != nil || response.StatusCode != http.StatusBadRequest {
+		t.Errorf("Object is put in the system folder %v", err)
+	}
+
+	// The key records of the earlier versions are tightened
+	legacyFolder := t.TempDir()
+	os.MkdirAll(legacyFolder+"/"+services.SYSTEM_FOLDER, 0775)
+	os.WriteFile(legacyFolder+"/"+services.SYSTEM_FOLDER+"/"+services.MASTER_KEY_FILE, masterKey, 0644)
+	(&services.FileSystemBackend{RootFolder: legacyFolder}).Init()
+	if info, err := os.Stat(legacyFolder + "/" + services.SYSTEM_FOLDER + "/" + services.MASTER_KEY_FILE); err != nil || info.Mode().Perm() != services.RECORD_FILE_MODE {
+		t.Errorf("Key record of the earlier version is readable by all %v", err)
+	}
+}
+
+func TestMounts(t *testing.T) {
+	InitStorage(TEST_SERVED_LOCAL_FOLDER)
+	projectFolder, archiveFolder, smallFolder := t.TempDir(), t.TempDir(), t.TempDir()
+	os.WriteFile(projectFolder+"/README.md", []byte(TEST_OBJECT_CONTENT), 0644)
+	mounts := make([]services.Mount, 0)
+	for _, spec := range []string{"project=" + projectFolder, "archive=" + archiveFolder + ":ro", "small=" + smallFolder + ":quota=8"} {
+		mount, err := ParseMount(spec)
+		if err != nil {
+			t.Fatalf("Error in attempt to parse mount %v", err)
+		}
+		mounts = append(mounts, mount)
+	}
+	if _, err := ParseMount("missing=" + projectFolder + "/missing"); err == nil {
+		t.Errorf("Mount of missing folder was parsed")
+	}
+	backend, err := NewBackend(TEST_SERVED_LOCAL_FOLDER, mounts, 0)
+	if err != nil {
+		t.Fatalf("Error in attempt to create backend %v", err)
+	}
+	server := httptest.NewServer(&ServeLocalFolder{
+		RootFolder: TEST_SERVED_LOCAL_FOLDER,
+		Backend:    backend,
+	})
+	// Close the server when test finishes
+	defer server.Close()
+
+	response, err := http.Get(server.URL + "/project/README.md")
+	if err != nil || response.StatusCode != http.StatusOK {
+		t.Fatalf("Error in attempt to get mounted object %v", err)
+	}
+	request, _ := http.NewRequest("PUT", server.URL+"/project/docs/guide.md", bytes.NewReader([]byte(TEST_OBJECT_CONTENT)))
+	response, err = http.DefaultClient.Do(request)
+	if err != nil || response.StatusCode != http.StatusOK {
+		t.Fatalf("Error in attempt to put mounted object %v", err)
+	}
+	if data, err := os.ReadFile(projectFolder + "/docs/guide.md"); err != nil || string(data) != TEST_OBJECT_CONTENT {
+		t.Errorf("Object is not stored in mounted folder %v", err)
+	}
+	response, err = http.Get(server.URL + "/project?list-type=2&prefix=.s2d3")
+	data, _ := io.ReadAll(response.Body)
+	if err != nil || bytes.Contains(data, []byte("<Key>")) {
+		t.Errorf("System folder of mounted folder is listed %s", data)
+	}
+
+	request, _ = http.NewRequest("PUT", server.URL+"/archive/object", bytes.NewReader([]byte(TEST_OBJECT_CONTENT)))
+	response, err = http.DefaultClient.Do(request)
+	if err != nil || response.StatusCode != http.StatusForbidden {
+		t.Errorf("Object was put into read-only mount %v", err)
+	}
+
+	for i, expectedStatus := range []int{http.StatusOK, http.StatusOK, http.StatusForbidden} {
+		request, _ = http.NewRequest("PUT", fmt.Sprintf("%s/small/object%d", server.URL, i), bytes.NewReader([]byte(TEST_OBJECT_CONTENT)))
+		response, err = http.DefaultClient.Do(request)
+		if err != nil || response.StatusCode != expectedStatus {
+			t.Errorf("Wrong status of put into mount with quota %d %v", i, err)
+		}
+	}
+	// The quota of the mount is the hard limit of the bucket quota
+	config, err := (&services.Storage{Backend: backend}).GetQuotaConfiguration("small")
+	if err != nil || config == nil || config.MaxBytes != 8 {
+		t.Errorf("Wrong quota of mounted bucket %v %v", config, err)
+	}
+
+	// The invalid mounts don't start the server with the default backend
+	os.Setenv("MOUNTS_FILE", projectFolder+"/missing")
+	defer os.Unsetenv("MOUNTS_FILE")
+	ctx, _ := AsyncServe(TEST_SERVED_LOCAL_FOLDER, "localhost", 0)
+	if ctx.Err() == nil {
+		t.Errorf("Server was started with invalid mounts file")
+	}
+}
+
+func TestPackStorage(t *testing.T) {
+	InitStorage(TEST_SERVED_LOCAL_FOLDER)
+	services.ClosePackStores()
+	os.RemoveAll(TEST_SERVED_LOCAL_FOLDER + "/packed")
+	packsFolder := TEST_SERVED_LOCAL_FOLDER + "/" + services.SYSTEM_FOLDER + "/" + services.PACKS_FOLDER + "/packed"
+	os.RemoveAll(packsFolder)
+	server := httptest.NewServer(WithContextDecorator(services.ApiRouter, TEST_SERVED_LOCAL_FOLDER, ""))
+	// Close the server when test finishes
+	defer server.Close()
+
+	request, _ := http.NewRequest("PUT", server.URL+"/packed", nil)
+	if _, err := http.DefaultClient.Do(request); err != nil {
+		t.Fatalf("Error in attempt to create bucket %v", err)
+	}
+	request, _ = http.NewRequest("PUT", server.URL+"/packed?pack", bytes.NewReader([]byte("<PackConfiguration><MaxObjectSize>1000</MaxObjectSize></PackConfiguration>")))
+	response, err := http.DefaultClient.Do(request)
+	if err != nil || response.StatusCode != http.StatusBadRequest {
+		t.Errorf("Pack configuration with wrong max object size is accepted %v", err)
+	}
+	request, _ = http.NewRequest("PUT", server.URL+"/packed?pack", bytes.NewReader([]byte("<PackConfiguration><MaxObjectSize>4096</MaxObjectSize></PackConfiguration>")))
+	response, err = http.DefaultClient.Do(request)
+	if err != nil || response.StatusCode != http.StatusOK {
+		t.Fatalf("Error in attempt to put pack configuration %v", err)
+	}
+
+	storage := services.NewStorage(TEST_SERVED_LOCAL_FOLDER)
+	put := func(objectKey string, content []byte) {
+		request, _ := http.NewRequest("PUT", server.URL+"/packed/"+objectKey, bytes.NewReader(content))
+		response, err := http.DefaultClient.Do(request)
+		if err != nil || response.StatusCode != http.StatusOK || response.Header.Get("ETag") != fmt.Sprintf("\"%x\"", md5.Sum(content)) {
+			t.Fatalf("Error in attempt to put object %s %v", objectKey, err)
+		}
+	}
+	check := func(objectKey string, content []byte) {
+		if content == nil {
+			if _, err := storage.Stat("packed", objectKey); !errors.Is(err, services.ErrNoSuchKey) {
+				t.Errorf("Deleted object %s is found %v", objectKey, err)
+			}
+			return
+		}
+		response, err := http.Get(server.URL + "/packed/" + objectKey)
+		if err != nil || response.StatusCode != http.StatusOK {
+			t.Fatalf("Error in attempt to get object %s %v", objectKey, err)
+		}
+		data, _ := io.ReadAll(response.Body)
+		if !bytes.Equal(data, content) {
+			t.Errorf("Wrong content of object %s: %s", objectKey, data)
+		}
+	}
+
+	large := bytes.Repeat([]byte("large"), 1000)
+	put("a", []byte("first a"))
+	put("b", []byte("b"))
+	put("folder/c", []byte("c"))
+	put("a", []byte("second a"))
+	put("large", large)
+	request, _ = http.NewRequest("DELETE", server.URL+"/packed/b", nil)
+	response, err = http.DefaultClient.Do(request)
+	if err != nil || response.StatusCode != http.StatusNoContent {
+		t.Fatalf("Error in attempt to delete packed object %v", err)
+	}
+	check("a", []byte("second a"))
+	check("b", nil)
+	check("folder/c", []byte("c"))
+	check("large", large)
+	if _, err := os.Stat(TEST_SERVED_LOCAL_FOLDER + "/packed/a"); !os.IsNotExist(err) {
+		t.Errorf("Packed object is stored as file %v", err)
+	}
+	if _, err := os.Stat(TEST_SERVED_LOCAL_FOLDER + "/packed/large"); err != nil {
+		t.Errorf("Large object is not stored as file %v", err)
+	}
+	versions, _ := storage.List("packed")
+	if len(versions) != 3 || versions[0].Key != "a" || int(versions[0].Size) != len("second a") || versions[1].Key != "folder/c" || versions[2].Key != "large" {
+		t.Errorf("Wrong listing of packed bucket %v", versions)
+	}
+
+	// The process crashed in the middle of the append
+	packs, _ := os.ReadDir(packsFolder)
+	lastPack := packsFolder + "/" + packs[len(packs)-1].Name()
+	file, _ := os.OpenFile(lastPack, os.O_WRONLY|os.O_APPEND, 0644)
+	file.Write([]byte{0x53, 0x32, 0x70, 0x43, 0, 0, 1})
+	file.Close()
+	services.ClosePackStores()
+	check("a", []byte("second a"))
+	put("d", []byte("d"))
+	services.ClosePackStores()
+	check("d", []byte("d"))
+	check("folder/c", []byte("c"))
+
+	// The process crashed after the compacted pack was renamed but before
+	// the old packs were removed
+	oldPacks := map[string][]byte{}
+	packs, _ = os.ReadDir(packsFolder)
+	for _, pack := range packs {
+		oldPacks[pack.Name()], _ = os.ReadFile(packsFolder + "/" + pack.Name())
+	}
+	reclaimed, err := (&services.FileSystemBackend{RootFolder: TEST_SERVED_LOCAL_FOLDER}).CompactPacks("packed", 0)
+	if err != nil || reclaimed <= 0 {
+		t.Fatalf("Packs are not compacted %d %v", reclaimed, err)
+	}
+	check("a", []byte("second a"))
+	for name, data := range oldPacks {
+		os.WriteFile(packsFolder+"/"+name, data, 0644)
+	}
+	os.WriteFile(packsFolder+"/00000099.pack.tmp", []byte("unfinished"), 0644)
+	services.ClosePackStores()
+	check("a", []byte("second a"))
+	check("b", nil)
+	check("folder/c", []byte("c"))
+	check("d", []byte("d"))
+	if _, err := os.Stat(packsFolder + "/00000099.pack.tmp"); !os.IsNotExist(err) {
+		t.Errorf("Unfinished pack is not removed %v", err)
+	}
+	versions, _ = storage.List("packed")
+	if len(versions) != 4 {
+		t.Errorf("Wrong listing of recovered packs %v", versions)
+	}
+}
+
+func TestDeduplication(t *testing.T) {
+	InitStorage(TEST_SERVED_LOCAL_FOLDER)
+	os.RemoveAll(TEST_SERVED_LOCAL_FOLDER + "/dedup")
+	os.RemoveAll(TEST_SERVED_LOCAL_FOLDER + "/" + services.SYSTEM_FOLDER + "/" + services.SEGMENTS_FOLDER)
+	os.RemoveAll(TEST_SERVED_LOCAL_FOLDER + "/" + services.SYSTEM_FOLDER + "/" + services.MANIFESTS_FOLDER)
+	server := httptest.NewServer(WithContextDecorator(services.ApiRouter, TEST_SERVED_LOCAL_FOLDER, ""))
+	// Close the server when test finishes
+	defer server.Close()
+
+	request, _ := http.NewRequest("PUT", server.URL+"/dedup", nil)
+	if _, err := http.DefaultClient.Do(request); err != nil {
+		t.Fatalf("Error in attempt to create bucket %v", err)
+	}
+	request, _ = http.NewRequest("PUT", server.URL+"/dedup?dedup", bytes.NewReader([]byte("<DedupConfiguration><SegmentSize>5000</SegmentSize></DedupConfiguration>")))
+	response, err := http.DefaultClient.Do(request)
+	if err != nil || response.StatusCode != http.StatusBadRequest {
+		t.Errorf("Dedup configuration with wrong segment size is accepted %v", err)
+	}
+	request, _ = http.NewRequest("PUT", server.URL+"/dedup?dedup", bytes.NewReader([]byte("<DedupConfiguration><SegmentSize>4096</SegmentSize></DedupConfiguration>")))
+	response, err = http.DefaultClient.Do(request)
+	if err != nil || response.StatusCode != http.StatusOK {
+		t.Fatalf("Error in attempt to put dedup configuration %v", err)
+	}
+
+	statistics := func() models.DedupStatistics {
+		response, err := http.Get(server.URL + "/?dedup-stats")
+		if err != nil || response.StatusCode != http.StatusOK {
+			t.Fatalf("Error in attempt to get dedup statistics %v", err)
+		}
+		statistics := models.DedupStatistics{}
+		data, _ := io.ReadAll(response.Body)
+		if err := xml.Unmarshal(data, &statistics); err != nil {
+			t.Fatalf("Wrong dedup statistics %s", data)
+		}
+		return statistics
+	}
+	put := func(objectKey string, content []byte) {
+		request, _ := http.NewRequest("PUT", server.URL+"/dedup/"+objectKey, bytes.NewReader(content))
+		response, err := http.DefaultClient.Do(request)
+		if err != nil || response.StatusCode != http.StatusOK || response.Header.Get("ETag") != fmt.Sprintf("\"%x\"", md5.Sum(content)) {
+			t.Fatalf("Error in attempt to put object %s %v", objectKey, err)
+		}
+	}
+
+	firstBuild := make([]byte, 0)
+	for i := 0; i < 5; i++ {
+		firstBuild = append(firstBuild, bytes.Repeat([]byte{byte('a' + i)}, 4096)...)
+	}
+	secondBuild := append(append([]byte{}, firstBuild[:4*4096]...), bytes.Repeat([]byte("changed"), 400)...)
+	put("build-1", firstBuild)
+	put("build-2", secondBuild)
+
+	request, _ = http.NewRequest("GET", server.URL+"/dedup/build-2", nil)
+	request.Header.Set("Range", "bytes=4000-17000")
+	response, err = http.DefaultClient.Do(request)
+	if err != nil || response.StatusCode != http.StatusPartialContent {
+		t.Fatalf("Error in attempt to get range of deduplicated object %v", err)
+	}
+	data, _ := io.ReadAll(response.Body)
+	if !bytes.Equal(data, secondBuild[4000:17001]) {
+		t.Errorf("Wrong range of deduplicated object")
+	}
+	response, err = http.Get(server.URL + "/dedup/build-1")
+	if err != nil || response.StatusCode != http.StatusOK {
+		t.Fatalf("Error in attempt to get deduplicated object %v", err)
+	}
+	data, _ = io.ReadAll(response.Body)
+	if !bytes.Equal(data, firstBuild) {
+		t.Errorf("Wrong content of deduplicated object")
+	}
+	if _, err := os.Stat(TEST_SERVED_LOCAL_FOLDER + "/dedup/build-1"); !os.IsNotExist(err) {
+		t.Errorf("Deduplicated object is stored as file %v", err)
+	}
+	storage := services.NewStorage(TEST_SERVED_LOCAL_FOLDER)
+	versions, _ := storage.List("dedup")
+	if len(versions) != 2 || int(versions[0].Size) != len(firstBuild) || int(versions[1].Size) != len(secondBuild) {
+		t.Errorf("Wrong listing of deduplicated bucket %v", versions)
+	}
+
+	current := statistics()
+	if current.Segments != 6 || current.References != 10 || current.DedupRatio < 1.5 {
+		t.Errorf("Wrong dedup statistics %v", current)
+	}
+
+	request, _ = http.NewRequest("DELETE", server.URL+"/dedup/build-1", nil)
+	response, err = http.DefaultClient.Do(request)
+	if err != nil || response.StatusCode != http.StatusNoContent {
+		t.Fatalf("Error in attempt to delete deduplicated object %v", err)
+	}
+	put("build-2", []byte("small"))
+	current = statistics()
+	if current.Segments != 7 || current.References != 1 || current.UnreferencedBytes != int64(len(firstBuild)+2800) {
+		t.Errorf("Wrong dedup statistics of released segments %v", current)
+	}
+
+	// The references left by the crash are recounted from the manifests
+	hash := sha256.Sum256([]byte("small"))
+	segmentPath := fmt.Sprintf("%s/%s/%s/%x/%x", TEST_SERVED_LOCAL_FOLDER, services.SYSTEM_FOLDER, services.SEGMENTS_FOLDER, hash[:1], hash)
+	if err := os.WriteFile(segmentPath+services.SEGMENT_REFERENCES_SUFFIX, []byte("3"), 0644); err != nil {
+		t.Fatalf("Error in attempt to change the segment references %v", err)
+	}
+	removed, reclaimed, err := (&services.FileSystemBackend{RootFolder: TEST_SERVED_LOCAL_FOLDER}).CollectSegments()
+	if err != nil || removed != 6 || reclaimed != int64(len(firstBuild)+2800) {
+		t.Errorf("Wrong collection of unreferenced segments %d %d %v", removed, reclaimed, err)
+	}
+	current = statistics()
+	if current.Segments != 1 || current.References != 1 || current.DedupRatio != 1 {
+		t.Errorf("Wrong dedup statistics after collection %v", current)
+	}
+	response, err = http.Get(server.URL + "/dedup/build-2")
+	if err != nil || response.StatusCode != http.StatusOK {
+		t.Fatalf("Error in attempt to get deduplicated object %v", err)
+	}
+	data, _ = io.ReadAll(response.Body)
+	if string(data) != "small" {
+		t.Errorf("Wrong content of deduplicated object %s", data)
+	}
+
+	// The segments of the dot-prefixed keys are referenced by their manifests
+	put("dir/.env", []byte("SECRET=1"))
+	removed, _, err = (&services.FileSystemBackend{RootFolder: TEST_SERVED_LOCAL_FOLDER}).CollectSegments()
+	if err != nil || removed != 0 {
+		t.Errorf("Segments of dot-prefixed key are collected %d %v", removed, err)
+	}
+	versions, _ = storage.List("dedup")
+	if len(versions) != 2 || versions[1].Key != "dir/.env" {
+		t.Errorf("Dot-prefixed key is not listed %v", versions)
+	}
+	response, err = http.Get(server.URL + "/dedup/dir/.env")
+	if err != nil || response.StatusCode != http.StatusOK {
+		t.Fatalf("Error in attempt to get dot-prefixed object %v", err)
+	}
+	data, _ = io.ReadAll(response.Body)
+	if string(data) != "SECRET=1" {
+		t.Errorf("Wrong content of dot-prefixed object %s", data)
+	}
+}
+
+func TestMetadataIndex(t *testing.T) {
+	InitStorage(TEST_SERVED_LOCAL_FOLDER)
+	services.CloseIndexes()
+	os.RemoveAll(TEST_SERVED_LOCAL_FOLDER + "/indexed")
+	indexFolder := TEST_SERVED_LOCAL_FOLDER + "/" + services.SYSTEM_FOLDER + "/" + services.INDEX_FOLDER + "/indexed"
+	os.RemoveAll(indexFolder)
+	backend := NewIndexedBackend(TEST_SERVED_LOCAL_FOLDER, &services.FileSystemBackend{RootFolder: TEST_SERVED_LOCAL_FOLDER})
+	server := httptest.NewServer(&ServeLocalFolder{
+		RootFolder: TEST_SERVED_LOCAL_FOLDER,
+		Backend:    backend,
+	})
+	// Close the server when test finishes
+	defer server.Close()
+
+	// The capabilities of the wrapped backend are found through the index
+	if _, exists := services.CapabilityOf[services.DiskReporter](backend); !exists {
+		t.Errorf("Disk reporter of the wrapped backend is not found")
+	}
+
+	request, _ := http.NewRequest("PUT", server.URL+"/indexed", nil)
+	if _, err := http.DefaultClient.Do(request); err != nil {
+		t.Fatalf("Error in attempt to create bucket %v", err)
+	}
+	if _, err := os.Stat(indexFolder + "/" + services.INDEX_SNAPSHOT); err != nil {
+		t.Fatalf("Index of new bucket is not created %v", err)
+	}
+	for _, objectKey := range []string{"b", "a/1", "c"} {
+		request, _ = http.NewRequest("PUT", server.URL+"/indexed/"+objectKey, bytes.NewReader([]byte(TEST_OBJECT_CONTENT)))
+		if response, err := http.DefaultClient.Do(request); err != nil || response.StatusCode != http.StatusOK {
+			t.Fatalf("Error in attempt to put object %s %v", objectKey, err)
+		}
+	}
+	request, _ = http.NewRequest("DELETE", server.URL+"/indexed/c", nil)
+	if response, err := http.DefaultClient.Do(request); err != nil || response.StatusCode != http.StatusNoContent {
+		t.Fatalf("Error in attempt to delete object %v", err)
+	}
+
+	keysOf := func(versions []models.ObjectVersion) string {
+		keys := make([]string, 0)
+		for _, version := range versions {
+			keys = append(keys, fmt.Sprintf("%s:%d", version.Key, version.Size))
+		}
+		return fmt.Sprint(keys)
+	}
+	response, err := http.Get(server.URL + "/indexed?list-type=2")
+	if err != nil || response.StatusCode != http.StatusOK {
+		t.Fatalf("Error in attempt to list indexed bucket %v", err)
+	}
+	data, _ := io.ReadAll(response.Body)
+	if !bytes.Contains(data, []byte("<Key>a/1</Key>")) || bytes.Contains(data, []byte("<Key>c</Key>")) {
+		t.Errorf("Wrong listing of indexed bucket %s", data)
+	}
+
+	// The page of the indexed bucket starts at the prefix or after the marker
+	for query, expected := range map[string][]string{
+		"list-type=2&max-keys=1":             {"<Key>a/1</Key>", "<IsTruncated>true</IsTruncated>"},
+		"list-type=2&start-after=a/1":        {"<Key>b</Key>", "<IsTruncated>false</IsTruncated>"},
+		"list-type=2&prefix=b":               {"<Key>b</Key>", "<KeyCount>1</KeyCount>"},
+		"list-type=2&delimiter=/&max-keys=1": {"<Prefix>a/</Prefix>", "<IsTruncated>true</IsTruncated>"},
+		"marker=a/1&max-keys=1":              {"<Key>b</Key>", "<IsTruncated>false</IsTruncated>"},
+	} {
+		response, err := http.Get(server.URL + "/indexed?" + query)
+		if err != nil || response.StatusCode != http.StatusOK {
+			t.Fatalf("Error in attempt to list indexed bucket %s %v", query, err)
+		}
+		data, _ := io.ReadAll(response.Body)
+		for _, element := range expected {
+			if !bytes.Contains(data, []byte(element)) {
+				t.Errorf("Wrong page %s of indexed bucket %s", query, data)
+			}
+		}
+	}
+
+	// The file copied by hand is listed after the index is rebuilt
+	os.WriteFile(TEST_SERVED_LOCAL_FOLDER+"/indexed/manual.txt", []byte("manual"), 0644)
+	versions, _ := backend.List("indexed")
+	if keys := keysOf(versions); keys != "[a/1:4 b:4]" {
+		t.Errorf("Wrong listing of index %s", keys)
+	}
+	if err := backend.Rebuild("indexed"); err != nil {
+		t.Fatalf("Error in attempt to rebuild index %v", err)
+	}
+	versions, _ = backend.List("indexed")
+	if keys := keysOf(versions); keys != "[a/1:4 b:4 manual.txt:6]" {
+		t.Errorf("Wrong listing of rebuilt index %s", keys)
+	}
+
+	// The process crashed while the object was stored
+	os.WriteFile(TEST_SERVED_LOCAL_FOLDER+"/indexed/b", []byte("changed"), 0644)
+	journal, _ := os.OpenFile(indexFolder+"/"+services.INDEX_JOURNAL, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	journal.Write([]byte("{\"op\":\"begin\",\"key\":\"b\"}\n{\"op\":\"put\",\"key\":\"b\",\"en"))
+	journal.Close()
+	services.CloseIndexes()
+	versions, _ = backend.List("indexed")
+	if keys := keysOf(versions); keys != "[a/1:4 b:7 manual.txt:6]" {
+		t.Errorf("Wrong listing of recovered index %s", keys)
+	}
+
+	// The bucket without the index is listed walking its folder
+	os.RemoveAll(indexFolder)
+	services.CloseIndexes()
+	os.WriteFile(TEST_SERVED_LOCAL_FOLDER+"/indexed/walked.txt", []byte("walked"), 0644)
+	versions, err = backend.List("indexed")
+	if keys := keysOf(versions); err != nil || keys != "[a/1:4 b:7 manual.txt:6 walked.txt:6]" {
+		t.Errorf("Wrong listing of bucket without index %s %v", keys, err)
+	}
+}
+
+// statCountingBackend counts the Stat calls of the backend.
+type statCountingBackend struct {
+	services.Backend
+	stats int
+}
+
+func (backend *statCountingBackend) Stat(bucketName string, objectKey string) (*services.ObjectInfo, error) {
+	backend.stats++
+	return backend.Backend.Stat(bucketName, objectKey)
+}
+
+func TestFolderWatcher(t *testing.T) {
+	InitStorage(TEST_SERVED_LOCAL_FOLDER)
+	services.CloseIndexes()
+	os.RemoveAll(TEST_SERVED_LOCAL_FOLDER + "/watched")
+	os.RemoveAll(TEST_SERVED_LOCAL_FOLDER + "/" + services.SYSTEM_FOLDER + "/" + services.INDEX_FOLDER + "/watched")
+	os.RemoveAll(TEST_SERVED_LOCAL_FOLDER + "/" + services.SYSTEM_FOLDER + "/" + services.NOTIFICATIONS_FOLDER)
+	defer os.RemoveAll(TEST_SERVED_LOCAL_FOLDER + "/" + services.SYSTEM_FOLDER + "/" + services.NOTIFICATIONS_FOLDER)
+	counter := &statCountingBackend{Backend: &services.FileSystemBackend{RootFolder: TEST_SERVED_LOCAL_FOLDER}}
+	backend := NewIndexedBackend(TEST_SERVED_LOCAL_FOLDER, counter)
+	if err := backend.CreateBucket("watched"); err != nil {
+		t.Fatalf("Error in attempt to create bucket %v", err)
+	}
+	configuration := "<NotificationConfiguration><QueueConfiguration><Queue>http://127.0.0.1:9/events</Queue>" +
+		"<Event>s3:ObjectCreated:*</Event><Event>s3:ObjectRemoved:*</Event></QueueConfiguration></NotificationConfiguration>"
+	backend.PutBucketConfig("watched", services.NOTIFICATION_CONFIG, []byte(configuration))
+	// The changes are told from the writes of the service by the index only
+	if bucketNames := UnwatchedNotifications(counter.Backend); !slices.Contains(bucketNames, "watched") {
+		t.Errorf("Notifications of the unindexed backend are not reported %v", bucketNames)
+	}
+	if bucketNames := UnwatchedNotifications(backend); len(bucketNames) != 0 {
+		t.Errorf("Notifications of the indexed backend are reported %v", bucketNames)
+	}
+
+	queuedEvents := func() string {
+		names, _ := backend.ListRecords(services.NOTIFICATIONS_FOLDER)
+		events := make([]string, 0)
+		for _, name := range names {
+			data, _ := backend.GetRecord(services.NOTIFICATIONS_FOLDER + "/" + name)
+			queuedEvent := models.QueuedEvent{}
+			json.Unmarshal(data, &queuedEvent)
+			for _, record := range queuedEvent.Event.Records {
+				events = append(events, record.EventName+" "+record.S3.Object.Key)
+			}
+			backend.DeleteRecord(services.NOTIFICATIONS_FOLDER + "/" + name)
+		}
+		sort.Strings(events)
+		return fmt.Sprint(events)
+	}
+	keysOf := func(versions []models.ObjectVersion) string {
+		keys := make([]string, 0)
+		for _, version := range versions {
+			keys = append(keys, fmt.Sprintf("%s:%s", version.Key, version.ETag))
+		}
+		return fmt.Sprint(keys)
+	}
+
+	watcher := &services.FolderWatcher{
+		Backend:    backend,
+		RootFolder: TEST_SERVED_LOCAL_FOLDER,
+		Interval:   time.Hour,
+	}
+	backend.Put("watched", "api.txt", bytes.NewReader([]byte(TEST_OBJECT_CONTENT)), &models.ObjectMetadata{})
+	os.MkdirAll(TEST_SERVED_LOCAL_FOLDER+"/watched/dropped", 0775)
+	os.WriteFile(TEST_SERVED_LOCAL_FOLDER+"/watched/dropped/file.txt", []byte("dropped"), 0644)
+	if err := watcher.Rescan(); err != nil {
+		t.Fatalf("Error in attempt to rescan folder %v", err)
+	}
+	versions, _ := backend.List("watched")
+	if keys := keysOf(versions); keys != fmt.Sprintf("[api.txt:%x dropped/file.txt:%x]", md5.Sum([]byte(TEST_OBJECT_CONTENT)), md5.Sum([]byte("dropped"))) {
+		t.Errorf("Wrong listing of rescanned bucket %s", keys)
+	}
+	if events := queuedEvents(); events != "[ObjectCreated:External dropped%2Ffile.txt]" {
+		t.Errorf("Wrong events of rescanned bucket %s", events)
+	}
+
+	os.WriteFile(TEST_SERVED_LOCAL_FOLDER+"/watched/dropped/file.txt", []byte("modified"), 0644)
+	os.Remove(TEST_SERVED_LOCAL_FOLDER + "/watched/api.txt")
+	if err := watcher.Rescan(); err != nil {
+		t.Fatalf("Error in attempt to rescan folder %v", err)
+	}
+	versions, _ = backend.List("watched")
+	if keys := keysOf(versions); keys != fmt.Sprintf("[dropped/file.txt:%x]", md5.Sum([]byte("modified"))) {
+		t.Errorf("Wrong listing of rescanned bucket %s", keys)
+	}
+	if events := queuedEvents(); events != "[ObjectCreated:External dropped%2Ffile.txt ObjectRemoved:External api.txt]" {
+		t.Errorf("Wrong events of rescanned bucket %s", events)
+	}
+
+	// The object stored transformed differs from its file, it is restated
+	// once and not by every rescan
+	size := int64(100)
+	backend.Put("watched", "transformed.txt", bytes.NewReader([]byte("stored")), &models.ObjectMetadata{Size: &size})
+	watcher.Rescan()
+	counter.stats = 0
+	if err := watcher.Rescan(); err != nil || counter.stats != 0 {
+		t.Errorf("Unchanged objects are restated by rescan %d %v", counter.stats, err)
+	}
+	if events := queuedEvents(); events != "[]" {
+		t.Errorf("Wrong events of transformed object %s", events)
+	}
+	backend.Delete("watched", "transformed.txt")
+
+	if runtime.GOOS != "linux" {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go watcher.Run(ctx)
+	// The watches are added when the watcher starts
+	time.Sleep(100 * time.Millisecond)
+	os.MkdirAll(TEST_SERVED_LOCAL_FOLDER+"/watched/live", 0775)
+	os.WriteFile(TEST_SERVED_LOCAL_FOLDER+"/watched/live/file.txt", []byte("live"), 0644)
+	os.RemoveAll(TEST_SERVED_LOCAL_FOLDER + "/watched/dropped")
+	expectedKeys := fmt.Sprintf("[live/file.txt:%x]", md5.Sum([]byte("live")))
+	for attempt := 0; attempt < 50; attempt++ {
+		versions, _ = backend.List("watched")
+		if keysOf(versions) == expectedKeys {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if keys := keysOf(versions); keys != expectedKeys {
+		t.Errorf("Wrong listing of watched bucket %s", keys)
+	}
+	if events := queuedEvents(); events != "[ObjectCreated:External live%2Ffile.txt ObjectRemoved:External dropped%2Ffile.txt]" {
+		t.Errorf("Wrong events of watched bucket %s", events)
+	}
+}
+
+func TestQuotas(t *testing.T) {
+	InitStorage(TEST_SERVED_LOCAL_FOLDER)
+	os.RemoveAll(TEST_SERVED_LOCAL_FOLDER + "/quota")
+	// The quota is managed by the admin api only
+	serveLocalFolder := &ServeLocalFolder{RootFolder: TEST_SERVED_LOCAL_FOLDER, AdminToken: "admin-token"}
+	multiplexer := http.NewServeMux()
+	multiplexer.Handle("/", serveLocalFolder)
+	multiplexer.Handle(services.ADMIN_PATH, serveLocalFolder.AdminHandler())
+	server := httptest.NewServer(multiplexer)
+	// Close the server when test finishes
+	defer server.Close()
+	admin := client.NewAdminClient(server.URL, "admin-token")
+
+	request, _ := http.NewRequest("PUT", server.URL+"/quota", nil)
+	if _, err := http.DefaultClient.Do(request); err != nil {
+		t.Fatalf("Error in attempt to create bucket %v", err)
+	}
+	put := func(objectKey string, content string) int {
+		request, _ := http.NewRequest("PUT", server.URL+"/quota/"+objectKey, strings.NewReader(content))
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatalf("Error in attempt to put object %s %v", objectKey, err)
+		}
+		return response.StatusCode
+	}
+	put("existing.txt", "0123456789")
+
+	if err := admin.PutQuota("quota", &models.QuotaConfiguration{MaxBytes: -1}); err == nil {
+		t.Errorf("Quota configuration with negative limit is accepted")
+	}
+	if err := admin.PutQuota("quota", &models.QuotaConfiguration{MaxBytes: 30, SoftMaxBytes: 15, MaxObjects: 3}); err != nil {
+		t.Fatalf("Error in attempt to put quota configuration %v", err)
+	}
+	response, err := http.Get(server.URL + "/quota?quota")
+	if err != nil {
+		t.Fatalf("Error in attempt to get quota by the S3 api %v", err)
+	}
+	if data, _ := io.ReadAll(response.Body); bytes.Contains(data, []byte("QuotaConfiguration")) {
+		t.Errorf("Quota is served by the S3 api %s", data)
+	}
+
+	usage := func() models.QuotaUsage {
+		config, err := admin.GetQuota("quota")
+		if err != nil || config.MaxBytes != 30 || config.Usage == nil {
+			t.Fatalf("Wrong quota configuration %v %v", config, err)
+		}
+		return *config.Usage
+	}
+	if current := usage(); current.Bytes != 10 || current.Objects != 1 || current.SoftLimitExceeded {
+		t.Errorf("Wrong usage of bucket with quota %v", current)
+	}
+
+	if status := put("second.txt", "0123456789"); status != http.StatusOK {
+		t.Errorf("Wrong status of put under quota %d", status)
+	}
+	if current := usage(); current.Bytes != 20 || current.Objects != 2 || !current.SoftLimitExceeded {
+		t.Errorf("Wrong usage over soft quota %v", current)
+	}
+	if status := put("third.txt", "0123456789ABC"); status != http.StatusForbidden {
+		t.Errorf("Wrong status of put over size quota %d", status)
+	}
+	// The replaced object is not counted
+	if status := put("second.txt", "0123456789ABCDEFGHIJ"); status != http.StatusOK {
+		t.Errorf("Wrong status of put replacing object under quota %d", status)
+	}
+	if status := put("third.txt", ""); status != http.StatusOK {
+		t.Errorf("Wrong status of put of empty object %d", status)
+	}
+	if status := put("fourth.txt", ""); status != http.StatusForbidden {
+		t.Errorf("Wrong status of put over object count quota %d", status)
+	}
+
+	request, _ = http.NewRequest("DELETE", server.URL+"/quota/second.txt", nil)
+	if _, err := http.DefaultClient.Do(request); err != nil {
+		t.Fatalf("Error in attempt to delete object %v", err)
+	}
+	if current := usage(); current.Bytes != 10 || current.Objects != 2 || current.SoftLimitExceeded {
+		t.Errorf("Wrong usage after delete %v", current)
+	}
+	// The content of the unknown size is stopped once it exceeds the quota
+	streamed := io.NopCloser(strings.NewReader(strings.Repeat("x", 21)))
+	if err := services.NewStorage(TEST_SERVED_LOCAL_FOLDER).PutObject("quota", "streamed.txt", streamed, &models.ObjectMetadata{}, nil); !errors.Is(err, services.ErrQuotaExceeded) {
+		t.Errorf("Streamed object over quota is accepted %v", err)
+	}
+	if _, err := os.Stat(TEST_SERVED_LOCAL_FOLDER + "/quota/streamed.txt"); !os.IsNotExist(err) {
+		t.Errorf("Streamed object over quota is stored %v", err)
+	}
+
+	storage := services.NewStorage(TEST_SERVED_LOCAL_FOLDER)
+	if err := storage.CreateUpload("quota", "multipart.txt", "upload", &models.ObjectMetadata{}, nil); err != nil {
+		t.Fatalf("Error in attempt to create upload %v", err)
+	}
+	if _, err := storage.PushPart("quota", "multipart.txt", "upload", 1, io.NopCloser(strings.NewReader(strings.Repeat("x", 21))), nil); !errors.Is(err, services.ErrQuotaExceeded) {
+		t.Errorf("Part over quota is accepted %v", err)
+	}
+	for partNumber, part := range []string{"0123456789A", "0123456789"} {
+		if _, err := storage.PushPart("quota", "multipart.txt", "upload", partNumber+1, io.NopCloser(strings.NewReader(part)), nil); err != nil {
+			t.Fatalf("Error in attempt to push part %v", err)
+		}
+	}
+	if err := storage.CompleteUpload("quota", "multipart.txt", "upload", services.UploadDone{}, nil); !errors.Is(err, services.ErrMissingParts) {
+		t.Errorf("Upload without parts is completed %v", err)
+	}
+	uploadDone := services.UploadDone{Parts: []models.XmlPart{{PartNumber: 1}, {PartNumber: 2}}}
+	if err := storage.CompleteUpload("quota", "multipart.txt", "upload", uploadDone, nil); !errors.Is(err, services.ErrQuotaExceeded) {
+		t.Errorf("Upload over quota is completed %v", err)
+	}
+
+	if err := admin.DeleteQuota("quota"); err != nil {
+		t.Fatalf("Error in attempt to delete quota %v", err)
+	}
+	if err := storage.CompleteUpload("quota", "multipart.txt", "upload", uploadDone, nil); err != nil {
+		t.Errorf("Error in attempt to complete upload without quota %v", err)
+	}
+}
+
+// sizedReader is the content of the declared size, as the request body is.
+type sizedReader struct {
+	io.Reader
+	size int64
+}
+
+func (reader *sizedReader) Size() int64 {
+	return reader.size
+}
+
+func TestDiskReserve(t *testing.T) {
+	InitStorage(TEST_SERVED_LOCAL_FOLDER)
+	os.RemoveAll(TEST_SERVED_LOCAL_FOLDER + "/reserve")
+	backend := &services.FileSystemBackend{RootFolder: TEST_SERVED_LOCAL_FOLDER}
+	server := httptest.NewServer(&ServeLocalFolder{
+		RootFolder: TEST_SERVED_LOCAL_FOLDER,
+		Backend:    backend,
+	})
+	// Close the server when test finishes
+	defer server.Close()
+
+	request, _ := http.NewRequest("PUT", server.URL+"/reserve", nil)
+	if _, err := http.DefaultClient.Do(request); err != nil {
+		t.Fatalf("Error in attempt to create bucket %v", err)
+	}
+	put := func(objectKey string) int {
+		request, _ := http.NewRequest("PUT", server.URL+"/reserve/"+objectKey, strings.NewReader(TEST_OBJECT_CONTENT))
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatalf("Error in attempt to put object %s %v", objectKey, err)
+		}
+		return response.StatusCode
+	}
+	status := func() models.DiskStatus {
+		response, err := http.Get(server.URL + "/?disk-status")
+		if err != nil || response.StatusCode != http.StatusOK {
+			t.Fatalf("Error in attempt to get disk status %v", err)
+		}
+		result := models.DiskStatusResult{}
+		data, _ := io.ReadAll(response.Body)
+		if err := xml.Unmarshal(data, &result); err != nil || len(result.Disks) != 1 {
+			t.Fatalf("Wrong disk status %s", data)
+		}
+		return result.Disks[0]
+	}
+
+	if code := put("kept.txt"); code != http.StatusOK {
+		t.Errorf("Wrong status of put without reserve %d", code)
+	}
+	// The failed overwrite keeps the previous object
+	os.Mkdir(TEST_SERVED_LOCAL_FOLDER+"/reserve/.kept.txt.tmp", 0775)
+	if err := backend.PushData("reserve", "kept.txt", "", strings.NewReader("overwritten")); err == nil {
+		t.Errorf("Blocked overwrite is accepted")
+	}
+	os.Remove(TEST_SERVED_LOCAL_FOLDER + "/reserve/.kept.txt.tmp")
+	if content, err := os.ReadFile(TEST_SERVED_LOCAL_FOLDER + "/reserve/kept.txt"); err != nil || string(content) != TEST_OBJECT_CONTENT {
+		t.Errorf("Failed overwrite lost the object %s %v", content, err)
+	}
+	// The content is streamed into the temporary file, the broken stream
+	// keeps the previous object
+	broken := io.MultiReader(strings.NewReader("overwritten"), iotest.ErrReader(errors.New("broken stream")))
+	if err := backend.PushData("reserve", "kept.txt", "", broken); err == nil {
+		t.Errorf("Broken stream is accepted")
+	}
+	if content, err := os.ReadFile(TEST_SERVED_LOCAL_FOLDER + "/reserve/kept.txt"); err != nil || string(content) != TEST_OBJECT_CONTENT {
+		t.Errorf("Broken stream lost the object %s %v", content, err)
+	}
+	if _, err := os.Stat(TEST_SERVED_LOCAL_FOLDER + "/reserve/.kept.txt.tmp"); !os.IsNotExist(err) {
+		t.Errorf("Temporary file of the broken stream is kept %v", err)
+	}
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" && runtime.GOOS != "freebsd" {
+		return
+	}
+	disk := status()
+	if disk.TotalBytes <= 0 || disk.FreeBytes <= 0 || disk.FreeBytes > disk.TotalBytes || disk.WritesRefused {
+		t.Errorf("Wrong disk status without reserve %v", disk)
+	}
+
+	backend.Reserve = disk.TotalBytes + 1
+	if code := put("refused.txt"); code != http.StatusInsufficientStorage {
+		t.Errorf("Wrong status of put below reserve %d", code)
+	}
+	if _, err := os.Stat(TEST_SERVED_LOCAL_FOLDER + "/reserve/refused.txt"); !os.IsNotExist(err) {
+		t.Errorf("Refused object is stored %v", err)
+	}
+	if disk := status(); !disk.WritesRefused || disk.ReserveBytes != backend.Reserve {
+		t.Errorf("Wrong disk status below reserve %v", disk)
+	}
+	storage := services.Storage{Backend: backend}
+	if err := storage.CreateUpload("reserve", "multipart.txt", "upload", &models.ObjectMetadata{}, nil); err != nil {
+		t.Fatalf("Error in attempt to create upload %v", err)
+	}
+	if _, err := storage.PushPart("reserve", "multipart.txt", "upload", 1, io.NopCloser(strings.NewReader(TEST_OBJECT_CONTENT)), nil); !errors.Is(err, services.ErrInsufficientStorage) {
+		t.Errorf("Part below reserve is accepted %v", err)
+	}
+	// The space is freed by the deletes
+	request, _ = http.NewRequest("DELETE", server.URL+"/reserve/kept.txt", nil)
+	response, err := http.DefaultClient.Do(request)
+	if err != nil || response.StatusCode != http.StatusNoContent {
+		t.Errorf("Error in attempt to delete object below reserve %v", err)
+	}
+
+	backend.Reserve = 0
+	if code := put("accepted.txt"); code != http.StatusOK {
+		t.Errorf("Wrong status of put without reserve %d", code)
+	}
+
+	// The objects are checked by their declared size before they are read,
+	// whichever way they are stored
+	backend.Reserve = 1
+	if err := backend.PutBucketConfig("reserve", services.PACK_CONFIG, []byte("<PackConfiguration><MaxObjectSize>1024</MaxObjectSize></PackConfiguration>")); err != nil {
+		t.Fatalf("Error in attempt to put pack configuration %v", err)
+	}
+	defer backend.DeleteBucketConfig("reserve", services.PACK_CONFIG)
+	declared := &sizedReader{Reader: strings.NewReader(TEST_OBJECT_CONTENT), size: disk.TotalBytes}
+	if err := backend.Put("reserve", "declared.txt", declared, &models.ObjectMetadata{}); !errors.Is(err, services.ErrInsufficientStorage) {
+		t.Errorf("Object larger than the free space is accepted %v", err)
+	}
+	if err := backend.PutPart("reserve", "multipart.txt", "upload", 2, declared); !errors.Is(err, services.ErrInsufficientStorage) {
+		t.Errorf("Part larger than the free space is accepted %v", err)
+	}
+	if err := backend.Put("reserve", "packed.txt", strings.NewReader(TEST_OBJECT_CONTENT), &models.ObjectMetadata{}); err != nil {
+		t.Errorf("Error in attempt to put object above reserve %v", err)
+	}
+	backend.Reserve = 0
+}
+
+func TestMetrics(t *testing.T) {
+	InitStorage(TEST_SERVED_LOCAL_FOLDER)
+	os.RemoveAll(TEST_SERVED_LOCAL_FOLDER + "/metered")
+	serveLocalFolder := &ServeLocalFolder{RootFolder: TEST_SERVED_LOCAL_FOLDER}
+	multiplexer := http.NewServeMux()
+	multiplexer.Handle("/", serveLocalFolder)
+	multiplexer.Handle("/metrics", serveLocalFolder.MetricsHandler())
+	server := httptest.NewServer(multiplexer)
+	// Close the server when test finishes
+	defer server.Close()
+
+	request, _ := http.NewRequest("PUT", server.URL+"/metered", nil)
+	if _, err := http.DefaultClient.Do(request); err != nil {
+		t.Fatalf("Error in attempt to create bucket %v", err)
+	}
+	for _, objectKey := range []string{"first.txt", "second.txt"} {
+		request, _ = http.NewRequest("PUT", server.URL+"/metered/"+objectKey, strings.NewReader(TEST_OBJECT_CONTENT))
+		response, err := http.DefaultClient.Do(request)
+		if err != nil || response.StatusCode != http.StatusOK {
+			t.Fatalf("Error in attempt to put object %s %v", objectKey, err)
+		}
+	}
+	response, err := http.Get(server.URL + "/metered/first.txt")
+	if err != nil || response.StatusCode != http.StatusOK {
+		t.Fatalf("Error in attempt to get object %v", err)
+	}
+	io.ReadAll(response.Body)
+	request, _ = http.NewRequest("DELETE", server.URL+"/metered/second.txt", nil)
+	if _, err := http.DefaultClient.Do(request); err != nil {
+		t.Fatalf("Error in attempt to delete object %v", err)
+	}
+	storage := services.NewStorage(TEST_SERVED_LOCAL_FOLDER)
+	if err := storage.CreateUpload("metered", "multipart.txt", "upload", &models.ObjectMetadata{}, nil); err != nil {
+		t.Fatalf("Error in attempt to create upload %v", err)
+	}
+
+	response, err = http.Get(server.URL + "/metrics")
+	if err != nil || response.StatusCode != http.StatusOK || !strings.HasPrefix(response.Header.Get("Content-Type"), "text/plain") {
+		t.Fatalf("Error in attempt to get metrics %v", err)
+	}
+	data, _ := io.ReadAll(response.Body)
+	metrics := string(data)
+	size := len(TEST_OBJECT_CONTENT)
+	for _, line := range []string{
+		"# TYPE s2d3_request_duration_seconds histogram",
+		"s2d3_request_duration_seconds_bucket{operation=\"GetObject\",code=\"200\",le=\"+Inf\"} ",
+		"s2d3_requests_total{operation=\"CreateBucket\",code=\"200\"} ",
+		"s2d3_requests_total{operation=\"DeleteObject\",code=\"204\"} ",
+		fmt.Sprintf("s2d3_bucket_received_bytes_total{bucket=\"metered\"} %d\n", 2*size),
+		fmt.Sprintf("s2d3_bucket_sent_bytes_total{bucket=\"metered\"} %d\n", size),
+		fmt.Sprintf("s2d3_bucket_usage_bytes{bucket=\"metered\"} %d\n", size),
+		"s2d3_bucket_objects{bucket=\"metered\"} 1\n",
+		"s2d3_multipart_uploads_in_flight{bucket=\"metered\"} 1\n",
+	} {
+		if !strings.Contains(metrics, line) {
+			t.Errorf("Metrics don't contain %q:\n%s", line, metrics)
+		}
+	}
+
+	// The usage is tracked after it is counted
+	request, _ = http.NewRequest("PUT", server.URL+"/metered/third.txt", strings.NewReader(TEST_OBJECT_CONTENT+TEST_OBJECT_CONTENT))
+	if _, err := http.DefaultClient.Do(request); err != nil {
+		t.Fatalf("Error in attempt to put object %v", err)
+	}
+	response, _ = http.Get(server.URL + "/metrics")
+	data, _ = io.ReadAll(response.Body)
+	if line := fmt.Sprintf("s2d3_bucket_usage_bytes{bucket=\"metered\"} %d\n", 3*size); !strings.Contains(string(data), line) {
+		t.Errorf("Metrics don't contain %q", line)
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	InitStorage(TEST_SERVED_LOCAL_FOLDER)
+	os.RemoveAll(TEST_SERVED_LOCAL_FOLDER + "/logged")
+	os.RemoveAll(TEST_SERVED_LOCAL_FOLDER + "/access-logs")
+	output := &bytes.Buffer{}
+	services.AccessLogOutput = output
+	defer func() { services.AccessLogOutput = os.Stdout }()
+	serveLocalFolder := &ServeLocalFolder{
+		RootFolder:      TEST_SERVED_LOCAL_FOLDER,
+		AccessLogFormat: services.ACCESS_LOG_JSON,
+	}
+	server := httptest.NewServer(serveLocalFolder)
+	// Close the server when test finishes
+	defer server.Close()
+
+	for _, bucketName := range []string{"logged", "access-logs"} {
+		request, _ := http.NewRequest("PUT", server.URL+"/"+bucketName, nil)
+		if _, err := http.DefaultClient.Do(request); err != nil {
+			t.Fatalf("Error in attempt to create bucket %v", err)
+		}
+	}
+	output.Reset()
+	request, _ := http.NewRequest("PUT", server.URL+"/logged/folder/object.txt", strings.NewReader(TEST_OBJECT_CONTENT))
+	request.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential=writer/20240101/us-east-1/s3/aws4_request, SignedHeaders=host, Signature=0")
+	if _, err := http.DefaultClient.Do(request); err != nil {
+		t.Fatalf("Error in attempt to put object %v", err)
+	}
+	response, err := http.Get(server.URL + "/missing?logging")
+	if err != nil || response.StatusCode != http.StatusNotFound {
+		t.Fatalf("Error in attempt to get logging of missing bucket %v", err)
+	}
+
+	entries := make([]map[string]any, 0)
+	for _, line := range strings.Split(strings.TrimSpace(output.String()), "\n") {
+		entry := map[string]any{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("Wrong access log line %s", line)
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Wrong access log %s", output)
+	}
+	put, failed := entries[0], entries[1]
+	if put["operation"] != "PutObject" || put["bucket"] != "logged" || put["key"] != "folder/object.txt" || put["status"] != float64(200) ||
+		put["bytes_received"] != float64(len(TEST_OBJECT_CONTENT)) || put["access_key"] != "writer" || put["remote_ip"] != "127.0.0.1" || len(put["request_id"].(string)) != 16 {
+		t.Errorf("Wrong access log of put %v", put)
+	}
+	if failed["operation"] != "GetBucketLogging" || failed["status"] != float64(404) || failed["error_code"] != "NoSuchBucket" {
+		t.Errorf("Wrong access log of failed request %v", failed)
+	}
+
+	request, _ = http.NewRequest("PUT", server.URL+"/logged?logging", strings.NewReader("<BucketLoggingStatus><LoggingEnabled><TargetBucket>missing</TargetBucket></LoggingEnabled></BucketLoggingStatus>"))
+	response, err = http.DefaultClient.Do(request)
+	if err != nil || response.StatusCode != http.StatusBadRequest {
+		t.Errorf("Logging into missing bucket is accepted %v", err)
+	}
+	// The caller not allowed to write into the target bucket can't log into it
+	storage := services.NewStorage(TEST_SERVED_LOCAL_FOLDER)
+	storage.PutBucketPolicy("access-logs", &models.BucketPolicy{Statement: []models.PolicyStatement{{
+		Effect:    models.PolicyEffectDeny,
+		Principal: models.PolicyPrincipal{AWS: models.PolicyValues{"*"}},
+		Action:    models.PolicyValues{"s3:PutObject"},
+		Resource:  models.PolicyValues{"arn:aws:s3:::access-logs/*"},
+	}}})
+	request, _ = http.NewRequest("PUT", server.URL+"/logged?logging", strings.NewReader("<BucketLoggingStatus><LoggingEnabled><TargetBucket>access-logs</TargetBucket><TargetPrefix>logged/</TargetPrefix></LoggingEnabled></BucketLoggingStatus>"))
+	response, err = http.DefaultClient.Do(request)
+	if err != nil || response.StatusCode != http.StatusForbidden {
+		t.Errorf("Logging into forbidden bucket is accepted %v", err)
+	}
+	storage.DeleteBucketPolicy("access-logs")
+	request, _ = http.NewRequest("PUT", server.URL+"/logged?logging", strings.NewReader("<BucketLoggingStatus><LoggingEnabled><TargetBucket>access-logs</TargetBucket><TargetPrefix>logged/</TargetPrefix></LoggingEnabled></BucketLoggingStatus>"))
+	response, err = http.DefaultClient.Do(request)
+	if err != nil || response.StatusCode != http.StatusOK {
+		t.Fatalf("Error in attempt to put logging %v", err)
+	}
+	response, err = http.Get(server.URL + "/logged?logging")
+	if err != nil || response.StatusCode != http.StatusOK {
+		t.Fatalf("Error in attempt to get logging %v", err)
+	}
+	status := models.BucketLoggingStatus{}
+	data, _ := io.ReadAll(response.Body)
+	if err := xml.Unmarshal(data, &status); err != nil || status.LoggingEnabled == nil || status.LoggingEnabled.TargetBucket != "access-logs" {
+		t.Errorf("Wrong logging status %s", data)
+	}
+
+	response, err = http.Get(server.URL + "/logged/folder/object.txt")
+	if err != nil || response.StatusCode != http.StatusOK {
+		t.Fatalf("Error in attempt to get object %v", err)
+	}
+	io.ReadAll(response.Body)
+	if err := services.DeliverAccessLogs(time.Now()); err != nil {
+		t.Fatalf("Error in attempt to deliver access logs %v", err)
+	}
+	versions, _ := storage.List("access-logs")
+	if len(versions) != 1 || !strings.HasPrefix(versions[0].Key, "logged/") {
+		t.Fatalf("Wrong delivered access logs %v", versions)
+	}
+	data, _ = storage.GetData("access-logs", versions[0].Key, "")
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	// The logging PUT and GET and the object GET are delivered
+	if len(lines) != 3 || !strings.Contains(lines[2], " logged [") || !strings.Contains(lines[2], " REST.GET.OBJECT folder/object.txt \"GET /logged/folder/object.txt HTTP/1.1\" 200 - 4 - ") {
+		t.Errorf("Wrong delivered access log %s", data)
+	}
+
+	request, _ = http.NewRequest("PUT", server.URL+"/logged?logging", strings.NewReader("<BucketLoggingStatus/>"))
+	response, err = http.DefaultClient.Do(request)
+	if err != nil || response.StatusCode != http.StatusOK {
+		t.Fatalf("Error in attempt to turn logging off %v", err)
+	}
+	http.Get(server.URL + "/logged/folder/object.txt")
+	services.DeliverAccessLogs(time.Now())
+	if versions, _ := storage.List("access-logs"); len(versions) != 1 {
+		t.Errorf("Access log is delivered after logging is off %v", versions)
+	}
+}
+
+func TestRequestIds(t *testing.T) {
+	InitStorage(TEST_SERVED_LOCAL_FOLDER)
+	os.RemoveAll(TEST_SERVED_LOCAL_FOLDER + "/identified")
+	output := &bytes.Buffer{}
+	services.AccessLogOutput = output
+	defer func() { services.AccessLogOutput = os.Stdout }()
+	server := httptest.NewServer(&ServeLocalFolder{
+		RootFolder:      TEST_SERVED_LOCAL_FOLDER,
+		ServerAddr:      "s2d3.test",
+		AccessLogFormat: services.ACCESS_LOG_S3,
+	})
+	// Close the server when test finishes
+	defer server.Close()
+	parsedUrl, _ := url.Parse(server.URL)
+
+	response, err := http.Get(server.URL + "/missing?logging")
+	if err != nil || response.StatusCode != http.StatusNotFound {
+		t.Fatalf("Error in attempt to get logging of missing bucket %v", err)
+	}
+	requestId, hostId := response.Header.Get("x-amz-request-id"), response.Header.Get("x-amz-id-2")
+	if len(requestId) != 16 || hostId == "" {
+		t.Fatalf("Wrong request ids %s %s", requestId, hostId)
+	}
+	payload := models.Error{}
+	data, _ := io.ReadAll(response.Body)
+	if err := xml.Unmarshal(data, &payload); err != nil || payload.RequestId != requestId || payload.HostId != hostId {
+		t.Errorf("Wrong request ids of error %s", data)
+	}
+	if line := output.String(); !strings.Contains(line, " "+requestId+" REST.GET.LOGGING_STATUS ") || !strings.Contains(line, " "+hostId+" ") {
+		t.Errorf("Wrong request ids in access log %s", line)
+	}
+
+	request, _ := http.NewRequest("PUT", server.URL+"/identified", nil)
+	response, err = http.DefaultClient.Do(request)
+	if err != nil || response.StatusCode != http.StatusOK || response.Header.Get("x-amz-request-id") == "" || response.Header.Get("x-amz-request-id") == requestId {
+		t.Errorf("Wrong request id of successful request %v", err)
+	}
+
+	s3Client, err := client.NewClient(&client.Client{
+		Domain:         parsedUrl.Host,
+		Protocol:       "http",
+		Bucket:         "missing",
+		UsePathBuckets: true,
+	})
+	if err != nil {
+		t.Fatalf("Error in attempt to create new client %s", err)
+	}
+	_, err = s3Client.List()
+	requestError := &client.RequestError{}
+	if !errors.As(err, &requestError) || requestError.StatusCode != http.StatusNotFound || requestError.Code != "NoSuchBucket" || len(requestError.RequestId) != 16 || !strings.Contains(err.Error(), requestError.RequestId) {
+		t.Errorf("Wrong client error %v", err)
+	}
+}
+
+func TestStatisticsApi(t *testing.T) {
+	InitStorage(TEST_SERVED_LOCAL_FOLDER)
+	os.RemoveAll(TEST_SERVED_LOCAL_FOLDER + "/charted")
+	serveLocalFolder := &ServeLocalFolder{RootFolder: TEST_SERVED_LOCAL_FOLDER, AdminToken: "admin-token"}
+	multiplexer := http.NewServeMux()
+	multiplexer.Handle("/", serveLocalFolder)
+	multiplexer.Handle(services.ADMIN_PATH, serveLocalFolder.AdminHandler())
+	server := httptest.NewServer(multiplexer)
+	// Close the server when test finishes
+	defer server.Close()
+
+	send := func(method string, path string, body io.Reader) {
+		request, _ := http.NewRequest(method, server.URL+path, body)
+		request.Header.Set("Authorization", "AWS CHARTEDACCESSKEY:signature")
+		response, err := http.DefaultClient.Do(request)
+		if err != nil || response.StatusCode >= http.StatusBadRequest {
+			t.Fatalf("Error in attempt to %s %s %v", method, path, err)
+		}
+		io.ReadAll(response.Body)
+	}
+	get := func(path string) (*http.Response, error) {
+		request, _ := http.NewRequest("GET", server.URL+services.STATISTICS_API_PATH+path, nil)
+		request.Header.Set("Authorization", "Bearer admin-token")
+		return http.DefaultClient.Do(request)
+	}
+	send("PUT", "/charted", nil)
+	charted := func() models.BucketStatistics {
+		buckets := []models.BucketStatistics{}
+		response, err := get("buckets")
+		if err != nil || response.StatusCode != http.StatusOK {
+			t.Fatalf("Error in attempt to get buckets statistics %v", err)
+		}
+		json.NewDecoder(response.Body).Decode(&buckets)
+		index := slices.IndexFunc(buckets, func(bucket models.BucketStatistics) bool { return bucket.Name == "charted" })
+		if index < 0 {
+			t.Fatalf("Statistics don't contain the bucket %v", buckets)
+		}
+		return buckets[index]
+	}
+	// The usage of the bucket may be counted before it is removed by the
+	// previous tests, the changes are checked
+	before := charted()
+	send("PUT", "/charted/popular.txt", strings.NewReader(TEST_OBJECT_CONTENT))
+	send("PUT", "/charted/rare.txt", strings.NewReader(TEST_OBJECT_CONTENT))
+	for i := 0; i < 3; i++ {
+		send("GET", "/charted/popular.txt", nil)
+	}
+
+	statistics := models.Statistics{}
+	response, err := get("?top=1000")
+	if err != nil || response.StatusCode != http.StatusOK || response.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("Error in attempt to get statistics %v", err)
+	}
+	if err := json.NewDecoder(response.Body).Decode(&statistics); err != nil {
+		t.Fatalf("Error in attempt to decode statistics %v", err)
+	}
+
+	if !slices.ContainsFunc(statistics.Buckets, func(bucket models.BucketStatistics) bool { return bucket.Name == "charted" }) {
+		t.Errorf("Statistics don't contain the bucket %v", statistics.Buckets)
+	}
+	size := int64(len(TEST_OBJECT_CONTENT))
+	if bucket := charted(); bucket.Objects-before.Objects != 2 || bucket.Bytes-before.Bytes != 2*size || bucket.ReceivedBytes != 2*size || bucket.SentBytes != 3*size {
+		t.Errorf("Wrong statistics of the bucket %+v", bucket)
+	}
+
+	samples := statistics.Requests.Samples
+	if len(samples) != services.STATISTICS_RATE_SAMPLES || statistics.Requests.IntervalSeconds != 60 || samples[len(samples)-2].Requests+samples[len(samples)-1].Requests < 6 {
+		t.Errorf("Wrong request rates %+v", statistics.Requests)
+	}
+
+	popular := slices.IndexFunc(statistics.TopKeys, func(key models.KeyStatistics) bool { return key.Bucket == "charted" && key.Key == "popular.txt" })
+	rare := slices.IndexFunc(statistics.TopKeys, func(key models.KeyStatistics) bool { return key.Bucket == "charted" && key.Key == "rare.txt" })
+	if popular < 0 || rare < popular || statistics.TopKeys[popular].Requests != 4 || statistics.TopKeys[popular].SentBytes != 3*size {
+		t.Errorf("Wrong top keys %+v", statistics.TopKeys)
+	}
+
+	accessKeys := []models.AccessKeyStatistics{}
+	response, err = get("access-keys")
+	if err != nil || response.StatusCode != http.StatusOK {
+		t.Fatalf("Error in attempt to get access keys %v", err)
+	}
+	json.NewDecoder(response.Body).Decode(&accessKeys)
+	if len(accessKeys) == 0 || accessKeys[0].AccessKey != "CHARTEDACCESSKEY" || accessKeys[0].Requests != 6 {
+		t.Errorf("Wrong access keys %+v", accessKeys)
+	}
+
+	keys := []models.KeyStatistics{}
+	response, _ = get("keys?top=1")
+	json.NewDecoder(response.Body).Decode(&keys)
+	if len(keys) != 1 {
+		t.Errorf("Wrong count of top keys %+v", keys)
+	}
+
+	response, _ = get("unknown")
+	if response.StatusCode != http.StatusNotFound {
+		t.Errorf("Wrong status of unknown statistics %d", response.StatusCode)
+	}
+	// The statistics are not served without the admin token
+	response, _ = http.Get(server.URL + services.STATISTICS_API_PATH + "access-keys")
+	if response.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Statistics are served without the admin token %d", response.StatusCode)
+	}
+}
+
+func TestAdminNamespace(t *testing.T) {
+	InitStorage(TEST_SERVED_LOCAL_FOLDER)
+	statisticsApplicationFolder := t.TempDir()
+	os.WriteFile(statisticsApplicationFolder+"/index.html", []byte("<app/>"), 0644)
+	os.WriteFile(statisticsApplicationFolder+"/app.js", []byte("app()"), 0644)
+	os.RemoveAll(TEST_SERVED_LOCAL_FOLDER + "/namespaced")
+	serveLocalFolder := &ServeLocalFolder{RootFolder: TEST_SERVED_LOCAL_FOLDER, StatisticsApplicationFolder: statisticsApplicationFolder, AdminToken: "admin-token"}
+	multiplexer := http.NewServeMux()
+	multiplexer.Handle("/", serveLocalFolder)
+	multiplexer.Handle(services.ADMIN_PATH, serveLocalFolder.AdminHandler())
+	server := httptest.NewServer(multiplexer)
+	// Close the server when test finishes
+	defer server.Close()
+
+	request, _ := http.NewRequest("PUT", server.URL+"/namespaced", nil)
+	if _, err := http.DefaultClient.Do(request); err != nil {
+		t.Fatalf("Error in attempt to create bucket %v", err)
+	}
+	for _, objectKey := range []string{"index.html", "app.js"} {
+		response, err := http.Get(server.URL + "/namespaced/" + objectKey)
+		if err != nil || response.StatusCode != http.StatusNotFound {
+			t.Fatalf("Error in attempt to get missing object %s %v", objectKey, err)
+		}
+		payload := models.Error{}
+		data, _ := io.ReadAll(response.Body)
+		if err := xml.Unmarshal(data, &payload); err != nil || payload.Code != "NoSuchKey" {
+			t.Errorf("Missing object %s is not reported by the S3 error %s", objectKey, data)
+		}
+	}
+
+	for path, content := range map[string]string{
+		services.ADMIN_PATH:                 "<app/>",
+		services.ADMIN_PATH + "app.js":      "app()",
+		services.ADMIN_PATH + "access-keys": "<app/>",
+	} {
+		response, err := http.Get(server.URL + path)
+		if err != nil || response.StatusCode != http.StatusOK {
+			t.Fatalf("Error in attempt to get dashboard %s %v", path, err)
+		}
+		if data, _ := io.ReadAll(response.Body); string(data) != content {
+			t.Errorf("Wrong dashboard %s %s", path, data)
+		}
+	}
+	request, _ = http.NewRequest("GET", server.URL+services.STATISTICS_API_PATH+"requests", nil)
+	request.Header.Set("Authorization", "Bearer admin-token")
+	response, err := http.DefaultClient.Do(request)
+	if err != nil || response.StatusCode != http.StatusOK || response.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("Error in attempt to get statistics %v", err)
+	}
+
+	// The S3 listener without the admin namespace answers it by the S3 errors
+	s3Server := httptest.NewServer(WithContextDecorator(services.ApiRouter, TEST_SERVED_LOCAL_FOLDER, ""))
+	defer s3Server.Close()
+	for _, path := range []string{"/missing/index.html", services.STATISTICS_API_PATH + "buckets", services.ADMIN_PATH} {
+		response, err := http.Get(s3Server.URL + path)
+		if err != nil || response.StatusCode != http.StatusNotFound {
+			t.Fatalf("Error in attempt to get %s %v", path, err)
+		}
+		payload := models.Error{}
+		data, _ := io.ReadAll(response.Body)
+		if err := xml.Unmarshal(data, &payload); err != nil || payload.Code == "" {
+			t.Errorf("%s is not reported by the S3 error %s", path, data)
+		}
+	}
+}
+
+func TestObjectBrowser(t *testing.T) {
+	InitStorage(TEST_SERVED_LOCAL_FOLDER)
+	os.RemoveAll(TEST_SERVED_LOCAL_FOLDER + "/browsed")
+	serveLocalFolder := &ServeLocalFolder{RootFolder: TEST_SERVED_LOCAL_FOLDER}
+	multiplexer := http.NewServeMux()
+	multiplexer.Handle("/", serveLocalFolder)
+	multiplexer.Handle(services.ADMIN_PATH, serveLocalFolder.AdminHandler())
+	server := httptest.NewServer(multiplexer)
+	// Close the server when test finishes
+	defer server.Close()
+	browser := server.URL + services.BROWSER_API_PATH
+
+	browse := func(method string, url string, body string, status int, result any) {
+		request, _ := http.NewRequest(method, url, strings.NewReader(body))
+		if strings.HasSuffix(url, "?uploads") {
+			request.Header.Set("Content-Type", "text/plain")
+		}
+		response, err := http.DefaultClient.Do(request)
+		if err != nil || response.StatusCode != status {
+			t.Fatalf("Error in attempt to %s %s %v", method, url, err)
+		}
+		if result != nil {
+			if err := json.NewDecoder(response.Body).Decode(result); err != nil {
+				t.Fatalf("Error in attempt to decode %s %v", url, err)
+			}
+		}
+	}
+
+	request, _ := http.NewRequest("PUT", server.URL+"/browsed", nil)
+	if _, err := http.DefaultClient.Do(request); err != nil {
+		t.Fatalf("Error in attempt to create bucket %v", err)
+	}
+	buckets := []models.BrowserBucket{}
+	browse("GET", browser, "", http.StatusOK, &buckets)
+	if !slices.ContainsFunc(buckets, func(bucket models.BrowserBucket) bool { return bucket.Name == "browsed" }) {
+		t.Errorf("Browser doesn't list the bucket %v", buckets)
+	}
+
+	// The dropped file is uploaded by the multipart upload
+	upload := models.BrowserUpload{}
+	browse("POST", browser+"browsed/folder/dropped.txt?uploads", "", http.StatusOK, &upload)
+	if upload.Bucket != "browsed" || upload.Key != "folder/dropped.txt" || upload.UploadId == "" {
+		t.Fatalf("Wrong upload %v", upload)
+	}
+	parts := make([]models.BrowserPart, 2)
+	for i, content := range []string{TEST_OBJECT_CONTENT, "Content"} {
+		browse("PUT", fmt.Sprintf("%sbrowsed/folder/dropped.txt?uploadId=%s&partNumber=%d", browser, upload.UploadId, i+1), content, http.StatusOK, &parts[i])
+	}
+	completion, _ := json.Marshal(models.BrowserCompletion{Parts: parts})
+	object := models.BrowserObject{}
+	browse("POST", browser+"browsed/folder/dropped.txt?uploadId="+upload.UploadId, string(completion), http.StatusOK, &object)
+	if object.Key != "folder/dropped.txt" || object.Size != int64(len(TEST_OBJECT_CONTENT+"Content")) || object.ContentType != "text/plain" {
+		t.Errorf("Wrong uploaded object %v", object)
+	}
+	browse("POST", browser+"browsed/aborted.txt?uploads", "", http.StatusOK, &upload)
+	browse("DELETE", browser+"browsed/aborted.txt?uploadId="+upload.UploadId, "", http.StatusNoContent, nil)
+
+	request, _ = http.NewRequest("PUT", server.URL+"/browsed/top.txt", strings.NewReader(TEST_OBJECT_CONTENT))
+	if _, err := http.DefaultClient.Do(request); err != nil {
+		t.Fatalf("Error in attempt to put object %v", err)
+	}
+	listing := models.BrowserListing{}
+	browse("GET", browser+"browsed", "", http.StatusOK, &listing)
+	if len(listing.Prefixes) != 1 || listing.Prefixes[0] != "folder/" || len(listing.Objects) != 1 || listing.Objects[0].Key != "top.txt" {
+		t.Errorf("Wrong listing of the bucket %v", listing)
+	}
+	browse("GET", browser+"browsed?prefix=folder/", "", http.StatusOK, &listing)
+	if len(listing.Prefixes) != 0 || len(listing.Objects) != 1 || listing.Objects[0].Key != "folder/dropped.txt" {
+		t.Errorf("Wrong listing of the folder %v", listing)
+	}
+
+	response, err := http.Get(browser + "browsed/folder/dropped.txt?download")
+	if err != nil || response.StatusCode != http.StatusOK || response.Header.Get("Content-Disposition") != "attachment; filename=dropped.txt" {
+		t.Fatalf("Error in attempt to download object %v", err)
+	}
+	if data, _ := io.ReadAll(response.Body); string(data) != TEST_OBJECT_CONTENT+"Content" {
+		t.Errorf("Wrong downloaded content %s", data)
+	}
+
+	browse("PUT", browser+"browsed/top.txt?tags", `{"color":"blue"}`, http.StatusNoContent, nil)
+	browse("GET", browser+"browsed/top.txt?metadata", "", http.StatusOK, &object)
+	if object.Tags["color"] != "blue" || object.Size != int64(len(TEST_OBJECT_CONTENT)) {
+		t.Errorf("Wrong metadata of the object %v", object)
+	}
+	browse("PUT", browser+"browsed/top.txt?tags", `{"":"blue"}`, http.StatusBadRequest, nil)
+
+	browse("DELETE", browser+"browsed/top.txt", "", http.StatusNoContent, nil)
+	failure := map[string]string{}
+	browse("GET", browser+"browsed/top.txt?metadata", "", http.StatusNotFound, &failure)
+	if failure["error"] == "" {
+		t.Errorf("Wrong error of the missing object %v", failure)
+	}
+	browse("GET", browser+"missing", "", http.StatusNotFound, nil)
+	browse("DELETE", browser+"browsed", "", http.StatusMethodNotAllowed, nil)
+}
+
+func TestAdminApi(t *testing.T) {
+	InitStorage(TEST_SERVED_LOCAL_FOLDER)
+	os.RemoveAll(TEST_SERVED_LOCAL_FOLDER + "/administered")
+	os.RemoveAll(TEST_SERVED_LOCAL_FOLDER + "/dropped")
+	accessKeysRecord := TEST_SERVED_LOCAL_FOLDER + "/" + services.SYSTEM_FOLDER + "/" + services.ACCESS_KEYS_RECORD
+	os.Remove(accessKeysRecord)
+	// The other tests use the access keys which are not managed
+	defer os.Remove(accessKeysRecord)
+	serveLocalFolder := &ServeLocalFolder{RootFolder: TEST_SERVED_LOCAL_FOLDER, AdminToken: "admin-token"}
+	multiplexer := http.NewServeMux()
+	multiplexer.Handle("/", serveLocalFolder)
+	multiplexer.Handle(services.ADMIN_PATH, serveLocalFolder.AdminHandler())
+	server := httptest.NewServer(multiplexer)
+	// Close the server when test finishes
+	defer server.Close()
+	admin := client.NewAdminClient(server.URL, "admin-token")
+
+	s3 := func(method string, path string, accessKey *models.AccessKey, body string, status int, code string) {
+		request, _ := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		if accessKey != nil {
+			signRequest(request, accessKey)
+		}
+		response, err := http.DefaultClient.Do(request)
+		if err != nil || response.StatusCode != status {
+			t.Fatalf("Error in attempt to %s %s %v", method, path, err)
+		}
+		payload := models.Error{}
+		data, _ := io.ReadAll(response.Body)
+		xml.Unmarshal(data, &payload)
+		if payload.Code != code {
+			t.Errorf("Wrong error code of %s %s: %s", method, path, payload.Code)
+		}
+	}
+	statusOf := func(err error) int {
+		adminError := &client.AdminError{}
+		if !errors.As(err, &adminError) {
+			t.Fatalf("Wrong admin error %v", err)
+		}
+		return adminError.StatusCode
+	}
+
+	if _, err := client.NewAdminClient(server.URL, "wrong").Info(); statusOf(err) != http.StatusUnauthorized {
+		t.Errorf("Admin api accepts the wrong token %v", err)
+	}
+	if err := admin.CreateBucket("administered"); err != nil {
+		t.Fatalf("Error in attempt to create bucket %v", err)
+	}
+	if err := admin.CreateBucket("administered"); statusOf(err) != http.StatusConflict {
+		t.Errorf("Existing bucket is created again %v", err)
+	}
+	info, err := admin.Info()
+	if err != nil || info.Version != services.Version || info.Buckets == 0 || info.DataFolder != TEST_SERVED_LOCAL_FOLDER {
+		t.Errorf("Wrong server info %v %v", info, err)
+	}
+	buckets, err := admin.ListBuckets()
+	if err != nil || !slices.ContainsFunc(buckets, func(bucket models.BucketStatistics) bool { return bucket.Name == "administered" }) {
+		t.Errorf("Admin api doesn't list the bucket %v %v", buckets, err)
+	}
+
+	// The access keys are refused once they are revoked
+	accessKey, err := admin.CreateAccessKey("ci")
+	if err != nil || len(accessKey.AccessKeyId) != 20 || accessKey.SecretAccessKey == "" || accessKey.Description != "ci" {
+		t.Fatalf("Wrong created access key %v %v", accessKey, err)
+	}
+	accessKeys, err := admin.ListAccessKeys()
+	if err != nil || len(accessKeys) != 1 || accessKeys[0].AccessKeyId != accessKey.AccessKeyId || accessKeys[0].SecretAccessKey != "" {
+		t.Errorf("Wrong listed access keys %v %v", accessKeys, err)
+	}
+	// The signature version 4 binds the body by its signed hash and the host
+	signedHash := fmt.Sprintf("%x", sha256.Sum256([]byte(TEST_OBJECT_CONTENT)))
+	request, _ := http.NewRequest("PUT", server.URL+"/administered/signed", strings.NewReader(TEST_OBJECT_CONTENT))
+	signRequestV4(request, accessKey, signedHash)
+	if response, err := http.DefaultClient.Do(request); err != nil || response.StatusCode != http.StatusOK {
+		t.Fatalf("Error in attempt to put the object signed by the signature version 4 %v", err)
+	}
+	request, _ = http.NewRequest("PUT", server.URL+"/administered/replayed", strings.NewReader("Replayed"))
+	signRequestV4(request, accessKey, signedHash)
+	response, err := http.DefaultClient.Do(request)
+	if err != nil || response.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Object is put with the body of the other hash %v", err)
+	}
+	if payload, _ := io.ReadAll(response.Body); !strings.Contains(string(payload), services.CodeXAmzContentSHA256Mismatch) {
+		t.Errorf("Wrong error of the body of the other hash %s", payload)
+	}
+	request, _ = http.NewRequest("GET", server.URL+"/administered/replayed", nil)
+	signRequestV4(request, accessKey, services.UNSIGNED_PAYLOAD)
+	if response, err := http.DefaultClient.Do(request); err != nil || response.StatusCode != http.StatusNotFound {
+		t.Errorf("Object of the body of the other hash is stored %v", err)
+	}
+	request, _ = http.NewRequest("GET", server.URL+"/administered/signed", nil)
+	signRequestV4(request, accessKey, services.UNSIGNED_PAYLOAD)
+	request.Header.Set("Authorization", strings.Replace(request.Header.Get("Authorization"), "SignedHeaders=host;", "SignedHeaders=", 1))
+	if response, err := http.DefaultClient.Do(request); err != nil || response.StatusCode == http.StatusOK {
+		t.Errorf("Request is accepted without the signed host %v", err)
+	}
+	s3("DELETE", "/administered/signed", accessKey, "", http.StatusNoContent, "")
+
+	// The secrets are kept readable by the server user only and they are
+	// never served as the objects
+	if info, err := os.Stat(accessKeysRecord); err != nil || info.Mode().Perm() != services.RECORD_FILE_MODE {
+		t.Errorf("Wrong mode of the access keys record %v", err)
+	}
+	for _, path := range []string{"/administered/../.s2d3/access-keys.json", "/administered/%2e%2e/.s2d3/access-keys.json"} {
+		request, _ := http.NewRequest("GET", server.URL+path, nil)
+		signRequest(request, accessKey)
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatalf("Error in attempt to get %s %v", path, err)
+		}
+		data, _ := io.ReadAll(response.Body)
+		if response.StatusCode == http.StatusOK || strings.Contains(string(data), accessKey.SecretAccessKey) {
+			t.Errorf("Access keys record is served by %s %d", path, response.StatusCode)
+		}
+	}
+	s3("PUT", "/administered/object", accessKey, TEST_OBJECT_CONTENT, http.StatusOK, "")
+	s3("GET", "/administered/object", &models.AccessKey{AccessKeyId: "UNKNOWNACCESSKEY", SecretAccessKey: "secret"}, "", http.StatusForbidden, services.CodeInvalidAccessKeyId)
+	// The managed access keys are verified by their secrets and the
+	// anonymous requests are refused unless the policy allows them
+	s3("GET", "/administered/object", &models.AccessKey{AccessKeyId: accessKey.AccessKeyId, SecretAccessKey: "forged"}, "", http.StatusForbidden, services.CodeSignatureDoesNotMatch)
+	s3("GET", "/administered/object", nil, "", http.StatusForbidden, services.CodeAccessDenied)
+	writer, err := admin.CreateAccessKey("writer")
+	if err != nil {
+		t.Fatalf("Error in attempt to create access key %v", err)
+	}
+	if err := admin.RevokeAccessKey(accessKey.AccessKeyId); err != nil {
+		t.Fatalf("Error in attempt to revoke access key %v", err)
+	}
+	s3("GET", "/administered/object", accessKey, "", http.StatusForbidden, services.CodeInvalidAccessKeyId)
+	s3("GET", "/administered/object", writer, "", http.StatusOK, "")
+	// The object browser is used by the admin token
+	for token, status := range map[string]int{"": http.StatusForbidden, "admin-token": http.StatusOK} {
+		request, _ := http.NewRequest("GET", server.URL+services.BROWSER_API_PATH+"administered", nil)
+		if token != "" {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+		response, err := http.DefaultClient.Do(request)
+		if err != nil || response.StatusCode != status {
+			t.Errorf("Wrong status of the browser request by the token %q %v", token, err)
+		}
+	}
+	if err := admin.RevokeAccessKey("missing"); statusOf(err) != http.StatusNotFound {
+		t.Errorf("Missing access key is revoked %v", err)
+	}
+
+	if err := admin.PutQuota("administered", &models.QuotaConfiguration{MaxObjects: 1}); err != nil {
+		t.Fatalf("Error in attempt to put quota %v", err)
+	}
+	quota, err := admin.GetQuota("administered")
+	if err != nil || quota.MaxObjects != 1 || quota.Usage == nil || quota.Usage.Objects != 1 {
+		t.Errorf("Wrong quota %v %v", quota, err)
+	}
+	s3("PUT", "/administered/other", writer, TEST_OBJECT_CONTENT, http.StatusForbidden, "QuotaExceeded")
+	if err := admin.PutQuota("administered", &models.QuotaConfiguration{MaxBytes: -1}); statusOf(err) != http.StatusBadRequest {
+		t.Errorf("Negative quota is accepted %v", err)
+	}
+	if err := admin.DeleteQuota("administered"); err != nil {
+		t.Fatalf("Error in attempt to delete quota %v", err)
+	}
+	if _, err := admin.GetQuota("administered"); statusOf(err) != http.StatusNotFound {
+		t.Errorf("Deleted quota is found %v", err)
+	}
+
+	// The policy denies the reads of the objects and allows the rest
+	policy := &models.BucketPolicy{Statement: []models.PolicyStatement{{
+		Effect:    models.PolicyEffectAllow,
+		Principal: models.PolicyPrincipal{AWS: models.PolicyValues{"*"}},
+		Action:    models.PolicyValues{"s3:*"},
+		Resource:  models.PolicyValues{"arn:aws:s3:::administered", "arn:aws:s3:::administered/*"},
+	}, {
+		Effect:    models.PolicyEffectDeny,
+		Principal: models.PolicyPrincipal{AWS: models.PolicyValues{"*"}},
+		Action:    models.PolicyValues{"s3:GetObject"},
+		Resource:  models.PolicyValues{"arn:aws:s3:::administered/*"},
+	}}}
+	if err := admin.PutPolicy("administered", policy); err != nil {
+		t.Fatalf("Error in attempt to put policy %v", err)
+	}
+	s3("GET", "/administered/object", writer, "", http.StatusForbidden, services.CodeAccessDenied)
+	s3("HEAD", "/administered/object", nil, "", http.StatusForbidden, "")
+	s3("PUT", "/administered/other", nil, TEST_OBJECT_CONTENT, http.StatusOK, "")
+	response, err = http.Get(server.URL + "/administered?policy")
+	if data, _ := io.ReadAll(response.Body); err != nil || !strings.Contains(string(data), `"Principal":"*"`) {
+		t.Errorf("Wrong bucket policy %s %v", data, err)
+	}
+	stored, err := admin.GetPolicy("administered")
+	if err != nil || len(stored.Statement) != 2 || stored.Statement[1].Effect != models.PolicyEffectDeny {
+		t.Errorf("Wrong stored policy %v %v", stored, err)
+	}
+	policy.Statement[0].Resource = models.PolicyValues{"arn:aws:s3:::other/*"}
+	if err := admin.PutPolicy("administered", policy); statusOf(err) != http.StatusBadRequest {
+		t.Errorf("Policy of the other bucket is accepted %v", err)
+	}
+	s3("PUT", "/administered?policy", nil, `{"Statement":[{"Effect":"Maybe"}]}`, http.StatusBadRequest, services.CodeMalformedPolicy)
+	if err := admin.DeletePolicy("administered"); err != nil {
+		t.Fatalf("Error in attempt to delete policy %v", err)
+	}
+	s3("GET", "/administered/object", writer, "", http.StatusOK, "")
+	s3("GET", "/administered/object", nil, "", http.StatusForbidden, services.CodeAccessDenied)
+	s3("GET", "/administered?policy", writer, "", http.StatusNotFound, services.CodeNoSuchBucketPolicy)
+
+	// The scrub is run in the background, its job is checked
+	job, err := admin.StartScrub("administered")
+	if err != nil || job.Id == "" || job.Bucket != "administered" || job.Status != models.ScrubStatusRunning {
+		t.Fatalf("Wrong scrub job %v %v", job, err)
+	}
+	if _, err := admin.ScrubJob("missing"); statusOf(err) != http.StatusNotFound {
+		t.Errorf("Missing scrub job is found %v", err)
+	}
+	result, err := admin.Scrub("administered")
+	if err != nil || result.Buckets != 1 || result.Objects != 2 || result.Bytes != int64(2*len(TEST_OBJECT_CONTENT)) || len(result.Corrupted) != 0 {
+		t.Errorf("Wrong scrub result %v %v", result, err)
+	}
+	if job, err = admin.ScrubJob(job.Id); err != nil || job.Status != models.ScrubStatusDone || job.Result == nil || job.EndTime == nil {
+		t.Errorf("Wrong finished scrub job %v %v", job, err)
+	}
+	if _, err := admin.Scrub("missing"); statusOf(err) != http.StatusNotFound {
+		t.Errorf("Missing bucket is scrubbed %v", err)
+	}
+	if err := admin.RunLifecycle(true); err != nil {
+		t.Errorf("Error in attempt to run lifecycle %v", err)
+	}
+
+	// The buckets are deleted once they are empty
+	if err := admin.DeleteBucket("administered"); statusOf(err) != http.StatusConflict {
+		t.Errorf("Bucket with objects is deleted %v", err)
+	}
+	s3("DELETE", "/administered/object", writer, "", http.StatusNoContent, "")
+	s3("DELETE", "/administered/other", writer, "", http.StatusNoContent, "")
+	if err := admin.DeleteBucket("administered"); err != nil {
+		t.Errorf("Error in attempt to delete bucket %v", err)
+	}
+	if _, err := os.Stat(TEST_SERVED_LOCAL_FOLDER + "/administered"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Deleted bucket is kept %v", err)
+	}
+	s3("PUT", "/dropped", writer, "", http.StatusOK, "")
+	s3("DELETE", "/dropped", writer, "", http.StatusNoContent, "")
+	s3("DELETE", "/dropped", writer, "", http.StatusNotFound, services.CodeNoSuchBucket)
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	InitStorage(TEST_SERVED_LOCAL_FOLDER)
+	mountedFolder := t.TempDir()
+	mount, err := ParseMount("mounted=" + mountedFolder + ":ro")
+	if err != nil {
+		t.Fatalf("Error in attempt to parse mount %v", err)
+	}
+	backend, err := NewBackend(TEST_SERVED_LOCAL_FOLDER, []services.Mount{mount}, 0)
+	if err != nil {
+		t.Fatalf("Error in attempt to create backend %v", err)
+	}
+	serveLocalFolder := &ServeLocalFolder{
+		RootFolder: TEST_SERVED_LOCAL_FOLDER,
+		Backend:    NewIndexedBackend(TEST_SERVED_LOCAL_FOLDER, backend),
+		AdminToken: "admin-token",
+	}
+	multiplexer := http.NewServeMux()
+	multiplexer.Handle("/", serveLocalFolder)
+	multiplexer.Handle(services.ADMIN_PATH, serveLocalFolder.AdminHandler())
+	server := httptest.NewServer(multiplexer)
+	// Close the server when test finishes
+	defer server.Close()
+
+	probe := func(url string, status int, result any) {
+		response, err := http.Get(url)
+		if err != nil || response.StatusCode != status {
+			t.Fatalf("Error in attempt to probe %s %v", url, err)
+		}
+		if err := json.NewDecoder(response.Body).Decode(result); err != nil {
+			t.Fatalf("Error in attempt to decode %s %v", url, err)
+		}
+	}
+	health := models.HealthStatus{}
+	probe(server.URL+services.HEALTHZ_PATH, http.StatusOK, &health)
+	if health.Status != models.HealthStatusOk {
+		t.Errorf("Wrong health %v", health)
+	}
+
+	// The background workers are reported once they are started
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	StartLifecycleWorker(ctx, backend, time.Hour, true)
+	lifecycleCheck := func() *models.HealthCheck {
+		probe(server.URL+services.READYZ_PATH, http.StatusOK, &health)
+		index := slices.IndexFunc(health.Checks, func(check models.HealthCheck) bool { return check.Name == "worker:lifecycle" })
+		if index < 0 {
+			return nil
+		}
+		return &health.Checks[index]
+	}
+	for attempt := 0; attempt < 100 && (lifecycleCheck() == nil || lifecycleCheck().LastRun == nil); attempt++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if check := lifecycleCheck(); health.Status != models.HealthStatusOk || check == nil || check.Status != models.HealthStatusOk || check.LastRun == nil {
+		t.Errorf("Wrong readiness of the lifecycle worker %v", health)
+	}
+	for _, name := range []string{"data-folder", "index"} {
+		if !slices.ContainsFunc(health.Checks, func(check models.HealthCheck) bool {
+			return check.Name == name && check.Status == models.HealthStatusOk
+		}) {
+			t.Errorf("Readiness doesn't check %s %v", name, health)
+		}
+	}
+
+	info := models.ServerInfo{}
+	probe(server.URL+services.INFO_PATH, http.StatusOK, &info)
+	if info.Version != services.Version || info.StartTime.IsZero() || len(info.Mounts) != 1 || info.Mounts[0].Bucket != "mounted" || !info.Mounts[0].ReadOnly {
+		t.Errorf("Wrong info %v", info)
+	}
+	for _, feature := range []string{"index", "mounts", "admin-api", "lifecycle"} {
+		if !slices.Contains(info.Features, feature) {
+			t.Errorf("Info doesn't report the feature %s %v", feature, info.Features)
+		}
+	}
+	if err := HealthCheck(server.Listener.Addr().String(), time.Second); err != nil {
+		t.Errorf("Health check of the ready service failed %v", err)
+	}
+
+	// The read-only backend can't keep the data
+	readOnly := httptest.NewServer((&ServeLocalFolder{Backend: &services.FSBackend{FS: os.DirFS(mountedFolder)}}).AdminHandler())
+	// Close the server when test finishes
+	defer readOnly.Close()
+	probe(readOnly.URL+services.READYZ_PATH, http.StatusServiceUnavailable, &health)
+	if health.Status != models.HealthStatusFailed || health.Checks[0].Name != "data-folder" || health.Checks[0].Error == "" {
+		t.Errorf("Wrong readiness of the read-only service %v", health)
+	}
+	if err := HealthCheck(readOnly.Listener.Addr().String(), time.Second); err == nil || !strings.Contains(err.Error(), "data-folder") {
+		t.Errorf("Health check of the read-only service passed %v", err)
+	}
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	if err := HealthCheck("0.0.0.0:"+port, time.Second); err != nil {
+		t.Errorf("Health check of the unspecified address failed %v", err)
+	}
+}
@@ -12,13 +12,69 @@ type ServeLocalFolder struct {
 	UrlContext                  string `default:""`
 	ServerAddr                  string `default:"localhost:8081"`
 	StatisticsApplicationFolder string `default:"/statistics/app"`
+	// Access keys allowed to bypass the governance mode retention, the keys
+	// are managed by the admin api and sign their requests
+	GovernanceBypassAccessKeys []string
+	// Master key file of the server side encryption, the SSE-S3 is refused
+	// without it unless GenerateMasterKey is set
+	MasterKeyFile string
+	// GenerateMasterKey allows to generate the master key in the system
+	// folder of RootFolder, next to the encrypted data
+	GenerateMasterKey bool
+	// Backend keeping the data, by default the data is kept in RootFolder
+	Backend services.Backend
+	// AccessLogFormat is the format of the access log of the requests (json
+	// or s3), the access log is not written by default
+	AccessLogFormat string
+	// AdminToken is the bearer token of the admin api, the api is disabled
+	// without it
+	AdminToken string
+	// NotificationSignal wakes up the notification worker (the signal returned
+	// by StartNotificationWorker), the worker waits for its interval without it
+	NotificationSignal chan struct{}
+
+	// accessKeys keeps the access keys record of the served folder
+	accessKeys services.AccessKeyCache
 }
 
 func (serveLocalFolder *ServeLocalFolder) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	services.ApiRouter(writer, request.WithContext(serveLocalFolder.contextOf(request)))
+}
+
+// MetricsHandler exports the metrics of the served folder in the Prometheus
+// text format.
+func (serveLocalFolder *ServeLocalFolder) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		services.Metrics(writer, request.WithContext(serveLocalFolder.contextOf(request)))
+	})
+}
+
+// AdminHandler serves the admin namespace (services.ADMIN_PATH) of the served
+// folder: the dashboard, the statistics api, the object browser api and the
+// admin api.
+func (serveLocalFolder *ServeLocalFolder) AdminHandler() http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		services.AdminRouter(writer, request.WithContext(serveLocalFolder.contextOf(request)))
+	})
+}
+
+func (serveLocalFolder *ServeLocalFolder) contextOf(request *http.Request) context.Context {
 	ctx := request.Context()
 	ctx = context.WithValue(ctx, services.KeyServerAddr, serveLocalFolder.ServerAddr)
 	ctx = context.WithValue(ctx, services.KeyDataFolder, serveLocalFolder.RootFolder)
 	ctx = context.WithValue(ctx, services.KeyUrlContext, serveLocalFolder.UrlContext)
 	ctx = context.WithValue(ctx, services.KeyStatisticsApplicationFolder, serveLocalFolder.StatisticsApplicationFolder)
-	services.ApiRouter(writer, request.WithContext(ctx))
+	ctx = context.WithValue(ctx, services.KeyGovernanceBypassAccessKeys, serveLocalFolder.GovernanceBypassAccessKeys)
+	ctx = context.WithValue(ctx, services.KeyMasterKeyFile, serveLocalFolder.MasterKeyFile)
+	ctx = context.WithValue(ctx, services.KeyGenerateMasterKey, serveLocalFolder.GenerateMasterKey)
+	ctx = context.WithValue(ctx, services.KeyAccessLogFormat, serveLocalFolder.AccessLogFormat)
+	ctx = context.WithValue(ctx, services.KeyAdminToken, serveLocalFolder.AdminToken)
+	ctx = context.WithValue(ctx, services.KeyAccessKeyCache, &serveLocalFolder.accessKeys)
+	if serveLocalFolder.NotificationSignal != nil {
+		ctx = context.WithValue(ctx, services.KeyNotificationSignal, serveLocalFolder.NotificationSignal)
+	}
+	if serveLocalFolder.Backend != nil {
+		ctx = context.WithValue(ctx, services.KeyBackend, serveLocalFolder.Backend)
+	}
+	return ctx
 }
@@ -0,0 +1,188 @@
+/**
+ * Author: Vanya Usalko <ivict@rambler.ru>
+ * File: access_key.go
+ */
+
+package services
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/usalko/s2d3/models"
+)
+
+// ACCESS_KEYS_RECORD keeps the access keys managed by the admin api with
+// their secrets.
+const ACCESS_KEYS_RECORD = "access-keys.json"
+
+const CodeInvalidAccessKeyId = "InvalidAccessKeyId"
+
+var ErrNoSuchAccessKey = errors.New("the access key does not exist")
+var ErrInvalidAccessKeyId = errors.New("the access key id does not exist or is revoked")
+
+// accessKeysLock serializes the changes of the access keys record.
+var accessKeysLock sync.Mutex
+
+// AccessKeyCache keeps the access keys record read by the requests of one
+// server, the record is read again once the server changes it.
+type AccessKeyCache struct {
+	lock       sync.Mutex
+	accessKeys []models.AccessKey
+}
+
+func (cache *AccessKeyCache) get(read func() ([]models.AccessKey, error)) ([]models.AccessKey, error) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	if cache.accessKeys == nil {
+		accessKeys, err := read()
+		if err != nil {
+			return nil, err
+		}
+		cache.accessKeys = accessKeys
+	}
+	return slices.Clone(cache.accessKeys), nil
+}
+
+func (cache *AccessKeyCache) forget() {
+	if cache == nil {
+		return
+	}
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	cache.accessKeys = nil
+}
+
+// ListAccessKeys returns the managed access keys with their secrets, the
+// revoked keys are kept to be reported.
+func (storage *Storage) ListAccessKeys() ([]models.AccessKey, error) {
+	if storage.AccessKeys != nil {
+		return storage.AccessKeys.get(storage.readAccessKeys)
+	}
+	return storage.readAccessKeys()
+}
+
+func (storage *Storage) readAccessKeys() ([]models.AccessKey, error) {
+	data, err := storage.GetRecord(ACCESS_KEYS_RECORD)
+	if errors.Is(err, fs.ErrNotExist) {
+		return []models.AccessKey{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	accessKeys := []models.AccessKey{}
+	if err := json.Unmarshal(data, &accessKeys); err != nil {
+		return nil, err
+	}
+	return accessKeys, nil
+}
+
+func (storage *Storage) putAccessKeys(accessKeys []models.AccessKey) error {
+	data, err := json.MarshalIndent(accessKeys, "", "  ")
+	if err != nil {
+		return err
+	}
+	defer storage.AccessKeys.forget()
+	return storage.PutRecord(ACCESS_KEYS_RECORD, data)
+}
+
+// CreateAccessKey generates the access key id with its secret.
+func (storage *Storage) CreateAccessKey(description string) (*models.AccessKey, error) {
+	accessKeysLock.Lock()
+	defer accessKeysLock.Unlock()
+
+	accessKeys, err := storage.ListAccessKeys()
+	if err != nil {
+		return nil, err
+	}
+	id := make([]byte, 15)
+	secret := make([]byte, 30)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	accessKey := models.AccessKey{
+		AccessKeyId:     base32.StdEncoding.EncodeToString(id)[:20],
+		SecretAccessKey: base64.StdEncoding.EncodeToString(secret),
+		Description:     description,
+		CreationDate:    time.Now().UTC(),
+	}
+	if err := storage.putAccessKeys(append(accessKeys, accessKey)); err != nil {
+		return nil, err
+	}
+	return &accessKey, nil
+}
+
+// RevokeAccessKey refuses the following requests of the access key.
+func (storage *Storage) RevokeAccessKey(accessKeyId string) error {
+	accessKeysLock.Lock()
+	defer accessKeysLock.Unlock()
+
+	accessKeys, err := storage.ListAccessKeys()
+	if err != nil {
+		return err
+	}
+	for i := range accessKeys {
+		if accessKeys[i].AccessKeyId != accessKeyId {
+			continue
+		}
+		if accessKeys[i].RevocationDate == nil {
+			now := time.Now().UTC()
+			accessKeys[i].RevocationDate = &now
+		}
+		return storage.putAccessKeys(accessKeys)
+	}
+	return ErrNoSuchAccessKey
+}
+
+// activeAccessKey returns the managed access key which is not revoked, the
+// unknown and the revoked keys return ErrInvalidAccessKeyId.
+func (storage *Storage) activeAccessKey(accessKeyId string) (*models.AccessKey, error) {
+	accessKeys, err := storage.ListAccessKeys()
+	if err != nil {
+		return nil, err
+	}
+	for _, accessKey := range accessKeys {
+		if accessKey.AccessKeyId == accessKeyId && accessKey.RevocationDate == nil {
+			return &accessKey, nil
+		}
+	}
+	return nil, ErrInvalidAccessKeyId
+}
+
+// checkAccessKey authenticates the request once the access keys are managed
+// by the admin api: the signature of the request is verified by the secret of
+// its access key, the unknown and the revoked keys are refused. It returns
+// the access key id of the request and whether the keys are managed, the
+// service without the managed keys accepts any access key unverified.
+func checkAccessKey(request *http.Request, storage *Storage) (string, bool, error) {
+	accessKeys, err := storage.ListAccessKeys()
+	if err != nil {
+		return "", false, err
+	}
+	accessKeyId := requestAccessKey(request)
+	if len(accessKeys) == 0 || accessKeyId == "" {
+		return accessKeyId, len(accessKeys) > 0, nil
+	}
+	for _, accessKey := range accessKeys {
+		if accessKey.AccessKeyId == accessKeyId && accessKey.RevocationDate == nil {
+			if err := verifySignature(request, accessKey.SecretAccessKey, time.Now()); err != nil {
+				return "", true, err
+			}
+			return accessKeyId, true, nil
+		}
+	}
+	return "", true, ErrInvalidAccessKeyId
+}
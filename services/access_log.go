@@ -0,0 +1,464 @@
+/**
+ * Author: Vanya Usalko <ivict@rambler.ru>
+ * File: access_log.go
+ */
+
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/usalko/s2d3/models"
+)
+
+const LOGGING_CONFIG = "logging"
+
+const CodeInvalidTargetBucketForLogging = "InvalidTargetBucketForLogging"
+
+// The formats of the access log written by the service, the logs delivered
+// into the target buckets are always in the S3 server access log format.
+const (
+	ACCESS_LOG_OFF  = "off"
+	ACCESS_LOG_JSON = "json"
+	ACCESS_LOG_S3   = "s3"
+)
+
+var ACCESS_LOG_FORMATS = []string{ACCESS_LOG_OFF, ACCESS_LOG_JSON, ACCESS_LOG_S3}
+
+const ACCESS_LOG_TIME_FORMAT = "02/Jan/2006:15:04:05 -0700"
+
+// MAX_PENDING_ACCESS_LOG_LINES limits the lines kept for the delivery into
+// one target, the older lines are dropped when the delivery fails.
+const MAX_PENDING_ACCESS_LOG_LINES = 100000
+
+// AccessLogOutput receives the access log of the requests.
+var AccessLogOutput io.Writer = os.Stdout
+
+// accessLogEntry describes the served request.
+type accessLogEntry struct {
+	time               time.Time
+	requestId          string
+	hostId             string
+	operation          string
+	restOperation      string
+	bucketName         string
+	objectKey          string
+	method             string
+	uri                string
+	protocol           string
+	status             int
+	errorCode          string
+	bytesSent          int64
+	bytesReceived      int64
+	duration           time.Duration
+	remoteIp           string
+	accessKey          string
+	userAgent          string
+	referer            string
+	host               string
+	signatureVersion   string
+	authenticationType string
+	tlsVersion         string
+}
+
+func newRequestId() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return strings.ToUpper(hex.EncodeToString(id))
+}
+
+// hostIdOf returns the extended request id (x-amz-id-2), it identifies the
+// request together with the server which served it.
+func hostIdOf(request *http.Request, requestId string) string {
+	serverAddr, _ := request.Context().Value(KeyServerAddr).(string)
+	hash := sha256.Sum256([]byte(serverAddr + "/" + requestId))
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// signatureOf returns the signature version and the authentication type of
+// the request, both are empty for the anonymous request.
+func signatureOf(request *http.Request) (string, string) {
+	authorization := request.Header.Get("Authorization")
+	switch {
+	case strings.HasPrefix(authorization, "AWS4-HMAC-SHA256 "):
+		return "SigV4", "AuthHeader"
+	case strings.HasPrefix(authorization, "AWS "):
+		return "SigV2", "AuthHeader"
+	case request.URL.Query().Get("X-Amz-Credential") != "":
+		return "SigV4", "QueryString"
+	case request.URL.Query().Get("AWSAccessKeyId") != "":
+		return "SigV2", "QueryString"
+	}
+	return "", ""
+}
+
+func accessLogEntryOf(request *http.Request, start time.Time, recorder *responseRecorder, received int64) *accessLogEntry {
+	urlContext, _ := request.Context().Value(KeyUrlContext).(string)
+	requestId, _ := request.Context().Value(KeyRequestId).(string)
+	hostId, _ := request.Context().Value(KeyHostId).(string)
+	bucketName, objectKey := bucketNameAndObjectKey(request.URL.Path, urlContext)
+	remoteIp, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		remoteIp = request.RemoteAddr
+	}
+	signatureVersion, authenticationType := signatureOf(request)
+	tlsVersion := ""
+	if request.TLS != nil {
+		tlsVersion = strings.Replace(tls.VersionName(request.TLS.Version), "TLS ", "TLSv", 1)
+	}
+
+	return &accessLogEntry{
+		time:               start.UTC(),
+		requestId:          requestId,
+		hostId:             hostId,
+		operation:          operationOf(request),
+		restOperation:      restOperationOf(request),
+		bucketName:         bucketName,
+		objectKey:          objectKey,
+		method:             request.Method,
+		uri:                request.URL.RequestURI(),
+		protocol:           request.Proto,
+		status:             recorder.status,
+		errorCode:          recorder.errorCode,
+		bytesSent:          recorder.written,
+		bytesReceived:      received,
+		duration:           time.Since(start),
+		remoteIp:           remoteIp,
+		accessKey:          requestAccessKey(request),
+		userAgent:          request.UserAgent(),
+		referer:            request.Referer(),
+		host:               request.Host,
+		signatureVersion:   signatureVersion,
+		authenticationType: authenticationType,
+		tlsVersion:         tlsVersion,
+	}
+}
+
+func (entry *accessLogEntry) attributes() []slog.Attr {
+	return []slog.Attr{
+		slog.String("request_id", entry.requestId),
+		slog.String("host_id", entry.hostId),
+		slog.String("operation", entry.operation),
+		slog.String("bucket", entry.bucketName),
+		slog.String("key", entry.objectKey),
+		slog.String("method", entry.method),
+		slog.String("uri", entry.uri),
+		slog.Int("status", entry.status),
+		slog.String("error_code", entry.errorCode),
+		slog.Int64("bytes_sent", entry.bytesSent),
+		slog.Int64("bytes_received", entry.bytesReceived),
+		slog.Float64("duration_ms", float64(entry.duration.Microseconds())/1000),
+		slog.String("remote_ip", entry.remoteIp),
+		slog.String("access_key", entry.accessKey),
+		slog.String("user_agent", entry.userAgent),
+	}
+}
+
+// s3Line formats the entry as the line of the S3 server access log, the
+// unknown fields are written as -.
+func (entry *accessLogEntry) s3Line() string {
+	field := func(value string) string {
+		if value == "" {
+			return "-"
+		}
+		return value
+	}
+	quoted := func(value string) string {
+		return "\"" + strings.ReplaceAll(field(value), "\"", "\\\"") + "\""
+	}
+	bytesSent := "-"
+	if entry.bytesSent > 0 {
+		bytesSent = strconv.FormatInt(entry.bytesSent, 10)
+	}
+	objectSize := "-"
+	if entry.method == "PUT" && entry.objectKey != "" && entry.bytesReceived > 0 {
+		objectSize = strconv.FormatInt(entry.bytesReceived, 10)
+	}
+	objectKey := ""
+	if entry.objectKey != "" {
+		objectKey = strings.ReplaceAll(url.PathEscape(entry.objectKey), "%2F", "/")
+	}
+
+	return strings.Join([]string{
+		"-",
+		field(entry.bucketName),
+		"[" + entry.time.Format(ACCESS_LOG_TIME_FORMAT) + "]",
+		field(entry.remoteIp),
+		field(entry.accessKey),
+		field(entry.requestId),
+		entry.restOperation,
+		field(objectKey),
+		quoted(entry.method + " " + entry.uri + " " + entry.protocol),
+		strconv.Itoa(entry.status),
+		field(entry.errorCode),
+		bytesSent,
+		objectSize,
+		strconv.FormatInt(entry.duration.Milliseconds(), 10),
+		"-",
+		quoted(entry.referer),
+		quoted(entry.userAgent),
+		"-",
+		field(entry.hostId),
+		field(entry.signatureVersion),
+		"-",
+		field(entry.authenticationType),
+		field(entry.host),
+		field(entry.tlsVersion),
+		"-",
+		"-",
+	}, " ")
+}
+
+// logAccess writes the access log of the request in the format of the
+// context and queues it for the delivery into the target bucket of the
+// bucket logging (if any).
+func logAccess(request *http.Request, entry *accessLogEntry) {
+	format, _ := request.Context().Value(KeyAccessLogFormat).(string)
+	switch format {
+	case ACCESS_LOG_JSON:
+		accessLoggerOf(AccessLogOutput).LogAttrs(context.Background(), slog.LevelInfo, "access", entry.attributes()...)
+	case ACCESS_LOG_S3:
+		fmt.Fprintln(AccessLogOutput, entry.s3Line())
+	}
+
+	if entry.bucketName == "" {
+		return
+	}
+	storage := storageOf(request)
+	target, err := storage.bucketLoggingOf(entry.bucketName)
+	if err != nil {
+		fmt.Printf("[logging] the access log of %s is not delivered: %s\n", entry.bucketName, err)
+		return
+	}
+	if target != nil {
+		queueAccessLog(storage, target, entry.s3Line())
+	}
+}
+
+var accessLogger *slog.Logger
+var accessLoggerOutput io.Writer
+var accessLoggerLock sync.Mutex
+
+// accessLoggerOf returns the JSON logger of the output, the logger is created
+// again only when AccessLogOutput is changed.
+func accessLoggerOf(output io.Writer) *slog.Logger {
+	accessLoggerLock.Lock()
+	defer accessLoggerLock.Unlock()
+
+	if accessLogger == nil || accessLoggerOutput != output {
+		accessLogger = slog.New(slog.NewJSONHandler(output, nil))
+		accessLoggerOutput = output
+	}
+	return accessLogger
+}
+
+// bucketLoggings keeps the logging of the buckets read by logAccess, the
+// buckets without the logging are kept as nil.
+var bucketLoggings = map[string]*models.LoggingEnabled{}
+var bucketLoggingsLock sync.Mutex
+
+// bucketLoggingOf returns the target of the bucket logging, nil if the
+// logging is off or the bucket doesn't exist. The logging is read once until
+// it is changed, see forgetBucketLogging.
+func (storage *Storage) bucketLoggingOf(bucketName string) (*models.LoggingEnabled, error) {
+	key := usageKey(storage.Backend, bucketName)
+
+	bucketLoggingsLock.Lock()
+	defer bucketLoggingsLock.Unlock()
+
+	if target, exists := bucketLoggings[key]; exists {
+		return target, nil
+	}
+	// The missing buckets are not kept, their names are up to the clients
+	err := storage.CheckBucket(bucketName)
+	if errors.Is(err, ErrNoSuchBucket) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	config, err := storage.GetBucketLogging(bucketName)
+	if err != nil {
+		return nil, err
+	}
+	bucketLoggings[key] = config.LoggingEnabled
+	return config.LoggingEnabled, nil
+}
+
+// forgetBucketLogging drops the kept logging of the bucket, it is read again
+// by the next request.
+func forgetBucketLogging(backend Backend, bucketName string) {
+	key := usageKey(backend, bucketName)
+
+	bucketLoggingsLock.Lock()
+	defer bucketLoggingsLock.Unlock()
+
+	delete(bucketLoggings, key)
+}
+
+// pendingAccessLog keeps the lines delivered into the target bucket by the
+// next DeliverAccessLogs call, the delivery is the best effort and the
+// pending lines are lost when the service is stopped.
+type pendingAccessLog struct {
+	storage      Storage
+	targetBucket string
+	targetPrefix string
+	lines        []string
+}
+
+var pendingAccessLogs = map[string]*pendingAccessLog{}
+var pendingAccessLogsLock sync.Mutex
+
+func queueAccessLog(storage Storage, target *models.LoggingEnabled, lines ...string) {
+	key := usageKey(storage.Backend, target.TargetBucket) + "\x00" + target.TargetPrefix
+
+	pendingAccessLogsLock.Lock()
+	defer pendingAccessLogsLock.Unlock()
+
+	pending, exists := pendingAccessLogs[key]
+	if !exists {
+		pending = &pendingAccessLog{
+			storage:      storage,
+			targetBucket: target.TargetBucket,
+			targetPrefix: target.TargetPrefix,
+		}
+		pendingAccessLogs[key] = pending
+	}
+	pending.lines = append(pending.lines, lines...)
+	if dropped := len(pending.lines) - MAX_PENDING_ACCESS_LOG_LINES; dropped > 0 {
+		fmt.Printf("[logging] %d lines of the access log of %s are dropped\n", dropped, target.TargetBucket)
+		pending.lines = pending.lines[dropped:]
+	}
+}
+
+// DeliverAccessLogs writes the pending access logs as the objects of their
+// target buckets, one object per target. The lines which are not delivered
+// are kept for the next call.
+func DeliverAccessLogs(now time.Time) error {
+	pendingAccessLogsLock.Lock()
+	delivered := pendingAccessLogs
+	pendingAccessLogs = map[string]*pendingAccessLog{}
+	pendingAccessLogsLock.Unlock()
+
+	var result error
+	for _, pending := range delivered {
+		if len(pending.lines) == 0 {
+			continue
+		}
+		objectKey := pending.targetPrefix + now.UTC().Format("2006-01-02-15-04-05-") + newRequestId()
+		data := []byte(strings.Join(pending.lines, "\n") + "\n")
+		err := pending.storage.PutObject(pending.targetBucket, objectKey, io.NopCloser(bytes.NewReader(data)), &models.ObjectMetadata{ContentType: "text/plain"}, nil)
+		if err != nil {
+			result = errors.Join(result, fmt.Errorf("%s/%s: %w", pending.targetBucket, objectKey, err))
+			queueAccessLog(pending.storage, &models.LoggingEnabled{
+				TargetBucket: pending.targetBucket,
+				TargetPrefix: pending.targetPrefix,
+			}, pending.lines...)
+		}
+	}
+	return result
+}
+
+// GetBucketLogging returns the empty status for the bucket without the
+// logging.
+func (storage *Storage) GetBucketLogging(bucketName string) (*models.BucketLoggingStatus, error) {
+	config := &models.BucketLoggingStatus{}
+	data, err := storage.GetBucketConfig(bucketName, LOGGING_CONFIG)
+	if errors.Is(err, ErrNoSuchConfiguration) || errors.Is(err, ErrNoSuchBucket) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := xml.Unmarshal(data, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// Logging implements PUT and GET of the bucket logging, PUT of the status
+// without LoggingEnabled turns the logging off.
+func Logging(writer http.ResponseWriter, request *http.Request) {
+	bucketName, _ := bucketNameAndObjectKey(request.URL.Path, request.Context().Value(KeyUrlContext).(string))
+	storage := storageOf(request)
+
+	switch request.Method {
+
+	case "GET":
+		if err := storage.CheckBucket(bucketName); err != nil {
+			writeBucketConfigError(writer, err, CodeNoSuchBucket)
+			return
+		}
+		config, err := storage.GetBucketLogging(bucketName)
+		var data []byte
+		if err == nil {
+			data, err = xml.Marshal(config)
+		}
+		if err != nil {
+			writeError(writer, http.StatusInternalServerError, CodeInternalError, err.Error())
+			return
+		}
+		writer.Header().Set("Content-Type", "application/xml")
+		writer.Write(data)
+
+	case "PUT":
+		body, err := io.ReadAll(request.Body)
+		if err != nil {
+			writeError(writer, http.StatusBadRequest, CodeInvalidArgument, err.Error())
+			return
+		}
+		config := models.BucketLoggingStatus{}
+		if err := xml.Unmarshal(body, &config); err != nil {
+			writeError(writer, http.StatusBadRequest, CodeMalformedXML, err.Error())
+			return
+		}
+		if config.LoggingEnabled == nil {
+			err := storage.DeleteBucketConfig(bucketName, LOGGING_CONFIG)
+			forgetBucketLogging(storage.Backend, bucketName)
+			if err != nil && !errors.Is(err, ErrNoSuchConfiguration) {
+				writeBucketConfigError(writer, err, CodeNoSuchBucket)
+			}
+			return
+		}
+		target := config.LoggingEnabled
+		if err := storage.CheckBucket(target.TargetBucket); err != nil {
+			writeError(writer, http.StatusBadRequest, CodeInvalidTargetBucketForLogging, fmt.Sprintf("the target bucket %s does not exist", target.TargetBucket))
+			return
+		}
+		// The logs are delivered on behalf of the caller, so the caller must
+		// be allowed to write them into the target bucket
+		if err := authorize(request, &storage, target.TargetBucket, target.TargetPrefix, "s3:PutObject"); err != nil {
+			writeAuthorizationError(writer, err)
+			return
+		}
+		data, err := xml.Marshal(&config)
+		if err == nil {
+			err = storage.PutBucketConfig(bucketName, LOGGING_CONFIG, data)
+		}
+		forgetBucketLogging(storage.Backend, bucketName)
+		if err != nil {
+			writeBucketConfigError(writer, err, CodeNoSuchBucket)
+			return
+		}
+
+	}
+}
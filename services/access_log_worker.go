@@ -0,0 +1,48 @@
+/**
+ * Author: Vanya Usalko <ivict@rambler.ru>
+ * File: access_log_worker.go
+ */
+
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const DEFAULT_ACCESS_LOG_DELIVERY_INTERVAL = 5 * time.Minute
+
+// AccessLogWorker delivers the access logs of the buckets with the logging
+// into their target buckets every Interval, the pending logs are delivered
+// once more when the worker is stopped.
+type AccessLogWorker struct {
+	Interval time.Duration
+}
+
+func (worker *AccessLogWorker) Run(ctx context.Context) {
+	interval := worker.Interval
+	if interval <= 0 {
+		interval = DEFAULT_ACCESS_LOG_DELIVERY_INTERVAL
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	health := startWorkerHealth("access-log-delivery", interval)
+	defer health.stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := DeliverAccessLogs(time.Now()); err != nil {
+				fmt.Printf("[logging] %s\n", err)
+			}
+			return
+		case now := <-ticker.C:
+			err := DeliverAccessLogs(now)
+			if err != nil {
+				fmt.Printf("[logging] %s\n", err)
+			}
+			health.ran(err)
+		}
+	}
+}
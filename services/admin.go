@@ -0,0 +1,87 @@
+/**
+ * Author: Vanya Usalko <ivict@rambler.ru>
+ * File: admin.go
+ */
+
+package services
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ADMIN_PATH is the reserved path of the dashboard and the admin apis, they
+// are out of the key space of the S3 api as the bucket names do not start
+// with the underscore.
+const ADMIN_PATH = "/_s2d3/"
+
+// AdminRouter routes the requests of the admin namespace: the probes, the
+// statistics api, the object browser api, the admin api and the dashboard.
+func AdminRouter(writer http.ResponseWriter, request *http.Request) {
+	switch request.URL.Path {
+	case HEALTHZ_PATH:
+		Healthz(writer, request)
+		return
+	case READYZ_PATH:
+		Readyz(writer, request)
+		return
+	case INFO_PATH:
+		Info(writer, request)
+		return
+	}
+	if strings.HasPrefix(request.URL.Path, STATISTICS_API_PATH) {
+		StatisticsApi(writer, request)
+		return
+	}
+	if strings.HasPrefix(request.URL.Path, BROWSER_API_PATH) {
+		BrowserApi(writer, request)
+		return
+	}
+	if strings.HasPrefix(request.URL.Path, ADMIN_API_PATH) {
+		AdminApi(writer, request)
+		return
+	}
+	Dashboard(writer, request)
+}
+
+// Dashboard serves the statistics application, the paths which are not the
+// files of the application are its views and are served by its index.html.
+func Dashboard(writer http.ResponseWriter, request *http.Request) {
+	statisticsApplicationFolder, _ := request.Context().Value(KeyStatisticsApplicationFolder).(string)
+	if statisticsApplicationFolder == "" || !strings.HasPrefix(request.URL.Path, ADMIN_PATH) {
+		http.NotFound(writer, request)
+		return
+	}
+
+	name := path.Clean("/" + strings.TrimPrefix(request.URL.Path, ADMIN_PATH))
+	if _, err := os.Stat(filepath.Join(statisticsApplicationFolder, filepath.FromSlash(name))); err != nil {
+		name = "/"
+	}
+	request = request.Clone(request.Context())
+	request.URL.Path = name
+	request.URL.RawPath = ""
+	http.FileServer(http.Dir(statisticsApplicationFolder)).ServeHTTP(writer, request)
+}
+
+// writeJsonError writes the error of the json apis of the admin namespace.
+func writeJsonError(writer http.ResponseWriter, status int, message string) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	json.NewEncoder(writer).Encode(map[string]string{"error": message})
+}
+
+func writeJson(writer http.ResponseWriter, status int, result any) {
+	data, err := json.Marshal(result)
+	if err != nil {
+		writeJsonError(writer, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Header().Set("Cache-Control", "no-store")
+	writer.WriteHeader(status)
+	writer.Write(data)
+}
@@ -0,0 +1,337 @@
+/**
+ * Author: Vanya Usalko <ivict@rambler.ru>
+ * File: admin_api.go
+ */
+
+package services
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/usalko/s2d3/models"
+)
+
+// ADMIN_API_PATH is the path of the admin api in the admin namespace.
+const ADMIN_API_PATH = ADMIN_PATH + "admin/"
+
+// Version is the version of the service, the builds set it by
+// -ldflags "-X github.com/usalko/s2d3/services.Version=...".
+var Version = "dev"
+
+var startTime = time.Now()
+
+// adminErrorStatusOf maps the errors of the admin operations to the statuses,
+// the errors of the storage are mapped as the browser maps them.
+func adminErrorStatusOf(err error) int {
+	switch {
+	case errors.Is(err, ErrNoSuchAccessKey), errors.Is(err, ErrNoSuchConfiguration), errors.Is(err, ErrNoSuchScrubJob):
+		return http.StatusNotFound
+	case errors.Is(err, ErrBucketAlreadyExists), errors.Is(err, ErrBucketNotEmpty):
+		return http.StatusConflict
+	case errors.Is(err, ErrInvalidQuota), errors.Is(err, ErrInvalidPolicy):
+		return http.StatusBadRequest
+	}
+	return browserErrorStatusOf(err)
+}
+
+func writeAdminError(writer http.ResponseWriter, err error) {
+	writeJsonError(writer, adminErrorStatusOf(err), err.Error())
+}
+
+// checkAdminToken checks the bearer token of the request, the admin api is
+// disabled unless the token is configured.
+func checkAdminToken(writer http.ResponseWriter, request *http.Request) bool {
+	token, _ := request.Context().Value(KeyAdminToken).(string)
+	if token == "" {
+		writeJsonError(writer, http.StatusForbidden, "the admin api is disabled, the admin token is not set")
+		return false
+	}
+	if !hasAdminToken(request) {
+		writer.Header().Set("WWW-Authenticate", `Bearer realm="s2d3"`)
+		writeJsonError(writer, http.StatusUnauthorized, "invalid admin token")
+		return false
+	}
+	return true
+}
+
+// hasAdminToken returns true for the request carrying the configured admin
+// token as the bearer token.
+func hasAdminToken(request *http.Request) bool {
+	token, _ := request.Context().Value(KeyAdminToken).(string)
+	provided, found := strings.CutPrefix(request.Header.Get("Authorization"), "Bearer ")
+	return token != "" && found && subtle.ConstantTimeCompare([]byte(provided), []byte(token)) == 1
+}
+
+// serverInfoOf describes the running service.
+func serverInfoOf(request *http.Request, storage *Storage) (*models.ServerInfo, error) {
+	buckets, err := storage.ListBuckets()
+	if err != nil {
+		return nil, err
+	}
+	accessKeys, err := storage.ListAccessKeys()
+	if err != nil {
+		return nil, err
+	}
+	info := &models.ServerInfo{
+		Version:       Version,
+		StartTime:     startTime.UTC(),
+		UptimeSeconds: int64(time.Since(startTime) / time.Second),
+		Buckets:       len(buckets),
+		Mounts:        []models.Mount{},
+		Features:      featuresOf(request, storage),
+	}
+	info.ServerAddr, _ = request.Context().Value(KeyServerAddr).(string)
+	info.DataFolder, _ = request.Context().Value(KeyDataFolder).(string)
+	for _, accessKey := range accessKeys {
+		if accessKey.RevocationDate == nil {
+			info.AccessKeys++
+		}
+	}
+	if reporter, exists := CapabilityOf[MountReporter](storage.Backend); exists {
+		for _, mount := range reporter.Mounts() {
+			info.Mounts = append(info.Mounts, models.Mount{
+				Bucket:   mount.Bucket,
+				ReadOnly: mount.ReadOnly,
+				Quota:    mount.Quota,
+			})
+		}
+	}
+	return info, nil
+}
+
+// AdminApi serves the administration of the service, the requests must carry
+// the admin token as the bearer token. The api is not limited by the access
+// keys and the bucket policies:
+//
+//	GET    admin/info                       the server info
+//	GET    admin/access-keys                the access keys (without secrets)
+//	POST   admin/access-keys                the new access key with its secret
+//	DELETE admin/access-keys/id             the revocation of the access key
+//	GET    admin/buckets                    the buckets with their usage
+//	PUT    admin/buckets/bucket             the new bucket
+//	DELETE admin/buckets/bucket             the deletion of the empty bucket
+//	GET    admin/buckets/bucket/quota       the quota with the usage (also PUT, DELETE)
+//	GET    admin/buckets/bucket/policy      the bucket policy (also PUT, DELETE)
+//	POST   admin/lifecycle?dry-run          the run of the lifecycle rules
+//	POST   admin/scrub?bucket=              the verification of the objects (the job)
+//	GET    admin/scrub/id                   the state of the verification job
+func AdminApi(writer http.ResponseWriter, request *http.Request) {
+	if !checkAdminToken(writer, request) {
+		return
+	}
+	if !strings.HasPrefix(request.URL.Path, ADMIN_API_PATH) {
+		writeJsonError(writer, http.StatusNotFound, "no such api: "+request.URL.Path)
+		return
+	}
+	names := strings.Split(strings.Trim(strings.TrimPrefix(request.URL.Path, ADMIN_API_PATH), "/"), "/")
+	storage := storageOf(request)
+
+	switch {
+	case len(names) == 1 && names[0] == "info" && request.Method == "GET":
+		info, err := serverInfoOf(request, &storage)
+		if err != nil {
+			writeAdminError(writer, err)
+			return
+		}
+		writeJson(writer, http.StatusOK, info)
+	case names[0] == "access-keys" && len(names) <= 2:
+		adminAccessKeys(writer, request, &storage, names[1:])
+	case names[0] == "buckets" && len(names) <= 3:
+		adminBuckets(writer, request, &storage, names[1:])
+	case len(names) == 1 && names[0] == "lifecycle" && request.Method == "POST":
+		worker := &LifecycleWorker{Storage: storage, DryRun: request.URL.Query().Has("dry-run")}
+		if err := worker.Apply(time.Now()); err != nil {
+			writeAdminError(writer, err)
+			return
+		}
+		writer.WriteHeader(http.StatusNoContent)
+	case len(names) == 1 && names[0] == "scrub" && request.Method == "POST":
+		job, err := storage.StartScrub(request.URL.Query().Get("bucket"))
+		if err != nil {
+			writeAdminError(writer, err)
+			return
+		}
+		writer.Header().Set("Location", ADMIN_API_PATH+"scrub/"+job.Id)
+		writeJson(writer, http.StatusAccepted, job)
+	case len(names) == 2 && names[0] == "scrub" && request.Method == "GET":
+		job, err := ScrubJobOf(names[1])
+		if err != nil {
+			writeAdminError(writer, err)
+			return
+		}
+		writeJson(writer, http.StatusOK, job)
+	default:
+		writeJsonError(writer, http.StatusNotFound, "no such api: "+request.Method+" "+request.URL.Path)
+	}
+}
+
+func adminAccessKeys(writer http.ResponseWriter, request *http.Request, storage *Storage, names []string) {
+	switch {
+	case len(names) == 0 && request.Method == "GET":
+		accessKeys, err := storage.ListAccessKeys()
+		if err != nil {
+			writeAdminError(writer, err)
+			return
+		}
+		for i := range accessKeys {
+			accessKeys[i].SecretAccessKey = ""
+		}
+		writeJson(writer, http.StatusOK, accessKeys)
+	case len(names) == 0 && request.Method == "POST":
+		options := models.AccessKey{}
+		if err := decodeAdminBody(request, &options); err != nil {
+			writeJsonError(writer, http.StatusBadRequest, err.Error())
+			return
+		}
+		accessKey, err := storage.CreateAccessKey(options.Description)
+		if err != nil {
+			writeAdminError(writer, err)
+			return
+		}
+		fmt.Printf("[admin] the access key %s is created\n", accessKey.AccessKeyId)
+		writeJson(writer, http.StatusCreated, accessKey)
+	case len(names) == 1 && request.Method == "DELETE":
+		if err := storage.RevokeAccessKey(names[0]); err != nil {
+			writeAdminError(writer, err)
+			return
+		}
+		fmt.Printf("[admin] the access key %s is revoked\n", names[0])
+		writer.WriteHeader(http.StatusNoContent)
+	default:
+		writeJsonError(writer, http.StatusNotFound, "no such api: "+request.Method+" "+request.URL.Path)
+	}
+}
+
+func adminBuckets(writer http.ResponseWriter, request *http.Request, storage *Storage, names []string) {
+	switch {
+	case len(names) == 0 && request.Method == "GET":
+		buckets, err := bucketStatistics(storage)
+		if err != nil {
+			writeAdminError(writer, err)
+			return
+		}
+		writeJson(writer, http.StatusOK, buckets)
+	case len(names) == 1 && request.Method == "PUT":
+		if !ValidBucketName(names[0]) {
+			writeJsonError(writer, http.StatusBadRequest, "invalid bucket name: "+names[0])
+			return
+		}
+		if err := storage.CreateBucket(names[0]); err != nil {
+			writeAdminError(writer, err)
+			return
+		}
+		fmt.Printf("[admin] the bucket %s is created\n", names[0])
+		writer.WriteHeader(http.StatusCreated)
+	case len(names) == 1 && request.Method == "DELETE":
+		if err := storage.DeleteBucket(names[0]); err != nil {
+			writeAdminError(writer, err)
+			return
+		}
+		fmt.Printf("[admin] the bucket %s is deleted\n", names[0])
+		writer.WriteHeader(http.StatusNoContent)
+	case len(names) == 2 && names[1] == "quota":
+		adminQuota(writer, request, storage, names[0])
+	case len(names) == 2 && names[1] == "policy":
+		adminPolicy(writer, request, storage, names[0])
+	default:
+		writeJsonError(writer, http.StatusNotFound, "no such api: "+request.Method+" "+request.URL.Path)
+	}
+}
+
+func adminQuota(writer http.ResponseWriter, request *http.Request, storage *Storage, bucketName string) {
+	if err := storage.CheckBucket(bucketName); err != nil {
+		writeAdminError(writer, err)
+		return
+	}
+
+	switch request.Method {
+	case "GET":
+		config, err := storage.GetQuotaConfiguration(bucketName)
+		if err == nil && config == nil {
+			err = ErrNoSuchConfiguration
+		}
+		if err == nil {
+			config.Usage, err = storage.GetQuotaUsage(bucketName)
+		}
+		if err != nil {
+			writeAdminError(writer, err)
+			return
+		}
+		writeJson(writer, http.StatusOK, config)
+	case "PUT":
+		config := models.QuotaConfiguration{}
+		if err := decodeAdminBody(request, &config); err != nil {
+			writeJsonError(writer, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := storage.PutQuotaConfiguration(bucketName, &config); err != nil {
+			writeAdminError(writer, err)
+			return
+		}
+		writer.WriteHeader(http.StatusNoContent)
+	case "DELETE":
+		if err := storage.DeleteQuotaConfiguration(bucketName); err != nil {
+			writeAdminError(writer, err)
+			return
+		}
+		writer.WriteHeader(http.StatusNoContent)
+	default:
+		writeJsonError(writer, http.StatusMethodNotAllowed, request.Method+" is not supported by the quota")
+	}
+}
+
+func adminPolicy(writer http.ResponseWriter, request *http.Request, storage *Storage, bucketName string) {
+	if err := storage.CheckBucket(bucketName); err != nil {
+		writeAdminError(writer, err)
+		return
+	}
+
+	switch request.Method {
+	case "GET":
+		policy, err := storage.GetBucketPolicy(bucketName)
+		if err == nil && policy == nil {
+			err = ErrNoSuchConfiguration
+		}
+		if err != nil {
+			writeAdminError(writer, err)
+			return
+		}
+		writeJson(writer, http.StatusOK, policy)
+	case "PUT":
+		policy := models.BucketPolicy{}
+		if err := decodeAdminBody(request, &policy); err != nil {
+			writeJsonError(writer, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := storage.PutBucketPolicy(bucketName, &policy); err != nil {
+			writeAdminError(writer, err)
+			return
+		}
+		writer.WriteHeader(http.StatusNoContent)
+	case "DELETE":
+		if err := storage.DeleteBucketPolicy(bucketName); err != nil {
+			writeAdminError(writer, err)
+			return
+		}
+		writer.WriteHeader(http.StatusNoContent)
+	default:
+		writeJsonError(writer, http.StatusMethodNotAllowed, request.Method+" is not supported by the policy")
+	}
+}
+
+// decodeAdminBody decodes the json body of the request, the empty body
+// leaves the value as it is.
+func decodeAdminBody(request *http.Request, value any) error {
+	err := json.NewDecoder(request.Body).Decode(value)
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,88 @@
+/**
+ * Author: Vanya Usalko <ivict@rambler.ru>
+ * File: auth.go
+ */
+
+package services
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// requestAccessKey returns the access key id of the signature v2 or v4
+// authorization header, or of the presigned url.
+func requestAccessKey(request *http.Request) string {
+	authorization := request.Header.Get("Authorization")
+	if strings.HasPrefix(authorization, "AWS4-HMAC-SHA256 ") {
+		_, credential, found := strings.Cut(authorization, "Credential=")
+		if found {
+			accessKey, _, _ := strings.Cut(credential, "/")
+			return accessKey
+		}
+		return ""
+	}
+	if strings.HasPrefix(authorization, "AWS ") {
+		accessKey, _, _ := strings.Cut(strings.TrimPrefix(authorization, "AWS "), ":")
+		return accessKey
+	}
+
+	query := request.URL.Query()
+	if credential := query.Get("X-Amz-Credential"); credential != "" {
+		accessKey, _, _ := strings.Cut(credential, "/")
+		return accessKey
+	}
+	return query.Get("AWSAccessKeyId")
+}
+
+// authenticatedAccessKey returns the access key id of the request once its
+// signature is verified by the secret of the managed access key, the
+// anonymous request has the empty id.
+func authenticatedAccessKey(request *http.Request, storage *Storage) (string, error) {
+	accessKeyId := requestAccessKey(request)
+	if accessKeyId == "" {
+		return "", nil
+	}
+	accessKey, err := storage.activeAccessKey(accessKeyId)
+	if err != nil {
+		return "", err
+	}
+	if err := verifySignature(request, accessKey.SecretAccessKey, time.Now()); err != nil {
+		return "", err
+	}
+	return accessKeyId, nil
+}
+
+// authorize checks the access key of the request (see checkAccessKey) and
+// the policy of the bucket allows the action. The anonymous requests are
+// refused once the access keys are managed unless the policy allows the
+// action to everyone.
+func authorize(request *http.Request, storage *Storage, bucketName string, objectKey string, action string) error {
+	accessKeyId, managed, err := checkAccessKey(request, storage)
+	if err != nil {
+		return err
+	}
+	if managed && accessKeyId == "" {
+		return storage.checkAnonymousPolicy(bucketName, objectKey, action)
+	}
+	return storage.checkPolicy(bucketName, objectKey, accessKeyId, action)
+}
+
+func writeAuthorizationError(writer http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrInvalidAccessKeyId):
+		writeError(writer, http.StatusForbidden, CodeInvalidAccessKeyId, err.Error())
+	case errors.Is(err, ErrPolicyDenied), errors.Is(err, ErrRequestExpired):
+		writeError(writer, http.StatusForbidden, CodeAccessDenied, err.Error())
+	case errors.Is(err, ErrSignatureDoesNotMatch):
+		writeError(writer, http.StatusForbidden, CodeSignatureDoesNotMatch, err.Error())
+	case errors.Is(err, ErrRequestTimeTooSkewed):
+		writeError(writer, http.StatusForbidden, CodeRequestTimeTooSkewed, err.Error())
+	case errors.Is(err, ErrMalformedAuthorization):
+		writeError(writer, http.StatusBadRequest, CodeAuthorizationHeaderMalformed, err.Error())
+	default:
+		writeError(writer, http.StatusInternalServerError, CodeInternalError, err.Error())
+	}
+}
@@ -0,0 +1,142 @@
+/**
+ * Author: Vanya Usalko <ivict@rambler.ru>
+ * File: backend.go
+ */
+
+package services
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/usalko/s2d3/models"
+)
+
+var ErrReadOnly = errors.New("the bucket is read-only")
+
+// Backend keeps the buckets with their configurations, the object data with
+// the object metadata, the multipart uploads and the service records (keys,
+// event queue). The data is kept as it is given, the compression, encryption,
+// object lock etc. are applied by Storage on top of the backend.
+type Backend interface {
+	ListBuckets() ([]models.Bucket, error)
+	CreateBucket(bucketName string) error
+	// CheckBucket returns ErrNoSuchBucket for the missing bucket
+	CheckBucket(bucketName string) error
+	// GetBucketConfig returns ErrNoSuchConfiguration for the missing configuration
+	GetBucketConfig(bucketName string, configName string) ([]byte, error)
+	PutBucketConfig(bucketName string, configName string, data []byte) error
+	DeleteBucketConfig(bucketName string, configName string) error
+	// CheckWritable returns ErrReadOnly for the bucket which can't be modified
+	CheckWritable(bucketName string) error
+
+	// Put stores the object data with its metadata, the bucket is created
+	// with its first object
+	Put(bucketName string, objectKey string, reader io.Reader, metadata *models.ObjectMetadata) error
+	// Get returns ErrNoSuchKey for the missing object, the ETag of the object
+	// info is empty unless it is known without reading the data
+	Get(bucketName string, objectKey string) (io.ReadSeekCloser, *ObjectInfo, error)
+	Stat(bucketName string, objectKey string) (*ObjectInfo, error)
+	// List returns the versions of the bucket objects sorted in the listing
+	// order
+	List(bucketName string) ([]models.ObjectVersion, error)
+	Delete(bucketName string, objectKey string) error
+	GetMetadata(bucketName string, objectKey string) (*models.ObjectMetadata, error)
+	PutMetadata(bucketName string, objectKey string, metadata *models.ObjectMetadata) error
+
+	// PutUpload creates the multipart upload record, GetUpload and
+	// DeleteUpload return ErrNoSuchUpload for the missing upload
+	PutUpload(upload *models.Upload) error
+	GetUpload(bucketName string, objectKey string, uploadId string) (*models.Upload, error)
+	DeleteUpload(bucketName string, objectKey string, uploadId string) error
+	ListUploads(bucketName string) ([]models.Upload, error)
+	PutPart(bucketName string, objectKey string, uploadId string, partNumber int, reader io.Reader) error
+	// GetPart returns ErrInvalidPart for the missing part
+	GetPart(bucketName string, objectKey string, uploadId string, partNumber int) (io.ReadSeekCloser, error)
+	// ListParts returns the part numbers in ascending order
+	ListParts(bucketName string, objectKey string, uploadId string) ([]int, error)
+
+	// GetRecord returns fs.ErrNotExist for the missing record
+	GetRecord(name string) ([]byte, error)
+	PutRecord(name string, data []byte) error
+	DeleteRecord(name string) error
+	// ListRecords returns the sorted names of the records in the folder,
+	// the nested folders are not listed
+	ListRecords(folder string) ([]string, error)
+}
+
+// BackendWrapper is implemented by the backends keeping the data in the other
+// backend (e.g. the indexed backend), the optional capabilities of the wrapped
+// backend are found by CapabilityOf.
+type BackendWrapper interface {
+	Unwrap() Backend
+}
+
+// CapabilityOf returns the optional capability (PackCompactor, Deduplicator,
+// DiskReporter, ...) of the backend or of the first backend it wraps which
+// has it.
+func CapabilityOf[T any](backend Backend) (T, bool) {
+	for backend != nil {
+		if capability, exists := backend.(T); exists {
+			return capability, true
+		}
+		wrapper, exists := backend.(BackendWrapper)
+		if !exists {
+			break
+		}
+		backend = wrapper.Unwrap()
+	}
+	var none T
+	return none, false
+}
+
+// ObjectInfo describes the stored object. Size and ETag are the ones of the
+// object content, for objects stored transformed they are taken from metadata.
+type ObjectInfo struct {
+	Size         int64
+	ETag         string
+	LastModified time.Time
+	Metadata     *models.ObjectMetadata
+}
+
+// contentAttributes returns the size and the ETag of the object content, the
+// ones of the stored data are used unless they are given in metadata.
+func contentAttributes(metadata *models.ObjectMetadata, dataSize int64, dataETag func() (string, error)) (int64, string, error) {
+	size := dataSize
+	if metadata.Size != nil {
+		size = *metadata.Size
+	}
+	if metadata.ETag != "" {
+		return size, metadata.ETag, nil
+	}
+	etag, err := dataETag()
+	return size, etag, err
+}
+
+// backendOf returns the backend of the service, the filesystem backend of
+// the data folder unless other backend is configured.
+func backendOf(request *http.Request) Backend {
+	backend, exists := request.Context().Value(KeyBackend).(Backend)
+	if exists && backend != nil {
+		return backend
+	}
+	dataFolder, _ := request.Context().Value(KeyDataFolder).(string)
+	return &FileSystemBackend{RootFolder: dataFolder}
+}
+
+// storageOf returns the storage of the request handlers.
+func storageOf(request *http.Request) Storage {
+	masterKeyFile, _ := request.Context().Value(KeyMasterKeyFile).(string)
+	generateMasterKey, _ := request.Context().Value(KeyGenerateMasterKey).(bool)
+	notificationSignal, _ := request.Context().Value(KeyNotificationSignal).(chan struct{})
+	accessKeys, _ := request.Context().Value(KeyAccessKeyCache).(*AccessKeyCache)
+	return Storage{
+		Backend:            backendOf(request),
+		MasterKeyFile:      masterKeyFile,
+		GenerateMasterKey:  generateMasterKey,
+		NotificationSignal: notificationSignal,
+		AccessKeys:         accessKeys,
+	}
+}
@@ -0,0 +1,358 @@
+/**
+ * Author: Vanya Usalko <ivict@rambler.ru>
+ * File: browser.go
+ */
+
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/usalko/s2d3/models"
+)
+
+// BROWSER_API_PATH is the path of the object browser api in the admin
+// namespace, the path after it is the bucket name and the object key.
+const BROWSER_API_PATH = ADMIN_PATH + "browser/"
+
+// browserErrorStatusOf maps the errors of the storage to the statuses of the
+// S3 errors the S3 api answers them with.
+func browserErrorStatusOf(err error) int {
+	switch {
+	case errors.Is(err, ErrNoSuchBucket), errors.Is(err, ErrNoSuchKey), errors.Is(err, ErrNoSuchUpload), errors.Is(err, fs.ErrNotExist):
+		return http.StatusNotFound
+	case errors.Is(err, ErrInvalidPart), errors.Is(err, ErrMissingParts), errors.Is(err, ErrCustomerKeyRequired), errors.Is(err, ErrMasterKeyRequired),
+		errors.Is(err, ErrObjectLockNotEnabled), errors.Is(err, ErrMalformedAuthorization):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrReadOnly), errors.Is(err, ErrObjectLocked), errors.Is(err, ErrQuotaExceeded), errors.Is(err, ErrInvalidEncryptionKey),
+		errors.Is(err, ErrInvalidAccessKeyId), errors.Is(err, ErrPolicyDenied), errors.Is(err, ErrSignatureDoesNotMatch),
+		errors.Is(err, ErrRequestTimeTooSkewed), errors.Is(err, ErrRequestExpired):
+		return http.StatusForbidden
+	case errors.Is(err, ErrInsufficientStorage):
+		return http.StatusInsufficientStorage
+	}
+	return http.StatusInternalServerError
+}
+
+func writeBrowserError(writer http.ResponseWriter, err error) {
+	writeJsonError(writer, browserErrorStatusOf(err), err.Error())
+}
+
+// browserActionOf returns the policy action of the browser request.
+func browserActionOf(request *http.Request, objectKey string, parsedQuery url.Values) string {
+	switch {
+	case objectKey == "":
+		return policyActionOf("ListObjects")
+	case request.Method == "DELETE" && parsedQuery.Has("uploadId"):
+		return policyActionOf("AbortMultipartUpload")
+	case parsedQuery.Has("uploads") || parsedQuery.Has("uploadId"):
+		return policyActionOf("PutObject")
+	case request.Method == "PUT" && parsedQuery.Has("tags"):
+		return policyActionOf("PutObjectTagging")
+	case request.Method == "DELETE":
+		return policyActionOf("DeleteObject")
+	}
+	return policyActionOf("GetObject")
+}
+
+// BrowserApi serves the object browser of the statistics application. The
+// requests carry the credentials of the S3 api, they are authorized by the
+// access keys and the bucket policies unless they carry the admin token, and
+// the writes are checked as the S3 api checks them (read-only buckets, object
+// lock, quotas, disk reserve):
+//
+//	GET    browser/                            the buckets
+//	GET    browser/bucket?prefix=&delimiter=   one page of the objects
+//	GET    browser/bucket/key                  the content (?download to save it)
+//	GET    browser/bucket/key?metadata         the metadata and the tags
+//	PUT    browser/bucket/key?tags             the tags (json object)
+//	DELETE browser/bucket/key                  the object
+//	POST   browser/bucket/key?uploads          the new multipart upload
+//	PUT    browser/bucket/key?uploadId=&partNumber=  the part
+//	POST   browser/bucket/key?uploadId=        the completion
+//	DELETE browser/bucket/key?uploadId=        the abort
+func BrowserApi(writer http.ResponseWriter, request *http.Request) {
+	if !strings.HasPrefix(request.URL.Path, BROWSER_API_PATH) {
+		writeJsonError(writer, http.StatusNotFound, "no such api: "+request.URL.Path)
+		return
+	}
+	bucketName, objectKey, _ := strings.Cut(strings.TrimPrefix(request.URL.Path, BROWSER_API_PATH), "/")
+	if (bucketName != "" && !ValidBucketName(bucketName)) || !validObjectKey(objectKey) {
+		writeJsonError(writer, http.StatusBadRequest, "invalid bucket or key: "+bucketName+"/"+objectKey)
+		return
+	}
+	parsedQuery, err := url.ParseQuery(request.URL.RawQuery)
+	if err != nil {
+		writeJsonError(writer, http.StatusBadRequest, err.Error())
+		return
+	}
+	storage := storageOf(request)
+	if !hasAdminToken(request) {
+		if err := authorize(request, &storage, bucketName, objectKey, browserActionOf(request, objectKey, parsedQuery)); err != nil {
+			writeBrowserError(writer, err)
+			return
+		}
+	}
+
+	if request.Method != "GET" && request.Method != "HEAD" {
+		if bucketName == "" || objectKey == "" {
+			writeJsonError(writer, http.StatusMethodNotAllowed, "the objects are modified by the browser, the buckets are not")
+			return
+		}
+		if err := storage.CheckWritable(bucketName); err != nil {
+			writeBrowserError(writer, err)
+			return
+		}
+	}
+
+	switch {
+	case bucketName == "":
+		browseBuckets(writer, &storage)
+	case objectKey == "":
+		browseObjects(writer, &storage, bucketName, parsedQuery)
+	case parsedQuery.Has("uploads") || parsedQuery.Has("uploadId"):
+		browseUpload(writer, request, &storage, bucketName, objectKey, parsedQuery)
+	case request.Method == "GET" && parsedQuery.Has("metadata"):
+		browseMetadata(writer, &storage, bucketName, objectKey)
+	case request.Method == "GET" || request.Method == "HEAD":
+		browseContent(writer, request, &storage, bucketName, objectKey, parsedQuery.Has("download"))
+	case request.Method == "PUT" && parsedQuery.Has("tags"):
+		browseTags(writer, request, &storage, bucketName, objectKey)
+	case request.Method == "DELETE":
+		err := storage.CheckObjectLock(bucketName, objectKey, bypassGovernance(request), time.Now())
+		if err == nil {
+			err = storage.Delete(bucketName, objectKey)
+		}
+		if err != nil {
+			writeBrowserError(writer, err)
+			return
+		}
+		notify(request, &storage, bucketName, objectKey, EventObjectRemovedDelete)
+		writer.WriteHeader(http.StatusNoContent)
+	default:
+		writeJsonError(writer, http.StatusMethodNotAllowed, fmt.Sprintf("%s is not supported by the browser", request.Method))
+	}
+}
+
+func browseBuckets(writer http.ResponseWriter, storage *Storage) {
+	buckets, err := storage.ListBuckets()
+	if err != nil {
+		writeBrowserError(writer, err)
+		return
+	}
+	result := make([]models.BrowserBucket, len(buckets))
+	for i, bucket := range buckets {
+		result[i] = models.BrowserBucket{Name: bucket.Name, CreationDate: bucket.CreationDate}
+	}
+	writeJson(writer, http.StatusOK, result)
+}
+
+// browseObjects lists one page of the objects, the objects are grouped by
+// the "/" delimiter unless other delimiter is given.
+func browseObjects(writer http.ResponseWriter, storage *Storage, bucketName string, parsedQuery url.Values) {
+	query := listingQuery{
+		Prefix:    parsedQuery.Get("prefix"),
+		Delimiter: "/",
+		KeyMarker: parsedQuery.Get("marker"),
+		MaxKeys:   DEFAULT_MAX_KEYS,
+	}
+	if parsedQuery.Has("delimiter") {
+		query.Delimiter = parsedQuery.Get("delimiter")
+	}
+	if value := parsedQuery.Get("max-keys"); value != "" {
+		maxKeys, err := strconv.Atoi(value)
+		if err != nil || maxKeys < 1 || maxKeys > DEFAULT_MAX_KEYS {
+			writeJsonError(writer, http.StatusBadRequest, fmt.Sprintf("max-keys must be an integer between 1 and %d", DEFAULT_MAX_KEYS))
+			return
+		}
+		query.MaxKeys = maxKeys
+	}
+
+	if err := storage.CheckBucket(bucketName); err != nil {
+		writeBrowserError(writer, err)
+		return
+	}
+	objects, err := storage.ListObjects(bucketName)
+	if err != nil {
+		writeBrowserError(writer, err)
+		return
+	}
+	page := listingPageOf(objects, query)
+	listing := &models.BrowserListing{
+		Bucket:      bucketName,
+		Prefix:      query.Prefix,
+		Delimiter:   query.Delimiter,
+		Prefixes:    page.CommonPrefixes,
+		Objects:     make([]models.BrowserObject, len(page.Entries)),
+		IsTruncated: page.IsTruncated,
+	}
+	if page.IsTruncated {
+		listing.NextMarker = page.NextKeyMarker
+	}
+	for i, object := range page.Entries {
+		listing.Objects[i] = models.BrowserObject{
+			Key:          object.Key,
+			Size:         int64(object.Size),
+			ETag:         object.ETag,
+			LastModified: object.LastModified,
+		}
+	}
+	writeJson(writer, http.StatusOK, listing)
+}
+
+func browseMetadata(writer http.ResponseWriter, storage *Storage, bucketName string, objectKey string) {
+	info, err := storage.Stat(bucketName, objectKey)
+	if err != nil {
+		writeBrowserError(writer, err)
+		return
+	}
+	object := &models.BrowserObject{
+		Key:          objectKey,
+		Size:         info.Size,
+		ETag:         info.ETag,
+		LastModified: info.LastModified,
+	}
+	if metadata := info.Metadata; metadata != nil {
+		object.ContentType = metadata.ContentType
+		object.Tags = metadata.Tags
+		object.Retention = metadata.Retention
+		object.LegalHold = metadata.LegalHold
+		object.Compression = metadata.Compression
+		if metadata.Encryption != nil {
+			object.Encryption = metadata.Encryption.Algorithm
+		}
+	}
+	writeJson(writer, http.StatusOK, object)
+}
+
+// browseContent serves the object content for the preview or, as the
+// attachment, for the download.
+func browseContent(writer http.ResponseWriter, request *http.Request, storage *Storage, bucketName string, objectKey string, download bool) {
+	sse, err := serverSideEncryptionOf(request)
+	if err != nil {
+		writeJsonError(writer, http.StatusBadRequest, err.Error())
+		return
+	}
+	reader, err := storage.OpenObject(bucketName, objectKey, sse)
+	if err != nil {
+		writeBrowserError(writer, err)
+		return
+	}
+	defer reader.Close()
+
+	if reader.Metadata.ContentType != "" {
+		writer.Header().Set("Content-Type", reader.Metadata.ContentType)
+	}
+	if download {
+		writer.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(objectKey)}))
+	}
+	http.ServeContent(writer, request, objectKey, reader.LastModified, reader)
+}
+
+func browseTags(writer http.ResponseWriter, request *http.Request, storage *Storage, bucketName string, objectKey string) {
+	values := map[string]string{}
+	if err := json.NewDecoder(request.Body).Decode(&values); err != nil {
+		writeJsonError(writer, http.StatusBadRequest, err.Error())
+		return
+	}
+	tagSet := make([]models.Tag, 0, len(values))
+	for key, value := range values {
+		tagSet = append(tagSet, models.Tag{Key: key, Value: value})
+	}
+	tags, err := tagsOf(tagSet, models.MaxObjectTags)
+	if err != nil {
+		writeJsonError(writer, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := storage.PutObjectTags(bucketName, objectKey, tags); err != nil {
+		writeBrowserError(writer, err)
+		return
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+// browseUpload creates, fills, completes and aborts the multipart uploads of
+// the dropped files.
+func browseUpload(writer http.ResponseWriter, request *http.Request, storage *Storage, bucketName string, objectKey string, parsedQuery url.Values) {
+	sse, err := serverSideEncryptionOf(request)
+	if err != nil {
+		writeJsonError(writer, http.StatusBadRequest, err.Error())
+		return
+	}
+	uploadId := parsedQuery.Get("uploadId")
+
+	switch {
+	case request.Method == "POST" && parsedQuery.Has("uploads"):
+		if err := storage.CheckBucket(bucketName); err != nil {
+			writeBrowserError(writer, err)
+			return
+		}
+		metadata := &models.ObjectMetadata{ContentType: request.Header.Get("Content-Type")}
+		if err := storage.objectLockOfHeaders(request, bucketName, metadata, time.Now()); err != nil {
+			writeBrowserError(writer, err)
+			return
+		}
+		upload := &models.BrowserUpload{Bucket: bucketName, Key: objectKey, UploadId: newUploadSuffix()}
+		if err := storage.CreateUpload(bucketName, objectKey, upload.UploadId, metadata, sse); err != nil {
+			writeBrowserError(writer, err)
+			return
+		}
+		writeJson(writer, http.StatusOK, upload)
+
+	case request.Method == "PUT":
+		partNumber, err := strconv.Atoi(parsedQuery.Get("partNumber"))
+		if err != nil || partNumber < 1 || partNumber > 10000 {
+			writeJsonError(writer, http.StatusBadRequest, "part number must be an integer between 1 and 10000")
+			return
+		}
+		etag, err := storage.PushPart(bucketName, objectKey, uploadId, partNumber, declaredBodyOf(request), sse)
+		if err != nil {
+			writeBrowserError(writer, err)
+			return
+		}
+		writeJson(writer, http.StatusOK, &models.BrowserPart{PartNumber: partNumber, ETag: etag})
+
+	case request.Method == "POST":
+		completion := models.BrowserCompletion{}
+		if request.ContentLength != 0 {
+			if err := json.NewDecoder(request.Body).Decode(&completion); err != nil {
+				writeJsonError(writer, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+		uploadDone := UploadDone{Parts: make([]models.XmlPart, len(completion.Parts))}
+		for i, part := range completion.Parts {
+			uploadDone.Parts[i] = models.XmlPart{PartNumber: part.PartNumber, ETag: part.ETag}
+		}
+		err := storage.CheckObjectLock(bucketName, objectKey, bypassGovernance(request), time.Now())
+		if err == nil {
+			err = storage.CompleteUpload(bucketName, objectKey, uploadId, uploadDone, sse)
+		}
+		if err != nil {
+			writeBrowserError(writer, err)
+			return
+		}
+		notify(request, storage, bucketName, objectKey, EventObjectCreatedCompleteMultipartUpload)
+		browseMetadata(writer, storage, bucketName, objectKey)
+
+	case request.Method == "DELETE":
+		if err := storage.DeleteUpload(bucketName, objectKey, uploadId); err != nil {
+			writeBrowserError(writer, err)
+			return
+		}
+		writer.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeJsonError(writer, http.StatusMethodNotAllowed, fmt.Sprintf("%s is not supported by the multipart upload", request.Method))
+	}
+}
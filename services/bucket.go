@@ -0,0 +1,112 @@
+/**
+ * Author: Vanya Usalko <ivict@rambler.ru>
+ * File: bucket.go
+ */
+
+package services
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/usalko/s2d3/models"
+)
+
+const CodeBucketAlreadyOwnedByYou = "BucketAlreadyOwnedByYou"
+const CodeInvalidBucketName = "InvalidBucketName"
+const CodeBucketNotEmpty = "BucketNotEmpty"
+
+var ErrBucketAlreadyExists = errors.New("the bucket already exists")
+var ErrBucketNotEmpty = errors.New("the bucket is not empty")
+
+var bucketNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+
+// ValidBucketName checks the name is the S3 bucket name, such names are kept
+// in the folders of the data folder without escaping it.
+func ValidBucketName(bucketName string) bool {
+	return bucketNamePattern.MatchString(bucketName)
+}
+
+// validObjectKey refuses the keys escaping the bucket folder (the absolute
+// keys and the keys of the "." and ".." segments) and the keys of the system
+// folder.
+func validObjectKey(objectKey string) bool {
+	return containedPath(objectKey)
+}
+
+// BucketRemover is implemented by the backends which can delete the buckets.
+type BucketRemover interface {
+	// DeleteBucket removes the empty bucket with its configurations
+	DeleteBucket(bucketName string) error
+}
+
+// DeleteBucket removes the bucket without objects and multipart uploads.
+func (storage *Storage) DeleteBucket(bucketName string) error {
+	if err := storage.CheckBucket(bucketName); err != nil {
+		return err
+	}
+	if err := storage.CheckWritable(bucketName); err != nil {
+		return err
+	}
+	objects, err := storage.ListObjects(bucketName)
+	if err != nil {
+		return err
+	}
+	uploads, err := storage.ListUploads(bucketName)
+	if err != nil {
+		return err
+	}
+	if len(objects) > 0 || len(uploads) > 0 {
+		return ErrBucketNotEmpty
+	}
+
+	remover, exists := CapabilityOf[BucketRemover](storage.Backend)
+	if !exists {
+		return fmt.Errorf("the backend can't delete the buckets")
+	}
+	if err := remover.DeleteBucket(bucketName); err != nil {
+		return err
+	}
+	forgetUsage(storage.Backend, bucketName)
+	forgetBucketLogging(storage.Backend, bucketName)
+	return nil
+}
+
+// CreateBucket implements CreateBucket, the object lock is enabled for the
+// bucket by the x-amz-bucket-object-lock-enabled header.
+func CreateBucket(writer http.ResponseWriter, request *http.Request) {
+	bucketName, _ := bucketNameAndObjectKey(request.URL.Path, request.Context().Value(KeyUrlContext).(string))
+	if !bucketNamePattern.MatchString(bucketName) {
+		writeError(writer, http.StatusBadRequest, CodeInvalidBucketName, "the specified bucket is not valid")
+		return
+	}
+
+	storage := storageOf(request)
+	err := storage.CreateBucket(bucketName)
+	if errors.Is(err, ErrBucketAlreadyExists) {
+		writeError(writer, http.StatusConflict, CodeBucketAlreadyOwnedByYou, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(writer, http.StatusInternalServerError, CodeInternalError, err.Error())
+		return
+	}
+
+	if strings.EqualFold(request.Header.Get(OBJECT_LOCK_ENABLED_HEADER), "true") {
+		data, err := xml.Marshal(&models.ObjectLockConfiguration{
+			ObjectLockEnabled: models.ObjectLockEnabled,
+		})
+		if err == nil {
+			err = storage.PutBucketConfig(bucketName, OBJECT_LOCK_CONFIG, data)
+		}
+		if err != nil {
+			writeError(writer, http.StatusInternalServerError, CodeInternalError, err.Error())
+			return
+		}
+	}
+	writer.Header().Set("Location", "/"+bucketName)
+}
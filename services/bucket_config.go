@@ -0,0 +1,13 @@
+/**
+ * Author: Vanya Usalko <ivict@rambler.ru>
+ * File: bucket_config.go
+ */
+
+package services
+
+import (
+	"errors"
+)
+
+var ErrNoSuchBucket = errors.New("the specified bucket does not exist")
+var ErrNoSuchConfiguration = errors.New("the bucket configuration does not exist")
@@ -0,0 +1,165 @@
+/**
+ * Author: Vanya Usalko <ivict@rambler.ru>
+ * File: compressed_object.go
+ */
+
+package services
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Compressed objects are stored as a sequence of chunks, every chunk is the
+// separately deflated COMPRESSION_CHUNK_SIZE bytes of the content (the last
+// one may be shorter). The chunks are followed by the offsets of the chunks
+// and the footer with the content size and the count of chunks, so the chunk
+// of any offset is inflated without reading the preceding ones.
+const COMPRESSION_CHUNK_SIZE = 64 * 1024
+const COMPRESSION_FOOTER_SIZE = 16
+
+func compressChunks(content []byte) ([]byte, error) {
+	var compressed bytes.Buffer
+	writer, err := flate.NewWriter(&compressed, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+
+	offsets := make([]uint64, 0, len(content)/COMPRESSION_CHUNK_SIZE+1)
+	for start := 0; start < len(content); start += COMPRESSION_CHUNK_SIZE {
+		offsets = append(offsets, uint64(compressed.Len()))
+		writer.Reset(&compressed)
+		if _, err := writer.Write(content[start:min(len(content), start+COMPRESSION_CHUNK_SIZE)]); err != nil {
+			return nil, err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, offset := range offsets {
+		compressed.Write(binary.BigEndian.AppendUint64(nil, offset))
+	}
+	compressed.Write(binary.BigEndian.AppendUint64(nil, uint64(len(content))))
+	compressed.Write(binary.BigEndian.AppendUint64(nil, uint64(len(offsets))))
+	return compressed.Bytes(), nil
+}
+
+// decompressingReader reads the content of the compressed data, it inflates
+// only the chunks of the requested range.
+type decompressingReader struct {
+	source  io.ReadSeekCloser
+	size    int64
+	offsets []int64
+	offset  int64
+
+	chunkIndex int
+	chunk      []byte
+}
+
+func newDecompressingReader(source io.ReadSeekCloser) (*decompressingReader, error) {
+	sourceSize, err := source.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if sourceSize < COMPRESSION_FOOTER_SIZE {
+		return nil, fmt.Errorf("the compressed data is truncated")
+	}
+	footer := make([]byte, COMPRESSION_FOOTER_SIZE)
+	if _, err := source.Seek(sourceSize-COMPRESSION_FOOTER_SIZE, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(source, footer); err != nil {
+		return nil, err
+	}
+	size := int64(binary.BigEndian.Uint64(footer))
+	count := int64(binary.BigEndian.Uint64(footer[8:]))
+
+	if size < 0 || count < 0 || count > (sourceSize-COMPRESSION_FOOTER_SIZE)/8 {
+		return nil, fmt.Errorf("the compressed data is truncated")
+	}
+	indexOffset := sourceSize - COMPRESSION_FOOTER_SIZE - count*8
+	index := make([]byte, count*8)
+	if _, err := source.Seek(indexOffset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(source, index); err != nil {
+		return nil, err
+	}
+	offsets := make([]int64, count+1)
+	for i := int64(0); i < count; i++ {
+		offsets[i] = int64(binary.BigEndian.Uint64(index[i*8:]))
+	}
+	offsets[count] = indexOffset
+
+	return &decompressingReader{
+		source:     source,
+		size:       size,
+		offsets:    offsets,
+		chunkIndex: -1,
+	}, nil
+}
+
+func (reader *decompressingReader) loadChunk(index int) error {
+	if reader.chunkIndex == index {
+		return nil
+	}
+	if index >= len(reader.offsets)-1 || reader.offsets[index] > reader.offsets[index+1] {
+		return fmt.Errorf("the compressed chunk %d is missing", index)
+	}
+	compressed := make([]byte, reader.offsets[index+1]-reader.offsets[index])
+	if _, err := reader.source.Seek(reader.offsets[index], io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(reader.source, compressed); err != nil {
+		return err
+	}
+	chunk, err := io.ReadAll(flate.NewReader(bytes.NewReader(compressed)))
+	if err != nil {
+		return fmt.Errorf("the compressed chunk %d is corrupted: %w", index, err)
+	}
+	reader.chunkIndex = index
+	reader.chunk = chunk
+	return nil
+}
+
+func (reader *decompressingReader) Read(buffer []byte) (int, error) {
+	if reader.offset >= reader.size {
+		return 0, io.EOF
+	}
+	index := int(reader.offset / COMPRESSION_CHUNK_SIZE)
+	if err := reader.loadChunk(index); err != nil {
+		return 0, err
+	}
+	chunkOffset := reader.offset - int64(index)*COMPRESSION_CHUNK_SIZE
+	if chunkOffset >= int64(len(reader.chunk)) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	n := copy(buffer, reader.chunk[chunkOffset:])
+	reader.offset += int64(n)
+	return n, nil
+}
+
+func (reader *decompressingReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += reader.offset
+	case io.SeekEnd:
+		offset += reader.size
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("negative position %d", offset)
+	}
+	reader.offset = offset
+	return offset, nil
+}
+
+func (reader *decompressingReader) Close() error {
+	return reader.source.Close()
+}
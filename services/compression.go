@@ -0,0 +1,134 @@
+/**
+ * Author: Vanya Usalko <ivict@rambler.ru>
+ * File: compression.go
+ */
+
+package services
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/usalko/s2d3/models"
+)
+
+const COMPRESSION_CONFIG = "compression"
+
+const CodeNoSuchCompressionConfiguration = "NoSuchCompressionConfiguration"
+
+// GetCompressionConfiguration returns nil for buckets without compression.
+func (storage *Storage) GetCompressionConfiguration(bucketName string) (*models.CompressionConfiguration, error) {
+	data, err := storage.GetBucketConfig(bucketName, COMPRESSION_CONFIG)
+	if errors.Is(err, ErrNoSuchConfiguration) || errors.Is(err, ErrNoSuchBucket) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	config := models.CompressionConfiguration{}
+	if err := xml.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// compressionMatches checks the media type of the object against the content
+// types of the configuration and the object key against the extensions.
+func compressionMatches(config *models.CompressionConfiguration, objectKey string, contentType string) bool {
+	if len(config.ContentTypes) == 0 && len(config.Extensions) == 0 {
+		return true
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err == nil && slices.ContainsFunc(config.ContentTypes, func(pattern string) bool {
+		return wildcardMatches(strings.ToLower(pattern), mediaType)
+	}) {
+		return true
+	}
+	extension := strings.ToLower(path.Ext(objectKey))
+	return extension != "" && slices.ContainsFunc(config.Extensions, func(configured string) bool {
+		return "."+strings.TrimPrefix(strings.ToLower(configured), ".") == extension
+	})
+}
+
+// compressionOf returns whether the new object of the bucket is compressed.
+func (storage *Storage) compressionOf(bucketName string, objectKey string, contentType string) (bool, error) {
+	config, err := storage.GetCompressionConfiguration(bucketName)
+	if err != nil || config == nil {
+		return false, err
+	}
+	return compressionMatches(config, objectKey, contentType), nil
+}
+
+func validateCompression(config *models.CompressionConfiguration) error {
+	for _, contentType := range config.ContentTypes {
+		if strings.Count(contentType, "*") > 1 || !strings.Contains(contentType, "/") {
+			return fmt.Errorf("the content type %s must be the media type with at most one wildcard", contentType)
+		}
+	}
+	for _, extension := range config.Extensions {
+		if strings.TrimPrefix(extension, ".") == "" || strings.ContainsAny(extension, "/*") {
+			return fmt.Errorf("the extension %s is not valid", extension)
+		}
+	}
+	return nil
+}
+
+// Compression implements PUT, GET and DELETE of the bucket compression at rest,
+// the objects stored before the change are kept as they are.
+func Compression(writer http.ResponseWriter, request *http.Request) {
+	bucketName, _ := bucketNameAndObjectKey(request.URL.Path, request.Context().Value(KeyUrlContext).(string))
+	storage := storageOf(request)
+
+	switch request.Method {
+
+	case "GET":
+		data, err := storage.GetBucketConfig(bucketName, COMPRESSION_CONFIG)
+		if err != nil {
+			writeBucketConfigError(writer, err, CodeNoSuchCompressionConfiguration)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/xml")
+		writer.Write(data)
+
+	case "PUT":
+		body, err := io.ReadAll(request.Body)
+		if err != nil {
+			writeError(writer, http.StatusBadRequest, CodeInvalidArgument, err.Error())
+			return
+		}
+		config := models.CompressionConfiguration{}
+		if err := xml.Unmarshal(body, &config); err != nil {
+			writeError(writer, http.StatusBadRequest, CodeMalformedXML, err.Error())
+			return
+		}
+		if err := validateCompression(&config); err != nil {
+			writeError(writer, http.StatusBadRequest, CodeInvalidArgument, err.Error())
+			return
+		}
+		data, err := xml.Marshal(&config)
+		if err == nil {
+			err = storage.PutBucketConfig(bucketName, COMPRESSION_CONFIG, data)
+		}
+		if err != nil {
+			writeBucketConfigError(writer, err, CodeNoSuchCompressionConfiguration)
+			return
+		}
+
+	case "DELETE":
+		if err := storage.DeleteBucketConfig(bucketName, COMPRESSION_CONFIG); err != nil {
+			writeBucketConfigError(writer, err, CodeNoSuchCompressionConfiguration)
+			return
+		}
+		writer.WriteHeader(http.StatusNoContent)
+
+	}
+}
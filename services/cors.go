@@ -0,0 +1,248 @@
+/**
+ * Author: Vanya Usalko <ivict@rambler.ru>
+ * File: cors.go
+ */
+
+package services
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/usalko/s2d3/models"
+)
+
+const CORS_CONFIG = "cors"
+const MAX_CORS_RULES = 100
+
+const CodeNoSuchCORSConfiguration = "NoSuchCORSConfiguration"
+const CodeAccessForbidden = "AccessForbidden"
+
+var CORS_METHODS = []string{"GET", "PUT", "HEAD", "POST", "DELETE"}
+
+// GetCorsConfiguration returns nil for buckets without CORS configuration.
+func (storage *Storage) GetCorsConfiguration(bucketName string) (*models.CORSConfiguration, error) {
+	data, err := storage.GetBucketConfig(bucketName, CORS_CONFIG)
+	if errors.Is(err, ErrNoSuchConfiguration) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	config := models.CORSConfiguration{}
+	if err := xml.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+func validateCors(config *models.CORSConfiguration) error {
+	if len(config.Rules) == 0 || len(config.Rules) > MAX_CORS_RULES {
+		return fmt.Errorf("the CORS configuration must have from 1 to %d rules", MAX_CORS_RULES)
+	}
+
+	for _, rule := range config.Rules {
+		if len(rule.AllowedOrigins) == 0 || len(rule.AllowedMethods) == 0 {
+			return fmt.Errorf("the CORS rule must have at least one allowed origin and method")
+		}
+		for _, origin := range rule.AllowedOrigins {
+			if strings.Count(origin, "*") > 1 {
+				return fmt.Errorf("the allowed origin %s can't have more than one wildcard", origin)
+			}
+		}
+		for _, method := range rule.AllowedMethods {
+			if !slices.Contains(CORS_METHODS, method) {
+				return fmt.Errorf("the CORS method %s is not supported", method)
+			}
+		}
+		for _, header := range rule.AllowedHeaders {
+			if strings.Count(header, "*") > 1 {
+				return fmt.Errorf("the allowed header %s can't have more than one wildcard", header)
+			}
+		}
+		if rule.MaxAgeSeconds != nil && *rule.MaxAgeSeconds < 0 {
+			return fmt.Errorf("the max age seconds must be a positive integer")
+		}
+	}
+	return nil
+}
+
+// wildcardMatches checks the value against the pattern with at most one "*".
+func wildcardMatches(pattern string, value string) bool {
+	prefix, suffix, found := strings.Cut(pattern, "*")
+	if !found {
+		return pattern == value
+	}
+	return len(value) >= len(prefix)+len(suffix) && strings.HasPrefix(value, prefix) && strings.HasSuffix(value, suffix)
+}
+
+// corsRuleOf returns the first rule which allows the origin, the method and
+// all the request headers.
+func corsRuleOf(config *models.CORSConfiguration, origin string, method string, requestHeaders []string) *models.CORSRule {
+	for i := range config.Rules {
+		rule := &config.Rules[i]
+		if !slices.Contains(rule.AllowedMethods, method) {
+			continue
+		}
+		if !slices.ContainsFunc(rule.AllowedOrigins, func(pattern string) bool { return wildcardMatches(pattern, origin) }) {
+			continue
+		}
+		allowed := true
+		for _, header := range requestHeaders {
+			if !slices.ContainsFunc(rule.AllowedHeaders, func(pattern string) bool {
+				return wildcardMatches(strings.ToLower(pattern), strings.ToLower(header))
+			}) {
+				allowed = false
+				break
+			}
+		}
+		if allowed {
+			return rule
+		}
+	}
+	return nil
+}
+
+func setCorsHeaders(writer http.ResponseWriter, rule *models.CORSRule, origin string) {
+	header := writer.Header()
+	if slices.Contains(rule.AllowedOrigins, "*") {
+		header.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		header.Set("Access-Control-Allow-Origin", origin)
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+	header.Set("Access-Control-Allow-Methods", strings.Join(rule.AllowedMethods, ", "))
+	if len(rule.ExposeHeaders) > 0 {
+		header.Set("Access-Control-Expose-Headers", strings.Join(rule.ExposeHeaders, ", "))
+	}
+	header.Add("Vary", "Origin")
+}
+
+// requestHeadersOf parses the Access-Control-Request-Headers of the preflight.
+func requestHeadersOf(request *http.Request) []string {
+	requestHeaders := make([]string, 0)
+	for _, value := range request.Header.Values("Access-Control-Request-Headers") {
+		for _, header := range strings.Split(value, ",") {
+			if strings.TrimSpace(header) != "" {
+				requestHeaders = append(requestHeaders, strings.TrimSpace(header))
+			}
+		}
+	}
+	return requestHeaders
+}
+
+// Cors implements PUT, GET and DELETE of the bucket CORS configuration.
+func Cors(writer http.ResponseWriter, request *http.Request) {
+	bucketName, _ := bucketNameAndObjectKey(request.URL.Path, request.Context().Value(KeyUrlContext).(string))
+	storage := storageOf(request)
+
+	switch request.Method {
+
+	case "GET":
+		data, err := storage.GetBucketConfig(bucketName, CORS_CONFIG)
+		if err != nil {
+			writeBucketConfigError(writer, err, CodeNoSuchCORSConfiguration)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/xml")
+		writer.Write(data)
+
+	case "PUT":
+		body, err := io.ReadAll(request.Body)
+		if err != nil {
+			writeError(writer, http.StatusBadRequest, CodeInvalidArgument, err.Error())
+			return
+		}
+		config := models.CORSConfiguration{}
+		if err := xml.Unmarshal(body, &config); err != nil {
+			writeError(writer, http.StatusBadRequest, CodeMalformedXML, err.Error())
+			return
+		}
+		if err := validateCors(&config); err != nil {
+			writeError(writer, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+			return
+		}
+		data, err := xml.Marshal(&config)
+		if err == nil {
+			err = storage.PutBucketConfig(bucketName, CORS_CONFIG, data)
+		}
+		if err != nil {
+			writeBucketConfigError(writer, err, CodeNoSuchCORSConfiguration)
+			return
+		}
+
+	case "DELETE":
+		if err := storage.DeleteBucketConfig(bucketName, CORS_CONFIG); err != nil {
+			writeBucketConfigError(writer, err, CodeNoSuchCORSConfiguration)
+			return
+		}
+		writer.WriteHeader(http.StatusNoContent)
+
+	}
+}
+
+// Preflight answers the OPTIONS request of the browser with the matching
+// CORS rule of the bucket.
+func Preflight(writer http.ResponseWriter, request *http.Request) {
+	origin := request.Header.Get("Origin")
+	method := request.Header.Get("Access-Control-Request-Method")
+	if origin == "" || method == "" {
+		writeError(writer, http.StatusBadRequest, CodeInvalidRequest, "the Origin and Access-Control-Request-Method headers are required")
+		return
+	}
+
+	bucketName, _ := bucketNameAndObjectKey(request.URL.Path, request.Context().Value(KeyUrlContext).(string))
+	storage := storageOf(request)
+	config, err := storage.GetCorsConfiguration(bucketName)
+	if err != nil && !errors.Is(err, ErrNoSuchBucket) {
+		writeError(writer, http.StatusInternalServerError, CodeInternalError, err.Error())
+		return
+	}
+
+	requestHeaders := requestHeadersOf(request)
+	var rule *models.CORSRule
+	if config != nil {
+		rule = corsRuleOf(config, origin, method, requestHeaders)
+	}
+	if rule == nil {
+		writeError(writer, http.StatusForbidden, CodeAccessForbidden, "CORSResponse: this CORS request is not allowed")
+		return
+	}
+
+	setCorsHeaders(writer, rule, origin)
+	if len(requestHeaders) > 0 {
+		writer.Header().Set("Access-Control-Allow-Headers", strings.Join(requestHeaders, ", "))
+	}
+	if rule.MaxAgeSeconds != nil {
+		writer.Header().Set("Access-Control-Max-Age", strconv.Itoa(*rule.MaxAgeSeconds))
+	}
+	writer.Header().Add("Vary", "Access-Control-Request-Headers")
+	writer.Header().Add("Vary", "Access-Control-Request-Method")
+}
+
+// applyCors adds the Access-Control headers to the response of the request
+// sent by a browser when a CORS rule of the bucket matches.
+func applyCors(writer http.ResponseWriter, request *http.Request) {
+	origin := request.Header.Get("Origin")
+	if origin == "" {
+		return
+	}
+
+	bucketName, _ := bucketNameAndObjectKey(request.URL.Path, request.Context().Value(KeyUrlContext).(string))
+	storage := storageOf(request)
+	config, err := storage.GetCorsConfiguration(bucketName)
+	if err != nil || config == nil {
+		return
+	}
+	rule := corsRuleOf(config, origin, request.Method, nil)
+	if rule != nil {
+		setCorsHeaders(writer, rule, origin)
+	}
+}
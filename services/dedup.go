@@ -0,0 +1,144 @@
+/**
+ * Author: Vanya Usalko <ivict@rambler.ru>
+ * File: dedup.go
+ */
+
+package services
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/usalko/s2d3/models"
+)
+
+const DEDUP_CONFIG = "dedup"
+
+const CodeNoSuchDedupConfiguration = "NoSuchDedupConfiguration"
+
+// DEFAULT_DEDUP_SEGMENT_SIZE is the segment size of the dedup configuration
+// without SegmentSize.
+var DEFAULT_DEDUP_SEGMENT_SIZE = int64(BREAKPOINTS[2])
+
+// Deduplicator is implemented by the backends keeping the objects in the
+// content addressed segments.
+type Deduplicator interface {
+	// CollectSegments removes the segments which are not referenced by any
+	// object, it returns the count of the removed segments and their size.
+	CollectSegments() (int64, int64, error)
+	DedupStatistics() (*models.DedupStatistics, error)
+}
+
+// GetDedupConfiguration returns nil for buckets without the deduplication.
+func (storage *Storage) GetDedupConfiguration(bucketName string) (*models.DedupConfiguration, error) {
+	return dedupConfiguration(storage.Backend, bucketName)
+}
+
+func dedupConfiguration(backend Backend, bucketName string) (*models.DedupConfiguration, error) {
+	data, err := backend.GetBucketConfig(bucketName, DEDUP_CONFIG)
+	if errors.Is(err, ErrNoSuchConfiguration) || errors.Is(err, ErrNoSuchBucket) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	config := models.DedupConfiguration{}
+	if err := xml.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+	if config.SegmentSize == 0 {
+		config.SegmentSize = DEFAULT_DEDUP_SEGMENT_SIZE
+	}
+	return &config, nil
+}
+
+// validateDedup accepts the breakpoints up to 4 MiB as the segment size.
+func validateDedup(config *models.DedupConfiguration) error {
+	if config.SegmentSize == 0 {
+		return nil
+	}
+	for _, breakpoint := range BREAKPOINTS[:4] {
+		if config.SegmentSize == int64(breakpoint) {
+			return nil
+		}
+	}
+	return fmt.Errorf("the segment size %d must be one of %v", config.SegmentSize, BREAKPOINTS[:4])
+}
+
+// Dedup implements PUT, GET and DELETE of the bucket deduplication, the
+// objects stored before the change are kept as they are until they are
+// rewritten.
+func Dedup(writer http.ResponseWriter, request *http.Request) {
+	bucketName, _ := bucketNameAndObjectKey(request.URL.Path, request.Context().Value(KeyUrlContext).(string))
+	storage := storageOf(request)
+
+	switch request.Method {
+
+	case "GET":
+		data, err := storage.GetBucketConfig(bucketName, DEDUP_CONFIG)
+		if err != nil {
+			writeBucketConfigError(writer, err, CodeNoSuchDedupConfiguration)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/xml")
+		writer.Write(data)
+
+	case "PUT":
+		body, err := io.ReadAll(request.Body)
+		if err != nil {
+			writeError(writer, http.StatusBadRequest, CodeInvalidArgument, err.Error())
+			return
+		}
+		config := models.DedupConfiguration{}
+		if err := xml.Unmarshal(body, &config); err != nil {
+			writeError(writer, http.StatusBadRequest, CodeMalformedXML, err.Error())
+			return
+		}
+		if err := validateDedup(&config); err != nil {
+			writeError(writer, http.StatusBadRequest, CodeInvalidArgument, err.Error())
+			return
+		}
+		data, err := xml.Marshal(&config)
+		if err == nil {
+			err = storage.PutBucketConfig(bucketName, DEDUP_CONFIG, data)
+		}
+		if err != nil {
+			writeBucketConfigError(writer, err, CodeNoSuchDedupConfiguration)
+			return
+		}
+
+	case "DELETE":
+		if err := storage.DeleteBucketConfig(bucketName, DEDUP_CONFIG); err != nil {
+			writeBucketConfigError(writer, err, CodeNoSuchDedupConfiguration)
+			return
+		}
+		writer.WriteHeader(http.StatusNoContent)
+
+	}
+}
+
+// DedupStats reports the segments stored by the service, the segments are
+// shared by all buckets so the statistics are not per bucket.
+func DedupStats(writer http.ResponseWriter, request *http.Request) {
+	statistics := &models.DedupStatistics{}
+	if deduplicator, exists := CapabilityOf[Deduplicator](backendOf(request)); exists {
+		var err error
+		statistics, err = deduplicator.DedupStatistics()
+		if err != nil {
+			writeError(writer, http.StatusInternalServerError, CodeInternalError, err.Error())
+			return
+		}
+	}
+
+	data, err := xml.Marshal(statistics)
+	if err != nil {
+		writeError(writer, http.StatusInternalServerError, CodeInternalError, err.Error())
+		return
+	}
+	writer.Header().Set("Content-Type", "application/xml")
+	writer.Write(data)
+}
@@ -0,0 +1,58 @@
+/**
+ * Author: Vanya Usalko <ivict@rambler.ru>
+ * File: dedup_worker.go
+ */
+
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const DEFAULT_DEDUP_COLLECTION_INTERVAL = time.Hour
+
+// DedupWorker collects the unreferenced segments periodically.
+type DedupWorker struct {
+	Storage  Storage
+	Interval time.Duration
+}
+
+func (worker *DedupWorker) Run(ctx context.Context) {
+	interval := worker.Interval
+	if interval <= 0 {
+		interval = DEFAULT_DEDUP_COLLECTION_INTERVAL
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	health := startWorkerHealth("dedup-collection", interval)
+	defer health.stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		err := worker.Collect()
+		if err != nil {
+			fmt.Printf("[dedup] %s\n", err)
+		}
+		health.ran(err)
+	}
+}
+
+// Collect removes the unreferenced segments once, the backends without the
+// segments are skipped.
+func (worker *DedupWorker) Collect() error {
+	deduplicator, exists := CapabilityOf[Deduplicator](worker.Storage.Backend)
+	if !exists {
+		return nil
+	}
+	removed, reclaimed, err := deduplicator.CollectSegments()
+	if removed > 0 {
+		fmt.Printf("[dedup] %d unreferenced segments are removed, %d bytes reclaimed\n", removed, reclaimed)
+	}
+	return err
+}
@@ -0,0 +1,69 @@
+/**
+ * Author: Vanya Usalko <ivict@rambler.ru>
+ * File: delete.go
+ */
+
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"time"
+)
+
+// DeleteBucket implements DeleteBucket, the buckets with objects or multipart
+// uploads are refused with BucketNotEmpty.
+func DeleteBucket(writer http.ResponseWriter, request *http.Request) {
+	bucketName, _ := bucketNameAndObjectKey(request.URL.Path, request.Context().Value(KeyUrlContext).(string))
+	storage := storageOf(request)
+	err := storage.DeleteBucket(bucketName)
+	switch {
+	case errors.Is(err, ErrNoSuchBucket):
+		writeError(writer, http.StatusNotFound, CodeNoSuchBucket, err.Error())
+		return
+	case errors.Is(err, ErrBucketNotEmpty):
+		writeError(writer, http.StatusConflict, CodeBucketNotEmpty, err.Error())
+		return
+	case errors.Is(err, ErrReadOnly):
+		writeError(writer, http.StatusForbidden, CodeAccessDenied, err.Error())
+		return
+	case err != nil:
+		writeError(writer, http.StatusInternalServerError, CodeInternalError, err.Error())
+		return
+	}
+
+	writer.WriteHeader(http.StatusNoContent)
+	fmt.Printf("%s: [%s] %s request\n", request.Context().Value(KeyServerAddr), request.Method, request.URL.Path)
+}
+
+// Delete implements DeleteObject, the objects protected by object lock are
+// refused with AccessDenied.
+func Delete(writer http.ResponseWriter, request *http.Request) {
+	bucketName, objectKey := bucketNameAndObjectKey(request.URL.Path, request.Context().Value(KeyUrlContext).(string))
+	storage := storageOf(request)
+	if err := storage.CheckBucket(bucketName); err != nil {
+		writeError(writer, http.StatusNotFound, CodeNoSuchBucket, err.Error())
+		return
+	}
+	if objectKey == "" {
+		DeleteBucket(writer, request)
+		return
+	}
+
+	err := storage.CheckObjectLock(bucketName, objectKey, bypassGovernance(request), time.Now())
+	if err == nil {
+		err = storage.Delete(bucketName, objectKey)
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		writeObjectLockError(writer, err)
+		return
+	}
+	if err == nil {
+		notify(request, &storage, bucketName, objectKey, EventObjectRemovedDelete)
+	}
+
+	writer.WriteHeader(http.StatusNoContent)
+	fmt.Printf("%s: [%s] %s request\n", request.Context().Value(KeyServerAddr), request.Method, request.URL.Path)
+}
@@ -0,0 +1,84 @@
+/**
+ * Author: Vanya Usalko <ivict@rambler.ru>
+ * File: disk_space.go
+ */
+
+package services
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/usalko/s2d3/models"
+)
+
+const CodeInsufficientStorage = "InsufficientStorage"
+
+var ErrInsufficientStorage = errors.New("the free disk space is below the reserve")
+
+// DiskReporter is implemented by the backends keeping the data on the local
+// disks.
+type DiskReporter interface {
+	DiskStatus() ([]models.DiskStatus, error)
+}
+
+// checkDiskSpace checks the disk of the folder keeps the reserve after the
+// data of the given size is written. The disk which free space is not known
+// is not checked.
+func checkDiskSpace(folder string, reserve int64, size int64) error {
+	if reserve <= 0 {
+		return nil
+	}
+	_, free, err := diskSpace(folder)
+	if err != nil {
+		return nil
+	}
+	if free-size < reserve {
+		return fmt.Errorf("%w: %d bytes free, %d bytes reserved", ErrInsufficientStorage, free, reserve)
+	}
+	return nil
+}
+
+func diskStatusOf(folder string, reserve int64) (models.DiskStatus, error) {
+	total, free, err := diskSpace(folder)
+	if err != nil {
+		return models.DiskStatus{}, err
+	}
+	return models.DiskStatus{
+		Folder:        folder,
+		TotalBytes:    total,
+		FreeBytes:     free,
+		ReserveBytes:  max(reserve, 0),
+		WritesRefused: reserve > 0 && free < reserve,
+	}, nil
+}
+
+// writeDiskSpaceError reports the refused write, the client should retry it
+// when the space is freed.
+func writeDiskSpaceError(writer http.ResponseWriter, err error) {
+	writer.Header().Set("Retry-After", "60")
+	writeError(writer, http.StatusInsufficientStorage, CodeInsufficientStorage, err.Error())
+}
+
+// DiskStats reports the disks of the backend, one disk per data folder.
+func DiskStats(writer http.ResponseWriter, request *http.Request) {
+	result := &models.DiskStatusResult{Disks: []models.DiskStatus{}}
+	if reporter, exists := CapabilityOf[DiskReporter](backendOf(request)); exists {
+		var err error
+		result.Disks, err = reporter.DiskStatus()
+		if err != nil {
+			writeError(writer, http.StatusInternalServerError, CodeInternalError, err.Error())
+			return
+		}
+	}
+
+	data, err := xml.Marshal(result)
+	if err != nil {
+		writeError(writer, http.StatusInternalServerError, CodeInternalError, err.Error())
+		return
+	}
+	writer.Header().Set("Content-Type", "application/xml")
+	writer.Write(data)
+}
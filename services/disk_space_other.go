@@ -0,0 +1,14 @@
+//go:build !linux && !darwin && !freebsd
+
+/**
+ * Author: Vanya Usalko <ivict@rambler.ru>
+ * File: disk_space_other.go
+ */
+
+package services
+
+import "errors"
+
+func diskSpace(folder string) (int64, int64, error) {
+	return 0, 0, errors.New("the free disk space is not known on this platform")
+}
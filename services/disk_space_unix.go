@@ -0,0 +1,20 @@
+//go:build linux || darwin || freebsd
+
+/**
+ * Author: Vanya Usalko <ivict@rambler.ru>
+ * File: disk_space_unix.go
+ */
+
+package services
+
+import "syscall"
+
+// diskSpace returns the total size and the space available to the service
+// of the disk of the folder.
+func diskSpace(folder string) (int64, int64, error) {
+	stat := syscall.Statfs_t{}
+	if err := syscall.Statfs(folder, &stat); err != nil {
+		return 0, 0, err
+	}
+	return int64(stat.Blocks) * int64(stat.Bsize), int64(stat.Bavail) * int64(stat.Bsize), nil
+}
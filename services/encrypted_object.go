@@ -0,0 +1,221 @@
+/**
+ * Author: Vanya Usalko <ivict@rambler.ru>
+ * File: encrypted_object.go
+ */
+
+package services
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Encrypted objects are stored as a sequence of chunks, every chunk is the
+// AES-GCM sealed SSE_CHUNK_SIZE bytes of the content (the last one may be
+// shorter) prefixed with its random nonce. The chunk index and the last chunk
+// flag are authenticated, so the chunks can't be reordered or truncated, and
+// the chunk of any offset is found without reading the preceding ones.
+const SSE_CHUNK_SIZE = 64 * 1024
+const SSE_NONCE_SIZE = 12
+const SSE_TAG_SIZE = 16
+const SSE_ENCRYPTED_CHUNK_SIZE = SSE_NONCE_SIZE + SSE_CHUNK_SIZE + SSE_TAG_SIZE
+
+var ErrInvalidEncryptionKey = errors.New("the provided encryption key doesn't match the one used to encrypt the object")
+
+func newAead(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func newDataKey() ([]byte, error) {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	return dataKey, nil
+}
+
+// wrapKey seals the data key with the key encryption key.
+func wrapKey(keyEncryptionKey []byte, dataKey []byte) (string, error) {
+	aead, err := newAead(keyEncryptionKey)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, SSE_NONCE_SIZE)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, dataKey, nil)), nil
+}
+
+func unwrapKey(keyEncryptionKey []byte, wrappedKey string) ([]byte, error) {
+	aead, err := newAead(keyEncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(wrappedKey)
+	if err != nil || len(sealed) < SSE_NONCE_SIZE {
+		return nil, fmt.Errorf("the wrapped data key is malformed")
+	}
+	dataKey, err := aead.Open(nil, sealed[:SSE_NONCE_SIZE], sealed[SSE_NONCE_SIZE:], nil)
+	if err != nil {
+		return nil, ErrInvalidEncryptionKey
+	}
+	return dataKey, nil
+}
+
+func chunkAdditionalData(index int64, last bool) []byte {
+	additionalData := make([]byte, 9)
+	binary.BigEndian.PutUint64(additionalData, uint64(index))
+	if last {
+		additionalData[8] = 1
+	}
+	return additionalData
+}
+
+func chunkCount(size int64) int64 {
+	return max(1, (size+SSE_CHUNK_SIZE-1)/SSE_CHUNK_SIZE)
+}
+
+// encryptChunks encrypts the content into the chunked format, the empty
+// content has one empty chunk.
+func encryptChunks(dataKey []byte, content []byte) ([]byte, error) {
+	aead, err := newAead(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	count := chunkCount(int64(len(content)))
+	encrypted := make([]byte, 0, int64(len(content))+count*(SSE_NONCE_SIZE+SSE_TAG_SIZE))
+	for index := int64(0); index < count; index++ {
+		chunk := content[index*SSE_CHUNK_SIZE : min(int64(len(content)), (index+1)*SSE_CHUNK_SIZE)]
+		nonce := make([]byte, SSE_NONCE_SIZE)
+		if _, err := rand.Read(nonce); err != nil {
+			return nil, err
+		}
+		encrypted = append(encrypted, nonce...)
+		encrypted = aead.Seal(encrypted, nonce, chunk, chunkAdditionalData(index, index == count-1))
+	}
+	return encrypted, nil
+}
+
+// encryptedSize returns the content size of the data in the chunked format.
+func encryptedSize(dataSize int64) (int64, error) {
+	count := (dataSize + SSE_ENCRYPTED_CHUNK_SIZE - 1) / SSE_ENCRYPTED_CHUNK_SIZE
+	size := dataSize - count*(SSE_NONCE_SIZE+SSE_TAG_SIZE)
+	if count == 0 || size < 0 {
+		return 0, fmt.Errorf("the encrypted data is truncated")
+	}
+	return size, nil
+}
+
+// decryptingReader reads the content of the encrypted data, it decrypts only
+// the chunks of the requested range.
+type decryptingReader struct {
+	source io.ReadSeekCloser
+	aead   cipher.AEAD
+	size   int64
+	offset int64
+
+	chunkIndex int64
+	chunk      []byte
+}
+
+func newDecryptingReader(source io.ReadSeekCloser, dataKey []byte) (*decryptingReader, error) {
+	aead, err := newAead(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	sourceSize, err := source.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	size, err := encryptedSize(sourceSize)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptingReader{
+		source:     source,
+		aead:       aead,
+		size:       size,
+		chunkIndex: -1,
+	}, nil
+}
+
+func (reader *decryptingReader) loadChunk(index int64) error {
+	if reader.chunkIndex == index {
+		return nil
+	}
+	sealed := make([]byte, SSE_ENCRYPTED_CHUNK_SIZE)
+	if _, err := reader.source.Seek(index*SSE_ENCRYPTED_CHUNK_SIZE, io.SeekStart); err != nil {
+		return err
+	}
+	n, err := io.ReadFull(reader.source, sealed)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return err
+	}
+	if n < SSE_NONCE_SIZE+SSE_TAG_SIZE {
+		return fmt.Errorf("the encrypted chunk %d is truncated", index)
+	}
+	last := index == chunkCount(reader.size)-1
+	chunk, err := reader.aead.Open(reader.chunk[:0], sealed[:SSE_NONCE_SIZE], sealed[SSE_NONCE_SIZE:n], chunkAdditionalData(index, last))
+	if err != nil {
+		return fmt.Errorf("the encrypted chunk %d can't be authenticated", index)
+	}
+	reader.chunkIndex = index
+	reader.chunk = chunk
+	return nil
+}
+
+func (reader *decryptingReader) Read(buffer []byte) (int, error) {
+	if reader.offset >= reader.size {
+		return 0, io.EOF
+	}
+	index := reader.offset / SSE_CHUNK_SIZE
+	if err := reader.loadChunk(index); err != nil {
+		return 0, err
+	}
+	n := copy(buffer, reader.chunk[reader.offset-index*SSE_CHUNK_SIZE:])
+	reader.offset += int64(n)
+	return n, nil
+}
+
+func (reader *decryptingReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += reader.offset
+	case io.SeekEnd:
+		offset += reader.size
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("negative position %d", offset)
+	}
+	reader.offset = offset
+	return offset, nil
+}
+
+func (reader *decryptingReader) Close() error {
+	return reader.source.Close()
+}
+
+// decryptAll reads the whole content of the encrypted data, the source is
+// left open.
+func decryptAll(source io.ReadSeekCloser, dataKey []byte) ([]byte, error) {
+	reader, err := newDecryptingReader(source, dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(reader)
+}
@@ -0,0 +1,288 @@
+/**
+ * Author: Vanya Usalko <ivict@rambler.ru>
+ * File: encryption.go
+ */
+
+package services
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/usalko/s2d3/models"
+)
+
+const ENCRYPTION_CONFIG = "encryption"
+const MASTER_KEY_FILE = "master.key"
+
+const SSE_HEADER = "x-amz-server-side-encryption"
+const SSE_CUSTOMER_ALGORITHM_HEADER = "x-amz-server-side-encryption-customer-algorithm"
+const SSE_CUSTOMER_KEY_HEADER = "x-amz-server-side-encryption-customer-key"
+const SSE_CUSTOMER_KEY_MD5_HEADER = "x-amz-server-side-encryption-customer-key-MD5"
+
+const CodeServerSideEncryptionConfigurationNotFoundError = "ServerSideEncryptionConfigurationNotFoundError"
+
+var ErrCustomerKeyRequired = errors.New("the object was stored using a form of server side encryption, the correct parameters must be provided to retrieve the object")
+var ErrMasterKeyRequired = errors.New("the server side encryption requires the master key file of the server")
+
+// ServerSideEncryption is the encryption requested for the object, the
+// customer key is given for SSE-C only.
+type ServerSideEncryption struct {
+	CustomerKey    []byte
+	CustomerKeyMD5 string
+}
+
+// serverSideEncryptionOf parses the SSE-S3 and SSE-C headers of the request,
+// it returns nil when no encryption is requested.
+func serverSideEncryptionOf(request *http.Request) (*ServerSideEncryption, error) {
+	algorithm := request.Header.Get(SSE_HEADER)
+	customerAlgorithm := request.Header.Get(SSE_CUSTOMER_ALGORITHM_HEADER)
+	if algorithm != "" && customerAlgorithm != "" {
+		return nil, fmt.Errorf("the %s and %s headers can't be given together", SSE_HEADER, SSE_CUSTOMER_ALGORITHM_HEADER)
+	}
+
+	if algorithm != "" {
+		if algorithm != models.SSEAlgorithmAES256 {
+			return nil, fmt.Errorf("the server side encryption %s is not supported", algorithm)
+		}
+		return &ServerSideEncryption{}, nil
+	}
+
+	if customerAlgorithm == "" {
+		if request.Header.Get(SSE_CUSTOMER_KEY_HEADER) != "" {
+			return nil, fmt.Errorf("the %s header is required", SSE_CUSTOMER_ALGORITHM_HEADER)
+		}
+		return nil, nil
+	}
+	if customerAlgorithm != models.SSEAlgorithmAES256 {
+		return nil, fmt.Errorf("the customer encryption algorithm %s is not supported", customerAlgorithm)
+	}
+	customerKey, err := base64.StdEncoding.DecodeString(request.Header.Get(SSE_CUSTOMER_KEY_HEADER))
+	if err != nil || len(customerKey) != 32 {
+		return nil, fmt.Errorf("the %s header must be the base64 encoded 256-bit key", SSE_CUSTOMER_KEY_HEADER)
+	}
+	hash := md5.Sum(customerKey)
+	customerKeyMD5 := base64.StdEncoding.EncodeToString(hash[:])
+	if request.Header.Get(SSE_CUSTOMER_KEY_MD5_HEADER) != customerKeyMD5 {
+		return nil, fmt.Errorf("the %s header doesn't match the customer key", SSE_CUSTOMER_KEY_MD5_HEADER)
+	}
+	return &ServerSideEncryption{
+		CustomerKey:    customerKey,
+		CustomerKeyMD5: customerKeyMD5,
+	}, nil
+}
+
+// masterKeyLock serializes the generation of the master key record.
+var masterKeyLock sync.Mutex
+
+// masterKey reads the hex encoded 256-bit master key. The key is kept in the
+// configured key file or in the key record of the backend, the record is
+// generated with the first encrypted object only when GenerateMasterKey is set.
+func (storage *Storage) masterKey() ([]byte, error) {
+	source := storage.MasterKeyFile
+	var data []byte
+	var err error
+	if source != "" {
+		data, err = os.ReadFile(source)
+	} else {
+		source = MASTER_KEY_FILE
+		data, err = storage.masterKeyRecord()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	masterKey, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(masterKey) != 32 {
+		return nil, fmt.Errorf("the master key %s must have the hex encoded 256-bit key", source)
+	}
+	return masterKey, nil
+}
+
+func (storage *Storage) masterKeyRecord() ([]byte, error) {
+	masterKeyLock.Lock()
+	defer masterKeyLock.Unlock()
+
+	data, err := storage.GetRecord(MASTER_KEY_FILE)
+	if !errors.Is(err, fs.ErrNotExist) {
+		return data, err
+	}
+	if !storage.GenerateMasterKey {
+		return nil, ErrMasterKeyRequired
+	}
+	masterKey := make([]byte, 32)
+	if _, err := rand.Read(masterKey); err != nil {
+		return nil, err
+	}
+	data = []byte(hex.EncodeToString(masterKey))
+	if err := storage.PutRecord(MASTER_KEY_FILE, data); err != nil {
+		return nil, err
+	}
+	fmt.Printf("[encryption] the master key %s is generated\n", MASTER_KEY_FILE)
+	return data, nil
+}
+
+// newObjectEncryption generates the data key of the new object or upload.
+func (storage *Storage) newObjectEncryption(sse *ServerSideEncryption) (*models.ObjectEncryption, []byte, error) {
+	keyEncryptionKey := sse.CustomerKey
+	if keyEncryptionKey == nil {
+		masterKey, err := storage.masterKey()
+		if err != nil {
+			return nil, nil, err
+		}
+		keyEncryptionKey = masterKey
+	}
+
+	dataKey, err := newDataKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	wrappedKey, err := wrapKey(keyEncryptionKey, dataKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &models.ObjectEncryption{
+		Algorithm:      models.SSEAlgorithmAES256,
+		CustomerKeyMD5: sse.CustomerKeyMD5,
+		DataKey:        wrappedKey,
+	}, dataKey, nil
+}
+
+// dataKeyOf unwraps the data key of the encrypted object or upload, the
+// customer key must be given for SSE-C.
+func (storage *Storage) dataKeyOf(encryption *models.ObjectEncryption, sse *ServerSideEncryption) ([]byte, error) {
+	if encryption.CustomerKeyMD5 == "" {
+		masterKey, err := storage.masterKey()
+		if err != nil {
+			return nil, err
+		}
+		return unwrapKey(masterKey, encryption.DataKey)
+	}
+
+	if sse == nil || sse.CustomerKey == nil {
+		return nil, ErrCustomerKeyRequired
+	}
+	if sse.CustomerKeyMD5 != encryption.CustomerKeyMD5 {
+		return nil, ErrInvalidEncryptionKey
+	}
+	return unwrapKey(sse.CustomerKey, encryption.DataKey)
+}
+
+// defaultEncryption returns the SSE-S3 encryption for the buckets with the
+// default encryption and nil otherwise.
+func (storage *Storage) defaultEncryption(bucketName string) (*ServerSideEncryption, error) {
+	_, err := storage.GetBucketConfig(bucketName, ENCRYPTION_CONFIG)
+	if errors.Is(err, ErrNoSuchConfiguration) || errors.Is(err, ErrNoSuchBucket) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &ServerSideEncryption{}, nil
+}
+
+func setEncryptionHeaders(writer http.ResponseWriter, encryption *models.ObjectEncryption) {
+	if encryption == nil {
+		return
+	}
+	if encryption.CustomerKeyMD5 != "" {
+		writer.Header().Set(SSE_CUSTOMER_ALGORITHM_HEADER, encryption.Algorithm)
+		writer.Header().Set(SSE_CUSTOMER_KEY_MD5_HEADER, encryption.CustomerKeyMD5)
+		return
+	}
+	writer.Header().Set(SSE_HEADER, encryption.Algorithm)
+}
+
+func writeEncryptionError(writer http.ResponseWriter, err error) {
+	if errors.Is(err, ErrCustomerKeyRequired) || errors.Is(err, ErrMasterKeyRequired) {
+		writeError(writer, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	if errors.Is(err, ErrInvalidEncryptionKey) {
+		writeError(writer, http.StatusForbidden, CodeAccessDenied, err.Error())
+		return
+	}
+	if errors.Is(err, ErrNoSuchKey) {
+		writeError(writer, http.StatusNotFound, CodeNoSuchKey, err.Error())
+		return
+	}
+	writeError(writer, http.StatusInternalServerError, CodeInternalError, err.Error())
+}
+
+func validateEncryption(config *models.ServerSideEncryptionConfiguration) error {
+	if len(config.Rules) != 1 || config.Rules[0].ApplyServerSideEncryptionByDefault == nil {
+		return fmt.Errorf("the server side encryption configuration must have one rule with the default encryption")
+	}
+	algorithm := config.Rules[0].ApplyServerSideEncryptionByDefault.SSEAlgorithm
+	if algorithm != models.SSEAlgorithmAES256 {
+		return fmt.Errorf("the server side encryption %s is not supported", algorithm)
+	}
+	return nil
+}
+
+// Encryption implements PUT, GET and DELETE of the bucket default encryption.
+func Encryption(writer http.ResponseWriter, request *http.Request) {
+	bucketName, _ := bucketNameAndObjectKey(request.URL.Path, request.Context().Value(KeyUrlContext).(string))
+	storage := storageOf(request)
+
+	switch request.Method {
+
+	case "GET":
+		data, err := storage.GetBucketConfig(bucketName, ENCRYPTION_CONFIG)
+		if err != nil {
+			writeBucketConfigError(writer, err, CodeServerSideEncryptionConfigurationNotFoundError)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/xml")
+		writer.Write(data)
+
+	case "PUT":
+		body, err := io.ReadAll(request.Body)
+		if err != nil {
+			writeError(writer, http.StatusBadRequest, CodeInvalidArgument, err.Error())
+			return
+		}
+		config := models.ServerSideEncryptionConfiguration{}
+		if err := xml.Unmarshal(body, &config); err != nil {
+			writeError(writer, http.StatusBadRequest, CodeMalformedXML, err.Error())
+			return
+		}
+		if err := validateEncryption(&config); err != nil {
+			writeError(writer, http.StatusBadRequest, CodeInvalidArgument, err.Error())
+			return
+		}
+		// The default encryption is refused up front rather than with the
+		// first object when the server has no master key
+		if _, err := storage.masterKey(); err != nil {
+			writeEncryptionError(writer, err)
+			return
+		}
+		data, err := xml.Marshal(&config)
+		if err == nil {
+			err = storage.PutBucketConfig(bucketName, ENCRYPTION_CONFIG, data)
+		}
+		if err != nil {
+			writeBucketConfigError(writer, err, CodeServerSideEncryptionConfigurationNotFoundError)
+			return
+		}
+
+	case "DELETE":
+		if err := storage.DeleteBucketConfig(bucketName, ENCRYPTION_CONFIG); err != nil {
+			writeBucketConfigError(writer, err, CodeServerSideEncryptionConfigurationNotFoundError)
+			return
+		}
+		writer.WriteHeader(http.StatusNoContent)
+
+	}
+}
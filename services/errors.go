@@ -0,0 +1,46 @@
+/**
+ * Author: Vanya Usalko <ivict@rambler.ru>
+ * File: errors.go
+ */
+
+package services
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+
+	"github.com/usalko/s2d3/models"
+)
+
+const (
+	CodeInvalidArgument = "InvalidArgument"
+	CodeNoSuchBucket    = "NoSuchBucket"
+	CodeNoSuchKey       = "NoSuchKey"
+	CodeInternalError   = "InternalError"
+	CodeMalformedXML    = "MalformedXML"
+	CodeNoSuchUpload    = "NoSuchUpload"
+	CodeInvalidPart     = "InvalidPart"
+	CodeNotImplemented  = "NotImplemented"
+)
+
+func writeError(writer http.ResponseWriter, status int, code string, message string) {
+	if recorder, exists := writer.(*responseRecorder); exists {
+		recorder.errorCode = code
+	}
+	response := &models.Error{
+		Code:      code,
+		Message:   message,
+		RequestId: writer.Header().Get("x-amz-request-id"),
+		HostId:    writer.Header().Get("x-amz-id-2"),
+	}
+	responseBytes, err := xml.Marshal(response)
+	if err != nil {
+		fmt.Printf("%s", err)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/xml")
+	writer.WriteHeader(status)
+	writer.Write(responseBytes)
+}
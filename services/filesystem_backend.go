@@ -0,0 +1,1063 @@
+/**
+ * Author: Vanya Usalko <ivict@rambler.ru>
+ * File: filesystem_backend.go
+ */
+
+package services
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/usalko/s2d3/models"
+	"github.com/usalko/s2d3/utils"
+)
+
+const BUCKETS_FOLDER = "buckets"
+const METADATA_FOLDER = "metadata"
+const UPLOADS_FOLDER = "uploads"
+const UPLOAD_RECORD = "upload.json"
+
+var BREAKPOINTS = [...]utils.SizeInBytes{
+	4096,
+	16384,
+	65536,
+	4194304,
+	16777216,
+	4294967296,
+}
+var BREAKPOINTS_DELTA = [...]utils.SizeInBytes{
+	0,
+	4096,
+	16384,
+	65536,
+	4194304,
+	16777216,
+}
+
+// FileSystemBackend keeps every bucket as a folder of RootFolder and every
+// object as a file of the bucket folder, so the data folder can be served
+// as it is. The metadata, bucket configurations, uploads and records are kept
+// in the system folder.
+type FileSystemBackend struct {
+	RootFolder string
+	// Bucket is the name of the bucket of RootFolder (e.g. the mounted
+	// folder), the folders of RootFolder are the buckets when it is empty
+	Bucket string
+	// Reserve is the free space kept on the disk of RootFolder, the objects
+	// and the parts are refused below it, 0 is no reserve
+	Reserve int64
+}
+
+func findBreakpoint(dataSize int) (int, int) {
+	breakpointIndex := 0
+	countOfSegments := 1
+	for index, breakpoint := range BREAKPOINTS {
+		if int(breakpoint) > dataSize {
+			if index > 0 {
+				breakpointIndex = index - 1
+				countOfSegments = dataSize / int(BREAKPOINTS[breakpointIndex])
+			}
+			break
+		}
+		if int(breakpoint+BREAKPOINTS_DELTA[index]) > dataSize {
+			breakpointIndex = index
+			countOfSegments = 1
+			break
+		}
+	}
+	return breakpointIndex, countOfSegments
+}
+
+func (backend *FileSystemBackend) Init() {
+	os.Mkdir(backend.RootFolder, fs.ModeDir|0775)
+	// The key records written by the earlier versions are readable by all
+	for _, name := range KEY_RECORDS {
+		os.Chmod(backend.recordPath(name), RECORD_FILE_MODE)
+	}
+}
+
+func (backend *FileSystemBackend) bucketPath(bucketName string) string {
+	if backend.Bucket != "" {
+		return backend.RootFolder
+	}
+	return strings.Join([]string{
+		backend.RootFolder,
+		bucketName,
+	}, "/")
+}
+
+// serves checks the object is kept in the bucket folder: the bucket must be
+// the one of RootFolder (if any), the names escaping the bucket folder are
+// refused and the system folder is never served.
+func (backend *FileSystemBackend) serves(bucketName string, objectKey string) bool {
+	if strings.Contains(bucketName, "/") || !containedPath(bucketName) || !containedPath(objectKey) {
+		return false
+	}
+	if backend.Bucket != "" && bucketName != backend.Bucket {
+		return false
+	}
+	// The cleaned path must stay in the bucket folder whatever the names are
+	bucketPath := filepath.Clean(backend.bucketPath(bucketName))
+	objectPath := filepath.Clean(backend.objectPath(bucketName, objectKey))
+	return objectPath == bucketPath || strings.HasPrefix(objectPath, bucketPath+string(filepath.Separator))
+}
+
+// containedPath checks the bucket name or the object key is kept inside its
+// folder: it is not absolute and none of its segments is ".", ".." or the
+// system folder.
+func containedPath(name string) bool {
+	if strings.HasPrefix(name, "/") || (filepath.Separator != '/' && strings.ContainsRune(name, filepath.Separator)) {
+		return false
+	}
+	for _, segment := range strings.Split(name, "/") {
+		if segment == "." || segment == ".." || segment == SYSTEM_FOLDER {
+			return false
+		}
+	}
+	return true
+}
+
+func (backend *FileSystemBackend) objectPath(bucketName string, objectKey string) string {
+	return strings.Join([]string{
+		backend.bucketPath(bucketName),
+		objectKey,
+	}, "/")
+}
+
+// Bucket configurations (lifecycle, cors, ...) are kept as documents in the
+// system folder, one file per configuration name.
+func (backend *FileSystemBackend) bucketConfigPath(bucketName string, configName string) string {
+	return strings.Join([]string{
+		backend.RootFolder,
+		SYSTEM_FOLDER,
+		BUCKETS_FOLDER,
+		bucketName,
+		fmt.Sprintf("%s.xml", configName),
+	}, "/")
+}
+
+// The object metadata store keeps one json document per object in the system
+// folder, the documents mirror the layout of the bucket folders.
+func (backend *FileSystemBackend) metadataPath(bucketName string, objectKey string) string {
+	return strings.Join([]string{
+		backend.RootFolder,
+		SYSTEM_FOLDER,
+		METADATA_FOLDER,
+		bucketName,
+		fmt.Sprintf("%s.json", objectKey),
+	}, "/")
+}
+
+// Parts of the multipart uploads are staged in the system folder until the
+// upload is completed or aborted.
+func (backend *FileSystemBackend) uploadsPath() string {
+	return strings.Join([]string{
+		backend.RootFolder,
+		SYSTEM_FOLDER,
+		UPLOADS_FOLDER,
+	}, "/")
+}
+
+func (backend *FileSystemBackend) uploadPath(bucketName string, objectKey string, uploadId string) string {
+	hash := md5.Sum([]byte(fmt.Sprintf("%s/%s:%s", bucketName, objectKey, uploadId)))
+	return strings.Join([]string{
+		backend.uploadsPath(),
+		hex.EncodeToString(hash[:]),
+	}, "/")
+}
+
+func partFileName(partNumber int) string {
+	return fmt.Sprintf("%05d.part", partNumber)
+}
+
+func (backend *FileSystemBackend) recordPath(name string) string {
+	return strings.Join([]string{
+		backend.RootFolder,
+		SYSTEM_FOLDER,
+		name,
+	}, "/")
+}
+
+// removeWithEmptyParents removes the file and then its parent folders while
+// they are empty, the stopPath folder itself is kept.
+func removeWithEmptyParents(path string, stopPath string) error {
+	err := os.Remove(path)
+	if err != nil {
+		return err
+	}
+
+	for parent := filepath.Dir(path); len(parent) > len(stopPath); parent = filepath.Dir(parent) {
+		if os.Remove(parent) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// writeFileAtomically writes the file in place only when it is complete, so
+// the readers never see a partial one, the failed write (e.g. when the disk is
+// full) keeps the previous file and removes the temporary one.
+func writeFileAtomically(path string, data []byte, perm fs.FileMode) error {
+	return streamFileAtomically(path, bytes.NewReader(data), perm, nil)
+}
+
+// streamFileAtomically streams the content into the temporary file and puts
+// it in place as writeFileAtomically does, the written file is checked (e.g.
+// by its size) before.
+func streamFileAtomically(path string, reader io.Reader, perm fs.FileMode, check func(size int64) error) error {
+	err := os.MkdirAll(filepath.Dir(path), fs.ModeDir|0775)
+	if err != nil {
+		return err
+	}
+	temporaryPath := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+TEMPORARY_FILE_SUFFIX)
+	file, err := os.OpenFile(temporaryPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	size, err := io.Copy(file, reader)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && check != nil {
+		err = check(size)
+	}
+	if err == nil {
+		err = os.Rename(temporaryPath, path)
+	}
+	if err != nil {
+		os.Remove(temporaryPath)
+	}
+	return err
+}
+
+// isTemporaryFile returns true for the files being written by
+// writeFileAtomically, they aren't the objects yet.
+func isTemporaryFile(name string) bool {
+	return strings.HasPrefix(name, ".") && strings.HasSuffix(name, TEMPORARY_FILE_SUFFIX)
+}
+
+func fileETag(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	return contentETag(file)
+}
+
+// contentETag returns the ETag of the content, the md5 hash of the plain
+// objects as the listings have it.
+func contentETag(reader io.Reader) (string, error) {
+	hash := md5.New()
+	if _, err := io.Copy(hash, reader); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// ListBuckets returns the folders of RootFolder, every folder is a bucket.
+func (backend *FileSystemBackend) ListBuckets() ([]models.Bucket, error) {
+	if backend.Bucket != "" {
+		fileInfo, err := os.Stat(backend.RootFolder)
+		if err != nil {
+			return nil, err
+		}
+		return []models.Bucket{{
+			Name:         backend.Bucket,
+			CreationDate: fileInfo.ModTime().UTC(),
+		}}, nil
+	}
+
+	entries, err := os.ReadDir(backend.RootFolder)
+	if err != nil {
+		return nil, err
+	}
+
+	buckets := make([]models.Bucket, 0)
+	for _, entry := range entries {
+		if !entry.IsDir() || entry.Name() == SYSTEM_FOLDER {
+			continue
+		}
+		fileInfo, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		buckets = append(buckets, models.Bucket{
+			Name:         entry.Name(),
+			CreationDate: fileInfo.ModTime().UTC(),
+		})
+	}
+	return buckets, nil
+}
+
+func (backend *FileSystemBackend) CreateBucket(bucketName string) error {
+	if !backend.serves(bucketName, "") {
+		return ErrNoSuchBucket
+	}
+	err := os.Mkdir(backend.bucketPath(bucketName), fs.ModeDir|0775)
+	if errors.Is(err, fs.ErrExist) {
+		return ErrBucketAlreadyExists
+	}
+	return err
+}
+
+func (backend *FileSystemBackend) CheckBucket(bucketName string) error {
+	if bucketName == "" || !backend.serves(bucketName, "") {
+		return ErrNoSuchBucket
+	}
+	fileInfo, err := os.Stat(backend.bucketPath(bucketName))
+	if err != nil || !fileInfo.IsDir() {
+		return ErrNoSuchBucket
+	}
+	return nil
+}
+
+// DeleteBucket removes the bucket folder with the configurations, the
+// metadata and the packs of the bucket, the bucket of RootFolder is never
+// removed.
+func (backend *FileSystemBackend) DeleteBucket(bucketName string) error {
+	if err := backend.CheckBucket(bucketName); err != nil {
+		return err
+	}
+	if backend.Bucket != "" {
+		return fmt.Errorf("the bucket %s is the served folder and can't be deleted", bucketName)
+	}
+	for _, folder := range []string{
+		strings.Join([]string{backend.RootFolder, SYSTEM_FOLDER, BUCKETS_FOLDER, bucketName}, "/"),
+		strings.Join([]string{backend.RootFolder, SYSTEM_FOLDER, METADATA_FOLDER, bucketName}, "/"),
+		backend.packFolder(bucketName),
+	} {
+		if err := os.RemoveAll(folder); err != nil {
+			return err
+		}
+	}
+	return os.RemoveAll(backend.bucketPath(bucketName))
+}
+
+func (backend *FileSystemBackend) CheckWritable(bucketName string) error {
+	return nil
+}
+
+func (backend *FileSystemBackend) GetBucketConfig(bucketName string, configName string) ([]byte, error) {
+	if err := backend.CheckBucket(bucketName); err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(backend.bucketConfigPath(bucketName, configName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNoSuchConfiguration
+	}
+	return data, err
+}
+
+func (backend *FileSystemBackend) PutBucketConfig(bucketName string, configName string, data []byte) error {
+	if err := backend.CheckBucket(bucketName); err != nil {
+		return err
+	}
+	configPath := backend.bucketConfigPath(bucketName, configName)
+	err := os.MkdirAll(configPath[:strings.LastIndex(configPath, "/")], fs.ModeDir|0775)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(configPath, data, 0644)
+}
+
+func (backend *FileSystemBackend) DeleteBucketConfig(bucketName string, configName string) error {
+	if err := backend.CheckBucket(bucketName); err != nil {
+		return err
+	}
+	err := os.Remove(backend.bucketConfigPath(bucketName, configName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (backend *FileSystemBackend) PushData(bucketName string, objectKey string, suffix string, reader io.Reader) error {
+	if !backend.serves(bucketName, objectKey) {
+		return ErrNoSuchBucket
+	}
+	size, _ := declaredSizeOf(reader, &models.ObjectMetadata{})
+	if err := checkDiskSpace(backend.RootFolder, backend.Reserve, size); err != nil {
+		return err
+	}
+
+	// Save object as single file, the objects of several segments are kept
+	// in a single file too until the segmented storage is implemented
+	return streamFileAtomically(strings.Join([]string{
+		backend.bucketPath(bucketName),
+		objectKey,
+	}, "/"), reader, OBJECT_FILE_MODE, func(size int64) error {
+		_, countOfSegments := findBreakpoint(int(size))
+		if countOfSegments < 1 {
+			return fmt.Errorf("invalid count of segments for content length %d", size)
+		}
+		// The content is not trusted to have the declared size
+		return checkDiskSpace(backend.RootFolder, backend.Reserve, 0)
+	})
+}
+
+// The packs of the bucket are kept in the system folder, see packStore.
+func (backend *FileSystemBackend) packFolder(bucketName string) string {
+	return strings.Join([]string{
+		backend.RootFolder,
+		SYSTEM_FOLDER,
+		PACKS_FOLDER,
+		bucketName,
+	}, "/")
+}
+
+// packThreshold returns the size of the objects appended into the packs of
+// the bucket, it is 0 for buckets keeping every object as the file.
+func (backend *FileSystemBackend) packThreshold(bucketName string) (int64, error) {
+	config, err := packConfiguration(backend, bucketName)
+	if err != nil || config == nil {
+		return 0, err
+	}
+	return config.MaxObjectSize, nil
+}
+
+// The segments of the deduplicated objects are shared by all buckets of the
+// system folder, see segmentStore.
+func (backend *FileSystemBackend) segments() (*segmentStore, error) {
+	return openSegmentStore(strings.Join([]string{
+		backend.RootFolder,
+		SYSTEM_FOLDER,
+	}, "/"))
+}
+
+// segmentSize returns the size of the segments of the deduplicated objects
+// of the bucket, it is 0 for buckets without the deduplication.
+func (backend *FileSystemBackend) segmentSize(bucketName string) (int64, error) {
+	config, err := dedupConfiguration(backend, bucketName)
+	if err != nil || config == nil {
+		return 0, err
+	}
+	return config.SegmentSize, nil
+}
+
+// Put appends the objects smaller than the pack threshold of the bucket into
+// the packs, splits the other objects into the segments when the bucket is
+// deduplicated or stores them as files. The previous copy of the object is
+// removed from the other places.
+func (backend *FileSystemBackend) Put(bucketName string, objectKey string, reader io.Reader, metadata *models.ObjectMetadata) error {
+	if !backend.serves(bucketName, objectKey) {
+		return ErrNoSuchBucket
+	}
+	// The object is checked by its declared size, whichever way it is stored
+	size, _ := declaredSizeOf(reader, metadata)
+	if err := checkDiskSpace(backend.RootFolder, backend.Reserve, size); err != nil {
+		return err
+	}
+	threshold, err := backend.packThreshold(bucketName)
+	if err != nil {
+		return err
+	}
+	segmentSize, err := backend.segmentSize(bucketName)
+	if err != nil {
+		return err
+	}
+	store, err := openPackStore(backend.packFolder(bucketName), threshold > 0)
+	if err != nil {
+		return err
+	}
+	segments, err := backend.segments()
+	if err != nil {
+		return err
+	}
+
+	packed, deduplicated := false, false
+	if threshold > 0 {
+		content, err := io.ReadAll(io.LimitReader(reader, threshold))
+		if err != nil {
+			return err
+		}
+		packed = int64(len(content)) < threshold
+		if packed {
+			err = store.Put(objectKey, content, time.Now())
+			if err != nil {
+				return err
+			}
+		}
+		reader = io.MultiReader(bytes.NewReader(content), reader)
+	}
+	switch {
+	case packed:
+	case segmentSize > 0:
+		if _, err := segments.Put(bucketName, objectKey, reader, segmentSize); err != nil {
+			return err
+		}
+		deduplicated = true
+	default:
+		if err := backend.PushData(bucketName, objectKey, "", reader); err != nil {
+			return err
+		}
+	}
+
+	if !packed && store != nil {
+		if _, err := store.Delete(objectKey); err != nil {
+			return err
+		}
+	}
+	if !deduplicated {
+		if _, err := segments.Delete(bucketName, objectKey); err != nil {
+			return err
+		}
+	}
+	if packed || deduplicated {
+		objectPath := backend.objectPath(bucketName, objectKey)
+		if fileInfo, err := os.Stat(objectPath); err == nil && fileInfo.Mode().IsRegular() {
+			if err := removeWithEmptyParents(objectPath, backend.bucketPath(bucketName)); err != nil {
+				return err
+			}
+		}
+	}
+	return backend.PutMetadata(bucketName, objectKey, metadata)
+}
+
+// objectLocation is the file, the pack entry or the segment manifest keeping
+// the object data.
+type objectLocation struct {
+	fileInfo fs.FileInfo
+	store    *packStore
+	entry    packEntry
+	segments *segmentStore
+	manifest *segmentManifest
+}
+
+func (location *objectLocation) size() int64 {
+	switch {
+	case location.store != nil:
+		return location.entry.size
+	case location.manifest != nil:
+		return location.manifest.Size
+	}
+	return location.fileInfo.Size()
+}
+
+func (location *objectLocation) modTime() time.Time {
+	switch {
+	case location.store != nil:
+		return location.entry.modTime.UTC()
+	case location.manifest != nil:
+		return location.manifest.ModTime.UTC()
+	}
+	return location.fileInfo.ModTime().UTC()
+}
+
+// locate finds the object data, the newest copy is used when the object is
+// kept in several places (e.g. the file copied into the data folder by hand
+// over the packed object).
+func (backend *FileSystemBackend) locate(bucketName string, objectKey string) (*objectLocation, error) {
+	if !backend.serves(bucketName, objectKey) || objectKey == "" {
+		return nil, ErrNoSuchKey
+	}
+	var location *objectLocation
+	fileInfo, err := os.Stat(backend.objectPath(bucketName, objectKey))
+	if err == nil && !fileInfo.IsDir() {
+		location = &objectLocation{fileInfo: fileInfo}
+	}
+
+	store, err := openPackStore(backend.packFolder(bucketName), false)
+	if err != nil {
+		return nil, err
+	}
+	if store != nil {
+		entry, exists := store.Entry(objectKey)
+		if exists && (location == nil || entry.modTime.After(location.modTime())) {
+			location = &objectLocation{store: store, entry: entry}
+		}
+	}
+
+	segments, err := backend.segments()
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := segments.Manifest(bucketName, objectKey)
+	if err != nil {
+		return nil, err
+	}
+	if manifest != nil && (location == nil || manifest.ModTime.After(location.modTime())) {
+		location = &objectLocation{segments: segments, manifest: manifest}
+	}
+
+	if location == nil {
+		return nil, ErrNoSuchKey
+	}
+	return location, nil
+}
+
+func (backend *FileSystemBackend) Get(bucketName string, objectKey string) (io.ReadSeekCloser, *ObjectInfo, error) {
+	location, err := backend.locate(bucketName, objectKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	metadata, err := backend.readMetadata(bucketName, objectKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if location.store != nil {
+		data, entry, err := location.store.Read(objectKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		size, etag, _ := contentAttributes(metadata, entry.size, func() (string, error) { return entry.etag, nil })
+		return memoryReader{bytes.NewReader(data)}, &ObjectInfo{
+			Size:         size,
+			ETag:         etag,
+			LastModified: entry.modTime.UTC(),
+			Metadata:     metadata,
+		}, nil
+	}
+	if location.manifest != nil {
+		size, etag, _ := contentAttributes(metadata, location.manifest.Size, func() (string, error) { return location.manifest.ETag, nil })
+		return location.segments.Open(location.manifest), &ObjectInfo{
+			Size:         size,
+			ETag:         etag,
+			LastModified: location.modTime(),
+			Metadata:     metadata,
+		}, nil
+	}
+
+	file, err := os.Open(backend.objectPath(bucketName, objectKey))
+	if err != nil {
+		return nil, nil, err
+	}
+	fileInfo, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+
+	size, etag, err := contentAttributes(metadata, fileInfo.Size(), func() (string, error) {
+		etag, err := contentETag(file)
+		if err == nil {
+			_, err = file.Seek(0, io.SeekStart)
+		}
+		return etag, err
+	})
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	return file, &ObjectInfo{
+		Size:         size,
+		ETag:         etag,
+		LastModified: fileInfo.ModTime().UTC(),
+		Metadata:     metadata,
+	}, nil
+}
+
+func (backend *FileSystemBackend) Stat(bucketName string, objectKey string) (*ObjectInfo, error) {
+	location, err := backend.locate(bucketName, objectKey)
+	if err != nil {
+		return nil, err
+	}
+	metadata, err := backend.readMetadata(bucketName, objectKey)
+	if err != nil {
+		return nil, err
+	}
+	size, etag, err := contentAttributes(metadata, location.size(), func() (string, error) {
+		switch {
+		case location.store != nil:
+			return location.entry.etag, nil
+		case location.manifest != nil:
+			return location.manifest.ETag, nil
+		}
+		return fileETag(backend.objectPath(bucketName, objectKey))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &ObjectInfo{
+		Size:         size,
+		ETag:         etag,
+		LastModified: location.modTime(),
+		Metadata:     metadata,
+	}, nil
+}
+
+// List walks the bucket folder, the pack index and the segment manifests and
+// returns all object
+// versions sorted in the S3 listing order. Objects are stored without
+// history, so every object has exactly one "null" version which is the latest
+// one.
+func (backend *FileSystemBackend) List(bucketName string) ([]models.ObjectVersion, error) {
+	if !backend.serves(bucketName, "") {
+		return nil, fs.ErrNotExist
+	}
+	bucketPath := backend.bucketPath(bucketName)
+
+	versions := make([]models.ObjectVersion, 0)
+	listed := map[string]bool{}
+	appendVersion := func(objectKey string) error {
+		info, err := backend.Stat(bucketName, objectKey)
+		if err != nil {
+			return err
+		}
+		listed[objectKey] = true
+		versions = append(versions, models.ObjectVersion{
+			Object: models.Object{
+				Key:          objectKey,
+				LastModified: info.LastModified,
+				ETag:         info.ETag,
+				Size:         utils.SizeInBytes(info.Size),
+				StorageClass: "STANDARD",
+			},
+			VersionId: NULL_VERSION_ID,
+			IsLatest:  true,
+		})
+		return nil
+	}
+
+	err := filepath.WalkDir(bucketPath, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if entry.Name() == SYSTEM_FOLDER {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !entry.Type().IsRegular() || isTemporaryFile(entry.Name()) {
+			return nil
+		}
+
+		relativePath, err := filepath.Rel(bucketPath, path)
+		if err != nil {
+			return err
+		}
+		return appendVersion(filepath.ToSlash(relativePath))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := openPackStore(backend.packFolder(bucketName), false)
+	if err != nil {
+		return nil, err
+	}
+	objectKeys := make([]string, 0)
+	if store != nil {
+		for objectKey := range store.Entries() {
+			objectKeys = append(objectKeys, objectKey)
+		}
+	}
+	segments, err := backend.segments()
+	if err != nil {
+		return nil, err
+	}
+	deduplicatedKeys, err := segments.Keys(bucketName)
+	if err != nil {
+		return nil, err
+	}
+	for _, objectKey := range append(objectKeys, deduplicatedKeys...) {
+		if listed[objectKey] {
+			continue
+		}
+		// The object deleted after its key was listed is skipped
+		if err := appendVersion(objectKey); err != nil && !errors.Is(err, ErrNoSuchKey) {
+			return nil, err
+		}
+	}
+
+	sortVersions(versions)
+	return versions, nil
+}
+
+// Delete removes the object file, appends the tombstone of the packed object
+// or releases the segments of the deduplicated one, the metadata and the folders of the key which became empty are
+// removed too, the bucket folder itself is kept.
+func (backend *FileSystemBackend) Delete(bucketName string, objectKey string) error {
+	if !backend.serves(bucketName, objectKey) {
+		return fs.ErrNotExist
+	}
+	bucketPath := backend.bucketPath(bucketName)
+	objectPath := backend.objectPath(bucketName, objectKey)
+
+	packed := false
+	store, err := openPackStore(backend.packFolder(bucketName), false)
+	if err != nil {
+		return err
+	}
+	if store != nil {
+		packed, err = store.Delete(objectKey)
+		if err != nil {
+			return err
+		}
+	}
+	segments, err := backend.segments()
+	if err != nil {
+		return err
+	}
+	deduplicated, err := segments.Delete(bucketName, objectKey)
+	if err != nil {
+		return err
+	}
+	// The object kept out of the bucket folder may have no file
+	keptElsewhere := packed || deduplicated
+
+	fileInfo, err := os.Stat(objectPath)
+	switch {
+	case err == nil && !fileInfo.IsDir():
+		if err := removeWithEmptyParents(objectPath, bucketPath); err != nil {
+			return err
+		}
+	case err == nil && !keptElsewhere:
+		return fmt.Errorf("can't delete object %s/%s cause it is a folder", bucketName, objectKey)
+	case err != nil && !(keptElsewhere && errors.Is(err, fs.ErrNotExist)):
+		return err
+	}
+
+	err = removeWithEmptyParents(backend.metadataPath(bucketName, objectKey), strings.Join([]string{
+		backend.RootFolder,
+		SYSTEM_FOLDER,
+		METADATA_FOLDER,
+	}, "/"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// GetMetadata returns the object metadata, objects without the stored
+// metadata (e.g. copied into the data folder by hand) have empty one.
+func (backend *FileSystemBackend) GetMetadata(bucketName string, objectKey string) (*models.ObjectMetadata, error) {
+	if _, err := backend.locate(bucketName, objectKey); err != nil {
+		return nil, err
+	}
+	return backend.readMetadata(bucketName, objectKey)
+}
+
+func (backend *FileSystemBackend) readMetadata(bucketName string, objectKey string) (*models.ObjectMetadata, error) {
+	metadata := models.ObjectMetadata{}
+	data, err := os.ReadFile(backend.metadataPath(bucketName, objectKey))
+	if errors.Is(err, fs.ErrNotExist) {
+		return &metadata, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return nil, err
+	}
+	return &metadata, nil
+}
+
+func (backend *FileSystemBackend) PutMetadata(bucketName string, objectKey string, metadata *models.ObjectMetadata) error {
+	if _, err := backend.locate(bucketName, objectKey); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	// The metadata keeps the wrapped data key of the encrypted object
+	return writeFileAtomically(backend.metadataPath(bucketName, objectKey), data, RECORD_FILE_MODE)
+}
+
+// CollectSegments removes the unreferenced segments, see Deduplicator.
+func (backend *FileSystemBackend) CollectSegments() (int64, int64, error) {
+	segments, err := backend.segments()
+	if err != nil {
+		return 0, 0, err
+	}
+	return segments.Collect()
+}
+
+func (backend *FileSystemBackend) DedupStatistics() (*models.DedupStatistics, error) {
+	segments, err := backend.segments()
+	if err != nil {
+		return nil, err
+	}
+	return segments.Statistics()
+}
+
+// CompactPacks rewrites the packs of the bucket, see PackCompactor.
+func (backend *FileSystemBackend) CompactPacks(bucketName string, minGarbageRatio float64) (int64, error) {
+	if !backend.serves(bucketName, "") {
+		return 0, nil
+	}
+	store, err := openPackStore(backend.packFolder(bucketName), false)
+	if err != nil || store == nil {
+		return 0, err
+	}
+	if ratio := store.GarbageRatio(); ratio == 0 || ratio < minGarbageRatio {
+		return 0, nil
+	}
+	return store.Compact()
+}
+
+func (backend *FileSystemBackend) PutUpload(upload *models.Upload) error {
+	uploadPath := backend.uploadPath(upload.Bucket, upload.Key, upload.UploadId)
+	err := os.MkdirAll(uploadPath, fs.ModeDir|0775)
+	if err != nil {
+		return err
+	}
+
+	record, err := json.Marshal(upload)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(strings.Join([]string{uploadPath, UPLOAD_RECORD}, "/"), record, 0644)
+}
+
+func (backend *FileSystemBackend) GetUpload(bucketName string, objectKey string, uploadId string) (*models.Upload, error) {
+	record, err := os.ReadFile(strings.Join([]string{
+		backend.uploadPath(bucketName, objectKey, uploadId),
+		UPLOAD_RECORD,
+	}, "/"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNoSuchUpload
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	upload := models.Upload{}
+	if err := json.Unmarshal(record, &upload); err != nil {
+		return nil, err
+	}
+	return &upload, nil
+}
+
+func (backend *FileSystemBackend) DeleteUpload(bucketName string, objectKey string, uploadId string) error {
+	if _, err := backend.GetUpload(bucketName, objectKey, uploadId); err != nil {
+		return err
+	}
+	return os.RemoveAll(backend.uploadPath(bucketName, objectKey, uploadId))
+}
+
+// ListUploads returns the multipart uploads of the bucket which were not
+// completed or aborted yet.
+func (backend *FileSystemBackend) ListUploads(bucketName string) ([]models.Upload, error) {
+	entries, err := os.ReadDir(backend.uploadsPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return []models.Upload{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	uploads := make([]models.Upload, 0)
+	for _, entry := range entries {
+		record, err := os.ReadFile(strings.Join([]string{
+			backend.uploadsPath(),
+			entry.Name(),
+			UPLOAD_RECORD,
+		}, "/"))
+		if err != nil {
+			continue
+		}
+		upload := models.Upload{}
+		if err := json.Unmarshal(record, &upload); err != nil {
+			continue
+		}
+		if upload.Bucket == bucketName {
+			uploads = append(uploads, upload)
+		}
+	}
+	sortUploads(uploads)
+	return uploads, nil
+}
+
+func (backend *FileSystemBackend) PutPart(bucketName string, objectKey string, uploadId string, partNumber int, reader io.Reader) error {
+	if _, err := backend.GetUpload(bucketName, objectKey, uploadId); err != nil {
+		return err
+	}
+
+	size, _ := declaredSizeOf(reader, &models.ObjectMetadata{})
+	if err := checkDiskSpace(backend.RootFolder, backend.Reserve, size); err != nil {
+		return err
+	}
+	return streamFileAtomically(strings.Join([]string{
+		backend.uploadPath(bucketName, objectKey, uploadId),
+		partFileName(partNumber),
+	}, "/"), reader, OBJECT_FILE_MODE, func(int64) error {
+		return checkDiskSpace(backend.RootFolder, backend.Reserve, 0)
+	})
+}
+
+func (backend *FileSystemBackend) GetPart(bucketName string, objectKey string, uploadId string, partNumber int) (io.ReadSeekCloser, error) {
+	file, err := os.Open(strings.Join([]string{
+		backend.uploadPath(bucketName, objectKey, uploadId),
+		partFileName(partNumber),
+	}, "/"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrInvalidPart
+	}
+	return file, err
+}
+
+func (backend *FileSystemBackend) ListParts(bucketName string, objectKey string, uploadId string) ([]int, error) {
+	entries, err := os.ReadDir(backend.uploadPath(bucketName, objectKey, uploadId))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNoSuchUpload
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	partNumbers := make([]int, 0, len(entries))
+	for _, entry := range entries {
+		partNumber := 0
+		if _, err := fmt.Sscanf(entry.Name(), "%05d.part", &partNumber); err == nil {
+			partNumbers = append(partNumbers, partNumber)
+		}
+	}
+	sort.Ints(partNumbers)
+	return partNumbers, nil
+}
+
+func (backend *FileSystemBackend) GetRecord(name string) ([]byte, error) {
+	return os.ReadFile(backend.recordPath(name))
+}
+
+func (backend *FileSystemBackend) PutRecord(name string, data []byte) error {
+	return writeFileAtomically(backend.recordPath(name), data, RECORD_FILE_MODE)
+}
+
+func (backend *FileSystemBackend) DeleteRecord(name string) error {
+	return os.Remove(backend.recordPath(name))
+}
+
+func (backend *FileSystemBackend) ListRecords(folder string) ([]string, error) {
+	entries, err := os.ReadDir(backend.recordPath(folder))
+	if errors.Is(err, fs.ErrNotExist) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		// The records being written are hidden
+		if entry.Type().IsRegular() && !strings.HasPrefix(entry.Name(), ".") {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+func (backend *FileSystemBackend) DiskStatus() ([]models.DiskStatus, error) {
+	status, err := diskStatusOf(backend.RootFolder, backend.Reserve)
+	if err != nil {
+		return nil, err
+	}
+	return []models.DiskStatus{status}, nil
+}
@@ -0,0 +1,275 @@
+/**
+ * Author: Vanya Usalko <ivict@rambler.ru>
+ * File: folder_watcher.go
+ */
+
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DEFAULT_RESCAN_INTERVAL = 5 * time.Minute
+
+// WATCHER_SETTLE_DELAY is the time the changed paths are collected before
+// they are refreshed, so the files being written by the service are refreshed
+// after the service indexed them.
+const WATCHER_SETTLE_DELAY = time.Second
+
+// FolderWatcher detects the files added, modified or removed in the bucket
+// folders of RootFolder out of the service (e.g. copied by hand). The
+// changed files are restated into the indexes of the buckets, so their ETags
+// are computed once, and the notification events are queued for them. The
+// folder is watched by inotify on Linux and is rescanned every Interval
+// anyway. The buckets without the index are listed walking their folders so
+// they are never stale and are not watched.
+type FolderWatcher struct {
+	Backend    *IndexedBackend
+	RootFolder string
+	Interval   time.Duration
+
+	scannedLock sync.Mutex
+	// scanned are the files of the buckets seen by the previous rescan, the
+	// keys stored transformed (compressed, encrypted) or out of the bucket
+	// folder (packed, deduplicated) differ from their index entries, so they
+	// are restated only when their files are changed since that rescan
+	scanned map[string]map[string]scannedFile
+}
+
+// scannedFile is the state of the file of the key, missing is set for the
+// indexed keys without the file.
+type scannedFile struct {
+	size    int64
+	modTime time.Time
+	missing bool
+}
+
+func (file scannedFile) equal(other scannedFile) bool {
+	return file.size == other.size && file.modTime.Equal(other.modTime) && file.missing == other.missing
+}
+
+func (watcher *FolderWatcher) Run(ctx context.Context) {
+	interval := watcher.Interval
+	if interval <= 0 {
+		interval = DEFAULT_RESCAN_INTERVAL
+	}
+	changes := make(chan string, 256)
+	if err := watchFolder(ctx, watcher.RootFolder, changes); err != nil {
+		fmt.Printf("[watch] the changes are detected by the rescan every %s only: %s\n", interval, err)
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	health := startWorkerHealth("folder-watcher", interval)
+	defer health.stop()
+	settle := time.NewTimer(WATCHER_SETTLE_DELAY)
+	settle.Stop()
+
+	pending := map[string]bool{}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case path := <-changes:
+			if len(pending) == 0 {
+				settle.Reset(WATCHER_SETTLE_DELAY)
+			}
+			pending[path] = true
+		case <-settle.C:
+			for path := range pending {
+				if err := watcher.RefreshPath(path); err != nil {
+					fmt.Printf("[watch] %s\n", err)
+				}
+			}
+			pending = map[string]bool{}
+		case <-ticker.C:
+			err := watcher.Rescan()
+			if err != nil {
+				fmt.Printf("[watch] %s\n", err)
+			}
+			health.ran(err)
+		}
+	}
+}
+
+// objectOf returns the bucket and the key of the path of RootFolder, the
+// bucket is empty for the paths out of the bucket folders.
+func (watcher *FolderWatcher) objectOf(path string) (string, string) {
+	relativePath, err := filepath.Rel(watcher.RootFolder, path)
+	if err != nil || relativePath == "." || strings.HasPrefix(relativePath, "..") {
+		return "", ""
+	}
+	bucketName, objectKey, _ := strings.Cut(filepath.ToSlash(relativePath), "/")
+	if bucketName == SYSTEM_FOLDER || strings.HasPrefix(objectKey, SYSTEM_FOLDER+"/") {
+		return "", ""
+	}
+	return bucketName, objectKey
+}
+
+// refresh restates the object into the bucket index and queues the event of
+// the change.
+func (watcher *FolderWatcher) refresh(bucketName string, objectKey string) error {
+	event, err := watcher.Backend.Refresh(bucketName, objectKey)
+	if err != nil || event == "" {
+		return err
+	}
+	fmt.Printf("[watch] %s/%s is changed out of the service (%s)\n", bucketName, objectKey, event)
+	forgetUsage(watcher.Backend, bucketName)
+	storage := Storage{Backend: watcher.Backend}
+	return storage.Notify(bucketName, objectKey, event, "", "", "")
+}
+
+// RefreshPath refreshes the objects of the changed path, the path may be the
+// file, the folder of the files or the path removed with its subfolders.
+func (watcher *FolderWatcher) RefreshPath(path string) error {
+	bucketName, objectKey := watcher.objectOf(path)
+	if bucketName == "" {
+		return watcher.Rescan()
+	}
+	if objectKey == "" {
+		return watcher.rescanBucket(bucketName)
+	}
+	if isTemporaryFile(filepath.Base(path)) {
+		// The object is refreshed when the written file is renamed in place
+		return nil
+	}
+
+	var result error
+	fileInfo, err := os.Stat(path)
+	if err == nil && fileInfo.IsDir() {
+		err = filepath.WalkDir(path, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if entry.IsDir() && entry.Name() == SYSTEM_FOLDER {
+				return filepath.SkipDir
+			}
+			if entry.Type().IsRegular() && !isTemporaryFile(entry.Name()) {
+				_, objectKey := watcher.objectOf(path)
+				result = errors.Join(result, watcher.refresh(bucketName, objectKey))
+			}
+			return nil
+		})
+		return errors.Join(result, err)
+	}
+
+	result = watcher.refresh(bucketName, objectKey)
+	if err != nil {
+		// The removed folder takes the objects of its keys away
+		index, err := watcher.Backend.index(bucketName)
+		if err != nil || index == nil {
+			return errors.Join(result, err)
+		}
+		for _, entry := range index.entriesCopy() {
+			if strings.HasPrefix(entry.Key, objectKey+"/") {
+				result = errors.Join(result, watcher.refresh(bucketName, entry.Key))
+			}
+		}
+	}
+	return result
+}
+
+// Rescan compares the files of the indexed buckets with their indexes and
+// refreshes the differences.
+func (watcher *FolderWatcher) Rescan() error {
+	entries, err := os.ReadDir(watcher.RootFolder)
+	if err != nil {
+		return err
+	}
+	var result error
+	for _, entry := range entries {
+		if entry.IsDir() && entry.Name() != SYSTEM_FOLDER {
+			result = errors.Join(result, watcher.rescanBucket(entry.Name()))
+		}
+	}
+	return result
+}
+
+func (watcher *FolderWatcher) rescanBucket(bucketName string) error {
+	index, err := watcher.Backend.index(bucketName)
+	if err != nil || index == nil {
+		return err
+	}
+	indexed := map[string]indexEntry{}
+	for _, entry := range index.entriesCopy() {
+		indexed[entry.Key] = entry
+	}
+	watcher.scannedLock.Lock()
+	previous := watcher.scanned[bucketName]
+	watcher.scannedLock.Unlock()
+	unchanged := func(objectKey string, file scannedFile) bool {
+		last, exists := previous[objectKey]
+		return exists && last.equal(file)
+	}
+
+	bucketPath := filepath.Join(watcher.RootFolder, bucketName)
+	scanned := map[string]scannedFile{}
+	changed := make([]string, 0)
+	err = filepath.WalkDir(bucketPath, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if entry.Name() == SYSTEM_FOLDER {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !entry.Type().IsRegular() || isTemporaryFile(entry.Name()) {
+			return nil
+		}
+		fileInfo, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		_, objectKey := watcher.objectOf(path)
+		indexedEntry, exists := indexed[objectKey]
+		delete(indexed, objectKey)
+		file := scannedFile{size: fileInfo.Size(), modTime: fileInfo.ModTime()}
+		scanned[objectKey] = file
+		if unchanged(objectKey, file) {
+			return nil
+		}
+		// The objects stored transformed have the other size indexed, they
+		// are restated without computing the ETag
+		if !exists || indexedEntry.Size != fileInfo.Size() || !indexedEntry.LastModified.Equal(fileInfo.ModTime()) {
+			changed = append(changed, objectKey)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// The indexed objects without the file are removed or are kept out of
+	// the bucket folder (packed, deduplicated)
+	for objectKey := range indexed {
+		file := scannedFile{missing: true}
+		scanned[objectKey] = file
+		if !unchanged(objectKey, file) {
+			changed = append(changed, objectKey)
+		}
+	}
+
+	var result error
+	for _, objectKey := range changed {
+		if err := watcher.refresh(bucketName, objectKey); err != nil {
+			// The key is restated again by the next rescan
+			delete(scanned, objectKey)
+			result = errors.Join(result, err)
+		}
+	}
+	watcher.scannedLock.Lock()
+	defer watcher.scannedLock.Unlock()
+	if watcher.scanned == nil {
+		watcher.scanned = map[string]map[string]scannedFile{}
+	}
+	watcher.scanned[bucketName] = scanned
+	return result
+}
@@ -0,0 +1,114 @@
+//go:build linux
+
+/**
+ * Author: Vanya Usalko <ivict@rambler.ru>
+ * File: folder_watcher_linux.go
+ */
+
+package services
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+const WATCH_MASK = syscall.IN_CREATE | syscall.IN_CLOSE_WRITE | syscall.IN_ATTRIB | syscall.IN_DELETE |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO
+
+// watchFolder sends the paths changed in the folder and its subfolders until
+// the context is done, the system folder is not watched. The folder itself is
+// sent when the events were lost.
+func watchFolder(ctx context.Context, folder string, changes chan<- string) error {
+	descriptor, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return err
+	}
+	// The non-blocking file is read by the runtime poller, so it is unblocked
+	// when it is closed
+	events := os.NewFile(uintptr(descriptor), "inotify")
+
+	watches := map[int]string{}
+	addWatches := func(path string) error {
+		return filepath.WalkDir(path, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				// The folder removed meanwhile
+				if errors.Is(err, fs.ErrNotExist) {
+					return nil
+				}
+				return err
+			}
+			if !entry.IsDir() {
+				return nil
+			}
+			if entry.Name() == SYSTEM_FOLDER {
+				return filepath.SkipDir
+			}
+			watch, err := syscall.InotifyAddWatch(descriptor, path, WATCH_MASK)
+			if err != nil {
+				return err
+			}
+			watches[watch] = path
+			return nil
+		})
+	}
+	if err := addWatches(folder); err != nil {
+		events.Close()
+		return err
+	}
+
+	go func() {
+		<-ctx.Done()
+		events.Close()
+	}()
+	go func() {
+		buffer := make([]byte, 64*1024)
+		for {
+			count, err := events.Read(buffer)
+			if err != nil {
+				if !errors.Is(err, os.ErrClosed) {
+					fmt.Printf("[watch] the watching is stopped: %s\n", err)
+				}
+				return
+			}
+			for offset := 0; offset+syscall.SizeofInotifyEvent <= count; {
+				watch := int(int32(binary.NativeEndian.Uint32(buffer[offset:])))
+				mask := binary.NativeEndian.Uint32(buffer[offset+4:])
+				nameSize := int(binary.NativeEndian.Uint32(buffer[offset+12:]))
+				name := strings.TrimRight(string(buffer[offset+syscall.SizeofInotifyEvent:offset+syscall.SizeofInotifyEvent+nameSize]), "\x00")
+				offset += syscall.SizeofInotifyEvent + nameSize
+
+				path, exists := watches[watch]
+				switch {
+				case mask&syscall.IN_Q_OVERFLOW != 0:
+					path = folder
+				case mask&syscall.IN_IGNORED != 0:
+					delete(watches, watch)
+					continue
+				case !exists:
+					continue
+				case name != "":
+					path = filepath.Join(path, name)
+				}
+				if mask&syscall.IN_ISDIR != 0 && mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 && filepath.Base(path) != SYSTEM_FOLDER {
+					if err := addWatches(path); err != nil {
+						fmt.Printf("[watch] %s is not watched: %s\n", path, err)
+					}
+				}
+
+				select {
+				case changes <- path:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+
+/**
+ * Author: Vanya Usalko <ivict@rambler.ru>
+ * File: folder_watcher_other.go
+ */
+
+package services
+
+import (
+	"context"
+	"errors"
+)
+
+// watchFolder is implemented by inotify on Linux only, the folder is
+// rescanned on the other platforms.
+func watchFolder(ctx context.Context, folder string, changes chan<- string) error {
+	return errors.New("the folder watching is not supported on this platform")
+}
@@ -0,0 +1,377 @@
+/**
+ * Author: Vanya Usalko <ivict@rambler.ru>
+ * File: fs_backend.go
+ */
+
+package services
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/usalko/s2d3/models"
+	"github.com/usalko/s2d3/utils"
+)
+
+// FSBackend serves the read-only buckets of the io/fs.FS (embed.FS, zip
+// archive, os.DirFS etc.), all writes are refused with ErrReadOnly.
+type FSBackend struct {
+	FS fs.FS
+	// Bucket is the name of the bucket of the FS root, the top folders of FS
+	// are the buckets when it is empty
+	Bucket string
+
+	etagsLock sync.Mutex
+	// etags are the ETags of the files, the file is hashed again when its
+	// size or modification time is changed
+	etags map[string]fsETag
+}
+
+type fsETag struct {
+	size    int64
+	modTime time.Time
+	etag    string
+}
+
+// fsStreamReader streams the file which can't seek (e.g. the compressed file
+// of the zip archive), the seek back reads the file again from its start.
+type fsStreamReader struct {
+	fs.FS
+	path   string
+	size   int64
+	file   fs.File
+	read   int64
+	offset int64
+}
+
+func (reader *fsStreamReader) Read(data []byte) (int, error) {
+	if reader.file == nil || reader.offset < reader.read {
+		if reader.file != nil {
+			reader.file.Close()
+		}
+		file, err := reader.FS.Open(reader.path)
+		if err != nil {
+			return 0, err
+		}
+		reader.file, reader.read = file, 0
+	}
+	if reader.offset > reader.read {
+		skipped, err := io.CopyN(io.Discard, reader.file, reader.offset-reader.read)
+		reader.read += skipped
+		if err != nil {
+			return 0, err
+		}
+	}
+	count, err := reader.file.Read(data)
+	reader.read += int64(count)
+	reader.offset = reader.read
+	return count, err
+}
+
+// Seek only moves the offset, the file is read up to it by the next Read.
+func (reader *fsStreamReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += reader.offset
+	case io.SeekEnd:
+		offset += reader.size
+	}
+	if offset < 0 {
+		return 0, errors.New("the seek to the negative position")
+	}
+	reader.offset = offset
+	return offset, nil
+}
+
+func (reader *fsStreamReader) Close() error {
+	if reader.file == nil {
+		return nil
+	}
+	return reader.file.Close()
+}
+
+func (backend *FSBackend) bucketRoot(bucketName string) (string, error) {
+	if backend.Bucket != "" {
+		if bucketName != backend.Bucket {
+			return "", ErrNoSuchBucket
+		}
+		return ".", nil
+	}
+	if bucketName == "" || !fs.ValidPath(bucketName) || strings.Contains(bucketName, "/") {
+		return "", ErrNoSuchBucket
+	}
+	return bucketName, nil
+}
+
+func (backend *FSBackend) objectPath(bucketName string, objectKey string) (string, error) {
+	root, err := backend.bucketRoot(bucketName)
+	if err != nil || objectKey == "" {
+		return "", ErrNoSuchKey
+	}
+	objectPath := path.Join(root, objectKey)
+	if !fs.ValidPath(objectPath) {
+		return "", ErrNoSuchKey
+	}
+	return objectPath, nil
+}
+
+func (backend *FSBackend) stat(bucketName string, objectKey string) (string, fs.FileInfo, error) {
+	objectPath, err := backend.objectPath(bucketName, objectKey)
+	if err != nil {
+		return "", nil, err
+	}
+	fileInfo, err := fs.Stat(backend.FS, objectPath)
+	if err != nil || !fileInfo.Mode().IsRegular() {
+		return "", nil, ErrNoSuchKey
+	}
+	return objectPath, fileInfo, nil
+}
+
+func (backend *FSBackend) ListBuckets() ([]models.Bucket, error) {
+	if backend.Bucket != "" {
+		bucket := models.Bucket{Name: backend.Bucket}
+		if fileInfo, err := fs.Stat(backend.FS, "."); err == nil {
+			bucket.CreationDate = fileInfo.ModTime().UTC()
+		}
+		return []models.Bucket{bucket}, nil
+	}
+
+	entries, err := fs.ReadDir(backend.FS, ".")
+	if err != nil {
+		return nil, err
+	}
+	buckets := make([]models.Bucket, 0)
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		fileInfo, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		buckets = append(buckets, models.Bucket{
+			Name:         entry.Name(),
+			CreationDate: fileInfo.ModTime().UTC(),
+		})
+	}
+	return buckets, nil
+}
+
+func (backend *FSBackend) CreateBucket(bucketName string) error {
+	return ErrReadOnly
+}
+
+func (backend *FSBackend) CheckBucket(bucketName string) error {
+	root, err := backend.bucketRoot(bucketName)
+	if err != nil {
+		return err
+	}
+	fileInfo, err := fs.Stat(backend.FS, root)
+	if err != nil || !fileInfo.IsDir() {
+		return ErrNoSuchBucket
+	}
+	return nil
+}
+
+func (backend *FSBackend) CheckWritable(bucketName string) error {
+	return ErrReadOnly
+}
+
+func (backend *FSBackend) GetBucketConfig(bucketName string, configName string) ([]byte, error) {
+	if err := backend.CheckBucket(bucketName); err != nil {
+		return nil, err
+	}
+	return nil, ErrNoSuchConfiguration
+}
+
+func (backend *FSBackend) PutBucketConfig(bucketName string, configName string, data []byte) error {
+	return ErrReadOnly
+}
+
+func (backend *FSBackend) DeleteBucketConfig(bucketName string, configName string) error {
+	return ErrReadOnly
+}
+
+func (backend *FSBackend) Put(bucketName string, objectKey string, reader io.Reader, metadata *models.ObjectMetadata) error {
+	return ErrReadOnly
+}
+
+// Get returns the file of the object, the files which can't seek (e.g. the
+// compressed files of the zip archive) are streamed.
+func (backend *FSBackend) Get(bucketName string, objectKey string) (io.ReadSeekCloser, *ObjectInfo, error) {
+	objectPath, fileInfo, err := backend.stat(bucketName, objectKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	file, err := backend.FS.Open(objectPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	info := &ObjectInfo{
+		Size:         fileInfo.Size(),
+		LastModified: fileInfo.ModTime().UTC(),
+		Metadata:     &models.ObjectMetadata{},
+	}
+
+	if reader, seekable := file.(io.ReadSeekCloser); seekable {
+		return reader, info, nil
+	}
+	return &fsStreamReader{FS: backend.FS, path: objectPath, size: fileInfo.Size(), file: file}, info, nil
+}
+
+// etagOf returns the MD5 of the file, the hash is kept until the file is
+// changed.
+func (backend *FSBackend) etagOf(objectPath string, fileInfo fs.FileInfo) (string, error) {
+	backend.etagsLock.Lock()
+	cached, exists := backend.etags[objectPath]
+	backend.etagsLock.Unlock()
+	if exists && cached.size == fileInfo.Size() && cached.modTime.Equal(fileInfo.ModTime()) {
+		return cached.etag, nil
+	}
+
+	file, err := backend.FS.Open(objectPath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := md5.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	etag := hex.EncodeToString(hash.Sum(nil))
+
+	backend.etagsLock.Lock()
+	defer backend.etagsLock.Unlock()
+	if backend.etags == nil {
+		backend.etags = map[string]fsETag{}
+	}
+	backend.etags[objectPath] = fsETag{size: fileInfo.Size(), modTime: fileInfo.ModTime(), etag: etag}
+	return etag, nil
+}
+
+func (backend *FSBackend) Stat(bucketName string, objectKey string) (*ObjectInfo, error) {
+	objectPath, fileInfo, err := backend.stat(bucketName, objectKey)
+	if err != nil {
+		return nil, err
+	}
+	etag, err := backend.etagOf(objectPath, fileInfo)
+	if err != nil {
+		return nil, err
+	}
+	return &ObjectInfo{
+		Size:         fileInfo.Size(),
+		ETag:         etag,
+		LastModified: fileInfo.ModTime().UTC(),
+		Metadata:     &models.ObjectMetadata{},
+	}, nil
+}
+
+func (backend *FSBackend) List(bucketName string) ([]models.ObjectVersion, error) {
+	root, err := backend.bucketRoot(bucketName)
+	if err != nil {
+		return nil, fs.ErrNotExist
+	}
+
+	versions := make([]models.ObjectVersion, 0)
+	err = fs.WalkDir(backend.FS, root, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+
+		objectKey := filePath
+		if root != "." {
+			objectKey = strings.TrimPrefix(filePath, root+"/")
+		}
+		info, err := backend.Stat(bucketName, objectKey)
+		if err != nil {
+			return err
+		}
+		versions = append(versions, models.ObjectVersion{
+			Object: models.Object{
+				Key:          objectKey,
+				LastModified: info.LastModified,
+				ETag:         info.ETag,
+				Size:         utils.SizeInBytes(info.Size),
+				StorageClass: "STANDARD",
+			},
+			VersionId: NULL_VERSION_ID,
+			IsLatest:  true,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sortVersions(versions)
+	return versions, nil
+}
+
+func (backend *FSBackend) Delete(bucketName string, objectKey string) error {
+	return ErrReadOnly
+}
+
+func (backend *FSBackend) GetMetadata(bucketName string, objectKey string) (*models.ObjectMetadata, error) {
+	if _, _, err := backend.stat(bucketName, objectKey); err != nil {
+		return nil, err
+	}
+	return &models.ObjectMetadata{}, nil
+}
+
+func (backend *FSBackend) PutMetadata(bucketName string, objectKey string, metadata *models.ObjectMetadata) error {
+	return ErrReadOnly
+}
+
+func (backend *FSBackend) PutUpload(upload *models.Upload) error {
+	return ErrReadOnly
+}
+
+func (backend *FSBackend) GetUpload(bucketName string, objectKey string, uploadId string) (*models.Upload, error) {
+	return nil, ErrNoSuchUpload
+}
+
+func (backend *FSBackend) DeleteUpload(bucketName string, objectKey string, uploadId string) error {
+	return ErrNoSuchUpload
+}
+
+func (backend *FSBackend) ListUploads(bucketName string) ([]models.Upload, error) {
+	return []models.Upload{}, nil
+}
+
+func (backend *FSBackend) PutPart(bucketName string, objectKey string, uploadId string, partNumber int, reader io.Reader) error {
+	return ErrReadOnly
+}
+
+func (backend *FSBackend) GetPart(bucketName string, objectKey string, uploadId string, partNumber int) (io.ReadSeekCloser, error) {
+	return nil, ErrInvalidPart
+}
+
+func (backend *FSBackend) ListParts(bucketName string, objectKey string, uploadId string) ([]int, error) {
+	return nil, ErrNoSuchUpload
+}
+
+func (backend *FSBackend) GetRecord(name string) ([]byte, error) {
+	return nil, fs.ErrNotExist
+}
+
+func (backend *FSBackend) PutRecord(name string, data []byte) error {
+	return ErrReadOnly
+}
+
+func (backend *FSBackend) DeleteRecord(name string) error {
+	return fs.ErrNotExist
+}
+
+func (backend *FSBackend) ListRecords(folder string) ([]string, error) {
+	return []string{}, nil
+}
@@ -5,24 +5,34 @@ import (
 )
 
 func Get(writer http.ResponseWriter, request *http.Request) error {
-	storage := Storage{
-		RootFolder: request.Context().Value(KeyDataFolder).(string),
-	}
+	storage := storageOf(request)
 
 	bucketName, objectName := bucketNameAndObjectKey(request.URL.Path, request.Context().Value(KeyUrlContext).(string))
 
-	data, err := storage.GetData(bucketName, objectName, "")
+	sse, err := serverSideEncryptionOf(request)
 	if err != nil {
-		// Handle statistics application
-		statisticsApplicationFolder := request.Context().Value(KeyStatisticsApplicationFolder).(string)
-		fs := http.FileServer(http.Dir(statisticsApplicationFolder))
-		fs.ServeHTTP(writer, request)
+		writeError(writer, http.StatusBadRequest, CodeInvalidArgument, err.Error())
 		return err
 	}
 
-	_, err = writer.Write(data)
+	reader, err := storage.OpenObject(bucketName, objectName, sse)
 	if err != nil {
+		writeEncryptionError(writer, err)
 		return err
 	}
+	defer reader.Close()
+
+	setTaggingCount(writer, reader.Metadata.Tags)
+	setObjectLockHeaders(writer, reader.Metadata)
+	setEncryptionHeaders(writer, reader.Metadata.Encryption)
+	if reader.Metadata.ContentType != "" {
+		writer.Header().Set("Content-Type", reader.Metadata.ContentType)
+	}
+	if reader.ETag != "" {
+		writer.Header().Set("ETag", quotedETag(reader.ETag))
+	}
+
+	// The range and conditional requests are served by the content reader
+	http.ServeContent(writer, request, objectName, reader.LastModified, reader)
 	return nil
 }
@@ -0,0 +1,212 @@
+/**
+ * Author: Vanya Usalko <ivict@rambler.ru>
+ * File: health.go
+ */
+
+package services
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/usalko/s2d3/models"
+)
+
+// The probes of the orchestrators are served in the admin namespace without
+// the admin token.
+const HEALTHZ_PATH = ADMIN_PATH + "healthz"
+const READYZ_PATH = ADMIN_PATH + "readyz"
+const INFO_PATH = ADMIN_PATH + "info"
+
+// READYZ_PROBE_RECORD is written and removed to check the data folder is
+// writable.
+const READYZ_PROBE_RECORD = "readyz.probe"
+
+// The background worker is stalled when it doesn't run for
+// WORKER_STALL_INTERVALS of its intervals.
+const WORKER_STALL_INTERVALS = 3
+
+// IndexChecker is implemented by the backends listing the buckets from their
+// indexes.
+type IndexChecker interface {
+	// CheckIndexes loads the indexes of all buckets
+	CheckIndexes() error
+}
+
+// MountReporter is implemented by the backends serving the mounted buckets.
+type MountReporter interface {
+	Mounts() []Mount
+}
+
+// workerHealth is the state of the running background worker.
+type workerHealth struct {
+	name      string
+	interval  time.Duration
+	startTime time.Time
+	lastRun   time.Time
+	lastError error
+}
+
+var workerHealths = map[*workerHealth]bool{}
+var workerHealthsLock sync.Mutex
+
+// startWorkerHealth registers the background worker running every interval,
+// the worker reports its runs by ran and unregisters by stop.
+func startWorkerHealth(name string, interval time.Duration) *workerHealth {
+	health := &workerHealth{
+		name:      name,
+		interval:  interval,
+		startTime: time.Now(),
+	}
+
+	workerHealthsLock.Lock()
+	defer workerHealthsLock.Unlock()
+
+	workerHealths[health] = true
+	return health
+}
+
+func (health *workerHealth) ran(err error) {
+	workerHealthsLock.Lock()
+	defer workerHealthsLock.Unlock()
+
+	health.lastRun = time.Now()
+	health.lastError = err
+}
+
+func (health *workerHealth) stop() {
+	workerHealthsLock.Lock()
+	defer workerHealthsLock.Unlock()
+
+	delete(workerHealths, health)
+}
+
+// workerChecks returns the checks of the running background workers ordered
+// by their names. The worker which failed its last run stays healthy as the
+// runs fail by the bucket configurations too (e.g. the unreachable webhook),
+// the stalled worker fails the check.
+func workerChecks(now time.Time) []models.HealthCheck {
+	workerHealthsLock.Lock()
+	defer workerHealthsLock.Unlock()
+
+	checks := make([]models.HealthCheck, 0, len(workerHealths))
+	for health := range workerHealths {
+		check := models.HealthCheck{
+			Name:   "worker:" + health.name,
+			Status: models.HealthStatusOk,
+		}
+		since := health.startTime
+		if !health.lastRun.IsZero() {
+			lastRun := health.lastRun.UTC()
+			check.LastRun = &lastRun
+			since = health.lastRun
+		}
+		if health.lastError != nil {
+			check.LastError = health.lastError.Error()
+		}
+		if stalled := now.Sub(since); stalled > WORKER_STALL_INTERVALS*health.interval {
+			check.Status = models.HealthStatusFailed
+			check.Error = fmt.Sprintf("the worker has not run for %s", stalled.Truncate(time.Second))
+		}
+		checks = append(checks, check)
+	}
+	sort.Slice(checks, func(i, j int) bool {
+		return checks[i].Name < checks[j].Name
+	})
+	return checks
+}
+
+// runningWorkers returns the distinct names of the running background
+// workers.
+func runningWorkers() []string {
+	workerHealthsLock.Lock()
+	defer workerHealthsLock.Unlock()
+
+	names := make([]string, 0, len(workerHealths))
+	for health := range workerHealths {
+		names = append(names, health.name)
+	}
+	sort.Strings(names)
+	return slices.Compact(names)
+}
+
+// featuresOf returns the enabled features of the service and its running
+// background workers.
+func featuresOf(request *http.Request, storage *Storage) []string {
+	features := []string{}
+	if _, exists := CapabilityOf[IndexChecker](storage.Backend); exists {
+		features = append(features, "index")
+	}
+	if reporter, exists := CapabilityOf[MountReporter](storage.Backend); exists && len(reporter.Mounts()) > 0 {
+		features = append(features, "mounts")
+	}
+	if format, _ := request.Context().Value(KeyAccessLogFormat).(string); format != "" && format != ACCESS_LOG_OFF {
+		features = append(features, "access-log")
+	}
+	if token, _ := request.Context().Value(KeyAdminToken).(string); token != "" {
+		features = append(features, "admin-api")
+	}
+	if folder, _ := request.Context().Value(KeyStatisticsApplicationFolder).(string); folder != "" {
+		if _, err := os.Stat(folder); err == nil {
+			features = append(features, "dashboard")
+		}
+	}
+	return append(features, runningWorkers()...)
+}
+
+// Healthz answers while the process serves the requests.
+func Healthz(writer http.ResponseWriter, request *http.Request) {
+	writeJson(writer, http.StatusOK, &models.HealthStatus{Status: models.HealthStatusOk})
+}
+
+// Readyz checks the data folder is writable, the indexes are loaded and the
+// background workers are not stalled, the service which is not ready is
+// answered by 503.
+func Readyz(writer http.ResponseWriter, request *http.Request) {
+	storage := storageOf(request)
+	status := &models.HealthStatus{Status: models.HealthStatusOk}
+	check := func(name string, err error) {
+		check := models.HealthCheck{Name: name, Status: models.HealthStatusOk}
+		if err != nil {
+			check.Status, check.Error = models.HealthStatusFailed, err.Error()
+		}
+		status.Checks = append(status.Checks, check)
+	}
+
+	err := storage.PutRecord(READYZ_PROBE_RECORD, []byte(time.Now().UTC().Format(time.RFC3339)))
+	if err == nil {
+		err = storage.DeleteRecord(READYZ_PROBE_RECORD)
+	}
+	check("data-folder", err)
+	if checker, exists := CapabilityOf[IndexChecker](storage.Backend); exists {
+		check("index", checker.CheckIndexes())
+	}
+	status.Checks = append(status.Checks, workerChecks(time.Now())...)
+
+	for _, check := range status.Checks {
+		if check.Status != models.HealthStatusOk {
+			status.Status = models.HealthStatusFailed
+		}
+	}
+	if status.Status != models.HealthStatusOk {
+		writeJson(writer, http.StatusServiceUnavailable, status)
+		return
+	}
+	writeJson(writer, http.StatusOK, status)
+}
+
+// Info serves the server info (version, uptime, mounts, features).
+func Info(writer http.ResponseWriter, request *http.Request) {
+	storage := storageOf(request)
+	info, err := serverInfoOf(request, &storage)
+	if err != nil {
+		writeAdminError(writer, err)
+		return
+	}
+	writeJson(writer, http.StatusOK, info)
+}
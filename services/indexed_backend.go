@@ -0,0 +1,233 @@
+/**
+ * Author: Vanya Usalko <ivict@rambler.ru>
+ * File: indexed_backend.go
+ */
+
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/usalko/s2d3/models"
+)
+
+// IndexedBackend lists the buckets from their persistent indexes of keys, see
+// bucketIndex. The indexes are kept in sync with the objects changed through
+// the backend, the buckets without the index are listed by the wrapped
+// backend.
+type IndexedBackend struct {
+	Backend
+	// Folder keeps the indexes, one subfolder per bucket
+	Folder string
+}
+
+func NewIndexedBackend(backend Backend, folder string) *IndexedBackend {
+	return &IndexedBackend{
+		Backend: backend,
+		Folder:  folder,
+	}
+}
+
+// indexable checks the bucket name can be the name of the index folder.
+func indexable(bucketName string) bool {
+	return bucketName != "" && bucketName != "." && bucketName != ".." && !strings.ContainsAny(bucketName, "/\\")
+}
+
+func (backend *IndexedBackend) indexFolder(bucketName string) string {
+	return filepath.Join(backend.Folder, bucketName)
+}
+
+// index returns nil for the bucket without the index.
+func (backend *IndexedBackend) index(bucketName string) (*bucketIndex, error) {
+	if !indexable(bucketName) {
+		return nil, nil
+	}
+	return openBucketIndex(backend.indexFolder(bucketName), func(objectKey string) (*ObjectInfo, error) {
+		return backend.Backend.Stat(bucketName, objectKey)
+	})
+}
+
+// change applies the change of the object and journals it in the index of
+// the bucket (if any).
+func (backend *IndexedBackend) change(bucketName string, objectKey string, change func() error) error {
+	index, err := backend.index(bucketName)
+	if err != nil {
+		return err
+	}
+	if index == nil {
+		return change()
+	}
+
+	if err := index.Begin(objectKey); err != nil {
+		return err
+	}
+	err = change()
+	finishErr := index.Finish(objectKey, func() (*ObjectInfo, error) {
+		return backend.Backend.Stat(bucketName, objectKey)
+	})
+	if err != nil {
+		return err
+	}
+	return finishErr
+}
+
+// Rebuild builds the index of the bucket from the wrapped backend, the
+// objects must not be changed while the index is rebuilt.
+func (backend *IndexedBackend) Rebuild(bucketName string) error {
+	if !indexable(bucketName) {
+		return fmt.Errorf("the bucket %s can't be indexed", bucketName)
+	}
+	folder := backend.indexFolder(bucketName)
+	closeBucketIndexes(folder)
+
+	versions, err := backend.Backend.List(bucketName)
+	if err != nil {
+		return err
+	}
+	entries := make([]indexEntry, 0, len(versions))
+	for _, version := range versions {
+		if !version.IsLatest || version.IsDeleteMarker {
+			continue
+		}
+		info, err := backend.Backend.Stat(bucketName, version.Key)
+		if errors.Is(err, ErrNoSuchKey) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		entries = append(entries, indexEntryOf(version.Key, info))
+	}
+	sortIndexEntries(entries)
+
+	if err := writeIndexSnapshot(folder, entries); err != nil {
+		return err
+	}
+	err = os.Remove(filepath.Join(folder, INDEX_JOURNAL))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// RebuildAll rebuilds the indexes of all buckets.
+func (backend *IndexedBackend) RebuildAll() error {
+	buckets, err := backend.Backend.ListBuckets()
+	if err != nil {
+		return err
+	}
+	var result error
+	for _, bucket := range buckets {
+		if err := backend.Rebuild(bucket.Name); err != nil {
+			result = errors.Join(result, fmt.Errorf("bucket %s: %w", bucket.Name, err))
+		}
+	}
+	return result
+}
+
+// CreateBucket creates the bucket with the empty index.
+func (backend *IndexedBackend) CreateBucket(bucketName string) error {
+	if err := backend.Backend.CreateBucket(bucketName); err != nil {
+		return err
+	}
+	if !indexable(bucketName) {
+		return nil
+	}
+	return backend.Rebuild(bucketName)
+}
+
+// DeleteBucket deletes the bucket with its index.
+func (backend *IndexedBackend) DeleteBucket(bucketName string) error {
+	remover, exists := CapabilityOf[BucketRemover](backend.Backend)
+	if !exists {
+		return fmt.Errorf("the backend can't delete the buckets")
+	}
+	if err := remover.DeleteBucket(bucketName); err != nil {
+		return err
+	}
+	if !indexable(bucketName) {
+		return nil
+	}
+	folder := backend.indexFolder(bucketName)
+	closeBucketIndexes(folder)
+	return os.RemoveAll(folder)
+}
+
+func (backend *IndexedBackend) Put(bucketName string, objectKey string, reader io.Reader, metadata *models.ObjectMetadata) error {
+	return backend.change(bucketName, objectKey, func() error {
+		return backend.Backend.Put(bucketName, objectKey, reader, metadata)
+	})
+}
+
+func (backend *IndexedBackend) Delete(bucketName string, objectKey string) error {
+	return backend.change(bucketName, objectKey, func() error {
+		return backend.Backend.Delete(bucketName, objectKey)
+	})
+}
+
+func (backend *IndexedBackend) PutMetadata(bucketName string, objectKey string, metadata *models.ObjectMetadata) error {
+	return backend.change(bucketName, objectKey, func() error {
+		return backend.Backend.PutMetadata(bucketName, objectKey, metadata)
+	})
+}
+
+// List returns the objects of the bucket index, the bucket without the index
+// is listed by the wrapped backend (e.g. walking the bucket folder).
+func (backend *IndexedBackend) List(bucketName string) ([]models.ObjectVersion, error) {
+	index, err := backend.index(bucketName)
+	if err != nil {
+		fmt.Printf("[index] the index of %s is not loaded, the bucket is listed without it: %s\n", bucketName, err)
+	}
+	if index == nil {
+		return backend.Backend.List(bucketName)
+	}
+	return index.Versions(), nil
+}
+
+// ListPage lists the page of the bucket from its index, see pagedLister.
+func (backend *IndexedBackend) ListPage(bucketName string, query listingQuery) (listingPage, bool) {
+	index, err := backend.index(bucketName)
+	if err != nil || index == nil {
+		return listingPage{}, false
+	}
+	return index.Page(query), true
+}
+
+// Unwrap returns the backend keeping the data, its capabilities (packs,
+// segments, disks, mounts) are not changed by the index.
+func (backend *IndexedBackend) Unwrap() Backend {
+	return backend.Backend
+}
+
+// CheckIndexes loads the indexes of all buckets, the buckets without the
+// index are skipped.
+func (backend *IndexedBackend) CheckIndexes() error {
+	buckets, err := backend.Backend.ListBuckets()
+	if err != nil {
+		return err
+	}
+	var result error
+	for _, bucket := range buckets {
+		if _, err := backend.index(bucket.Name); err != nil {
+			result = errors.Join(result, fmt.Errorf("bucket %s: %w", bucket.Name, err))
+		}
+	}
+	return result
+}
+
+// Refresh updates the index of the bucket with the state of the object in the
+// wrapped backend, it returns the event of the change or the empty string.
+func (backend *IndexedBackend) Refresh(bucketName string, objectKey string) (string, error) {
+	index, err := backend.index(bucketName)
+	if err != nil || index == nil {
+		return "", err
+	}
+	return index.Refresh(objectKey, func() (*ObjectInfo, error) {
+		return backend.Backend.Stat(bucketName, objectKey)
+	})
+}
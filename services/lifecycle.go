@@ -0,0 +1,214 @@
+/**
+ * Author: Vanya Usalko <ivict@rambler.ru>
+ * File: lifecycle.go
+ */
+
+package services
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/usalko/s2d3/models"
+)
+
+const LIFECYCLE_CONFIG = "lifecycle"
+const MAX_LIFECYCLE_RULES = 1000
+
+const CodeNoSuchLifecycleConfiguration = "NoSuchLifecycleConfiguration"
+
+func writeBucketConfigError(writer http.ResponseWriter, err error, missingCode string) {
+	if errors.Is(err, ErrNoSuchBucket) {
+		writeError(writer, http.StatusNotFound, CodeNoSuchBucket, err.Error())
+		return
+	}
+	if errors.Is(err, ErrNoSuchConfiguration) {
+		writeError(writer, http.StatusNotFound, missingCode, err.Error())
+		return
+	}
+	writeError(writer, http.StatusInternalServerError, CodeInternalError, err.Error())
+}
+
+func parseLifecycleDate(date string) (time.Time, error) {
+	parsed, err := time.Parse(time.RFC3339, date)
+	if err != nil {
+		parsed, err = time.Parse("2006-01-02", date)
+	}
+	return parsed, err
+}
+
+func validateLifecycle(config *models.LifecycleConfiguration) error {
+	if len(config.Rules) == 0 || len(config.Rules) > MAX_LIFECYCLE_RULES {
+		return fmt.Errorf("the lifecycle configuration must have from 1 to %d rules", MAX_LIFECYCLE_RULES)
+	}
+
+	ids := make(map[string]bool)
+	for _, rule := range config.Rules {
+		if len(rule.ID) > 255 {
+			return fmt.Errorf("the rule id %s is longer than 255 characters", rule.ID)
+		}
+		if rule.ID != "" && ids[rule.ID] {
+			return fmt.Errorf("the rule id %s is not unique", rule.ID)
+		}
+		ids[rule.ID] = true
+
+		if rule.Status != models.LifecycleEnabled && rule.Status != models.LifecycleDisabled {
+			return fmt.Errorf("the rule status must be %s or %s", models.LifecycleEnabled, models.LifecycleDisabled)
+		}
+		if rule.Prefix != nil && rule.Filter != nil {
+			return fmt.Errorf("the rule can't have both prefix and filter")
+		}
+		if rule.Expiration == nil && rule.NoncurrentVersionExpiration == nil && rule.AbortIncompleteMultipartUpload == nil {
+			return fmt.Errorf("the rule must specify at least one action")
+		}
+
+		if rule.Expiration != nil {
+			if (rule.Expiration.Days == 0) == (rule.Expiration.Date == "") {
+				return fmt.Errorf("the expiration must specify either days or date")
+			}
+			if rule.Expiration.Days < 0 {
+				return fmt.Errorf("the expiration days must be a positive integer")
+			}
+			if rule.Expiration.Date != "" {
+				date, err := parseLifecycleDate(rule.Expiration.Date)
+				if err != nil || !date.Equal(date.UTC().Truncate(24*time.Hour)) {
+					return fmt.Errorf("the expiration date must be midnight UTC in ISO 8601 format")
+				}
+			}
+		}
+		if rule.NoncurrentVersionExpiration != nil && rule.NoncurrentVersionExpiration.NoncurrentDays <= 0 {
+			return fmt.Errorf("the noncurrent days must be a positive integer")
+		}
+		if rule.AbortIncompleteMultipartUpload != nil {
+			if rule.AbortIncompleteMultipartUpload.DaysAfterInitiation <= 0 {
+				return fmt.Errorf("the days after initiation must be a positive integer")
+			}
+			if len(lifecycleRuleTags(&rule)) > 0 {
+				return fmt.Errorf("the abort incomplete multipart upload action can't be used with tag filters")
+			}
+		}
+
+		if rule.Filter != nil {
+			filters := 0
+			if rule.Filter.Prefix != nil {
+				filters++
+			}
+			if rule.Filter.Tag != nil {
+				filters++
+			}
+			if rule.Filter.And != nil {
+				filters++
+			}
+			if filters > 1 {
+				return fmt.Errorf("the filter must use And to combine prefix and tags")
+			}
+		}
+	}
+	return nil
+}
+
+func lifecycleRulePrefix(rule *models.LifecycleRule) string {
+	if rule.Prefix != nil {
+		return *rule.Prefix
+	}
+	if rule.Filter == nil {
+		return ""
+	}
+	if rule.Filter.Prefix != nil {
+		return *rule.Filter.Prefix
+	}
+	if rule.Filter.And != nil {
+		return rule.Filter.And.Prefix
+	}
+	return ""
+}
+
+func lifecycleRuleTags(rule *models.LifecycleRule) []models.Tag {
+	if rule.Filter == nil {
+		return nil
+	}
+	if rule.Filter.Tag != nil {
+		return []models.Tag{*rule.Filter.Tag}
+	}
+	if rule.Filter.And != nil {
+		return rule.Filter.And.Tags
+	}
+	return nil
+}
+
+// lifecycleRuleMatches checks the rule filter: the key must start with the
+// prefix and the object must have all the tags of the filter.
+func lifecycleRuleMatches(rule *models.LifecycleRule, objectKey string, tags map[string]string) bool {
+	if !strings.HasPrefix(objectKey, lifecycleRulePrefix(rule)) {
+		return false
+	}
+	for _, tag := range lifecycleRuleTags(rule) {
+		value, exists := tags[tag.Key]
+		if !exists || value != tag.Value {
+			return false
+		}
+	}
+	return true
+}
+
+// lifecycleDue returns the time when the action of the rule becomes due,
+// S3 rounds it up to the next midnight UTC.
+func lifecycleDue(since time.Time, days int) time.Time {
+	return since.UTC().AddDate(0, 0, days).Truncate(24 * time.Hour).Add(24 * time.Hour)
+}
+
+// Lifecycle implements PUT, GET and DELETE of the bucket lifecycle configuration.
+func Lifecycle(writer http.ResponseWriter, request *http.Request) {
+	bucketName, _ := bucketNameAndObjectKey(request.URL.Path, request.Context().Value(KeyUrlContext).(string))
+	storage := storageOf(request)
+
+	switch request.Method {
+
+	case "GET":
+		data, err := storage.GetBucketConfig(bucketName, LIFECYCLE_CONFIG)
+		if err != nil {
+			writeBucketConfigError(writer, err, CodeNoSuchLifecycleConfiguration)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/xml")
+		writer.Write(data)
+
+	case "PUT":
+		body, err := io.ReadAll(request.Body)
+		if err != nil {
+			writeError(writer, http.StatusBadRequest, CodeInvalidArgument, err.Error())
+			return
+		}
+		config := models.LifecycleConfiguration{}
+		if err := xml.Unmarshal(body, &config); err != nil {
+			writeError(writer, http.StatusBadRequest, CodeMalformedXML, err.Error())
+			return
+		}
+		if err := validateLifecycle(&config); err != nil {
+			writeError(writer, http.StatusBadRequest, CodeInvalidArgument, err.Error())
+			return
+		}
+		data, err := xml.Marshal(&config)
+		if err != nil {
+			writeError(writer, http.StatusInternalServerError, CodeInternalError, err.Error())
+			return
+		}
+		if err := storage.PutBucketConfig(bucketName, LIFECYCLE_CONFIG, data); err != nil {
+			writeBucketConfigError(writer, err, CodeNoSuchLifecycleConfiguration)
+			return
+		}
+
+	case "DELETE":
+		if err := storage.DeleteBucketConfig(bucketName, LIFECYCLE_CONFIG); err != nil {
+			writeBucketConfigError(writer, err, CodeNoSuchLifecycleConfiguration)
+			return
+		}
+		writer.WriteHeader(http.StatusNoContent)
+
+	}
+}
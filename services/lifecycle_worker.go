@@ -0,0 +1,186 @@
+/**
+ * Author: Vanya Usalko <ivict@rambler.ru>
+ * File: lifecycle_worker.go
+ */
+
+package services
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/usalko/s2d3/models"
+)
+
+const DEFAULT_LIFECYCLE_INTERVAL = time.Hour
+
+// LifecycleWorker applies the lifecycle rules of all buckets periodically.
+// In the dry-run mode the worker only logs what would be deleted.
+type LifecycleWorker struct {
+	Storage  Storage
+	Interval time.Duration
+	DryRun   bool
+}
+
+func (worker *LifecycleWorker) Run(ctx context.Context) {
+	interval := worker.Interval
+	if interval <= 0 {
+		interval = DEFAULT_LIFECYCLE_INTERVAL
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	health := startWorkerHealth("lifecycle", interval)
+	defer health.stop()
+
+	for {
+		err := worker.Apply(time.Now())
+		if err != nil {
+			fmt.Printf("[lifecycle] %s\n", err)
+		}
+		health.ran(err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Apply runs the lifecycle rules of every bucket once as of the given time.
+func (worker *LifecycleWorker) Apply(now time.Time) error {
+	buckets, err := worker.Storage.ListBuckets()
+	if err != nil {
+		return err
+	}
+
+	var result error
+	for _, bucket := range buckets {
+		data, err := worker.Storage.GetBucketConfig(bucket.Name, LIFECYCLE_CONFIG)
+		if errors.Is(err, ErrNoSuchConfiguration) {
+			continue
+		}
+		if err == nil {
+			config := models.LifecycleConfiguration{}
+			err = xml.Unmarshal(data, &config)
+			if err == nil {
+				err = worker.applyBucket(bucket.Name, &config, now)
+			}
+		}
+		if err != nil {
+			result = errors.Join(result, fmt.Errorf("bucket %s: %w", bucket.Name, err))
+		}
+	}
+	return result
+}
+
+func (worker *LifecycleWorker) expire(action string, bucketName string, objectKey string, remove func() error) error {
+	if worker.DryRun {
+		fmt.Printf("[lifecycle] dry-run: %s %s/%s would be deleted\n", action, bucketName, objectKey)
+		return nil
+	}
+	if err := remove(); err != nil {
+		return err
+	}
+	fmt.Printf("[lifecycle] %s %s/%s deleted\n", action, bucketName, objectKey)
+	return nil
+}
+
+func (worker *LifecycleWorker) applyBucket(bucketName string, config *models.LifecycleConfiguration, now time.Time) error {
+	versions, err := worker.Storage.List(bucketName)
+	if err != nil {
+		return err
+	}
+	uploads, err := worker.Storage.ListUploads(bucketName)
+	if err != nil {
+		return err
+	}
+
+	var result error
+	deleted := make(map[string]bool)
+	aborted := make(map[string]bool)
+	for i := range config.Rules {
+		rule := &config.Rules[i]
+		if rule.Status != models.LifecycleEnabled {
+			continue
+		}
+
+		for index, version := range versions {
+			versionKey := fmt.Sprintf("%s?versionId=%s", version.Key, version.VersionId)
+			if deleted[versionKey] || version.IsDeleteMarker {
+				continue
+			}
+			var tags map[string]string
+			if len(lifecycleRuleTags(rule)) > 0 {
+				tags, _ = worker.Storage.GetObjectTags(bucketName, version.Key)
+			}
+			if !lifecycleRuleMatches(rule, version.Key, tags) {
+				continue
+			}
+
+			// The lifecycle rules never remove the objects protected by object lock
+			if worker.Storage.CheckObjectLock(bucketName, version.Key, false, now) != nil {
+				continue
+			}
+
+			if version.IsLatest && rule.Expiration != nil {
+				due := lifecycleDue(version.LastModified, rule.Expiration.Days)
+				if rule.Expiration.Date != "" {
+					due, _ = parseLifecycleDate(rule.Expiration.Date)
+				}
+				if now.Before(due) {
+					continue
+				}
+				err = worker.expire("object", bucketName, version.Key, func() error {
+					if err := worker.Storage.Delete(bucketName, version.Key); err != nil {
+						return err
+					}
+					if err := worker.Storage.Notify(bucketName, version.Key, EventLifecycleExpirationDelete, "", "", ""); err != nil {
+						fmt.Printf("[lifecycle] %s event of %s/%s is not queued: %s\n", EventLifecycleExpirationDelete, bucketName, version.Key, err)
+					}
+					return nil
+				})
+			} else if !version.IsLatest && rule.NoncurrentVersionExpiration != nil && index > 0 && versions[index-1].Key == version.Key {
+				// The version became noncurrent when the next newer version was created
+				noncurrentSince := versions[index-1].LastModified
+				if now.Before(lifecycleDue(noncurrentSince, rule.NoncurrentVersionExpiration.NoncurrentDays)) {
+					continue
+				}
+				err = worker.expire("noncurrent version "+version.VersionId+" of", bucketName, version.Key, func() error {
+					return worker.Storage.DeleteObjectVersion(bucketName, version.Key, version.VersionId)
+				})
+			} else {
+				continue
+			}
+
+			if err != nil {
+				result = errors.Join(result, err)
+				continue
+			}
+			deleted[versionKey] = true
+		}
+
+		if rule.AbortIncompleteMultipartUpload == nil {
+			continue
+		}
+		for _, upload := range uploads {
+			if aborted[upload.UploadId] || !lifecycleRuleMatches(rule, upload.Key, nil) {
+				continue
+			}
+			if now.Before(lifecycleDue(upload.Initiated, rule.AbortIncompleteMultipartUpload.DaysAfterInitiation)) {
+				continue
+			}
+			err = worker.expire("incomplete multipart upload "+upload.UploadId+" of", bucketName, upload.Key, func() error {
+				return worker.Storage.DeleteUpload(bucketName, upload.Key, upload.UploadId)
+			})
+			if err != nil {
+				result = errors.Join(result, err)
+				continue
+			}
+			aborted[upload.UploadId] = true
+		}
+	}
+	return result
+}
@@ -6,11 +6,18 @@
 package services
 
 import (
+	"encoding/base64"
 	"encoding/xml"
+	"errors"
 	"fmt"
+	"io/fs"
 	"net/http"
+	"net/url"
+	"strconv"
 )
 
+const LAST_MODIFIED_FORMAT = "2006-01-02T15:04:05.000Z"
+
 type EntryOwner struct {
 	ID          string `xml:"ID"`
 	DisplayName string `xml:"DisplayName"`
@@ -25,20 +32,241 @@ type Entry struct {
 	Owner        EntryOwner `xml:"Owner"`
 }
 
+type CommonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
 type ListResponse struct {
-	XMLName  xml.Name `xml:"ListBucketResult"`
-	Next     string   `xml:"NextContinuationToken"`
-	Contents []Entry  `xml:"Contents"`
+	XMLName           xml.Name       `xml:"ListBucketResult"`
+	Name              string         `xml:"Name"`
+	Prefix            string         `xml:"Prefix"`
+	Delimiter         string         `xml:"Delimiter,omitempty"`
+	MaxKeys           int            `xml:"MaxKeys"`
+	KeyCount          int            `xml:"KeyCount"`
+	IsTruncated       bool           `xml:"IsTruncated"`
+	Marker            string         `xml:"Marker,omitempty"`
+	NextMarker        string         `xml:"NextMarker,omitempty"`
+	ContinuationToken string         `xml:"ContinuationToken,omitempty"`
+	Next              string         `xml:"NextContinuationToken,omitempty"`
+	StartAfter        string         `xml:"StartAfter,omitempty"`
+	Contents          []Entry        `xml:"Contents"`
+	CommonPrefixes    []CommonPrefix `xml:"CommonPrefixes"`
 }
 
-func List(writer http.ResponseWriter, request *http.Request, listType any) {
-	response := &ListResponse{}
-	responseBytes, err := xml.Marshal(response)
+type VersionEntry struct {
+	XMLName      xml.Name
+	Key          string     `xml:"Key"`
+	VersionId    string     `xml:"VersionId"`
+	IsLatest     bool       `xml:"IsLatest"`
+	LastModified string     `xml:"LastModified"`
+	ETag         string     `xml:"ETag,omitempty"`
+	Size         *int64     `xml:"Size,omitempty"`
+	StorageClass string     `xml:"StorageClass,omitempty"`
+	Owner        EntryOwner `xml:"Owner"`
+}
 
+type ListVersionsResponse struct {
+	XMLName             xml.Name       `xml:"ListVersionsResult"`
+	Name                string         `xml:"Name"`
+	Prefix              string         `xml:"Prefix"`
+	KeyMarker           string         `xml:"KeyMarker"`
+	VersionIdMarker     string         `xml:"VersionIdMarker"`
+	NextKeyMarker       string         `xml:"NextKeyMarker,omitempty"`
+	NextVersionIdMarker string         `xml:"NextVersionIdMarker,omitempty"`
+	MaxKeys             int            `xml:"MaxKeys"`
+	Delimiter           string         `xml:"Delimiter,omitempty"`
+	IsTruncated         bool           `xml:"IsTruncated"`
+	Versions            []VersionEntry // Version and DeleteMarker elements keep the listing order
+	CommonPrefixes      []CommonPrefix `xml:"CommonPrefixes"`
+}
+
+func commonPrefixesOf(prefixes []string) []CommonPrefix {
+	commonPrefixes := make([]CommonPrefix, len(prefixes))
+	for i, prefix := range prefixes {
+		commonPrefixes[i] = CommonPrefix{Prefix: prefix}
+	}
+	return commonPrefixes
+}
+
+func quotedETag(etag string) string {
+	return fmt.Sprintf("\"%s\"", etag)
+}
+
+// listingQueryOf reads the options common for all listing requests.
+func listingQueryOf(parsedQuery url.Values) (listingQuery, error) {
+	query := listingQuery{
+		Prefix:    parsedQuery.Get("prefix"),
+		Delimiter: parsedQuery.Get("delimiter"),
+		MaxKeys:   DEFAULT_MAX_KEYS,
+	}
+	if parsedQuery.Has("max-keys") {
+		maxKeys, err := strconv.Atoi(parsedQuery.Get("max-keys"))
+		if err != nil || maxKeys < 0 {
+			return query, fmt.Errorf("invalid max-keys value %s", parsedQuery.Get("max-keys"))
+		}
+		query.MaxKeys = min(maxKeys, DEFAULT_MAX_KEYS)
+	}
+	return query, nil
+}
+
+func writeListingError(writer http.ResponseWriter, bucketName string, err error) {
+	if errors.Is(err, fs.ErrNotExist) {
+		writeError(writer, http.StatusNotFound, CodeNoSuchBucket, fmt.Sprintf("the bucket %s does not exist", bucketName))
+		return
+	}
+	writeError(writer, http.StatusInternalServerError, CodeInternalError, err.Error())
+}
+
+func writeXml(writer http.ResponseWriter, response any) {
+	responseBytes, err := xml.Marshal(response)
 	if err != nil {
 		fmt.Printf("%s", err)
+		writeError(writer, http.StatusInternalServerError, CodeInternalError, err.Error())
 		return
 	}
 
+	writer.Header().Set("Content-Type", "application/xml")
 	writer.Write(responseBytes)
 }
+
+// List implements ListObjects (version 1) and ListObjectsV2 (list-type=2).
+func List(writer http.ResponseWriter, request *http.Request, listType any) {
+	parsedQuery, err := url.ParseQuery(request.URL.RawQuery)
+	if err != nil {
+		writeError(writer, http.StatusBadRequest, CodeInvalidArgument, err.Error())
+		return
+	}
+	query, err := listingQueryOf(parsedQuery)
+	if err != nil {
+		writeError(writer, http.StatusBadRequest, CodeInvalidArgument, err.Error())
+		return
+	}
+
+	bucketName, _ := bucketNameAndObjectKey(request.URL.Path, request.Context().Value(KeyUrlContext).(string))
+	response := &ListResponse{
+		Name:      bucketName,
+		Prefix:    query.Prefix,
+		Delimiter: query.Delimiter,
+		MaxKeys:   query.MaxKeys,
+	}
+
+	listV2 := parsedQuery.Get("list-type") == "2"
+	if listV2 {
+		response.ContinuationToken = parsedQuery.Get("continuation-token")
+		response.StartAfter = parsedQuery.Get("start-after")
+		query.KeyMarker = response.StartAfter
+		if response.ContinuationToken != "" {
+			marker, err := base64.StdEncoding.DecodeString(response.ContinuationToken)
+			if err != nil {
+				writeError(writer, http.StatusBadRequest, CodeInvalidArgument, "the continuation token provided is incorrect")
+				return
+			}
+			query.KeyMarker = string(marker)
+		}
+	} else {
+		response.Marker = parsedQuery.Get("marker")
+		query.KeyMarker = response.Marker
+	}
+
+	storage := storageOf(request)
+	page, err := storage.listObjectsPage(bucketName, query)
+	if err != nil {
+		writeListingError(writer, bucketName, err)
+		return
+	}
+
+	response.IsTruncated = page.IsTruncated
+	response.KeyCount = len(page.Entries) + len(page.CommonPrefixes)
+	response.CommonPrefixes = commonPrefixesOf(page.CommonPrefixes)
+	if page.IsTruncated {
+		if listV2 {
+			response.Next = base64.StdEncoding.EncodeToString([]byte(page.NextKeyMarker))
+		} else {
+			response.NextMarker = page.NextKeyMarker
+		}
+	}
+
+	response.Contents = make([]Entry, len(page.Entries))
+	for i, object := range page.Entries {
+		response.Contents[i] = Entry{
+			Key:          object.Key,
+			LastModified: object.LastModified.Format(LAST_MODIFIED_FORMAT),
+			ETag:         quotedETag(object.ETag),
+			Size:         int64(object.Size),
+			StorageClass: object.StorageClass,
+			Owner: EntryOwner{
+				ID:          object.OwnerID,
+				DisplayName: object.OwnerName,
+			},
+		}
+	}
+
+	writeXml(writer, response)
+}
+
+// ListVersions implements ListObjectVersions (GET /bucket?versions).
+func ListVersions(writer http.ResponseWriter, request *http.Request) {
+	parsedQuery, err := url.ParseQuery(request.URL.RawQuery)
+	if err != nil {
+		writeError(writer, http.StatusBadRequest, CodeInvalidArgument, err.Error())
+		return
+	}
+	query, err := listingQueryOf(parsedQuery)
+	if err != nil {
+		writeError(writer, http.StatusBadRequest, CodeInvalidArgument, err.Error())
+		return
+	}
+	query.KeyMarker = parsedQuery.Get("key-marker")
+	query.VersionIdMarker = parsedQuery.Get("version-id-marker")
+	if query.VersionIdMarker != "" && query.KeyMarker == "" {
+		writeError(writer, http.StatusBadRequest, CodeInvalidArgument, "a version-id marker cannot be specified without a key marker")
+		return
+	}
+
+	bucketName, _ := bucketNameAndObjectKey(request.URL.Path, request.Context().Value(KeyUrlContext).(string))
+	storage := storageOf(request)
+	versions, err := storage.List(bucketName)
+	if err != nil {
+		writeListingError(writer, bucketName, err)
+		return
+	}
+
+	page := listingPageOf(versions, query)
+	response := &ListVersionsResponse{
+		Name:                bucketName,
+		Prefix:              query.Prefix,
+		KeyMarker:           query.KeyMarker,
+		VersionIdMarker:     query.VersionIdMarker,
+		NextKeyMarker:       page.NextKeyMarker,
+		NextVersionIdMarker: page.NextVersionIdMarker,
+		MaxKeys:             query.MaxKeys,
+		Delimiter:           query.Delimiter,
+		IsTruncated:         page.IsTruncated,
+		Versions:            make([]VersionEntry, len(page.Entries)),
+		CommonPrefixes:      commonPrefixesOf(page.CommonPrefixes),
+	}
+	for i, version := range page.Entries {
+		entry := VersionEntry{
+			XMLName:      xml.Name{Local: "Version"},
+			Key:          version.Key,
+			VersionId:    version.VersionId,
+			IsLatest:     version.IsLatest,
+			LastModified: version.LastModified.Format(LAST_MODIFIED_FORMAT),
+			Owner: EntryOwner{
+				ID:          version.OwnerID,
+				DisplayName: version.OwnerName,
+			},
+		}
+		if version.IsDeleteMarker {
+			entry.XMLName = xml.Name{Local: "DeleteMarker"}
+		} else {
+			size := int64(version.Size)
+			entry.ETag = quotedETag(version.ETag)
+			entry.Size = &size
+			entry.StorageClass = version.StorageClass
+		}
+		response.Versions[i] = entry
+	}
+
+	writeXml(writer, response)
+}
@@ -0,0 +1,157 @@
+/**
+ * Author: Vanya Usalko <ivict@rambler.ru>
+ * File: listing.go
+ */
+
+package services
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/usalko/s2d3/models"
+)
+
+const DEFAULT_MAX_KEYS = 1000
+
+type listingQuery struct {
+	Prefix          string
+	Delimiter       string
+	KeyMarker       string
+	VersionIdMarker string
+	MaxKeys         int
+}
+
+type listingPage struct {
+	Entries             []models.ObjectVersion
+	CommonPrefixes      []string
+	IsTruncated         bool
+	NextKeyMarker       string
+	NextVersionIdMarker string
+}
+
+// pagedLister is implemented by the backends listing the page of the bucket
+// without reading all its objects, it returns false when the bucket is
+// listed by the other backend.
+type pagedLister interface {
+	ListPage(bucketName string, query listingQuery) (listingPage, bool)
+}
+
+// listObjectsPage returns the page of the latest objects of the bucket.
+func (storage *Storage) listObjectsPage(bucketName string, query listingQuery) (listingPage, error) {
+	if lister, exists := CapabilityOf[pagedLister](storage.Backend); exists {
+		if page, listed := lister.ListPage(bucketName, query); listed {
+			return page, nil
+		}
+	}
+	objects, err := storage.ListObjects(bucketName)
+	if err != nil {
+		return listingPage{}, err
+	}
+	return listingPageOf(objects, query), nil
+}
+
+// sortVersions orders entries the way S3 lists them: keys ascending and,
+// inside one key, the newest version first.
+func sortVersions(entries []models.ObjectVersion) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Key != entries[j].Key {
+			return entries[i].Key < entries[j].Key
+		}
+		return entries[i].LastModified.After(entries[j].LastModified)
+	})
+}
+
+// listingStart returns the index of the first entry of the prefix placed
+// after the markers.
+func listingStart(entries []models.ObjectVersion, query listingQuery) int {
+	if query.KeyMarker != "" && query.VersionIdMarker != "" {
+		for index, entry := range entries {
+			if entry.Key == query.KeyMarker && entry.VersionId == query.VersionIdMarker {
+				return index + 1
+			}
+		}
+	}
+	return sort.Search(len(entries), func(index int) bool {
+		return listedAfter(entries[index].Key, query)
+	})
+}
+
+// listedAfter checks the key is placed at the prefix or after it and after
+// the key marker, the sorted keys are searched by it.
+func listedAfter(objectKey string, query listingQuery) bool {
+	return objectKey >= query.Prefix && (query.KeyMarker == "" || objectKey > query.KeyMarker)
+}
+
+// listingPageOf is the listing engine shared by ListObjects and
+// ListObjectVersions: it applies prefix, delimiter, markers and max-keys to
+// the sorted entries of a bucket.
+func listingPageOf(entries []models.ObjectVersion, query listingQuery) listingPage {
+	start := listingStart(entries, query)
+	return listingPageFrom(len(entries)-start, func(index int) models.ObjectVersion {
+		return entries[start+index]
+	}, query)
+}
+
+// listingPageFrom is listingPageOf of the sorted entries placed after the
+// markers, they are taken by entryAt until the page is complete or the keys
+// of the prefix end.
+func listingPageFrom(count int, entryAt func(index int) models.ObjectVersion, query listingQuery) listingPage {
+	page := listingPage{
+		Entries:        make([]models.ObjectVersion, 0),
+		CommonPrefixes: make([]string, 0),
+	}
+
+	listed := 0
+	lastPrefix := ""
+	for index := 0; index < count; index++ {
+		entry := entryAt(index)
+		if !strings.HasPrefix(entry.Key, query.Prefix) {
+			// The keys of the prefix are sorted together
+			if entry.Key > query.Prefix {
+				break
+			}
+			continue
+		}
+
+		commonPrefix := ""
+		if query.Delimiter != "" {
+			index := strings.Index(entry.Key[len(query.Prefix):], query.Delimiter)
+			if index >= 0 {
+				commonPrefix = entry.Key[:len(query.Prefix)+index+len(query.Delimiter)]
+			}
+		}
+
+		if commonPrefix != "" {
+			// The prefix was already returned on this or on the previous page
+			if commonPrefix == lastPrefix || strings.HasPrefix(query.KeyMarker, commonPrefix) {
+				continue
+			}
+			if listed >= query.MaxKeys {
+				page.IsTruncated = true
+				break
+			}
+			page.CommonPrefixes = append(page.CommonPrefixes, commonPrefix)
+			page.NextKeyMarker = commonPrefix
+			page.NextVersionIdMarker = ""
+			lastPrefix = commonPrefix
+			listed++
+			continue
+		}
+
+		if listed >= query.MaxKeys {
+			page.IsTruncated = true
+			break
+		}
+		page.Entries = append(page.Entries, entry)
+		page.NextKeyMarker = entry.Key
+		page.NextVersionIdMarker = entry.VersionId
+		listed++
+	}
+
+	if !page.IsTruncated {
+		page.NextKeyMarker = ""
+		page.NextVersionIdMarker = ""
+	}
+	return page
+}
@@ -0,0 +1,456 @@
+/**
+ * Author: Vanya Usalko <ivict@rambler.ru>
+ * File: memory_backend.go
+ */
+
+package services
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/usalko/s2d3/models"
+	"github.com/usalko/s2d3/utils"
+)
+
+// MemoryBackend keeps everything in memory, it is meant for the tests and
+// for the services which don't need the data to outlive the process.
+type MemoryBackend struct {
+	lock    sync.Mutex
+	buckets map[string]*memoryBucket
+	uploads map[string]*memoryUpload
+	records map[string][]byte
+}
+
+type memoryBucket struct {
+	creationDate time.Time
+	configs      map[string][]byte
+	objects      map[string]*memoryObject
+}
+
+type memoryObject struct {
+	data         []byte
+	metadata     models.ObjectMetadata
+	lastModified time.Time
+}
+
+type memoryUpload struct {
+	upload models.Upload
+	parts  map[int][]byte
+}
+
+type memoryReader struct {
+	*bytes.Reader
+}
+
+func (reader memoryReader) Close() error {
+	return nil
+}
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{
+		buckets: map[string]*memoryBucket{},
+		uploads: map[string]*memoryUpload{},
+		records: map[string][]byte{},
+	}
+}
+
+func memoryUploadKey(bucketName string, objectKey string, uploadId string) string {
+	return strings.Join([]string{bucketName, objectKey, uploadId}, "\x00")
+}
+
+// copyMetadata copies the metadata with its tags, the other references are
+// never modified in place.
+func copyMetadata(metadata *models.ObjectMetadata) models.ObjectMetadata {
+	copied := *metadata
+	if metadata.Tags != nil {
+		copied.Tags = make(map[string]string, len(metadata.Tags))
+		for key, value := range metadata.Tags {
+			copied.Tags[key] = value
+		}
+	}
+	return copied
+}
+
+func (backend *MemoryBackend) object(bucketName string, objectKey string) (*memoryObject, error) {
+	bucket, exists := backend.buckets[bucketName]
+	if !exists {
+		return nil, ErrNoSuchKey
+	}
+	object, exists := bucket.objects[objectKey]
+	if !exists {
+		return nil, ErrNoSuchKey
+	}
+	return object, nil
+}
+
+func (backend *MemoryBackend) objectInfo(object *memoryObject) *ObjectInfo {
+	metadata := copyMetadata(&object.metadata)
+	size, etag, _ := contentAttributes(&metadata, int64(len(object.data)), func() (string, error) {
+		hash := md5.Sum(object.data)
+		return hex.EncodeToString(hash[:]), nil
+	})
+	return &ObjectInfo{
+		Size:         size,
+		ETag:         etag,
+		LastModified: object.lastModified,
+		Metadata:     &metadata,
+	}
+}
+
+func (backend *MemoryBackend) ListBuckets() ([]models.Bucket, error) {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	buckets := make([]models.Bucket, 0, len(backend.buckets))
+	for name, bucket := range backend.buckets {
+		buckets = append(buckets, models.Bucket{
+			Name:         name,
+			CreationDate: bucket.creationDate,
+		})
+	}
+	sort.Slice(buckets, func(i, j int) bool {
+		return buckets[i].Name < buckets[j].Name
+	})
+	return buckets, nil
+}
+
+func (backend *MemoryBackend) createBucket(bucketName string) *memoryBucket {
+	bucket := &memoryBucket{
+		creationDate: time.Now().UTC(),
+		configs:      map[string][]byte{},
+		objects:      map[string]*memoryObject{},
+	}
+	backend.buckets[bucketName] = bucket
+	return bucket
+}
+
+func (backend *MemoryBackend) CreateBucket(bucketName string) error {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	if _, exists := backend.buckets[bucketName]; exists {
+		return ErrBucketAlreadyExists
+	}
+	backend.createBucket(bucketName)
+	return nil
+}
+
+func (backend *MemoryBackend) CheckBucket(bucketName string) error {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	if _, exists := backend.buckets[bucketName]; !exists {
+		return ErrNoSuchBucket
+	}
+	return nil
+}
+
+func (backend *MemoryBackend) DeleteBucket(bucketName string) error {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	if _, exists := backend.buckets[bucketName]; !exists {
+		return ErrNoSuchBucket
+	}
+	delete(backend.buckets, bucketName)
+	return nil
+}
+
+func (backend *MemoryBackend) CheckWritable(bucketName string) error {
+	return nil
+}
+
+func (backend *MemoryBackend) GetBucketConfig(bucketName string, configName string) ([]byte, error) {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	bucket, exists := backend.buckets[bucketName]
+	if !exists {
+		return nil, ErrNoSuchBucket
+	}
+	data, exists := bucket.configs[configName]
+	if !exists {
+		return nil, ErrNoSuchConfiguration
+	}
+	return bytes.Clone(data), nil
+}
+
+func (backend *MemoryBackend) PutBucketConfig(bucketName string, configName string, data []byte) error {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	bucket, exists := backend.buckets[bucketName]
+	if !exists {
+		return ErrNoSuchBucket
+	}
+	bucket.configs[configName] = bytes.Clone(data)
+	return nil
+}
+
+func (backend *MemoryBackend) DeleteBucketConfig(bucketName string, configName string) error {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	bucket, exists := backend.buckets[bucketName]
+	if !exists {
+		return ErrNoSuchBucket
+	}
+	delete(bucket.configs, configName)
+	return nil
+}
+
+func (backend *MemoryBackend) Put(bucketName string, objectKey string, reader io.Reader, metadata *models.ObjectMetadata) error {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	bucket, exists := backend.buckets[bucketName]
+	if !exists {
+		bucket = backend.createBucket(bucketName)
+	}
+	bucket.objects[objectKey] = &memoryObject{
+		data:         data,
+		metadata:     copyMetadata(metadata),
+		lastModified: time.Now().UTC(),
+	}
+	return nil
+}
+
+func (backend *MemoryBackend) Get(bucketName string, objectKey string) (io.ReadSeekCloser, *ObjectInfo, error) {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	object, err := backend.object(bucketName, objectKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return memoryReader{bytes.NewReader(object.data)}, backend.objectInfo(object), nil
+}
+
+func (backend *MemoryBackend) Stat(bucketName string, objectKey string) (*ObjectInfo, error) {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	object, err := backend.object(bucketName, objectKey)
+	if err != nil {
+		return nil, err
+	}
+	return backend.objectInfo(object), nil
+}
+
+func (backend *MemoryBackend) List(bucketName string) ([]models.ObjectVersion, error) {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	bucket, exists := backend.buckets[bucketName]
+	if !exists {
+		return nil, fs.ErrNotExist
+	}
+	versions := make([]models.ObjectVersion, 0, len(bucket.objects))
+	for key, object := range bucket.objects {
+		info := backend.objectInfo(object)
+		versions = append(versions, models.ObjectVersion{
+			Object: models.Object{
+				Key:          key,
+				LastModified: info.LastModified,
+				ETag:         info.ETag,
+				Size:         utils.SizeInBytes(info.Size),
+				StorageClass: "STANDARD",
+			},
+			VersionId: NULL_VERSION_ID,
+			IsLatest:  true,
+		})
+	}
+	sortVersions(versions)
+	return versions, nil
+}
+
+func (backend *MemoryBackend) Delete(bucketName string, objectKey string) error {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	if _, err := backend.object(bucketName, objectKey); err != nil {
+		return fs.ErrNotExist
+	}
+	delete(backend.buckets[bucketName].objects, objectKey)
+	return nil
+}
+
+func (backend *MemoryBackend) GetMetadata(bucketName string, objectKey string) (*models.ObjectMetadata, error) {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	object, err := backend.object(bucketName, objectKey)
+	if err != nil {
+		return nil, err
+	}
+	metadata := copyMetadata(&object.metadata)
+	return &metadata, nil
+}
+
+func (backend *MemoryBackend) PutMetadata(bucketName string, objectKey string, metadata *models.ObjectMetadata) error {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	object, err := backend.object(bucketName, objectKey)
+	if err != nil {
+		return err
+	}
+	object.metadata = copyMetadata(metadata)
+	return nil
+}
+
+func (backend *MemoryBackend) PutUpload(upload *models.Upload) error {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	backend.uploads[memoryUploadKey(upload.Bucket, upload.Key, upload.UploadId)] = &memoryUpload{
+		upload: *upload,
+		parts:  map[int][]byte{},
+	}
+	return nil
+}
+
+func (backend *MemoryBackend) GetUpload(bucketName string, objectKey string, uploadId string) (*models.Upload, error) {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	upload, exists := backend.uploads[memoryUploadKey(bucketName, objectKey, uploadId)]
+	if !exists {
+		return nil, ErrNoSuchUpload
+	}
+	copied := upload.upload
+	copied.Metadata = copyMetadata(&upload.upload.Metadata)
+	return &copied, nil
+}
+
+func (backend *MemoryBackend) DeleteUpload(bucketName string, objectKey string, uploadId string) error {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	uploadKey := memoryUploadKey(bucketName, objectKey, uploadId)
+	if _, exists := backend.uploads[uploadKey]; !exists {
+		return ErrNoSuchUpload
+	}
+	delete(backend.uploads, uploadKey)
+	return nil
+}
+
+func (backend *MemoryBackend) ListUploads(bucketName string) ([]models.Upload, error) {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	uploads := make([]models.Upload, 0)
+	for _, upload := range backend.uploads {
+		if upload.upload.Bucket == bucketName {
+			uploads = append(uploads, upload.upload)
+		}
+	}
+	sortUploads(uploads)
+	return uploads, nil
+}
+
+func (backend *MemoryBackend) PutPart(bucketName string, objectKey string, uploadId string, partNumber int, reader io.Reader) error {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	upload, exists := backend.uploads[memoryUploadKey(bucketName, objectKey, uploadId)]
+	if !exists {
+		return ErrNoSuchUpload
+	}
+	upload.parts[partNumber] = data
+	return nil
+}
+
+func (backend *MemoryBackend) GetPart(bucketName string, objectKey string, uploadId string, partNumber int) (io.ReadSeekCloser, error) {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	upload, exists := backend.uploads[memoryUploadKey(bucketName, objectKey, uploadId)]
+	if !exists {
+		return nil, ErrInvalidPart
+	}
+	data, exists := upload.parts[partNumber]
+	if !exists {
+		return nil, ErrInvalidPart
+	}
+	return memoryReader{bytes.NewReader(data)}, nil
+}
+
+func (backend *MemoryBackend) ListParts(bucketName string, objectKey string, uploadId string) ([]int, error) {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	upload, exists := backend.uploads[memoryUploadKey(bucketName, objectKey, uploadId)]
+	if !exists {
+		return nil, ErrNoSuchUpload
+	}
+	partNumbers := make([]int, 0, len(upload.parts))
+	for partNumber := range upload.parts {
+		partNumbers = append(partNumbers, partNumber)
+	}
+	sort.Ints(partNumbers)
+	return partNumbers, nil
+}
+
+func (backend *MemoryBackend) GetRecord(name string) ([]byte, error) {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	data, exists := backend.records[name]
+	if !exists {
+		return nil, fs.ErrNotExist
+	}
+	return bytes.Clone(data), nil
+}
+
+func (backend *MemoryBackend) PutRecord(name string, data []byte) error {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	backend.records[name] = bytes.Clone(data)
+	return nil
+}
+
+func (backend *MemoryBackend) DeleteRecord(name string) error {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	if _, exists := backend.records[name]; !exists {
+		return fs.ErrNotExist
+	}
+	delete(backend.records, name)
+	return nil
+}
+
+func (backend *MemoryBackend) ListRecords(folder string) ([]string, error) {
+	backend.lock.Lock()
+	defer backend.lock.Unlock()
+
+	prefix := folder + "/"
+	names := make([]string, 0)
+	for name := range backend.records {
+		if strings.HasPrefix(name, prefix) && !strings.Contains(name[len(prefix):], "/") {
+			names = append(names, name[len(prefix):])
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
@@ -0,0 +1,105 @@
+/**
+ * Author: Vanya Usalko <ivict@rambler.ru>
+ * File: metadata.go
+ */
+
+package services
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/usalko/s2d3/models"
+)
+
+var ErrNoSuchKey = errors.New("the specified key does not exist")
+
+// PutObject stores the object data together with its metadata. The data is
+// compressed when the bucket compression matches the object, and encrypted
+// with the requested encryption or with the default encryption of the bucket.
+func (storage *Storage) PutObject(bucketName string, objectKey string, reader io.ReadCloser, metadata *models.ObjectMetadata, sse *ServerSideEncryption) error {
+	err := storage.applyDefaultRetention(bucketName, metadata, time.Now())
+	if err != nil {
+		return err
+	}
+
+	metadata.Size, metadata.ETag, metadata.Compression, metadata.Encryption = nil, "", "", nil
+	if sse == nil {
+		sse, err = storage.defaultEncryption(bucketName)
+		if err != nil {
+			return err
+		}
+	}
+	compress, err := storage.compressionOf(bucketName, objectKey, metadata.ContentType)
+	if err != nil {
+		return err
+	}
+	if sse != nil || compress {
+		content, err := io.ReadAll(reader)
+		if err != nil {
+			return err
+		}
+
+		data := content
+		if compress {
+			compressed, err := compressChunks(content)
+			if err != nil {
+				return err
+			}
+			// The incompressible content is stored as it is
+			if len(compressed) < len(content) {
+				data = compressed
+				metadata.Compression = models.CompressionDeflate
+			}
+		}
+		if sse != nil {
+			encryption, dataKey, err := storage.newObjectEncryption(sse)
+			if err != nil {
+				return err
+			}
+			data, err = encryptChunks(dataKey, data)
+			if err != nil {
+				return err
+			}
+			metadata.Encryption = encryption
+		}
+
+		if metadata.Compression != "" || metadata.Encryption != nil {
+			size := int64(len(content))
+			hash := md5.Sum(content)
+			if sse != nil && sse.CustomerKey != nil {
+				// The ETag of SSE-C objects doesn't disclose the content hash
+				hash = md5.Sum(data)
+			}
+			metadata.Size = &size
+			metadata.ETag = hex.EncodeToString(hash[:])
+		}
+		reader = io.NopCloser(bytes.NewReader(data))
+	}
+
+	return storage.putWithinQuota(bucketName, objectKey, reader, metadata)
+}
+
+func (storage *Storage) GetObjectTags(bucketName string, objectKey string) (map[string]string, error) {
+	metadata, err := storage.GetMetadata(bucketName, objectKey)
+	if err != nil {
+		return nil, err
+	}
+	if metadata.Tags == nil {
+		return map[string]string{}, nil
+	}
+	return metadata.Tags, nil
+}
+
+func (storage *Storage) PutObjectTags(bucketName string, objectKey string, tags map[string]string) error {
+	metadata, err := storage.GetMetadata(bucketName, objectKey)
+	if err != nil {
+		return err
+	}
+	metadata.Tags = tags
+	return storage.PutMetadata(bucketName, objectKey, metadata)
+}
@@ -0,0 +1,475 @@
+/**
+ * Author: Vanya Usalko <ivict@rambler.ru>
+ * File: metadata_index.go
+ */
+
+package services
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/usalko/s2d3/models"
+	"github.com/usalko/s2d3/utils"
+)
+
+// The index of the bucket keys is the snapshot of the entries sorted by key
+// and the journal of the changes made after the snapshot was written, both
+// are files of json lines:
+//
+//	index/<bucket>/snapshot
+//	index/<bucket>/journal
+//
+// Every change is journaled twice: the begin record before the backend is
+// changed and the put or delete record with the resulting state of the key
+// after it. The keys begun but not finished by the crashed process are
+// checked against the backend when the index is loaded. The journal is
+// merged into the new snapshot when it grows larger than the snapshot.
+const INDEX_FOLDER = "index"
+const INDEX_SNAPSHOT = "snapshot"
+const INDEX_JOURNAL = "journal"
+const INDEX_MIN_JOURNAL_RECORDS = 1024
+
+const (
+	indexBegin  = "begin"
+	indexPut    = "put"
+	indexDelete = "delete"
+)
+
+// indexEntry is the listed state of the key, the metadata is kept by the
+// backend and only its presence is indexed.
+type indexEntry struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	ETag         string    `json:"etag"`
+	LastModified time.Time `json:"lastModified"`
+	Metadata     bool      `json:"metadata,omitempty"`
+}
+
+type indexRecord struct {
+	Operation string      `json:"op"`
+	Key       string      `json:"key"`
+	Entry     *indexEntry `json:"entry,omitempty"`
+}
+
+// bucketIndex keeps the entries of the bucket sorted by key.
+type bucketIndex struct {
+	lock    sync.Mutex
+	folder  string
+	entries []indexEntry
+	journal *os.File
+	records int
+	// inflight counts the changes begun but not finished yet
+	inflight map[string]int
+}
+
+var bucketIndexes = map[string]*bucketIndex{}
+var bucketIndexesLock sync.Mutex
+
+// openBucketIndex returns the index of the folder, it returns nil when the
+// index is not built. The keys left unfinished by the crash are restated.
+func openBucketIndex(folder string, stat func(objectKey string) (*ObjectInfo, error)) (*bucketIndex, error) {
+	folder, err := filepath.Abs(folder)
+	if err != nil {
+		return nil, err
+	}
+
+	bucketIndexesLock.Lock()
+	defer bucketIndexesLock.Unlock()
+
+	if index, exists := bucketIndexes[folder]; exists {
+		return index, nil
+	}
+	if _, err := os.Stat(filepath.Join(folder, INDEX_SNAPSHOT)); errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+
+	index := &bucketIndex{
+		folder:   folder,
+		inflight: map[string]int{},
+	}
+	unfinished, err := index.load()
+	if err != nil {
+		return nil, err
+	}
+	for objectKey := range unfinished {
+		info, err := stat(objectKey)
+		if err != nil && !errors.Is(err, ErrNoSuchKey) {
+			return nil, err
+		}
+		fmt.Printf("[index] the unfinished change of %s in %s is restated\n", objectKey, folder)
+		if err := index.apply(objectKey, info); err != nil {
+			return nil, err
+		}
+	}
+	// The restated keys are not begun anymore
+	if len(unfinished) > 0 {
+		if err := index.writeSnapshot(); err != nil {
+			return nil, err
+		}
+	}
+	bucketIndexes[folder] = index
+	return index, nil
+}
+
+// closeBucketIndexes drops the loaded indexes of the folder and its
+// subfolders.
+func closeBucketIndexes(folder string) {
+	folder, _ = filepath.Abs(folder)
+
+	bucketIndexesLock.Lock()
+	defer bucketIndexesLock.Unlock()
+
+	for indexFolder, index := range bucketIndexes {
+		if indexFolder == folder || filepath.Dir(indexFolder) == folder {
+			index.lock.Lock()
+			if index.journal != nil {
+				index.journal.Close()
+			}
+			index.lock.Unlock()
+			delete(bucketIndexes, indexFolder)
+		}
+	}
+}
+
+// CloseIndexes drops all loaded indexes, they are loaded from the disk again
+// when they are accessed next time.
+func CloseIndexes() {
+	bucketIndexesLock.Lock()
+	folders := make([]string, 0, len(bucketIndexes))
+	for folder := range bucketIndexes {
+		folders = append(folders, folder)
+	}
+	bucketIndexesLock.Unlock()
+
+	for _, folder := range folders {
+		closeBucketIndexes(folder)
+	}
+}
+
+// load reads the snapshot and replays the journal, it returns the keys begun
+// more times than finished. The torn record at the end of the journal is
+// truncated.
+func (index *bucketIndex) load() (map[string]int, error) {
+	snapshot, err := os.Open(filepath.Join(index.folder, INDEX_SNAPSHOT))
+	if err != nil {
+		return nil, err
+	}
+	defer snapshot.Close()
+
+	index.entries = make([]indexEntry, 0)
+	scanner := bufio.NewScanner(snapshot)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		entry := indexEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("the snapshot of %s is broken: %w", index.folder, err)
+		}
+		index.entries = append(index.entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	unfinished := map[string]int{}
+	finish := func(objectKey string) {
+		unfinished[objectKey]--
+		if unfinished[objectKey] <= 0 {
+			delete(unfinished, objectKey)
+		}
+	}
+	journal, err := os.OpenFile(filepath.Join(index.folder, INDEX_JOURNAL), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	offset := int64(0)
+	reader := bufio.NewReader(journal)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) == 0 && err != nil {
+			break
+		}
+		record := indexRecord{}
+		if err != nil || json.Unmarshal(line, &record) != nil {
+			fmt.Printf("[index] the record of %s at %d is torn, the journal is truncated\n", index.folder, offset)
+			break
+		}
+		offset += int64(len(line))
+		index.records++
+
+		switch record.Operation {
+		case indexBegin:
+			unfinished[record.Key]++
+		case indexPut:
+			finish(record.Key)
+			if record.Entry != nil {
+				index.set(*record.Entry)
+			}
+		case indexDelete:
+			finish(record.Key)
+			index.remove(record.Key)
+		}
+	}
+	if err := journal.Truncate(offset); err != nil {
+		journal.Close()
+		return nil, err
+	}
+	if _, err := journal.Seek(offset, 0); err != nil {
+		journal.Close()
+		return nil, err
+	}
+	index.journal = journal
+	return unfinished, nil
+}
+
+func (index *bucketIndex) search(objectKey string) (int, bool) {
+	position := sort.Search(len(index.entries), func(i int) bool {
+		return index.entries[i].Key >= objectKey
+	})
+	return position, position < len(index.entries) && index.entries[position].Key == objectKey
+}
+
+func (index *bucketIndex) set(entry indexEntry) {
+	position, exists := index.search(entry.Key)
+	if exists {
+		index.entries[position] = entry
+		return
+	}
+	index.entries = append(index.entries, indexEntry{})
+	copy(index.entries[position+1:], index.entries[position:])
+	index.entries[position] = entry
+}
+
+func (index *bucketIndex) remove(objectKey string) {
+	if position, exists := index.search(objectKey); exists {
+		index.entries = append(index.entries[:position], index.entries[position+1:]...)
+	}
+}
+
+func (index *bucketIndex) append(record indexRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if _, err := index.journal.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	index.records++
+	return index.journal.Sync()
+}
+
+// Begin journals the change of the key before the backend is changed.
+func (index *bucketIndex) Begin(objectKey string) error {
+	index.lock.Lock()
+	defer index.lock.Unlock()
+
+	if err := index.append(indexRecord{Operation: indexBegin, Key: objectKey}); err != nil {
+		return err
+	}
+	index.inflight[objectKey]++
+	return nil
+}
+
+// Finish journals the state of the key after the backend was changed, the
+// state is read under the lock so the concurrent changes are journaled in
+// order.
+func (index *bucketIndex) Finish(objectKey string, stat func() (*ObjectInfo, error)) error {
+	index.lock.Lock()
+	defer index.lock.Unlock()
+
+	index.inflight[objectKey]--
+	if index.inflight[objectKey] <= 0 {
+		delete(index.inflight, objectKey)
+	}
+	info, err := stat()
+	if err != nil && !errors.Is(err, ErrNoSuchKey) {
+		return err
+	}
+	if err := index.apply(objectKey, info); err != nil {
+		return err
+	}
+	if index.records > max(INDEX_MIN_JOURNAL_RECORDS, len(index.entries)) {
+		return index.writeSnapshot()
+	}
+	return nil
+}
+
+// Refresh compares the indexed state of the key with the one of the backend
+// and journals the difference (e.g. the file changed out of the service), it
+// returns the event of the change or the empty string. The keys being changed
+// by the service are skipped.
+func (index *bucketIndex) Refresh(objectKey string, stat func() (*ObjectInfo, error)) (string, error) {
+	index.lock.Lock()
+	defer index.lock.Unlock()
+
+	if index.inflight[objectKey] > 0 {
+		return "", nil
+	}
+	info, err := stat()
+	if err != nil && !errors.Is(err, ErrNoSuchKey) {
+		return "", err
+	}
+	position, exists := index.search(objectKey)
+	switch {
+	case info == nil && !exists:
+		return "", nil
+	case info == nil:
+		return EventObjectRemovedExternal, index.apply(objectKey, nil)
+	case exists && index.entries[position].equal(indexEntryOf(objectKey, info)):
+		return "", nil
+	}
+	return EventObjectCreatedExternal, index.apply(objectKey, info)
+}
+
+// entriesCopy returns the copy of the entries sorted by key.
+func (index *bucketIndex) entriesCopy() []indexEntry {
+	index.lock.Lock()
+	defer index.lock.Unlock()
+
+	return slices.Clone(index.entries)
+}
+
+// apply journals the state of the key, the key is deleted when info is nil.
+func (index *bucketIndex) apply(objectKey string, info *ObjectInfo) error {
+	if info == nil {
+		if err := index.append(indexRecord{Operation: indexDelete, Key: objectKey}); err != nil {
+			return err
+		}
+		index.remove(objectKey)
+		return nil
+	}
+
+	entry := indexEntryOf(objectKey, info)
+	if err := index.append(indexRecord{Operation: indexPut, Key: objectKey, Entry: &entry}); err != nil {
+		return err
+	}
+	index.set(entry)
+	return nil
+}
+
+func (entry indexEntry) equal(other indexEntry) bool {
+	return entry.Key == other.Key && entry.Size == other.Size && entry.ETag == other.ETag &&
+		entry.LastModified.Equal(other.LastModified) && entry.Metadata == other.Metadata
+}
+
+func indexEntryOf(objectKey string, info *ObjectInfo) indexEntry {
+	entry := indexEntry{
+		Key:          objectKey,
+		Size:         info.Size,
+		ETag:         info.ETag,
+		LastModified: info.LastModified,
+	}
+	if info.Metadata != nil {
+		data, err := json.Marshal(info.Metadata)
+		entry.Metadata = err != nil || string(data) != "{}"
+	}
+	return entry
+}
+
+// writeSnapshot replaces the snapshot with the current entries and starts the
+// new journal. The journal left by the crash before it was truncated only
+// repeats the changes of the snapshot.
+func (index *bucketIndex) writeSnapshot() error {
+	if err := writeIndexSnapshot(index.folder, index.entries); err != nil {
+		return err
+	}
+	if err := index.journal.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := index.journal.Seek(0, 0); err != nil {
+		return err
+	}
+	index.records = 0
+	return nil
+}
+
+func writeIndexSnapshot(folder string, entries []indexEntry) error {
+	if err := os.MkdirAll(folder, fs.ModeDir|0775); err != nil {
+		return err
+	}
+	path := filepath.Join(folder, INDEX_SNAPSHOT)
+	file, err := os.OpenFile(path+".tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	for _, entry := range entries {
+		data, err := json.Marshal(entry)
+		if err == nil {
+			_, err = writer.Write(append(data, '\n'))
+		}
+		if err != nil {
+			file.Close()
+			return err
+		}
+	}
+	err = writer.Flush()
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path + ".tmp")
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// Versions returns the indexed objects in the S3 listing order.
+func (index *bucketIndex) Versions() []models.ObjectVersion {
+	index.lock.Lock()
+	defer index.lock.Unlock()
+
+	versions := make([]models.ObjectVersion, len(index.entries))
+	for i, entry := range index.entries {
+		versions[i] = entry.version()
+	}
+	return versions
+}
+
+// Page lists the page of the query: the first entry is found by the binary
+// search of the prefix and the markers and the entries are read until the
+// page is complete only.
+func (index *bucketIndex) Page(query listingQuery) listingPage {
+	index.lock.Lock()
+	defer index.lock.Unlock()
+
+	start := sort.Search(len(index.entries), func(position int) bool {
+		return listedAfter(index.entries[position].Key, query)
+	})
+	entries := index.entries[start:]
+	return listingPageFrom(len(entries), func(position int) models.ObjectVersion {
+		return entries[position].version()
+	}, query)
+}
+
+func (entry indexEntry) version() models.ObjectVersion {
+	return models.ObjectVersion{
+		Object: models.Object{
+			Key:          entry.Key,
+			LastModified: entry.LastModified,
+			ETag:         entry.ETag,
+			Size:         utils.SizeInBytes(entry.Size),
+			StorageClass: "STANDARD",
+		},
+		VersionId: NULL_VERSION_ID,
+		IsLatest:  true,
+	}
+}
+
+func sortIndexEntries(entries []indexEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Key < entries[j].Key
+	})
+}
@@ -0,0 +1,275 @@
+/**
+ * Author: Vanya Usalko <ivict@rambler.ru>
+ * File: metrics.go
+ */
+
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LATENCY_BUCKETS are the upper bounds in seconds of the request latency
+// histogram buckets.
+var LATENCY_BUCKETS = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type requestSeries struct {
+	operation string
+	code      int
+}
+
+type latencyHistogram struct {
+	// counts are the counts of the requests per bucket, not cumulative
+	counts []int64
+	sum    float64
+	count  int64
+}
+
+// The metrics of the requests are kept since the service start, the storage
+// metrics are collected when they are exported.
+var requestLatencies = map[requestSeries]*latencyHistogram{}
+var receivedBytes = map[string]int64{}
+var sentBytes = map[string]int64{}
+var backendErrors = map[string]int64{}
+var metricsLock sync.Mutex
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	io.Reader
+	count int64
+}
+
+func (reader *countingReader) Read(buffer []byte) (int, error) {
+	count, err := reader.Reader.Read(buffer)
+	reader.count += int64(count)
+	return count, err
+}
+
+func (reader *countingReader) Close() error {
+	if closer, exists := reader.Reader.(io.Closer); exists {
+		return closer.Close()
+	}
+	return nil
+}
+
+// responseRecorder keeps the status and the error code and counts the bytes
+// of the response.
+type responseRecorder struct {
+	http.ResponseWriter
+	status    int
+	errorCode string
+	written   int64
+}
+
+func (recorder *responseRecorder) WriteHeader(status int) {
+	recorder.status = status
+	recorder.ResponseWriter.WriteHeader(status)
+}
+
+func (recorder *responseRecorder) Write(data []byte) (int, error) {
+	count, err := recorder.ResponseWriter.Write(data)
+	recorder.written += int64(count)
+	return count, err
+}
+
+// observeRequest accounts the served request, the internal errors are the
+// errors of the backend which are not mapped to the S3 errors.
+func observeRequest(operation string, bucketName string, status int, duration time.Duration, received int64, sent int64) {
+	metricsLock.Lock()
+	defer metricsLock.Unlock()
+
+	series := requestSeries{operation: operation, code: status}
+	histogram, exists := requestLatencies[series]
+	if !exists {
+		histogram = &latencyHistogram{counts: make([]int64, len(LATENCY_BUCKETS))}
+		requestLatencies[series] = histogram
+	}
+	seconds := duration.Seconds()
+	if index := sort.SearchFloat64s(LATENCY_BUCKETS, seconds); index < len(LATENCY_BUCKETS) {
+		histogram.counts[index]++
+	}
+	histogram.sum += seconds
+	histogram.count++
+
+	if bucketName != "" {
+		if received > 0 {
+			receivedBytes[bucketName] += received
+		}
+		if sent > 0 {
+			sentBytes[bucketName] += sent
+		}
+	}
+	if status == http.StatusInternalServerError {
+		backendErrors[operation]++
+	}
+}
+
+// metricLabels formats the label pairs, the values are escaped as the text
+// format requires.
+func metricLabels(pairs ...string) string {
+	if len(pairs) == 0 {
+		return ""
+	}
+	labels := make([]string, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		value := strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n").Replace(pairs[i+1])
+		labels = append(labels, fmt.Sprintf("%s=\"%s\"", pairs[i], value))
+	}
+	return "{" + strings.Join(labels, ",") + "}"
+}
+
+func formatMetricValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func writeMetricHeader(writer io.Writer, name string, kind string, help string) {
+	fmt.Fprintf(writer, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func writeMetric(writer io.Writer, name string, labels string, value float64) {
+	fmt.Fprintf(writer, "%s%s %s\n", name, labels, formatMetricValue(value))
+}
+
+// writeBucketCounter writes the counter of the buckets sorted by name.
+func writeBucketCounter(writer io.Writer, name string, help string, counters map[string]int64) {
+	writeMetricHeader(writer, name, "counter", help)
+	bucketNames := make([]string, 0, len(counters))
+	for bucketName := range counters {
+		bucketNames = append(bucketNames, bucketName)
+	}
+	sort.Strings(bucketNames)
+	for _, bucketName := range bucketNames {
+		writeMetric(writer, name, metricLabels("bucket", bucketName), float64(counters[bucketName]))
+	}
+}
+
+func writeRequestMetrics(writer io.Writer) {
+	metricsLock.Lock()
+	defer metricsLock.Unlock()
+
+	series := make([]requestSeries, 0, len(requestLatencies))
+	for key := range requestLatencies {
+		series = append(series, key)
+	}
+	sort.Slice(series, func(i, j int) bool {
+		if series[i].operation != series[j].operation {
+			return series[i].operation < series[j].operation
+		}
+		return series[i].code < series[j].code
+	})
+
+	writeMetricHeader(writer, "s2d3_requests_total", "counter", "Requests served per S3 operation and status code.")
+	for _, key := range series {
+		writeMetric(writer, "s2d3_requests_total", metricLabels("operation", key.operation, "code", strconv.Itoa(key.code)), float64(requestLatencies[key].count))
+	}
+
+	writeMetricHeader(writer, "s2d3_request_duration_seconds", "histogram", "Latency of the requests per S3 operation and status code.")
+	for _, key := range series {
+		histogram := requestLatencies[key]
+		operation, code := key.operation, strconv.Itoa(key.code)
+		cumulative := int64(0)
+		for i, bound := range LATENCY_BUCKETS {
+			cumulative += histogram.counts[i]
+			writeMetric(writer, "s2d3_request_duration_seconds_bucket", metricLabels("operation", operation, "code", code, "le", formatMetricValue(bound)), float64(cumulative))
+		}
+		writeMetric(writer, "s2d3_request_duration_seconds_bucket", metricLabels("operation", operation, "code", code, "le", "+Inf"), float64(histogram.count))
+		writeMetric(writer, "s2d3_request_duration_seconds_sum", metricLabels("operation", operation, "code", code), histogram.sum)
+		writeMetric(writer, "s2d3_request_duration_seconds_count", metricLabels("operation", operation, "code", code), float64(histogram.count))
+	}
+
+	writeBucketCounter(writer, "s2d3_bucket_received_bytes_total", "Bytes of the request bodies per bucket.", receivedBytes)
+	writeBucketCounter(writer, "s2d3_bucket_sent_bytes_total", "Bytes of the response bodies per bucket.", sentBytes)
+
+	operations := make([]string, 0, len(backendErrors))
+	for operation := range backendErrors {
+		operations = append(operations, operation)
+	}
+	sort.Strings(operations)
+	writeMetricHeader(writer, "s2d3_backend_errors_total", "counter", "Requests failed by the errors of the backend (internal errors) per S3 operation.")
+	for _, operation := range operations {
+		writeMetric(writer, "s2d3_backend_errors_total", metricLabels("operation", operation), float64(backendErrors[operation]))
+	}
+}
+
+// writeStorageMetrics writes the usage and the in-flight multipart uploads of
+// the buckets and the status of the disks. The usage is counted once and is
+// tracked by the storage afterwards.
+func writeStorageMetrics(writer io.Writer, storage *Storage) error {
+	buckets, err := storage.ListBuckets()
+	if err != nil {
+		return err
+	}
+	usageBytes, usageObjects, uploads := &bytes.Buffer{}, &bytes.Buffer{}, &bytes.Buffer{}
+	for _, bucket := range buckets {
+		labels := metricLabels("bucket", bucket.Name)
+		usage, err := storage.GetQuotaUsage(bucket.Name)
+		if err != nil {
+			return err
+		}
+		writeMetric(usageBytes, "s2d3_bucket_usage_bytes", labels, float64(usage.Bytes))
+		writeMetric(usageObjects, "s2d3_bucket_objects", labels, float64(usage.Objects))
+		bucketUploads, err := storage.ListUploads(bucket.Name)
+		if err != nil {
+			return err
+		}
+		writeMetric(uploads, "s2d3_multipart_uploads_in_flight", labels, float64(len(bucketUploads)))
+	}
+	writeMetricHeader(writer, "s2d3_bucket_usage_bytes", "gauge", "Total size of the latest versions of the bucket objects.")
+	writer.Write(usageBytes.Bytes())
+	writeMetricHeader(writer, "s2d3_bucket_objects", "gauge", "Count of the bucket objects.")
+	writer.Write(usageObjects.Bytes())
+	writeMetricHeader(writer, "s2d3_multipart_uploads_in_flight", "gauge", "Multipart uploads which are neither completed nor aborted.")
+	writer.Write(uploads.Bytes())
+
+	reporter, exists := CapabilityOf[DiskReporter](storage.Backend)
+	if !exists {
+		return nil
+	}
+	disks, err := reporter.DiskStatus()
+	if err != nil {
+		return err
+	}
+	metrics := []struct {
+		name  string
+		help  string
+		value func(index int) float64
+	}{
+		{"s2d3_disk_total_bytes", "Size of the disk of the data folder.", func(index int) float64 { return float64(disks[index].TotalBytes) }},
+		{"s2d3_disk_free_bytes", "Free space of the disk of the data folder available to the service.", func(index int) float64 { return float64(disks[index].FreeBytes) }},
+		{"s2d3_disk_reserve_bytes", "Free space kept on the disk of the data folder.", func(index int) float64 { return float64(disks[index].ReserveBytes) }},
+		{"s2d3_disk_writes_refused", "1 when the writes into the data folder are refused as the free space is below the reserve.", func(index int) float64 {
+			if disks[index].WritesRefused {
+				return 1
+			}
+			return 0
+		}},
+	}
+	for _, metric := range metrics {
+		writeMetricHeader(writer, metric.name, "gauge", metric.help)
+		for index, disk := range disks {
+			writeMetric(writer, metric.name, metricLabels("folder", disk.Folder), metric.value(index))
+		}
+	}
+	return nil
+}
+
+// Metrics exports the metrics of the service in the Prometheus text format.
+func Metrics(writer http.ResponseWriter, request *http.Request) {
+	storage := storageOf(request)
+	buffer := &bytes.Buffer{}
+	writeRequestMetrics(buffer)
+	if err := writeStorageMetrics(buffer, &storage); err != nil {
+		fmt.Printf("[metrics] the storage metrics are not collected: %s\n", err)
+	}
+
+	writer.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	writer.Write(buffer.Bytes())
+}
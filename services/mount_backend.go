@@ -0,0 +1,287 @@
+/**
+ * Author: Vanya Usalko <ivict@rambler.ru>
+ * File: mount_backend.go
+ */
+
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+
+	"github.com/usalko/s2d3/models"
+)
+
+const CodeQuotaExceeded = "QuotaExceeded"
+
+var ErrQuotaExceeded = errors.New("the bucket quota is exceeded")
+
+// Mount is the bucket served by its own backend (e.g. the folder on the other
+// disk), the mount is read-only or limited by the quota optionally.
+type Mount struct {
+	Bucket   string
+	Backend  Backend
+	ReadOnly bool
+	// Quota is the limit of the total size of the bucket objects, 0 is no limit.
+	// It is the hard limit of the bucket quota (see GetQuotaConfiguration), so
+	// it is checked against the tracked usage of the bucket
+	Quota int64
+}
+
+// MountBackend routes the requests of the mounted buckets to their backends,
+// the other buckets and the records are kept by the default backend.
+type MountBackend struct {
+	Default Backend
+	mounts  map[string]*Mount
+}
+
+func NewMountBackend(defaultBackend Backend, mounts []Mount) (*MountBackend, error) {
+	backend := &MountBackend{
+		Default: defaultBackend,
+		mounts:  map[string]*Mount{},
+	}
+	for i := range mounts {
+		mount := &mounts[i]
+		if mount.Bucket == "" || mount.Backend == nil {
+			return nil, fmt.Errorf("the mount must have the bucket and the backend")
+		}
+		if _, exists := backend.mounts[mount.Bucket]; exists {
+			return nil, fmt.Errorf("the bucket %s is mounted twice", mount.Bucket)
+		}
+		backend.mounts[mount.Bucket] = mount
+	}
+	return backend, nil
+}
+
+func (backend *MountBackend) of(bucketName string) Backend {
+	if mount, exists := backend.mounts[bucketName]; exists {
+		return mount.Backend
+	}
+	return backend.Default
+}
+
+// Mounts returns the mounted buckets ordered by their names.
+func (backend *MountBackend) Mounts() []Mount {
+	mounts := make([]Mount, 0, len(backend.mounts))
+	for _, mount := range backend.mounts {
+		mounts = append(mounts, *mount)
+	}
+	sort.Slice(mounts, func(i, j int) bool {
+		return mounts[i].Bucket < mounts[j].Bucket
+	})
+	return mounts
+}
+
+func (backend *MountBackend) ListBuckets() ([]models.Bucket, error) {
+	defaultBuckets, err := backend.Default.ListBuckets()
+	if err != nil {
+		return nil, err
+	}
+	buckets := make([]models.Bucket, 0, len(defaultBuckets)+len(backend.mounts))
+	for _, bucket := range defaultBuckets {
+		if _, exists := backend.mounts[bucket.Name]; !exists {
+			buckets = append(buckets, bucket)
+		}
+	}
+	for bucketName, mount := range backend.mounts {
+		mountBuckets, err := mount.Backend.ListBuckets()
+		if err != nil {
+			return nil, err
+		}
+		for _, bucket := range mountBuckets {
+			if bucket.Name == bucketName {
+				buckets = append(buckets, bucket)
+			}
+		}
+	}
+	sort.Slice(buckets, func(i, j int) bool {
+		return buckets[i].Name < buckets[j].Name
+	})
+	return buckets, nil
+}
+
+func (backend *MountBackend) CreateBucket(bucketName string) error {
+	return backend.of(bucketName).CreateBucket(bucketName)
+}
+
+func (backend *MountBackend) CheckBucket(bucketName string) error {
+	return backend.of(bucketName).CheckBucket(bucketName)
+}
+
+// DeleteBucket deletes the bucket of the default backend, the mounted
+// buckets are kept as their folders are configured by the mounts.
+func (backend *MountBackend) DeleteBucket(bucketName string) error {
+	if _, exists := backend.mounts[bucketName]; exists {
+		return fmt.Errorf("the bucket %s is mounted and can't be deleted", bucketName)
+	}
+	remover, exists := CapabilityOf[BucketRemover](backend.Default)
+	if !exists {
+		return fmt.Errorf("the backend can't delete the buckets")
+	}
+	return remover.DeleteBucket(bucketName)
+}
+
+func (backend *MountBackend) CheckWritable(bucketName string) error {
+	if mount, exists := backend.mounts[bucketName]; exists && mount.ReadOnly {
+		return ErrReadOnly
+	}
+	return backend.of(bucketName).CheckWritable(bucketName)
+}
+
+func (backend *MountBackend) GetBucketConfig(bucketName string, configName string) ([]byte, error) {
+	return backend.of(bucketName).GetBucketConfig(bucketName, configName)
+}
+
+func (backend *MountBackend) PutBucketConfig(bucketName string, configName string, data []byte) error {
+	return backend.of(bucketName).PutBucketConfig(bucketName, configName, data)
+}
+
+func (backend *MountBackend) DeleteBucketConfig(bucketName string, configName string) error {
+	return backend.of(bucketName).DeleteBucketConfig(bucketName, configName)
+}
+
+func (backend *MountBackend) Put(bucketName string, objectKey string, reader io.Reader, metadata *models.ObjectMetadata) error {
+	return backend.of(bucketName).Put(bucketName, objectKey, reader, metadata)
+}
+
+func (backend *MountBackend) Get(bucketName string, objectKey string) (io.ReadSeekCloser, *ObjectInfo, error) {
+	return backend.of(bucketName).Get(bucketName, objectKey)
+}
+
+func (backend *MountBackend) Stat(bucketName string, objectKey string) (*ObjectInfo, error) {
+	return backend.of(bucketName).Stat(bucketName, objectKey)
+}
+
+func (backend *MountBackend) List(bucketName string) ([]models.ObjectVersion, error) {
+	return backend.of(bucketName).List(bucketName)
+}
+
+func (backend *MountBackend) Delete(bucketName string, objectKey string) error {
+	return backend.of(bucketName).Delete(bucketName, objectKey)
+}
+
+func (backend *MountBackend) GetMetadata(bucketName string, objectKey string) (*models.ObjectMetadata, error) {
+	return backend.of(bucketName).GetMetadata(bucketName, objectKey)
+}
+
+func (backend *MountBackend) PutMetadata(bucketName string, objectKey string, metadata *models.ObjectMetadata) error {
+	return backend.of(bucketName).PutMetadata(bucketName, objectKey, metadata)
+}
+
+func (backend *MountBackend) PutUpload(upload *models.Upload) error {
+	return backend.of(upload.Bucket).PutUpload(upload)
+}
+
+func (backend *MountBackend) GetUpload(bucketName string, objectKey string, uploadId string) (*models.Upload, error) {
+	return backend.of(bucketName).GetUpload(bucketName, objectKey, uploadId)
+}
+
+func (backend *MountBackend) DeleteUpload(bucketName string, objectKey string, uploadId string) error {
+	return backend.of(bucketName).DeleteUpload(bucketName, objectKey, uploadId)
+}
+
+func (backend *MountBackend) ListUploads(bucketName string) ([]models.Upload, error) {
+	return backend.of(bucketName).ListUploads(bucketName)
+}
+
+func (backend *MountBackend) PutPart(bucketName string, objectKey string, uploadId string, partNumber int, reader io.Reader) error {
+	return backend.of(bucketName).PutPart(bucketName, objectKey, uploadId, partNumber, reader)
+}
+
+func (backend *MountBackend) GetPart(bucketName string, objectKey string, uploadId string, partNumber int) (io.ReadSeekCloser, error) {
+	return backend.of(bucketName).GetPart(bucketName, objectKey, uploadId, partNumber)
+}
+
+func (backend *MountBackend) ListParts(bucketName string, objectKey string, uploadId string) ([]int, error) {
+	return backend.of(bucketName).ListParts(bucketName, objectKey, uploadId)
+}
+
+func (backend *MountBackend) GetRecord(name string) ([]byte, error) {
+	return backend.Default.GetRecord(name)
+}
+
+func (backend *MountBackend) PutRecord(name string, data []byte) error {
+	return backend.Default.PutRecord(name, data)
+}
+
+func (backend *MountBackend) DeleteRecord(name string) error {
+	return backend.Default.DeleteRecord(name)
+}
+
+func (backend *MountBackend) ListRecords(folder string) ([]string, error) {
+	return backend.Default.ListRecords(folder)
+}
+
+func (backend *MountBackend) CompactPacks(bucketName string, minGarbageRatio float64) (int64, error) {
+	compactor, exists := CapabilityOf[PackCompactor](backend.of(bucketName))
+	if !exists {
+		return 0, nil
+	}
+	return compactor.CompactPacks(bucketName, minGarbageRatio)
+}
+
+// backends returns the distinct backends of the default and the mounted
+// buckets.
+func (backend *MountBackend) backends() []Backend {
+	backends := []Backend{backend.Default}
+	for _, mount := range backend.mounts {
+		if !slices.Contains(backends, mount.Backend) {
+			backends = append(backends, mount.Backend)
+		}
+	}
+	return backends
+}
+
+func (backend *MountBackend) CollectSegments() (int64, int64, error) {
+	removed, reclaimed := int64(0), int64(0)
+	var result error
+	for _, mountedBackend := range backend.backends() {
+		if deduplicator, exists := CapabilityOf[Deduplicator](mountedBackend); exists {
+			count, size, err := deduplicator.CollectSegments()
+			removed += count
+			reclaimed += size
+			result = errors.Join(result, err)
+		}
+	}
+	return removed, reclaimed, result
+}
+
+func (backend *MountBackend) DedupStatistics() (*models.DedupStatistics, error) {
+	statistics := &models.DedupStatistics{}
+	for _, mountedBackend := range backend.backends() {
+		deduplicator, exists := CapabilityOf[Deduplicator](mountedBackend)
+		if !exists {
+			continue
+		}
+		backendStatistics, err := deduplicator.DedupStatistics()
+		if err != nil {
+			return nil, err
+		}
+		statistics.Segments += backendStatistics.Segments
+		statistics.References += backendStatistics.References
+		statistics.StoredBytes += backendStatistics.StoredBytes
+		statistics.LogicalBytes += backendStatistics.LogicalBytes
+		statistics.UnreferencedBytes += backendStatistics.UnreferencedBytes
+	}
+	statistics.DedupRatio = dedupRatio(statistics)
+	return statistics, nil
+}
+
+// DiskStatus reports the disks of the default and the mounted backends, the
+// folders of the same disk are reported separately.
+func (backend *MountBackend) DiskStatus() ([]models.DiskStatus, error) {
+	disks := make([]models.DiskStatus, 0)
+	for _, mountedBackend := range backend.backends() {
+		if reporter, exists := CapabilityOf[DiskReporter](mountedBackend); exists {
+			backendDisks, err := reporter.DiskStatus()
+			if err != nil {
+				return nil, err
+			}
+			disks = append(disks, backendDisks...)
+		}
+	}
+	return disks, nil
+}
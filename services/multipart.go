@@ -0,0 +1,169 @@
+/**
+ * Author: Vanya Usalko <ivict@rambler.ru>
+ * File: multipart.go
+ */
+
+package services
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/usalko/s2d3/models"
+)
+
+var ErrNoSuchUpload = errors.New("the specified multipart upload does not exist")
+var ErrInvalidPart = errors.New("one or more of the specified parts could not be found or its entity tag did not match")
+var ErrMissingParts = errors.New("the completion of the multipart upload must list its parts")
+
+// CreateUpload starts the multipart upload, the parts of the encrypted upload
+// are staged encrypted with the data key of the upload.
+func (storage *Storage) CreateUpload(bucketName string, objectKey string, suffix string, metadata *models.ObjectMetadata, sse *ServerSideEncryption) error {
+	var err error
+	if sse == nil {
+		sse, err = storage.defaultEncryption(bucketName)
+		if err != nil {
+			return err
+		}
+	}
+	if sse != nil {
+		metadata.Encryption, _, err = storage.newObjectEncryption(sse)
+		if err != nil {
+			return err
+		}
+	}
+
+	return storage.PutUpload(&models.Upload{
+		Bucket:    bucketName,
+		Key:       objectKey,
+		UploadId:  suffix,
+		Initiated: time.Now().UTC(),
+		Metadata:  *metadata,
+	})
+}
+
+// PushPart stages one part of the multipart upload and returns its ETag.
+func (storage *Storage) PushPart(bucketName string, objectKey string, suffix string, partNumber int, reader io.ReadCloser, sse *ServerSideEncryption) (string, error) {
+	upload, err := storage.GetUpload(bucketName, objectKey, suffix)
+	if err != nil {
+		return "", err
+	}
+
+	// The plain part of the declared size is streamed into its file, the
+	// other ones are checked by the size of their content
+	if size, declared := declaredSizeOf(reader, &models.ObjectMetadata{}); declared && upload.Metadata.Encryption == nil {
+		if err := storage.checkPartQuota(bucketName, objectKey, size); err != nil {
+			return "", err
+		}
+		hash := md5.New()
+		err = storage.PutPart(bucketName, objectKey, suffix, partNumber, &declaredBody{ReadCloser: io.NopCloser(io.TeeReader(reader, hash)), size: size})
+		if err != nil {
+			return "", err
+		}
+		return hex.EncodeToString(hash.Sum(nil)), nil
+	}
+
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return "", err
+	}
+	hash := md5.Sum(content)
+	if err := storage.checkPartQuota(bucketName, objectKey, int64(len(content))); err != nil {
+		return "", err
+	}
+
+	if upload.Metadata.Encryption != nil {
+		dataKey, err := storage.dataKeyOf(upload.Metadata.Encryption, sse)
+		if err != nil {
+			return "", err
+		}
+		content, err = encryptChunks(dataKey, content)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	err = storage.PutPart(bucketName, objectKey, suffix, partNumber, bytes.NewReader(content))
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// CompleteUpload assembles the listed parts into the object, the completion
+// without the parts is refused by ErrMissingParts. The customer key must be
+// given to complete the SSE-C upload.
+func (storage *Storage) CompleteUpload(bucketName string, objectKey string, suffix string, uploadDone UploadDone, sse *ServerSideEncryption) error {
+	upload, err := storage.GetUpload(bucketName, objectKey, suffix)
+	if err != nil {
+		return err
+	}
+
+	var dataKey []byte
+	var objectSse *ServerSideEncryption
+	if upload.Metadata.Encryption != nil {
+		dataKey, err = storage.dataKeyOf(upload.Metadata.Encryption, sse)
+		if err != nil {
+			return err
+		}
+		objectSse = &ServerSideEncryption{}
+		if upload.Metadata.Encryption.CustomerKeyMD5 != "" {
+			objectSse = sse
+		}
+	}
+
+	parts := uploadDone.Parts
+	if len(parts) == 0 {
+		return ErrMissingParts
+	}
+	if !sort.SliceIsSorted(parts, func(i, j int) bool { return parts[i].PartNumber < parts[j].PartNumber }) {
+		return ErrInvalidPart
+	}
+
+	readers := make([]io.Reader, len(parts))
+	for i, part := range parts {
+		partReader, err := storage.GetPart(bucketName, objectKey, suffix, part.PartNumber)
+		if err != nil {
+			return err
+		}
+		var content []byte
+		if dataKey != nil {
+			content, err = decryptAll(partReader, dataKey)
+		} else {
+			content, err = io.ReadAll(partReader)
+		}
+		partReader.Close()
+		if err != nil {
+			return err
+		}
+		hash := md5.Sum(content)
+		etag := strings.Trim(part.ETag, "\"")
+		if etag != "" && etag != hex.EncodeToString(hash[:]) {
+			return ErrInvalidPart
+		}
+		readers[i] = bytes.NewReader(content)
+	}
+
+	err = storage.PutObject(bucketName, objectKey, io.NopCloser(io.MultiReader(readers...)), &upload.Metadata, objectSse)
+	if err != nil {
+		return err
+	}
+	return storage.DeleteUpload(bucketName, objectKey, suffix)
+}
+
+// sortUploads sorts the uploads in the listing order, the uploads of the
+// same key are sorted by the initiation time.
+func sortUploads(uploads []models.Upload) {
+	sort.Slice(uploads, func(i, j int) bool {
+		if uploads[i].Key != uploads[j].Key {
+			return uploads[i].Key < uploads[j].Key
+		}
+		return uploads[i].Initiated.Before(uploads[j].Initiated)
+	})
+}
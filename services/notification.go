@@ -0,0 +1,329 @@
+/**
+ * Author: Vanya Usalko <ivict@rambler.ru>
+ * File: notification.go
+ */
+
+package services
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/usalko/s2d3/models"
+)
+
+const NOTIFICATION_CONFIG = "notification"
+const NOTIFICATIONS_FOLDER = "notifications"
+
+const (
+	EventObjectCreatedPut                     = "s3:ObjectCreated:Put"
+	EventObjectCreatedCompleteMultipartUpload = "s3:ObjectCreated:CompleteMultipartUpload"
+	EventObjectRemovedDelete                  = "s3:ObjectRemoved:Delete"
+	EventLifecycleExpirationDelete            = "s3:LifecycleExpiration:Delete"
+	// The objects changed in the data folder out of the service
+	EventObjectCreatedExternal = "s3:ObjectCreated:External"
+	EventObjectRemovedExternal = "s3:ObjectRemoved:External"
+)
+
+const EVENT_TIME_FORMAT = "2006-01-02T15:04:05.000Z"
+
+var NOTIFICATION_EVENTS = []string{
+	"s3:ObjectCreated:*",
+	EventObjectCreatedPut,
+	EventObjectCreatedCompleteMultipartUpload,
+	EventObjectCreatedExternal,
+	"s3:ObjectRemoved:*",
+	EventObjectRemovedDelete,
+	EventObjectRemovedExternal,
+	"s3:LifecycleExpiration:*",
+	EventLifecycleExpirationDelete,
+}
+
+var eventQueueSequence atomic.Uint64
+
+// GetNotificationConfiguration returns nil for buckets without notifications.
+func (storage *Storage) GetNotificationConfiguration(bucketName string) (*models.NotificationConfiguration, error) {
+	data, err := storage.GetBucketConfig(bucketName, NOTIFICATION_CONFIG)
+	if errors.Is(err, ErrNoSuchConfiguration) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	config := models.NotificationConfiguration{}
+	if err := xml.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+func validateNotification(config *models.NotificationConfiguration) error {
+	ids := make(map[string]bool)
+	for _, target := range config.Targets() {
+		endpoint, err := url.Parse(target.Endpoint())
+		if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+			return fmt.Errorf("the notification destination %s is not a http(s) url", target.Endpoint())
+		}
+		if target.Id != "" {
+			if ids[target.Id] {
+				return fmt.Errorf("the notification configuration id %s is not unique", target.Id)
+			}
+			ids[target.Id] = true
+		}
+		if len(target.Events) == 0 {
+			return fmt.Errorf("the notification configuration must have at least one event")
+		}
+		for _, event := range target.Events {
+			if !slices.Contains(NOTIFICATION_EVENTS, event) {
+				return fmt.Errorf("the event %s is not supported", event)
+			}
+		}
+		if target.Filter == nil {
+			continue
+		}
+		names := make(map[string]int)
+		for _, rule := range target.Filter.FilterRules {
+			name := strings.ToLower(rule.Name)
+			if name != models.FilterRulePrefix && name != models.FilterRuleSuffix {
+				return fmt.Errorf("the filter rule name %s must be either prefix or suffix", rule.Name)
+			}
+			names[name]++
+			if names[name] > 1 {
+				return fmt.Errorf("the filter rule name %s is not unique", name)
+			}
+		}
+	}
+	return nil
+}
+
+// checkNotificationDestinations refuses the destinations of the internal
+// addresses (loopback, private, link local) and the ones which are not
+// resolved, the destination names are resolved by the time of the check.
+func checkNotificationDestinations(config *models.NotificationConfiguration) error {
+	for _, target := range config.Targets() {
+		endpoint, err := url.Parse(target.Endpoint())
+		if err != nil {
+			return err
+		}
+		addresses := make([]net.IP, 0)
+		if address := net.ParseIP(endpoint.Hostname()); address != nil {
+			addresses = append(addresses, address)
+		} else {
+			addresses, err = net.LookupIP(endpoint.Hostname())
+			if err != nil {
+				return fmt.Errorf("the notification destination %s is not resolved: %w", target.Endpoint(), err)
+			}
+		}
+		for _, address := range addresses {
+			if internalAddress(address) {
+				return fmt.Errorf("the notification destination %s is the internal address %s", target.Endpoint(), address)
+			}
+		}
+	}
+	return nil
+}
+
+func internalAddress(address net.IP) bool {
+	return address.IsLoopback() || address.IsPrivate() || address.IsUnspecified() || address.IsMulticast() ||
+		address.IsLinkLocalUnicast() || address.IsLinkLocalMulticast() || address.IsInterfaceLocalMulticast()
+}
+
+// notificationMatches checks the event name against the configured events
+// (with the "s3:ObjectCreated:*" kind of wildcards) and the key filters.
+func notificationMatches(target *models.NotificationTarget, eventName string, objectKey string) bool {
+	if !slices.ContainsFunc(target.Events, func(event string) bool {
+		kind, found := strings.CutSuffix(event, "*")
+		if found {
+			return strings.HasPrefix(eventName, kind)
+		}
+		return event == eventName
+	}) {
+		return false
+	}
+
+	if target.Filter == nil {
+		return true
+	}
+	for _, rule := range target.Filter.FilterRules {
+		switch strings.ToLower(rule.Name) {
+		case models.FilterRulePrefix:
+			if !strings.HasPrefix(objectKey, rule.Value) {
+				return false
+			}
+		case models.FilterRuleSuffix:
+			if !strings.HasSuffix(objectKey, rule.Value) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Notify queues the event for all the matching notification targets of the
+// bucket, the object size and ETag are taken from the stored object unless
+// it was removed. The events out of the requests (lifecycle, folder watcher)
+// get the new request id.
+func (storage *Storage) Notify(bucketName string, objectKey string, eventName string, principalId string, sourceIp string, requestId string) error {
+	config, err := storage.GetNotificationConfiguration(bucketName)
+	if err != nil || config == nil {
+		return err
+	}
+
+	if requestId == "" {
+		requestId = newRequestId()
+	}
+	now := time.Now().UTC()
+	object := models.EventObject{
+		Key:       url.QueryEscape(objectKey),
+		Sequencer: fmt.Sprintf("%016X", now.UnixNano()),
+	}
+	if strings.HasPrefix(eventName, "s3:ObjectCreated:") {
+		info, err := storage.Stat(bucketName, objectKey)
+		if err != nil {
+			return err
+		}
+		object.Size, object.ETag = info.Size, info.ETag
+	}
+
+	var result error
+	for _, target := range config.Targets() {
+		if !notificationMatches(&target, eventName, objectKey) {
+			continue
+		}
+		record := models.EventRecord{
+			EventVersion: "2.1",
+			EventSource:  "aws:s3",
+			EventTime:    now.Format(EVENT_TIME_FORMAT),
+			EventName:    strings.TrimPrefix(eventName, "s3:"),
+			UserIdentity: models.EventIdentity{PrincipalId: principalId},
+			RequestParameters: map[string]string{
+				"sourceIPAddress": sourceIp,
+			},
+			ResponseElements: map[string]string{
+				"x-amz-request-id": requestId,
+			},
+			S3: models.EventS3{
+				SchemaVersion:   "1.0",
+				ConfigurationId: target.Id,
+				Bucket: models.EventBucket{
+					Name: bucketName,
+					Arn:  "arn:aws:s3:::" + bucketName,
+				},
+				Object: object,
+			},
+		}
+		result = errors.Join(result, storage.queueEvent(&models.QueuedEvent{
+			Endpoint:    target.Endpoint(),
+			Event:       models.Event{Records: []models.EventRecord{record}},
+			NextAttempt: now,
+		}))
+	}
+
+	if storage.NotificationSignal != nil {
+		select {
+		case storage.NotificationSignal <- struct{}{}:
+		default:
+		}
+	}
+	return result
+}
+
+// queueEvent writes the event into the queue records, the names of the
+// records keep the order of the events.
+func (storage *Storage) queueEvent(queuedEvent *models.QueuedEvent) error {
+	data, err := json.Marshal(queuedEvent)
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%020d-%06d.json", time.Now().UnixNano(), eventQueueSequence.Add(1)%1000000)
+	return storage.PutRecord(strings.Join([]string{NOTIFICATIONS_FOLDER, name}, "/"), data)
+}
+
+// notify queues the event of the request, the failure to queue it doesn't
+// fail the request and is only logged.
+func notify(request *http.Request, storage *Storage, bucketName string, objectKey string, eventName string) {
+	sourceIp, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		sourceIp = request.RemoteAddr
+	}
+	requestId, _ := request.Context().Value(KeyRequestId).(string)
+	err = storage.Notify(bucketName, objectKey, eventName, requestAccessKey(request), sourceIp, requestId)
+	if err != nil {
+		fmt.Printf("[notification] %s event of %s/%s is not queued: %s\n", eventName, bucketName, objectKey, err)
+	}
+}
+
+// Notification implements PUT and GET of the bucket notification
+// configuration, the empty configuration turns the notifications off.
+func Notification(writer http.ResponseWriter, request *http.Request) {
+	bucketName, _ := bucketNameAndObjectKey(request.URL.Path, request.Context().Value(KeyUrlContext).(string))
+	storage := storageOf(request)
+
+	switch request.Method {
+
+	case "GET":
+		config, err := storage.GetNotificationConfiguration(bucketName)
+		if err != nil {
+			writeBucketConfigError(writer, err, CodeInvalidArgument)
+			return
+		}
+		if config == nil {
+			config = &models.NotificationConfiguration{}
+		}
+		writeXml(writer, config)
+
+	case "PUT":
+		body, err := io.ReadAll(request.Body)
+		if err != nil {
+			writeError(writer, http.StatusBadRequest, CodeInvalidArgument, err.Error())
+			return
+		}
+		config := models.NotificationConfiguration{}
+		if err := xml.Unmarshal(body, &config); err != nil {
+			writeError(writer, http.StatusBadRequest, CodeMalformedXML, err.Error())
+			return
+		}
+		if err := validateNotification(&config); err != nil {
+			writeError(writer, http.StatusBadRequest, CodeInvalidArgument, err.Error())
+			return
+		}
+		// The webhooks are posted from the network of the server, so its
+		// internal addresses are the destinations of the admin only
+		if !hasAdminToken(request) {
+			if err := checkNotificationDestinations(&config); err != nil {
+				writeError(writer, http.StatusForbidden, CodeAccessDenied, err.Error())
+				return
+			}
+		}
+
+		if len(config.Targets()) == 0 {
+			err = storage.DeleteBucketConfig(bucketName, NOTIFICATION_CONFIG)
+			if errors.Is(err, ErrNoSuchConfiguration) {
+				err = nil
+			}
+		} else {
+			var data []byte
+			data, err = xml.Marshal(&config)
+			if err == nil {
+				err = storage.PutBucketConfig(bucketName, NOTIFICATION_CONFIG, data)
+			}
+		}
+		if err != nil {
+			writeBucketConfigError(writer, err, CodeInvalidArgument)
+			return
+		}
+
+	}
+}
@@ -0,0 +1,216 @@
+/**
+ * Author: Vanya Usalko <ivict@rambler.ru>
+ * File: notification_worker.go
+ */
+
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/usalko/s2d3/models"
+)
+
+const DEFAULT_NOTIFICATION_INTERVAL = 5 * time.Second
+const DEFAULT_NOTIFICATION_MAX_ATTEMPTS = 10
+const DEFAULT_NOTIFICATION_TIMEOUT = 10 * time.Second
+const DEFAULT_NOTIFICATION_MAX_DELIVERIES = 100
+const NOTIFICATION_MAX_BACKOFF = time.Hour
+const FAILED_NOTIFICATIONS_FOLDER = "failed"
+
+// errEndpointRefused is the failure of the endpoint which has responded, the
+// endpoint which doesn't respond gets no more events until the next delivery.
+var errEndpointRefused = errors.New("the endpoint refused the event")
+
+// NotificationWorker delivers the queued bucket events to the webhooks. The
+// failed deliveries are retried with the exponential backoff, the events
+// which exhausted the attempts are moved to the failed folder of the queue.
+type NotificationWorker struct {
+	Storage     Storage
+	Interval    time.Duration
+	MaxAttempts int
+	// MaxDeliveries limits the events posted to every endpoint by one
+	// delivery, the rest of them waits for the next one
+	MaxDeliveries int
+	Client        *http.Client
+	// Signal wakes up the worker when an event is queued, it is the
+	// NotificationSignal of the storages queueing the events
+	Signal chan struct{}
+}
+
+func (worker *NotificationWorker) Run(ctx context.Context) {
+	interval := worker.Interval
+	if interval <= 0 {
+		interval = DEFAULT_NOTIFICATION_INTERVAL
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	health := startWorkerHealth("notifications", interval)
+	defer health.stop()
+
+	for {
+		err := worker.Deliver(time.Now())
+		if err != nil {
+			fmt.Printf("[notification] %s\n", err)
+		}
+		health.ran(err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-worker.Signal:
+		}
+	}
+}
+
+// notificationBackoff is the delay before the next attempt: one second
+// doubled with every failed attempt.
+func notificationBackoff(attempts int) time.Duration {
+	backoff := time.Second
+	for i := 1; i < attempts && backoff < NOTIFICATION_MAX_BACKOFF; i++ {
+		backoff *= 2
+	}
+	return min(backoff, NOTIFICATION_MAX_BACKOFF)
+}
+
+// queuedRecord is the queued event with the name of its record.
+type queuedRecord struct {
+	name  string
+	data  []byte
+	event models.QueuedEvent
+}
+
+// Deliver sends the queued events which are due as of the given time. The
+// endpoints are delivered concurrently and the events of every endpoint in
+// the order they were queued, so the endpoint which doesn't respond delays
+// its own events only: it gets no more events once it has not responded and
+// at most MaxDeliveries events in any case.
+func (worker *NotificationWorker) Deliver(now time.Time) error {
+	names, err := worker.Storage.ListRecords(NOTIFICATIONS_FOLDER)
+	if err != nil {
+		return err
+	}
+
+	var result error
+	endpoints := make([]string, 0)
+	queues := make(map[string][]*queuedRecord)
+	for _, name := range names {
+		if !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		record, err := worker.readQueued(name)
+		if err != nil {
+			result = errors.Join(result, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+		if now.Before(record.event.NextAttempt) {
+			continue
+		}
+		endpoint := record.event.Endpoint
+		if _, exists := queues[endpoint]; !exists {
+			endpoints = append(endpoints, endpoint)
+		}
+		queues[endpoint] = append(queues[endpoint], record)
+	}
+
+	maxDeliveries := worker.MaxDeliveries
+	if maxDeliveries <= 0 {
+		maxDeliveries = DEFAULT_NOTIFICATION_MAX_DELIVERIES
+	}
+	var lock sync.Mutex
+	var group sync.WaitGroup
+	for _, endpoint := range endpoints {
+		group.Add(1)
+		go func(records []*queuedRecord) {
+			defer group.Done()
+			for _, record := range records[:min(len(records), maxDeliveries)] {
+				responded, err := worker.deliverQueued(record, now)
+				if err != nil {
+					lock.Lock()
+					result = errors.Join(result, fmt.Errorf("%s: %w", record.name, err))
+					lock.Unlock()
+				}
+				if !responded {
+					return
+				}
+			}
+		}(queues[endpoint])
+	}
+	group.Wait()
+	return result
+}
+
+func (worker *NotificationWorker) readQueued(name string) (*queuedRecord, error) {
+	data, err := worker.Storage.GetRecord(strings.Join([]string{NOTIFICATIONS_FOLDER, name}, "/"))
+	if err != nil {
+		return nil, err
+	}
+	record := &queuedRecord{name: name, data: data}
+	if err := json.Unmarshal(data, &record.event); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// deliverQueued posts the queued event and returns true when the endpoint
+// has responded, whether it has accepted the event or not.
+func (worker *NotificationWorker) deliverQueued(record *queuedRecord, now time.Time) (bool, error) {
+	name, data, queuedEvent := record.name, record.data, record.event
+	queueRecord := strings.Join([]string{NOTIFICATIONS_FOLDER, name}, "/")
+	err := worker.post(queuedEvent.Endpoint, &queuedEvent.Event)
+	if err == nil {
+		return true, worker.Storage.DeleteRecord(queueRecord)
+	}
+	responded := errors.Is(err, errEndpointRefused)
+
+	queuedEvent.Attempts++
+	maxAttempts := worker.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = DEFAULT_NOTIFICATION_MAX_ATTEMPTS
+	}
+	if queuedEvent.Attempts >= maxAttempts {
+		fmt.Printf("[notification] delivery to %s failed %d times, the event is moved to the %s folder: %s\n", queuedEvent.Endpoint, queuedEvent.Attempts, FAILED_NOTIFICATIONS_FOLDER, err)
+		err := worker.Storage.PutRecord(strings.Join([]string{NOTIFICATIONS_FOLDER, FAILED_NOTIFICATIONS_FOLDER, name}, "/"), data)
+		if err != nil {
+			return responded, err
+		}
+		return responded, worker.Storage.DeleteRecord(queueRecord)
+	}
+
+	queuedEvent.NextAttempt = now.Add(notificationBackoff(queuedEvent.Attempts))
+	fmt.Printf("[notification] delivery to %s failed, next attempt at %s: %s\n", queuedEvent.Endpoint, queuedEvent.NextAttempt.Format(time.RFC3339), err)
+	data, err = json.Marshal(&queuedEvent)
+	if err != nil {
+		return responded, err
+	}
+	return responded, worker.Storage.PutRecord(queueRecord, data)
+}
+
+func (worker *NotificationWorker) post(endpoint string, event *models.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	client := worker.Client
+	if client == nil {
+		client = &http.Client{Timeout: DEFAULT_NOTIFICATION_TIMEOUT}
+	}
+
+	response, err := client.Post(endpoint, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("%w, it responded with %s", errEndpointRefused, response.Status)
+	}
+	return nil
+}
@@ -0,0 +1,394 @@
+/**
+ * Author: Vanya Usalko <ivict@rambler.ru>
+ * File: object_lock.go
+ */
+
+package services
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/usalko/s2d3/models"
+)
+
+const OBJECT_LOCK_CONFIG = "object-lock"
+
+const OBJECT_LOCK_ENABLED_HEADER = "x-amz-bucket-object-lock-enabled"
+const OBJECT_LOCK_MODE_HEADER = "x-amz-object-lock-mode"
+const OBJECT_LOCK_RETAIN_UNTIL_DATE_HEADER = "x-amz-object-lock-retain-until-date"
+const OBJECT_LOCK_LEGAL_HOLD_HEADER = "x-amz-object-lock-legal-hold"
+const BYPASS_GOVERNANCE_RETENTION_HEADER = "x-amz-bypass-governance-retention"
+
+const CodeAccessDenied = "AccessDenied"
+const CodeInvalidRequest = "InvalidRequest"
+const CodeInvalidBucketState = "InvalidBucketState"
+const CodeObjectLockConfigurationNotFoundError = "ObjectLockConfigurationNotFoundError"
+const CodeNoSuchObjectLockConfiguration = "NoSuchObjectLockConfiguration"
+
+var ErrObjectLocked = errors.New("the object is protected by object lock")
+var ErrObjectLockNotEnabled = errors.New("the bucket is missing object lock configuration")
+
+// GetObjectLockConfiguration returns nil for buckets created without object lock.
+func (storage *Storage) GetObjectLockConfiguration(bucketName string) (*models.ObjectLockConfiguration, error) {
+	data, err := storage.GetBucketConfig(bucketName, OBJECT_LOCK_CONFIG)
+	if errors.Is(err, ErrNoSuchConfiguration) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	config := models.ObjectLockConfiguration{}
+	if err := xml.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+	if config.ObjectLockEnabled != models.ObjectLockEnabled {
+		return nil, nil
+	}
+	return &config, nil
+}
+
+// CheckObjectLock returns ErrObjectLocked when the object can't be deleted or
+// overwritten: it is under the legal hold or the retention period is active.
+// The governance mode retention is skipped with bypassGovernance.
+func (storage *Storage) CheckObjectLock(bucketName string, objectKey string, bypassGovernance bool, now time.Time) error {
+	metadata, err := storage.GetMetadata(bucketName, objectKey)
+	if errors.Is(err, ErrNoSuchKey) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if metadata.LegalHold {
+		return ErrObjectLocked
+	}
+	if metadata.Retention != nil && now.Before(metadata.Retention.RetainUntilDate) {
+		if metadata.Retention.Mode == models.ObjectLockCompliance || !bypassGovernance {
+			return ErrObjectLocked
+		}
+	}
+	return nil
+}
+
+// applyDefaultRetention protects the new object with the default retention of
+// the bucket when the retention was not given explicitly.
+func (storage *Storage) applyDefaultRetention(bucketName string, metadata *models.ObjectMetadata, now time.Time) error {
+	if metadata.Retention != nil {
+		return nil
+	}
+	config, err := storage.GetObjectLockConfiguration(bucketName)
+	if errors.Is(err, ErrNoSuchBucket) {
+		// The bucket folder is created with its first object
+		return nil
+	}
+	if err != nil || config == nil || config.Rule == nil {
+		return err
+	}
+
+	retention := config.Rule.DefaultRetention
+	metadata.Retention = &models.ObjectRetention{
+		Mode:            retention.Mode,
+		RetainUntilDate: now.UTC().AddDate(retention.Years, 0, retention.Days),
+	}
+	return nil
+}
+
+// bypassGovernance checks the x-amz-bypass-governance-retention header, it is
+// honored for the access keys allowed to bypass the governance mode only. The
+// request must be signed by the secret of the managed access key, the keys
+// which are not managed by the admin api never bypass the governance mode.
+func bypassGovernance(request *http.Request) bool {
+	if !strings.EqualFold(request.Header.Get(BYPASS_GOVERNANCE_RETENTION_HEADER), "true") {
+		return false
+	}
+	accessKeys, _ := request.Context().Value(KeyGovernanceBypassAccessKeys).([]string)
+	storage := storageOf(request)
+	accessKey, err := authenticatedAccessKey(request, &storage)
+	return err == nil && accessKey != "" && slices.Contains(accessKeys, accessKey)
+}
+
+func writeObjectLockError(writer http.ResponseWriter, err error) {
+	if errors.Is(err, ErrObjectLocked) {
+		writeError(writer, http.StatusForbidden, CodeAccessDenied, err.Error())
+		return
+	}
+	if errors.Is(err, ErrObjectLockNotEnabled) {
+		writeError(writer, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	if errors.Is(err, ErrNoSuchKey) {
+		writeError(writer, http.StatusNotFound, CodeNoSuchKey, err.Error())
+		return
+	}
+	writeBucketConfigError(writer, err, CodeObjectLockConfigurationNotFoundError)
+}
+
+func writeObjectLockHeadersError(writer http.ResponseWriter, err error) {
+	if errors.Is(err, ErrObjectLockNotEnabled) {
+		writeObjectLockError(writer, err)
+		return
+	}
+	writeError(writer, http.StatusBadRequest, CodeInvalidArgument, err.Error())
+}
+
+func validateRetention(retention *models.ObjectRetention, now time.Time) error {
+	if retention.Mode != models.ObjectLockGovernance && retention.Mode != models.ObjectLockCompliance {
+		return fmt.Errorf("the retention mode must be %s or %s", models.ObjectLockGovernance, models.ObjectLockCompliance)
+	}
+	if !retention.RetainUntilDate.After(now) {
+		return fmt.Errorf("the retain until date must be in the future")
+	}
+	return nil
+}
+
+// objectLockOfHeaders reads the object lock headers of PutObject and
+// CreateMultipartUpload into the object metadata.
+func (storage *Storage) objectLockOfHeaders(request *http.Request, bucketName string, metadata *models.ObjectMetadata, now time.Time) error {
+	mode := request.Header.Get(OBJECT_LOCK_MODE_HEADER)
+	retainUntilDate := request.Header.Get(OBJECT_LOCK_RETAIN_UNTIL_DATE_HEADER)
+	legalHold := request.Header.Get(OBJECT_LOCK_LEGAL_HOLD_HEADER)
+	if mode == "" && retainUntilDate == "" && legalHold == "" {
+		return nil
+	}
+
+	config, err := storage.GetObjectLockConfiguration(bucketName)
+	if err != nil {
+		return err
+	}
+	if config == nil {
+		return ErrObjectLockNotEnabled
+	}
+
+	if mode != "" || retainUntilDate != "" {
+		date, err := time.Parse(time.RFC3339, retainUntilDate)
+		if err != nil || mode == "" {
+			return fmt.Errorf("the %s and %s headers must be given together", OBJECT_LOCK_MODE_HEADER, OBJECT_LOCK_RETAIN_UNTIL_DATE_HEADER)
+		}
+		metadata.Retention = &models.ObjectRetention{
+			Mode:            mode,
+			RetainUntilDate: date.UTC(),
+		}
+		if err := validateRetention(metadata.Retention, now); err != nil {
+			return err
+		}
+	}
+
+	if legalHold != "" {
+		if legalHold != models.LegalHoldOn && legalHold != models.LegalHoldOff {
+			return fmt.Errorf("the legal hold status must be %s or %s", models.LegalHoldOn, models.LegalHoldOff)
+		}
+		metadata.LegalHold = legalHold == models.LegalHoldOn
+	}
+	return nil
+}
+
+// ObjectLock implements PUT and GET of the bucket object lock configuration,
+// PUT changes the default retention of the bucket created with object lock.
+func ObjectLock(writer http.ResponseWriter, request *http.Request) {
+	bucketName, _ := bucketNameAndObjectKey(request.URL.Path, request.Context().Value(KeyUrlContext).(string))
+	storage := storageOf(request)
+
+	switch request.Method {
+
+	case "GET":
+		config, err := storage.GetObjectLockConfiguration(bucketName)
+		if err == nil && config == nil {
+			err = ErrNoSuchConfiguration
+		}
+		if err != nil {
+			writeObjectLockError(writer, err)
+			return
+		}
+		writeXml(writer, config)
+
+	case "PUT":
+		body, err := io.ReadAll(request.Body)
+		if err != nil {
+			writeError(writer, http.StatusBadRequest, CodeInvalidArgument, err.Error())
+			return
+		}
+		config := models.ObjectLockConfiguration{}
+		if err := xml.Unmarshal(body, &config); err != nil {
+			writeError(writer, http.StatusBadRequest, CodeMalformedXML, err.Error())
+			return
+		}
+		if config.ObjectLockEnabled != models.ObjectLockEnabled {
+			writeError(writer, http.StatusBadRequest, CodeMalformedXML, "the object lock can't be disabled")
+			return
+		}
+		if config.Rule != nil {
+			retention := config.Rule.DefaultRetention
+			if retention.Mode != models.ObjectLockGovernance && retention.Mode != models.ObjectLockCompliance {
+				writeError(writer, http.StatusBadRequest, CodeMalformedXML, "the default retention mode must be GOVERNANCE or COMPLIANCE")
+				return
+			}
+			if (retention.Days > 0) == (retention.Years > 0) || retention.Days < 0 || retention.Years < 0 {
+				writeError(writer, http.StatusBadRequest, CodeMalformedXML, "the default retention must specify either days or years")
+				return
+			}
+		}
+
+		// The object lock is enabled by the creation of the bucket only, the
+		// configuration of the existing bucket can't turn it on
+		current, err := storage.GetObjectLockConfiguration(bucketName)
+		if err != nil {
+			writeObjectLockError(writer, err)
+			return
+		}
+		if current == nil {
+			writeError(writer, http.StatusConflict, CodeInvalidBucketState, "the object lock can't be enabled on the existing bucket")
+			return
+		}
+
+		data, err := xml.Marshal(&config)
+		if err == nil {
+			err = storage.PutBucketConfig(bucketName, OBJECT_LOCK_CONFIG, data)
+		}
+		if err != nil {
+			writeObjectLockError(writer, err)
+			return
+		}
+
+	}
+}
+
+// Retention implements PUT and GET of the object retention.
+func Retention(writer http.ResponseWriter, request *http.Request) {
+	bucketName, objectKey := bucketNameAndObjectKey(request.URL.Path, request.Context().Value(KeyUrlContext).(string))
+	storage := storageOf(request)
+
+	config, err := storage.GetObjectLockConfiguration(bucketName)
+	if err == nil && config == nil {
+		err = ErrObjectLockNotEnabled
+	}
+	if err != nil {
+		writeObjectLockError(writer, err)
+		return
+	}
+	metadata, err := storage.GetMetadata(bucketName, objectKey)
+	if err != nil {
+		writeObjectLockError(writer, err)
+		return
+	}
+
+	switch request.Method {
+
+	case "GET":
+		if metadata.Retention == nil {
+			writeError(writer, http.StatusNotFound, CodeNoSuchObjectLockConfiguration, "the specified object does not have a retention configuration")
+			return
+		}
+		writeXml(writer, metadata.Retention)
+
+	case "PUT":
+		body, err := io.ReadAll(request.Body)
+		if err != nil {
+			writeError(writer, http.StatusBadRequest, CodeInvalidArgument, err.Error())
+			return
+		}
+		retention := &models.ObjectRetention{}
+		if err := xml.Unmarshal(body, retention); err != nil {
+			writeError(writer, http.StatusBadRequest, CodeMalformedXML, err.Error())
+			return
+		}
+
+		now := time.Now()
+		if retention.Mode == "" && retention.RetainUntilDate.IsZero() {
+			retention = nil
+		} else if err := validateRetention(retention, now); err != nil {
+			writeError(writer, http.StatusBadRequest, CodeInvalidArgument, err.Error())
+			return
+		}
+
+		// The active retention can only be made stricter, the governance mode
+		// retention may be relaxed by the users allowed to bypass it
+		current := metadata.Retention
+		if current != nil && now.Before(current.RetainUntilDate) {
+			stricter := retention != nil && !retention.RetainUntilDate.Before(current.RetainUntilDate) &&
+				(retention.Mode == models.ObjectLockCompliance || current.Mode == models.ObjectLockGovernance)
+			if !stricter && (current.Mode == models.ObjectLockCompliance || !bypassGovernance(request)) {
+				writeObjectLockError(writer, ErrObjectLocked)
+				return
+			}
+		}
+
+		metadata.Retention = retention
+		if err := storage.PutMetadata(bucketName, objectKey, metadata); err != nil {
+			writeObjectLockError(writer, err)
+			return
+		}
+
+	}
+}
+
+// LegalHold implements PUT and GET of the object legal hold.
+func LegalHold(writer http.ResponseWriter, request *http.Request) {
+	bucketName, objectKey := bucketNameAndObjectKey(request.URL.Path, request.Context().Value(KeyUrlContext).(string))
+	storage := storageOf(request)
+
+	config, err := storage.GetObjectLockConfiguration(bucketName)
+	if err == nil && config == nil {
+		err = ErrObjectLockNotEnabled
+	}
+	if err != nil {
+		writeObjectLockError(writer, err)
+		return
+	}
+	metadata, err := storage.GetMetadata(bucketName, objectKey)
+	if err != nil {
+		writeObjectLockError(writer, err)
+		return
+	}
+
+	switch request.Method {
+
+	case "GET":
+		legalHold := &models.LegalHold{Status: models.LegalHoldOff}
+		if metadata.LegalHold {
+			legalHold.Status = models.LegalHoldOn
+		}
+		writeXml(writer, legalHold)
+
+	case "PUT":
+		body, err := io.ReadAll(request.Body)
+		if err != nil {
+			writeError(writer, http.StatusBadRequest, CodeInvalidArgument, err.Error())
+			return
+		}
+		legalHold := models.LegalHold{}
+		if err := xml.Unmarshal(body, &legalHold); err != nil {
+			writeError(writer, http.StatusBadRequest, CodeMalformedXML, err.Error())
+			return
+		}
+		if legalHold.Status != models.LegalHoldOn && legalHold.Status != models.LegalHoldOff {
+			writeError(writer, http.StatusBadRequest, CodeMalformedXML, "the legal hold status must be ON or OFF")
+			return
+		}
+
+		metadata.LegalHold = legalHold.Status == models.LegalHoldOn
+		if err := storage.PutMetadata(bucketName, objectKey, metadata); err != nil {
+			writeObjectLockError(writer, err)
+			return
+		}
+
+	}
+}
+
+// setObjectLockHeaders reports the object lock state on GetObject.
+func setObjectLockHeaders(writer http.ResponseWriter, metadata *models.ObjectMetadata) {
+	if metadata.Retention != nil {
+		writer.Header().Set(OBJECT_LOCK_MODE_HEADER, metadata.Retention.Mode)
+		writer.Header().Set(OBJECT_LOCK_RETAIN_UNTIL_DATE_HEADER, metadata.Retention.RetainUntilDate.Format(time.RFC3339))
+	}
+	if metadata.LegalHold {
+		writer.Header().Set(OBJECT_LOCK_LEGAL_HOLD_HEADER, models.LegalHoldOn)
+	}
+}
@@ -0,0 +1,164 @@
+/**
+ * Author: Vanya Usalko <ivict@rambler.ru>
+ * File: operation.go
+ */
+
+package services
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// CONFIGURATION_OPERATIONS names the bucket and object configurations by
+// their subresources, the operation is the name prefixed by the method (e.g.
+// GetBucketCors).
+var CONFIGURATION_OPERATIONS = map[string]string{
+	"cors":         "BucketCors",
+	"notification": "BucketNotificationConfiguration",
+	"encryption":   "BucketEncryption",
+	"compression":  "BucketCompression",
+	"pack":         "BucketPack",
+	"dedup":        "BucketDedup",
+	"policy":       "BucketPolicy",
+	"object-lock":  "ObjectLockConfiguration",
+	"retention":    "ObjectRetention",
+	"legal-hold":   "ObjectLegalHold",
+	"lifecycle":    "BucketLifecycleConfiguration",
+	"logging":      "BucketLogging",
+}
+
+var METHOD_PREFIXES = map[string]string{
+	"GET":    "Get",
+	"PUT":    "Put",
+	"DELETE": "Delete",
+}
+
+// operationOf names the S3 operation of the request as the S3 API does (e.g.
+// GetObject, UploadPart), the requests which are not routed are Unknown.
+func operationOf(request *http.Request) string {
+	parsedQuery, err := url.ParseQuery(request.URL.RawQuery)
+	if err != nil {
+		return "Unknown"
+	}
+	urlContext, _ := request.Context().Value(KeyUrlContext).(string)
+	bucketName, objectKey := bucketNameAndObjectKey(request.URL.Path, urlContext)
+	has := func(parameter string) bool {
+		_, exists := parsedQuery[parameter]
+		return exists
+	}
+
+	if request.Method == "OPTIONS" {
+		return "PreflightRequest"
+	}
+	if request.Method == "GET" {
+		switch {
+		case has("dedup-stats"):
+			return "GetDedupStatistics"
+		case has("disk-status"):
+			return "GetDiskStatus"
+		case bucketName == "":
+			return "ListBuckets"
+		}
+	}
+	if prefix, exists := METHOD_PREFIXES[request.Method]; exists {
+		for subresource, name := range CONFIGURATION_OPERATIONS {
+			if has(subresource) {
+				return prefix + name
+			}
+		}
+		if has("tagging") {
+			if objectKey == "" {
+				return prefix + "BucketTagging"
+			}
+			return prefix + "ObjectTagging"
+		}
+	}
+
+	switch request.Method {
+	case "GET":
+		switch {
+		case has("versions"):
+			return "ListObjectVersions"
+		case has("list-type"):
+			return "ListObjectsV2"
+		case has("uploads"):
+			return "ListMultipartUploads"
+		case has("uploadId"):
+			return "ListParts"
+		case objectKey == "":
+			return "ListObjects"
+		}
+		return "GetObject"
+	case "HEAD":
+		if objectKey == "" {
+			return "HeadBucket"
+		}
+		return "HeadObject"
+	case "POST":
+		switch {
+		case has("uploads"):
+			return "CreateMultipartUpload"
+		case has("uploadId"):
+			return "CompleteMultipartUpload"
+		}
+	case "PUT":
+		switch {
+		case has("uploadId"):
+			return "UploadPart"
+		case objectKey == "":
+			return "CreateBucket"
+		case request.Header.Get("x-amz-copy-source") != "":
+			return "CopyObject"
+		}
+		return "PutObject"
+	case "DELETE":
+		switch {
+		case has("uploadId"):
+			return "AbortMultipartUpload"
+		case objectKey == "":
+			return "DeleteBucket"
+		}
+		return "DeleteObject"
+	}
+	return "Unknown"
+}
+
+// restOperationOf names the operation of the request as the S3 server access
+// log does (e.g. REST.GET.OBJECT, REST.PUT.PART).
+func restOperationOf(request *http.Request) string {
+	parsedQuery, _ := url.ParseQuery(request.URL.RawQuery)
+	urlContext, _ := request.Context().Value(KeyUrlContext).(string)
+	bucketName, objectKey := bucketNameAndObjectKey(request.URL.Path, urlContext)
+	has := func(parameter string) bool {
+		_, exists := parsedQuery[parameter]
+		return exists
+	}
+
+	resource := "OBJECT"
+	switch {
+	case has("uploadId") && request.Method == "PUT":
+		resource = "PART"
+	case has("uploadId"):
+		resource = "UPLOAD"
+	case has("uploads"):
+		resource = "UPLOADS"
+	case has("versions"):
+		resource = "BUCKETVERSIONS"
+	case has("logging"):
+		resource = "LOGGING_STATUS"
+	case bucketName == "":
+		resource = "SERVICE"
+	default:
+		for subresource := range parsedQuery {
+			if _, exists := CONFIGURATION_OPERATIONS[subresource]; exists || subresource == "tagging" {
+				resource = strings.ToUpper(strings.ReplaceAll(subresource, "-", "_"))
+			}
+		}
+		if resource == "OBJECT" && objectKey == "" {
+			resource = "BUCKET"
+		}
+	}
+	return "REST." + request.Method + "." + resource
+}
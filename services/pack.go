@@ -0,0 +1,121 @@
+/**
+ * Author: Vanya Usalko <ivict@rambler.ru>
+ * File: pack.go
+ */
+
+package services
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/usalko/s2d3/models"
+)
+
+const PACK_CONFIG = "pack"
+
+const CodeNoSuchPackConfiguration = "NoSuchPackConfiguration"
+
+// DEFAULT_PACK_MAX_OBJECT_SIZE is the threshold of the pack configuration
+// without MaxObjectSize.
+var DEFAULT_PACK_MAX_OBJECT_SIZE = int64(BREAKPOINTS[2])
+
+// PackCompactor is implemented by the backends keeping the objects in packs.
+type PackCompactor interface {
+	// CompactPacks rewrites the packs of the bucket when the part of the
+	// deleted and the overwritten objects reaches minGarbageRatio, it returns
+	// the count of the reclaimed bytes.
+	CompactPacks(bucketName string, minGarbageRatio float64) (int64, error)
+}
+
+// GetPackConfiguration returns nil for buckets without the pack storage.
+func (storage *Storage) GetPackConfiguration(bucketName string) (*models.PackConfiguration, error) {
+	return packConfiguration(storage.Backend, bucketName)
+}
+
+func packConfiguration(backend Backend, bucketName string) (*models.PackConfiguration, error) {
+	data, err := backend.GetBucketConfig(bucketName, PACK_CONFIG)
+	if errors.Is(err, ErrNoSuchConfiguration) || errors.Is(err, ErrNoSuchBucket) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	config := models.PackConfiguration{}
+	if err := xml.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+	if config.MaxObjectSize == 0 {
+		config.MaxObjectSize = DEFAULT_PACK_MAX_OBJECT_SIZE
+	}
+	return &config, nil
+}
+
+// validatePack accepts the breakpoints up to the segment size as the
+// threshold, the larger objects are never packed.
+func validatePack(config *models.PackConfiguration) error {
+	if config.MaxObjectSize == 0 {
+		return nil
+	}
+	for _, breakpoint := range BREAKPOINTS[:3] {
+		if config.MaxObjectSize == int64(breakpoint) {
+			return nil
+		}
+	}
+	return fmt.Errorf("the max object size %d must be one of %v", config.MaxObjectSize, BREAKPOINTS[:3])
+}
+
+// Pack implements PUT, GET and DELETE of the bucket pack storage, the objects
+// stored before the change are kept where they are until they are rewritten.
+func Pack(writer http.ResponseWriter, request *http.Request) {
+	bucketName, _ := bucketNameAndObjectKey(request.URL.Path, request.Context().Value(KeyUrlContext).(string))
+	storage := storageOf(request)
+
+	switch request.Method {
+
+	case "GET":
+		data, err := storage.GetBucketConfig(bucketName, PACK_CONFIG)
+		if err != nil {
+			writeBucketConfigError(writer, err, CodeNoSuchPackConfiguration)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/xml")
+		writer.Write(data)
+
+	case "PUT":
+		body, err := io.ReadAll(request.Body)
+		if err != nil {
+			writeError(writer, http.StatusBadRequest, CodeInvalidArgument, err.Error())
+			return
+		}
+		config := models.PackConfiguration{}
+		if err := xml.Unmarshal(body, &config); err != nil {
+			writeError(writer, http.StatusBadRequest, CodeMalformedXML, err.Error())
+			return
+		}
+		if err := validatePack(&config); err != nil {
+			writeError(writer, http.StatusBadRequest, CodeInvalidArgument, err.Error())
+			return
+		}
+		data, err := xml.Marshal(&config)
+		if err == nil {
+			err = storage.PutBucketConfig(bucketName, PACK_CONFIG, data)
+		}
+		if err != nil {
+			writeBucketConfigError(writer, err, CodeNoSuchPackConfiguration)
+			return
+		}
+
+	case "DELETE":
+		if err := storage.DeleteBucketConfig(bucketName, PACK_CONFIG); err != nil {
+			writeBucketConfigError(writer, err, CodeNoSuchPackConfiguration)
+			return
+		}
+		writer.WriteHeader(http.StatusNoContent)
+
+	}
+}
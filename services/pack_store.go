@@ -0,0 +1,455 @@
+/**
+ * Author: Vanya Usalko <ivict@rambler.ru>
+ * File: pack_store.go
+ */
+
+package services
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The pack is the log of records, every record is the header followed by the
+// object key and the object data:
+//
+//	magic(4) flags(1) key size(2) data size(4) modification time(8) crc32(4)
+//
+// The crc32 covers the header before it, the key and the data. The record
+// with the tombstone flag removes the object. The last record of the pack is
+// torn when the process crashed during the append, the packs are truncated
+// to the last complete record when they are scanned.
+const PACKS_FOLDER = "packs"
+const PACK_MAX_SIZE = 64 * 1024 * 1024
+const PACK_RECORD_MAGIC = 0x53327043
+const PACK_RECORD_HEADER_SIZE = 23
+const PACK_RECORD_TOMBSTONE = 1
+
+type packEntry struct {
+	pack    int
+	offset  int64
+	size    int64
+	record  int64
+	etag    string
+	modTime time.Time
+}
+
+// packStore keeps the index of the packed objects of one bucket, the index
+// is built by scanning the packs when the store is opened.
+type packStore struct {
+	lock      sync.Mutex
+	folder    string
+	index     map[string]packEntry
+	packs     []int
+	sizes     map[int]int64
+	active    *os.File
+	activeNum int
+}
+
+var packStores = map[string]*packStore{}
+var packStoresLock sync.Mutex
+
+func packFileName(number int) string {
+	return fmt.Sprintf("%08d.pack", number)
+}
+
+// openPackStore returns the store of the packs folder, it returns nil for the
+// folder without packs unless create is set.
+func openPackStore(folder string, create bool) (*packStore, error) {
+	folder, err := filepath.Abs(folder)
+	if err != nil {
+		return nil, err
+	}
+
+	packStoresLock.Lock()
+	defer packStoresLock.Unlock()
+
+	if store, exists := packStores[folder]; exists {
+		return store, nil
+	}
+	if _, err := os.Stat(folder); errors.Is(err, fs.ErrNotExist) && !create {
+		return nil, nil
+	}
+	if err := os.MkdirAll(folder, fs.ModeDir|0775); err != nil {
+		return nil, err
+	}
+
+	store := &packStore{
+		folder: folder,
+		index:  map[string]packEntry{},
+		sizes:  map[int]int64{},
+	}
+	if err := store.load(); err != nil {
+		return nil, err
+	}
+	packStores[folder] = store
+	return store, nil
+}
+
+// ClosePackStores drops the indexes of the packs, the packs are scanned again
+// when they are accessed next time (e.g. after they were restored from the
+// backup).
+func ClosePackStores() {
+	packStoresLock.Lock()
+	defer packStoresLock.Unlock()
+
+	for folder, store := range packStores {
+		store.lock.Lock()
+		if store.active != nil {
+			store.active.Close()
+		}
+		store.lock.Unlock()
+		delete(packStores, folder)
+	}
+}
+
+func (store *packStore) load() error {
+	entries, err := os.ReadDir(store.folder)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		// The leftovers of the interrupted compaction
+		if strings.HasSuffix(entry.Name(), ".tmp") {
+			os.Remove(filepath.Join(store.folder, entry.Name()))
+			continue
+		}
+		number := 0
+		if _, err := fmt.Sscanf(entry.Name(), "%08d.pack", &number); err == nil {
+			store.packs = append(store.packs, number)
+		}
+	}
+	sort.Ints(store.packs)
+
+	for _, number := range store.packs {
+		if err := store.scan(number); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scan applies the records of the pack to the index, the torn record at the
+// end of the pack is truncated.
+func (store *packStore) scan(number int) error {
+	path := filepath.Join(store.folder, packFileName(number))
+	file, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	offset := int64(0)
+	header := make([]byte, PACK_RECORD_HEADER_SIZE)
+	for {
+		_, err := io.ReadFull(file, header)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		key, data, modTime, flags, err := readPackRecord(file, header, err)
+		if err != nil {
+			fmt.Printf("[pack] the record of %s at %d is torn, the pack is truncated: %s\n", path, offset, err)
+			if err := file.Truncate(offset); err != nil {
+				return err
+			}
+			break
+		}
+
+		record := int64(PACK_RECORD_HEADER_SIZE + len(key) + len(data))
+		if flags&PACK_RECORD_TOMBSTONE != 0 {
+			delete(store.index, key)
+		} else {
+			hash := md5.Sum(data)
+			store.index[key] = packEntry{
+				pack:    number,
+				offset:  offset + PACK_RECORD_HEADER_SIZE + int64(len(key)),
+				size:    int64(len(data)),
+				record:  record,
+				etag:    hex.EncodeToString(hash[:]),
+				modTime: modTime,
+			}
+		}
+		offset += record
+	}
+	store.sizes[number] = offset
+	return nil
+}
+
+func readPackRecord(reader io.Reader, header []byte, headerErr error) (string, []byte, time.Time, byte, error) {
+	if headerErr != nil {
+		return "", nil, time.Time{}, 0, headerErr
+	}
+	if binary.BigEndian.Uint32(header) != PACK_RECORD_MAGIC {
+		return "", nil, time.Time{}, 0, fmt.Errorf("wrong magic number")
+	}
+	flags := header[4]
+	keySize := binary.BigEndian.Uint16(header[5:])
+	dataSize := binary.BigEndian.Uint32(header[7:])
+	modTime := time.Unix(0, int64(binary.BigEndian.Uint64(header[11:]))).UTC()
+	if dataSize > PACK_MAX_SIZE {
+		return "", nil, time.Time{}, 0, fmt.Errorf("wrong data size %d", dataSize)
+	}
+
+	body := make([]byte, int(keySize)+int(dataSize))
+	if _, err := io.ReadFull(reader, body); err != nil {
+		return "", nil, time.Time{}, 0, err
+	}
+	checksum := crc32.NewIEEE()
+	checksum.Write(header[:PACK_RECORD_HEADER_SIZE-4])
+	checksum.Write(body)
+	if checksum.Sum32() != binary.BigEndian.Uint32(header[PACK_RECORD_HEADER_SIZE-4:]) {
+		return "", nil, time.Time{}, 0, fmt.Errorf("wrong checksum")
+	}
+	return string(body[:keySize]), body[keySize:], modTime, flags, nil
+}
+
+func packRecord(key string, data []byte, flags byte, modTime time.Time) []byte {
+	record := make([]byte, PACK_RECORD_HEADER_SIZE, PACK_RECORD_HEADER_SIZE+len(key)+len(data))
+	binary.BigEndian.PutUint32(record, PACK_RECORD_MAGIC)
+	record[4] = flags
+	binary.BigEndian.PutUint16(record[5:], uint16(len(key)))
+	binary.BigEndian.PutUint32(record[7:], uint32(len(data)))
+	binary.BigEndian.PutUint64(record[11:], uint64(modTime.UnixNano()))
+	record = append(record, key...)
+	record = append(record, data...)
+
+	checksum := crc32.NewIEEE()
+	checksum.Write(record[:PACK_RECORD_HEADER_SIZE-4])
+	checksum.Write(record[PACK_RECORD_HEADER_SIZE:])
+	binary.BigEndian.PutUint32(record[PACK_RECORD_HEADER_SIZE-4:], checksum.Sum32())
+	return record
+}
+
+// appendRecord appends the record to the active pack, the new pack is
+// started when the active one is full.
+func (store *packStore) appendRecord(key string, data []byte, flags byte, modTime time.Time) (packEntry, error) {
+	if len(key) > 0xFFFF {
+		return packEntry{}, fmt.Errorf("the key of %d bytes is too long for the pack", len(key))
+	}
+	number := store.activeNum
+	if store.active == nil || store.sizes[number] >= PACK_MAX_SIZE {
+		if store.active != nil {
+			store.active.Close()
+		}
+		if len(store.packs) == 0 || store.sizes[store.packs[len(store.packs)-1]] >= PACK_MAX_SIZE {
+			number = 1
+			if len(store.packs) > 0 {
+				number = store.packs[len(store.packs)-1] + 1
+			}
+			store.packs = append(store.packs, number)
+		} else {
+			number = store.packs[len(store.packs)-1]
+		}
+		file, err := os.OpenFile(filepath.Join(store.folder, packFileName(number)), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err == nil {
+			// The torn record of the failed append is dropped
+			err = file.Truncate(store.sizes[number])
+		}
+		if err != nil {
+			store.active = nil
+			return packEntry{}, err
+		}
+		store.active = file
+		store.activeNum = number
+	}
+
+	record := packRecord(key, data, flags, modTime)
+	offset := store.sizes[number]
+	if _, err := store.active.Write(record); err != nil {
+		store.active.Close()
+		store.active = nil
+		return packEntry{}, err
+	}
+	store.sizes[number] = offset + int64(len(record))
+
+	hash := md5.Sum(data)
+	return packEntry{
+		pack:    number,
+		offset:  offset + PACK_RECORD_HEADER_SIZE + int64(len(key)),
+		size:    int64(len(data)),
+		record:  int64(len(record)),
+		etag:    hex.EncodeToString(hash[:]),
+		modTime: modTime,
+	}, nil
+}
+
+func (store *packStore) Put(key string, data []byte, modTime time.Time) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	entry, err := store.appendRecord(key, data, 0, modTime)
+	if err != nil {
+		return err
+	}
+	store.index[key] = entry
+	return nil
+}
+
+// Delete appends the tombstone of the packed object, it returns false when
+// the object is not packed.
+func (store *packStore) Delete(key string) (bool, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	if _, exists := store.index[key]; !exists {
+		return false, nil
+	}
+	if _, err := store.appendRecord(key, nil, PACK_RECORD_TOMBSTONE, time.Now()); err != nil {
+		return false, err
+	}
+	delete(store.index, key)
+	return true, nil
+}
+
+func (store *packStore) Entry(key string) (packEntry, bool) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	entry, exists := store.index[key]
+	return entry, exists
+}
+
+// Entries returns the copy of the index.
+func (store *packStore) Entries() map[string]packEntry {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	entries := make(map[string]packEntry, len(store.index))
+	for key, entry := range store.index {
+		entries[key] = entry
+	}
+	return entries
+}
+
+func (store *packStore) read(entry packEntry) ([]byte, error) {
+	file, err := os.Open(filepath.Join(store.folder, packFileName(entry.pack)))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	data := make([]byte, entry.size)
+	if _, err := file.ReadAt(data, entry.offset); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// Read returns the data of the packed object, the pack may be replaced by
+// the compaction until the lock is taken so the entry is looked up again.
+func (store *packStore) Read(key string) ([]byte, packEntry, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	entry, exists := store.index[key]
+	if !exists {
+		return nil, packEntry{}, ErrNoSuchKey
+	}
+	data, err := store.read(entry)
+	return data, entry, err
+}
+
+// GarbageRatio is the part of the packs taken by the deleted and the
+// overwritten objects.
+func (store *packStore) GarbageRatio() float64 {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	total, live := store.usage()
+	if total == 0 {
+		return 0
+	}
+	return float64(total-live) / float64(total)
+}
+
+func (store *packStore) usage() (int64, int64) {
+	total, live := int64(0), int64(0)
+	for _, size := range store.sizes {
+		total += size
+	}
+	for _, entry := range store.index {
+		live += entry.record
+	}
+	return total, live
+}
+
+// Compact rewrites the live objects into the new pack and removes the old
+// packs, it returns the count of the reclaimed bytes. The new pack is renamed
+// in place only when it is complete, the old packs left by the crash before
+// they were removed hold the same objects and are compacted again later.
+func (store *packStore) Compact() (int64, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	if len(store.packs) == 0 {
+		return 0, nil
+	}
+	total, _ := store.usage()
+	number := store.packs[len(store.packs)-1] + 1
+	path := filepath.Join(store.folder, packFileName(number))
+	file, err := os.OpenFile(path+".tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return 0, err
+	}
+
+	keys := make([]string, 0, len(store.index))
+	for key := range store.index {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	index := make(map[string]packEntry, len(keys))
+	offset := int64(0)
+	for _, key := range keys {
+		entry := store.index[key]
+		data, err := store.read(entry)
+		if err == nil {
+			_, err = file.Write(packRecord(key, data, 0, entry.modTime))
+		}
+		if err != nil {
+			file.Close()
+			os.Remove(path + ".tmp")
+			return 0, err
+		}
+		entry.pack = number
+		entry.offset = offset + PACK_RECORD_HEADER_SIZE + int64(len(key))
+		index[key] = entry
+		offset += entry.record
+	}
+	err = file.Sync()
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(path+".tmp", path)
+	}
+	if err != nil {
+		os.Remove(path + ".tmp")
+		return 0, err
+	}
+
+	if store.active != nil {
+		store.active.Close()
+		store.active = nil
+	}
+	var result error
+	for _, old := range store.packs {
+		result = errors.Join(result, os.Remove(filepath.Join(store.folder, packFileName(old))))
+	}
+	store.packs = []int{number}
+	store.sizes = map[int]int64{number: offset}
+	store.index = index
+	return total - offset, result
+}
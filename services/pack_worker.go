@@ -0,0 +1,79 @@
+/**
+ * Author: Vanya Usalko <ivict@rambler.ru>
+ * File: pack_worker.go
+ */
+
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const DEFAULT_PACK_COMPACTION_INTERVAL = 10 * time.Minute
+const DEFAULT_PACK_GARBAGE_RATIO = 0.5
+
+// PackWorker compacts the packs of all buckets periodically, the packs are
+// rewritten when the deleted and the overwritten objects take GarbageRatio of
+// them.
+type PackWorker struct {
+	Storage      Storage
+	Interval     time.Duration
+	GarbageRatio float64
+}
+
+func (worker *PackWorker) Run(ctx context.Context) {
+	interval := worker.Interval
+	if interval <= 0 {
+		interval = DEFAULT_PACK_COMPACTION_INTERVAL
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	health := startWorkerHealth("pack-compaction", interval)
+	defer health.stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		err := worker.Compact()
+		if err != nil {
+			fmt.Printf("[pack] %s\n", err)
+		}
+		health.ran(err)
+	}
+}
+
+// Compact compacts the packs of every bucket once, the backends without the
+// packs are skipped.
+func (worker *PackWorker) Compact() error {
+	compactor, exists := CapabilityOf[PackCompactor](worker.Storage.Backend)
+	if !exists {
+		return nil
+	}
+	garbageRatio := worker.GarbageRatio
+	if garbageRatio <= 0 {
+		garbageRatio = DEFAULT_PACK_GARBAGE_RATIO
+	}
+	buckets, err := worker.Storage.ListBuckets()
+	if err != nil {
+		return err
+	}
+
+	var result error
+	for _, bucket := range buckets {
+		reclaimed, err := compactor.CompactPacks(bucket.Name, garbageRatio)
+		if err != nil {
+			result = errors.Join(result, fmt.Errorf("bucket %s: %w", bucket.Name, err))
+			continue
+		}
+		if reclaimed > 0 {
+			fmt.Printf("[pack] bucket %s is compacted, %d bytes reclaimed\n", bucket.Name, reclaimed)
+		}
+	}
+	return result
+}
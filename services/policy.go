@@ -0,0 +1,271 @@
+/**
+ * Author: Vanya Usalko <ivict@rambler.ru>
+ * File: policy.go
+ */
+
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/usalko/s2d3/models"
+)
+
+const POLICY_CONFIG = "policy"
+
+const CodeNoSuchBucketPolicy = "NoSuchBucketPolicy"
+const CodeMalformedPolicy = "MalformedPolicy"
+
+// POLICY_RESOURCE_PREFIX prefixes the bucket and the object resources of the
+// policy statements (arn:aws:s3:::bucket/key).
+const POLICY_RESOURCE_PREFIX = "arn:aws:s3:::"
+
+var ErrInvalidPolicy = errors.New("the bucket policy is invalid")
+var ErrPolicyDenied = errors.New("the access is denied by the bucket policy")
+
+// POLICY_ACTIONS names the policy actions of the operations which are not
+// authorized by the actions of their own names (e.g. UploadPart by
+// s3:PutObject).
+var POLICY_ACTIONS = map[string]string{
+	"HeadObject":                 "GetObject",
+	"HeadBucket":                 "ListBucket",
+	"ListObjects":                "ListBucket",
+	"ListObjectsV2":              "ListBucket",
+	"ListObjectVersions":         "ListBucketVersions",
+	"ListMultipartUploads":       "ListBucketMultipartUploads",
+	"ListParts":                  "ListMultipartUploadParts",
+	"CreateMultipartUpload":      "PutObject",
+	"UploadPart":                 "PutObject",
+	"CompleteMultipartUpload":    "PutObject",
+	"CopyObject":                 "PutObject",
+	"GetObjectLockConfiguration": "GetBucketObjectLockConfiguration",
+	"PutObjectLockConfiguration": "PutBucketObjectLockConfiguration",
+}
+
+// policyActionOf returns the policy action of the S3 operation.
+func policyActionOf(operation string) string {
+	if action, exists := POLICY_ACTIONS[operation]; exists {
+		return "s3:" + action
+	}
+	return "s3:" + operation
+}
+
+func policyResourceOf(bucketName string, objectKey string) string {
+	if objectKey == "" {
+		return POLICY_RESOURCE_PREFIX + bucketName
+	}
+	return POLICY_RESOURCE_PREFIX + bucketName + "/" + objectKey
+}
+
+// policyMatches checks the value against the pattern, "*" matches any
+// characters and "?" matches one character.
+func policyMatches(pattern string, value string) bool {
+	for pattern != "" {
+		switch pattern[0] {
+		case '*':
+			for i := len(value); i >= 0; i-- {
+				if policyMatches(pattern[1:], value[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if value == "" {
+				return false
+			}
+		default:
+			if value == "" || value[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern, value = pattern[1:], value[1:]
+	}
+	return value == ""
+}
+
+func validatePolicy(bucketName string, policy *models.BucketPolicy) error {
+	if len(policy.Statement) == 0 {
+		return fmt.Errorf("%w: the policy must have statements", ErrInvalidPolicy)
+	}
+	for _, statement := range policy.Statement {
+		if statement.Effect != models.PolicyEffectAllow && statement.Effect != models.PolicyEffectDeny {
+			return fmt.Errorf("%w: invalid effect %q", ErrInvalidPolicy, statement.Effect)
+		}
+		if len(statement.Principal.AWS) == 0 {
+			return fmt.Errorf("%w: the statement must have the principal", ErrInvalidPolicy)
+		}
+		if len(statement.Action) == 0 {
+			return fmt.Errorf("%w: the statement must have the actions", ErrInvalidPolicy)
+		}
+		for _, action := range statement.Action {
+			if action != "*" && !strings.HasPrefix(strings.ToLower(action), "s3:") {
+				return fmt.Errorf("%w: invalid action %q", ErrInvalidPolicy, action)
+			}
+		}
+		if len(statement.Resource) == 0 {
+			return fmt.Errorf("%w: the statement must have the resources", ErrInvalidPolicy)
+		}
+		for _, resource := range statement.Resource {
+			bucketPattern, _, _ := strings.Cut(strings.TrimPrefix(resource, POLICY_RESOURCE_PREFIX), "/")
+			if !strings.HasPrefix(resource, POLICY_RESOURCE_PREFIX) || !policyMatches(bucketPattern, bucketName) {
+				return fmt.Errorf("%w: the resource %q is not in the bucket %s", ErrInvalidPolicy, resource, bucketName)
+			}
+		}
+	}
+	return nil
+}
+
+// policyAllows checks the statements of the policy: the request is allowed
+// by the matching Allow statement unless a matching Deny statement refuses
+// it. The anonymous requests are matched by the "*" principal only.
+func policyAllows(policy *models.BucketPolicy, accessKeyId string, action string, resource string) bool {
+	allowed := false
+	for _, statement := range policy.Statement {
+		principalMatches := false
+		for _, principal := range statement.Principal.AWS {
+			if principal == "*" || (accessKeyId != "" && policyMatches(principal, accessKeyId)) {
+				principalMatches = true
+			}
+		}
+		actionMatches := false
+		for _, pattern := range statement.Action {
+			if policyMatches(strings.ToLower(pattern), strings.ToLower(action)) {
+				actionMatches = true
+			}
+		}
+		resourceMatches := false
+		for _, pattern := range statement.Resource {
+			if policyMatches(pattern, resource) {
+				resourceMatches = true
+			}
+		}
+		if !principalMatches || !actionMatches || !resourceMatches {
+			continue
+		}
+		if statement.Effect == models.PolicyEffectDeny {
+			return false
+		}
+		allowed = true
+	}
+	return allowed
+}
+
+// GetBucketPolicy returns nil for buckets without the policy.
+func (storage *Storage) GetBucketPolicy(bucketName string) (*models.BucketPolicy, error) {
+	data, err := storage.GetBucketConfig(bucketName, POLICY_CONFIG)
+	if errors.Is(err, ErrNoSuchConfiguration) || errors.Is(err, ErrNoSuchBucket) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	policy := models.BucketPolicy{}
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+func (storage *Storage) PutBucketPolicy(bucketName string, policy *models.BucketPolicy) error {
+	if err := validatePolicy(bucketName, policy); err != nil {
+		return err
+	}
+	data, err := json.Marshal(policy)
+	if err != nil {
+		return err
+	}
+	return storage.PutBucketConfig(bucketName, POLICY_CONFIG, data)
+}
+
+func (storage *Storage) DeleteBucketPolicy(bucketName string) error {
+	return storage.DeleteBucketConfig(bucketName, POLICY_CONFIG)
+}
+
+// checkPolicy refuses the action by the policy of the bucket, the buckets
+// without the policy allow all actions.
+func (storage *Storage) checkPolicy(bucketName string, objectKey string, accessKeyId string, action string) error {
+	if bucketName == "" {
+		return nil
+	}
+	policy, err := storage.GetBucketPolicy(bucketName)
+	if err != nil || policy == nil {
+		return err
+	}
+	if !policyAllows(policy, accessKeyId, action, policyResourceOf(bucketName, objectKey)) {
+		return ErrPolicyDenied
+	}
+	return nil
+}
+
+// checkAnonymousPolicy refuses the anonymous action unless the policy of the
+// bucket allows it to the "*" principal, the buckets without the policy
+// refuse it.
+func (storage *Storage) checkAnonymousPolicy(bucketName string, objectKey string, action string) error {
+	if bucketName == "" {
+		return ErrPolicyDenied
+	}
+	policy, err := storage.GetBucketPolicy(bucketName)
+	if err != nil {
+		return err
+	}
+	if policy == nil || !policyAllows(policy, "", action, policyResourceOf(bucketName, objectKey)) {
+		return ErrPolicyDenied
+	}
+	return nil
+}
+
+// Policy implements PUT, GET and DELETE of the bucket policy. The requests of
+// the admin api are not limited by the policies, so the policy which refuses
+// its own changes is changed by the admin api.
+func Policy(writer http.ResponseWriter, request *http.Request) {
+	bucketName, _ := bucketNameAndObjectKey(request.URL.Path, request.Context().Value(KeyUrlContext).(string))
+	storage := storageOf(request)
+
+	switch request.Method {
+
+	case "GET":
+		data, err := storage.GetBucketConfig(bucketName, POLICY_CONFIG)
+		if err != nil {
+			writeBucketConfigError(writer, err, CodeNoSuchBucketPolicy)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		writer.Write(data)
+
+	case "PUT":
+		body, err := io.ReadAll(request.Body)
+		if err != nil {
+			writeError(writer, http.StatusBadRequest, CodeInvalidArgument, err.Error())
+			return
+		}
+		policy := models.BucketPolicy{}
+		if err := json.Unmarshal(body, &policy); err != nil {
+			writeError(writer, http.StatusBadRequest, CodeMalformedPolicy, err.Error())
+			return
+		}
+		err = storage.PutBucketPolicy(bucketName, &policy)
+		if errors.Is(err, ErrInvalidPolicy) {
+			writeError(writer, http.StatusBadRequest, CodeMalformedPolicy, err.Error())
+			return
+		}
+		if err != nil {
+			writeBucketConfigError(writer, err, CodeNoSuchBucketPolicy)
+			return
+		}
+		writer.WriteHeader(http.StatusNoContent)
+
+	case "DELETE":
+		if err := storage.DeleteBucketPolicy(bucketName); err != nil {
+			writeBucketConfigError(writer, err, CodeNoSuchBucketPolicy)
+			return
+		}
+		writer.WriteHeader(http.StatusNoContent)
+
+	}
+}
@@ -0,0 +1,83 @@
+/**
+ * Author: Vanya Usalko <ivict@rambler.ru>
+ * File: put.go
+ */
+
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/usalko/s2d3/models"
+)
+
+// Put implements PutObject.
+func Put(writer http.ResponseWriter, request *http.Request) {
+	bucketName, objectKey := bucketNameAndObjectKey(request.URL.Path, request.Context().Value(KeyUrlContext).(string))
+	if bucketName == "" || objectKey == "" {
+		writeError(writer, http.StatusBadRequest, CodeInvalidArgument, "the bucket name and the object key are required")
+		return
+	}
+
+	tags, err := tagsOfHeader(request)
+	if err != nil {
+		writeError(writer, http.StatusBadRequest, CodeInvalidTag, err.Error())
+		return
+	}
+
+	sse, err := serverSideEncryptionOf(request)
+	if err != nil {
+		writeError(writer, http.StatusBadRequest, CodeInvalidArgument, err.Error())
+		return
+	}
+
+	storage := storageOf(request)
+	metadata := &models.ObjectMetadata{
+		Tags:        tags,
+		ContentType: request.Header.Get("Content-Type"),
+	}
+	now := time.Now()
+	if err := storage.objectLockOfHeaders(request, bucketName, metadata, now); err != nil {
+		writeObjectLockHeadersError(writer, err)
+		return
+	}
+	// Objects are stored without history, so the overwrite is refused as
+	// the deletion of the locked object
+	if err := storage.CheckObjectLock(bucketName, objectKey, bypassGovernance(request), now); err != nil {
+		writeObjectLockError(writer, err)
+		return
+	}
+
+	err = storage.PutObject(bucketName, objectKey, declaredBodyOf(request), metadata, sse)
+	if errors.Is(err, ErrQuotaExceeded) {
+		writeError(writer, http.StatusForbidden, CodeQuotaExceeded, err.Error())
+		return
+	}
+	if errors.Is(err, ErrContentSHA256Mismatch) {
+		writeError(writer, http.StatusBadRequest, CodeXAmzContentSHA256Mismatch, err.Error())
+		return
+	}
+	if errors.Is(err, ErrInsufficientStorage) {
+		writeDiskSpaceError(writer, err)
+		return
+	}
+	if errors.Is(err, ErrMasterKeyRequired) {
+		writeEncryptionError(writer, err)
+		return
+	}
+	if err != nil {
+		writeError(writer, http.StatusInternalServerError, CodeInternalError, err.Error())
+		return
+	}
+
+	info, err := storage.Stat(bucketName, objectKey)
+	if err == nil {
+		writer.Header().Set("ETag", quotedETag(info.ETag))
+	}
+	setEncryptionHeaders(writer, metadata.Encryption)
+	notify(request, &storage, bucketName, objectKey, EventObjectCreatedPut)
+	fmt.Printf("%s: [%s] %s request\n", request.Context().Value(KeyServerAddr), request.Method, request.URL.Path)
+}
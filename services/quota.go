@@ -0,0 +1,357 @@
+/**
+ * Author: Vanya Usalko <ivict@rambler.ru>
+ * File: quota.go
+ */
+
+package services
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"sync"
+
+	"github.com/usalko/s2d3/models"
+)
+
+const QUOTA_CONFIG = "quota"
+
+var ErrInvalidQuota = errors.New("the quota limits must not be negative")
+
+// bucketUsage is the usage of the bucket with the quota (or the reported
+// one), it is counted once from the bucket listing and is updated by the
+// writes of the storage. The lock serializes the writes of the bucket, so
+// the concurrent writes can't exceed the hard limits together.
+type bucketUsage struct {
+	lock    sync.Mutex
+	loaded  bool
+	bytes   int64
+	objects int64
+}
+
+var bucketUsages = map[string]*bucketUsage{}
+var bucketUsagesLock sync.Mutex
+
+// usageKey identifies the bucket of the backend, the file system backends
+// are created per request so they are identified by their folders.
+func usageKey(backend Backend, bucketName string) string {
+	if fileSystem, exists := backend.(*FileSystemBackend); exists {
+		if folder, err := filepath.Abs(fileSystem.RootFolder); err == nil {
+			return folder + "\x00" + bucketName
+		}
+	}
+	return fmt.Sprintf("%T:%p\x00%s", backend, backend, bucketName)
+}
+
+// usageOf returns the usage of the bucket, the usage which is not tracked yet
+// is returned only if create is set.
+func usageOf(backend Backend, bucketName string, create bool) *bucketUsage {
+	key := usageKey(backend, bucketName)
+
+	bucketUsagesLock.Lock()
+	defer bucketUsagesLock.Unlock()
+
+	usage, exists := bucketUsages[key]
+	if !exists && create {
+		usage = &bucketUsage{}
+		bucketUsages[key] = usage
+	}
+	return usage
+}
+
+// forgetUsage drops the tracked usage of the bucket, it is counted again by
+// the next write.
+func forgetUsage(backend Backend, bucketName string) {
+	key := usageKey(backend, bucketName)
+
+	bucketUsagesLock.Lock()
+	defer bucketUsagesLock.Unlock()
+
+	delete(bucketUsages, key)
+}
+
+// load counts the usage of the bucket unless it is counted already, the lock
+// must be held.
+func (usage *bucketUsage) load(backend Backend, bucketName string) error {
+	if usage.loaded {
+		return nil
+	}
+	versions, err := backend.List(bucketName)
+	if err != nil {
+		return err
+	}
+	usage.bytes, usage.objects = 0, 0
+	for _, version := range versions {
+		if version.IsLatest && !version.IsDeleteMarker {
+			usage.bytes += int64(version.Size)
+			usage.objects++
+		}
+	}
+	usage.loaded = true
+	return nil
+}
+
+// checkQuota checks the bucket can grow to the given usage, the writes which
+// don't increase the usage are accepted even over the limits (e.g. after the
+// quota is lowered).
+func checkQuota(config *models.QuotaConfiguration, usage *bucketUsage, bytes int64, objects int64) error {
+	if config.MaxBytes > 0 && bytes > config.MaxBytes && bytes > usage.bytes {
+		return fmt.Errorf("%w: %d of %d bytes", ErrQuotaExceeded, bytes, config.MaxBytes)
+	}
+	if config.MaxObjects > 0 && objects > config.MaxObjects && objects > usage.objects {
+		return fmt.Errorf("%w: %d of %d objects", ErrQuotaExceeded, objects, config.MaxObjects)
+	}
+	return nil
+}
+
+func softLimitExceeded(config *models.QuotaConfiguration, bytes int64, objects int64) bool {
+	return (config.SoftMaxBytes > 0 && bytes > config.SoftMaxBytes) ||
+		(config.SoftMaxObjects > 0 && objects > config.SoftMaxObjects)
+}
+
+// GetQuotaConfiguration returns nil for buckets without the quota. The quota
+// of the mounted bucket limits its MaxBytes, the lower limit of both is used.
+func (storage *Storage) GetQuotaConfiguration(bucketName string) (*models.QuotaConfiguration, error) {
+	mountQuota := storage.mountQuota(bucketName)
+	data, err := storage.GetBucketConfig(bucketName, QUOTA_CONFIG)
+	if errors.Is(err, ErrNoSuchConfiguration) || errors.Is(err, ErrNoSuchBucket) {
+		if mountQuota > 0 {
+			return &models.QuotaConfiguration{MaxBytes: mountQuota}, nil
+		}
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	config := models.QuotaConfiguration{}
+	if err := xml.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+	if mountQuota > 0 && (config.MaxBytes == 0 || config.MaxBytes > mountQuota) {
+		config.MaxBytes = mountQuota
+	}
+	return &config, nil
+}
+
+// mountQuota returns the quota of the mounted bucket, 0 for the buckets which
+// are not mounted or have no quota.
+func (storage *Storage) mountQuota(bucketName string) int64 {
+	reporter, exists := CapabilityOf[MountReporter](storage.Backend)
+	if !exists {
+		return 0
+	}
+	for _, mount := range reporter.Mounts() {
+		if mount.Bucket == bucketName {
+			return mount.Quota
+		}
+	}
+	return 0
+}
+
+// PutQuotaConfiguration sets the quota of the bucket, the usage is counted
+// again as the objects could be changed while the bucket had no quota.
+func (storage *Storage) PutQuotaConfiguration(bucketName string, config *models.QuotaConfiguration) error {
+	if config.MaxBytes < 0 || config.SoftMaxBytes < 0 || config.MaxObjects < 0 || config.SoftMaxObjects < 0 {
+		return ErrInvalidQuota
+	}
+	stored := *config
+	stored.Usage = nil
+	data, err := xml.Marshal(&stored)
+	if err != nil {
+		return err
+	}
+	if err := storage.PutBucketConfig(bucketName, QUOTA_CONFIG, data); err != nil {
+		return err
+	}
+	forgetUsage(storage.Backend, bucketName)
+	return nil
+}
+
+func (storage *Storage) DeleteQuotaConfiguration(bucketName string) error {
+	if err := storage.DeleteBucketConfig(bucketName, QUOTA_CONFIG); err != nil {
+		return err
+	}
+	forgetUsage(storage.Backend, bucketName)
+	return nil
+}
+
+// GetQuotaUsage returns the usage of the bucket, it is counted for the
+// bucket without the quota too.
+func (storage *Storage) GetQuotaUsage(bucketName string) (*models.QuotaUsage, error) {
+	config, err := storage.GetQuotaConfiguration(bucketName)
+	if err != nil {
+		return nil, err
+	}
+	usage := usageOf(storage.Backend, bucketName, true)
+	usage.lock.Lock()
+	defer usage.lock.Unlock()
+
+	if err := usage.load(storage.Backend, bucketName); err != nil {
+		return nil, err
+	}
+	return &models.QuotaUsage{
+		Bytes:             usage.bytes,
+		Objects:           usage.objects,
+		SoftLimitExceeded: config != nil && softLimitExceeded(config, usage.bytes, usage.objects),
+	}, nil
+}
+
+// declaredSizeOf returns the size of the object known before its content is
+// read: the size of the object stored transformed or the declared content
+// length of the request.
+func declaredSizeOf(reader io.Reader, metadata *models.ObjectMetadata) (int64, bool) {
+	if metadata.Size != nil {
+		return *metadata.Size, true
+	}
+	if sized, exists := reader.(interface{ Size() int64 }); exists {
+		return sized.Size(), true
+	}
+	return 0, false
+}
+
+// declaredBody is the request body with the declared content length.
+type declaredBody struct {
+	io.ReadCloser
+	size int64
+}
+
+func (body *declaredBody) Size() int64 {
+	return body.size
+}
+
+// declaredBodyOf returns the body of the request with its declared content
+// length, if any.
+func declaredBodyOf(request *http.Request) io.ReadCloser {
+	if request.ContentLength < 0 {
+		return request.Body
+	}
+	return &declaredBody{ReadCloser: request.Body, size: request.ContentLength}
+}
+
+// quotaReader fails the write of the object which content exceeds the bytes
+// left by the hard quota.
+type quotaReader struct {
+	io.Reader
+	left int64
+}
+
+func (reader *quotaReader) Read(buffer []byte) (int, error) {
+	count, err := reader.Reader.Read(buffer)
+	reader.left -= int64(count)
+	if reader.left < 0 {
+		return count, fmt.Errorf("%w: the object is larger than the bytes left", ErrQuotaExceeded)
+	}
+	return count, err
+}
+
+// putWithinQuota stores the object unless the bucket would exceed its hard
+// quota and updates the tracked usage of the bucket. The object is checked by
+// its declared size before it is stored and is streamed, the write is stopped
+// once the content exceeds the quota.
+func (storage *Storage) putWithinQuota(bucketName string, objectKey string, reader io.Reader, metadata *models.ObjectMetadata) error {
+	config, err := storage.GetQuotaConfiguration(bucketName)
+	if err != nil {
+		return err
+	}
+	usage := usageOf(storage.Backend, bucketName, config != nil)
+	if usage == nil {
+		return storage.Backend.Put(bucketName, objectKey, reader, metadata)
+	}
+	usage.lock.Lock()
+	defer usage.lock.Unlock()
+
+	if err := usage.load(storage.Backend, bucketName); err != nil {
+		return err
+	}
+	previousBytes, previousObjects := int64(0), int64(0)
+	previous, err := storage.Backend.Stat(bucketName, objectKey)
+	if err != nil && !errors.Is(err, ErrNoSuchKey) {
+		return err
+	}
+	if previous != nil {
+		previousBytes, previousObjects = previous.Size, 1
+	}
+	declaredSize, declared := declaredSizeOf(reader, metadata)
+	if config != nil {
+		if err := checkQuota(config, usage, usage.bytes-previousBytes+declaredSize, usage.objects-previousObjects+1); err != nil {
+			return err
+		}
+		// The content is not trusted to have the declared size
+		if metadata.Size == nil && config.MaxBytes > 0 {
+			reader = &quotaReader{Reader: reader, left: max(config.MaxBytes, usage.bytes) - usage.bytes + previousBytes}
+		}
+	}
+
+	counter := &countingReader{Reader: reader}
+	var body io.Reader = counter
+	if declared && metadata.Size == nil {
+		// The backend checks the disk space by the declared size too
+		body = &declaredBody{ReadCloser: counter, size: declaredSize}
+	}
+	if err := storage.Backend.Put(bucketName, objectKey, body, metadata); err != nil {
+		// The failed write may leave the object changed
+		usage.loaded = false
+		return err
+	}
+	size := counter.count
+	if metadata.Size != nil {
+		size = *metadata.Size
+	}
+	usedBytes, usedObjects := usage.bytes-previousBytes+size, usage.objects-previousObjects+1
+	if config != nil && !softLimitExceeded(config, usage.bytes, usage.objects) && softLimitExceeded(config, usedBytes, usedObjects) {
+		fmt.Printf("[quota] the bucket %s exceeds its soft quota: %d bytes, %d objects\n", bucketName, usedBytes, usedObjects)
+	}
+	usage.bytes, usage.objects = usedBytes, usedObjects
+	return nil
+}
+
+// checkPartQuota checks the part of the multipart upload fits into the hard
+// quota of the bucket, the assembled object is checked when the upload is
+// completed.
+func (storage *Storage) checkPartQuota(bucketName string, objectKey string, size int64) error {
+	config, err := storage.GetQuotaConfiguration(bucketName)
+	if err != nil || config == nil {
+		return err
+	}
+
+	usage := usageOf(storage.Backend, bucketName, true)
+	usage.lock.Lock()
+	defer usage.lock.Unlock()
+
+	if err := usage.load(storage.Backend, bucketName); err != nil {
+		return err
+	}
+	usedBytes, usedObjects := usage.bytes+size, usage.objects+1
+	previous, err := storage.Backend.Stat(bucketName, objectKey)
+	if err != nil && !errors.Is(err, ErrNoSuchKey) {
+		return err
+	}
+	if previous != nil {
+		usedBytes, usedObjects = usedBytes-previous.Size, usedObjects-1
+	}
+	return checkQuota(config, usage, usedBytes, usedObjects)
+}
+
+// Delete removes the object and updates the tracked usage of the bucket.
+func (storage *Storage) Delete(bucketName string, objectKey string) error {
+	usage := usageOf(storage.Backend, bucketName, false)
+	if usage == nil {
+		return storage.Backend.Delete(bucketName, objectKey)
+	}
+	usage.lock.Lock()
+	defer usage.lock.Unlock()
+
+	previous, _ := storage.Backend.Stat(bucketName, objectKey)
+	if err := storage.Backend.Delete(bucketName, objectKey); err != nil {
+		usage.loaded = false
+		return err
+	}
+	if previous != nil && usage.loaded {
+		usage.bytes, usage.objects = usage.bytes-previous.Size, usage.objects-1
+	}
+	return nil
+}
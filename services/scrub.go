@@ -0,0 +1,179 @@
+/**
+ * Author: Vanya Usalko <ivict@rambler.ru>
+ * File: scrub.go
+ */
+
+package services
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/usalko/s2d3/models"
+)
+
+// The ETags of the multipart uploads are not the md5 of the content.
+var md5ETagPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+var errScrubSkipped = errors.New("the object checksum is unknown")
+
+var ErrNoSuchScrubJob = errors.New("the scrub job does not exist")
+
+// MAX_SCRUB_JOBS limits the jobs kept to be reported, the oldest finished
+// jobs are dropped beyond it.
+const MAX_SCRUB_JOBS = 100
+
+// The scrub jobs are kept in memory since the service start.
+var scrubJobs = []*models.ScrubJob{}
+var scrubJobsLock sync.Mutex
+
+// StartScrub runs Scrub in the background and returns its job, the missing
+// bucket is refused before the job is started.
+func (storage *Storage) StartScrub(bucketName string) (*models.ScrubJob, error) {
+	if bucketName != "" {
+		if err := storage.CheckBucket(bucketName); err != nil {
+			return nil, err
+		}
+	}
+	job := &models.ScrubJob{
+		Id:        newRequestId(),
+		Bucket:    bucketName,
+		Status:    models.ScrubStatusRunning,
+		StartTime: time.Now().UTC(),
+	}
+
+	scrubJobsLock.Lock()
+	scrubJobs = append(scrubJobs, job)
+	for i := 0; len(scrubJobs) > MAX_SCRUB_JOBS && i < len(scrubJobs); {
+		if scrubJobs[i].Status == models.ScrubStatusRunning {
+			i++
+			continue
+		}
+		scrubJobs = append(scrubJobs[:i], scrubJobs[i+1:]...)
+	}
+	reported := *job
+	scrubJobsLock.Unlock()
+
+	go func() {
+		result, err := storage.Scrub(bucketName)
+		endTime := time.Now().UTC()
+
+		scrubJobsLock.Lock()
+		defer scrubJobsLock.Unlock()
+
+		job.EndTime, job.Result = &endTime, result
+		job.Status = models.ScrubStatusDone
+		if err != nil {
+			fmt.Printf("[scrub] the scrub %s is failed: %s\n", job.Id, err)
+			job.Status, job.Error = models.ScrubStatusFailed, err.Error()
+		}
+	}()
+	return &reported, nil
+}
+
+// ScrubJobOf returns the state of the scrub job started by StartScrub.
+func ScrubJobOf(id string) (*models.ScrubJob, error) {
+	scrubJobsLock.Lock()
+	defer scrubJobsLock.Unlock()
+
+	for _, job := range scrubJobs {
+		if job.Id == id {
+			reported := *job
+			return &reported, nil
+		}
+	}
+	return nil, ErrNoSuchScrubJob
+}
+
+// Scrub reads the objects of the bucket (of all buckets for the empty name)
+// and reports the objects which can't be read or don't match their ETags.
+func (storage *Storage) Scrub(bucketName string) (*models.ScrubResult, error) {
+	bucketNames := []string{bucketName}
+	if bucketName == "" {
+		buckets, err := storage.ListBuckets()
+		if err != nil {
+			return nil, err
+		}
+		bucketNames = make([]string, 0, len(buckets))
+		for _, bucket := range buckets {
+			bucketNames = append(bucketNames, bucket.Name)
+		}
+	} else if err := storage.CheckBucket(bucketName); err != nil {
+		return nil, err
+	}
+
+	result := &models.ScrubResult{Corrupted: []models.ScrubFailure{}}
+	for _, name := range bucketNames {
+		objects, err := storage.ListObjects(name)
+		if err != nil {
+			return nil, err
+		}
+		result.Buckets++
+		for _, object := range objects {
+			size, err := storage.scrubObject(name, object.Key)
+			switch {
+			case errors.Is(err, ErrNoSuchKey):
+				// The object is deleted meanwhile
+			case errors.Is(err, errScrubSkipped):
+				result.Skipped++
+				result.Bytes += size
+			case err != nil:
+				fmt.Printf("[scrub] %s/%s: %s\n", name, object.Key, err)
+				result.Corrupted = append(result.Corrupted, models.ScrubFailure{
+					Bucket: name,
+					Key:    object.Key,
+					Error:  err.Error(),
+				})
+			default:
+				result.Objects++
+				result.Bytes += size
+			}
+		}
+	}
+	return result, nil
+}
+
+// scrubObject reads the object and checks its size and its ETag, the objects
+// encrypted by the customer keys are checked as they are stored since their
+// ETags are the md5 of the encrypted data.
+func (storage *Storage) scrubObject(bucketName string, objectKey string) (int64, error) {
+	info, err := storage.Stat(bucketName, objectKey)
+	if err != nil {
+		return 0, err
+	}
+	var reader io.ReadCloser
+	expectedSize := info.Size
+	if info.Metadata.Encryption != nil && info.Metadata.Encryption.CustomerKeyMD5 != "" {
+		// The size of the encrypted data is not recorded
+		reader, _, err = storage.Get(bucketName, objectKey)
+		expectedSize = -1
+	} else {
+		reader, err = storage.OpenObject(bucketName, objectKey, nil)
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+
+	hash := md5.New()
+	size, err := io.Copy(hash, reader)
+	if err != nil {
+		return size, err
+	}
+	if expectedSize >= 0 && size != expectedSize {
+		return size, fmt.Errorf("the size is %d bytes instead of %d", size, expectedSize)
+	}
+	if !md5ETagPattern.MatchString(info.ETag) {
+		return size, errScrubSkipped
+	}
+	if etag := hex.EncodeToString(hash.Sum(nil)); etag != info.ETag {
+		return size, fmt.Errorf("the content md5 %s doesn't match the ETag %s", etag, info.ETag)
+	}
+	return size, nil
+}
@@ -0,0 +1,482 @@
+/**
+ * Author: Vanya Usalko <ivict@rambler.ru>
+ * File: segment_store.go
+ */
+
+package services
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/usalko/s2d3/models"
+)
+
+// The deduplicated objects are split into segments, every segment is stored
+// once as the file named by the sha256 of its content with the count of the
+// references next to it:
+//
+//	segments/<2 first hex digits>/<sha256>
+//	segments/<2 first hex digits>/<sha256>.ref
+//
+// The object itself is the manifest listing its segments. The references
+// are added before the manifest is written and released after it is
+// removed, so the crash only leaves the counts too high and the garbage
+// collection recounts them from the manifests.
+const SEGMENTS_FOLDER = "segments"
+const MANIFESTS_FOLDER = "manifests"
+const SEGMENT_REFERENCES_SUFFIX = ".ref"
+
+// segmentManifest lists the segments of the deduplicated object.
+type segmentManifest struct {
+	Size        int64     `json:"size"`
+	ETag        string    `json:"etag"`
+	ModTime     time.Time `json:"modTime"`
+	SegmentSize int64     `json:"segmentSize"`
+	Segments    []string  `json:"segments"`
+}
+
+// segmentStore keeps the segments of the objects of all buckets of the
+// system folder, so the segments are shared across the buckets.
+type segmentStore struct {
+	lock   sync.Mutex
+	folder string
+	// pending are the references taken by the objects being stored, they
+	// have no manifest yet
+	pending map[string]int64
+}
+
+var segmentStores = map[string]*segmentStore{}
+var segmentStoresLock sync.Mutex
+
+// openSegmentStore returns the store of the system folder.
+func openSegmentStore(systemFolder string) (*segmentStore, error) {
+	folder, err := filepath.Abs(systemFolder)
+	if err != nil {
+		return nil, err
+	}
+
+	segmentStoresLock.Lock()
+	defer segmentStoresLock.Unlock()
+
+	store, exists := segmentStores[folder]
+	if !exists {
+		store = &segmentStore{
+			folder:  folder,
+			pending: map[string]int64{},
+		}
+		segmentStores[folder] = store
+	}
+	return store, nil
+}
+
+func (store *segmentStore) segmentPath(hash string) string {
+	return filepath.Join(store.folder, SEGMENTS_FOLDER, hash[:2], hash)
+}
+
+func (store *segmentStore) manifestsPath(bucketName string) string {
+	return filepath.Join(store.folder, MANIFESTS_FOLDER, bucketName)
+}
+
+func (store *segmentStore) manifestPath(bucketName string, objectKey string) string {
+	return filepath.Join(store.manifestsPath(bucketName), fmt.Sprintf("%s.json", objectKey))
+}
+
+func (store *segmentStore) references(hash string) (int64, error) {
+	data, err := os.ReadFile(store.segmentPath(hash) + SEGMENT_REFERENCES_SUFFIX)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+}
+
+func (store *segmentStore) setReferences(hash string, references int64) error {
+	return writeFileAtomically(store.segmentPath(hash)+SEGMENT_REFERENCES_SUFFIX, []byte(strconv.FormatInt(references, 10)), OBJECT_FILE_MODE)
+}
+
+// addSegment references the segment, the segment is written when it is not
+// stored yet.
+func (store *segmentStore) addSegment(hash string, data []byte) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	references, err := store.references(hash)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(store.segmentPath(hash)); errors.Is(err, fs.ErrNotExist) {
+		err = writeFileAtomically(store.segmentPath(hash), data, OBJECT_FILE_MODE)
+		if err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+	if err := store.setReferences(hash, references+1); err != nil {
+		return err
+	}
+	store.pending[hash]++
+	return nil
+}
+
+// release drops the references of the segments, the unreferenced segments
+// are kept until the garbage collection so the objects uploaded again reuse
+// them.
+func (store *segmentStore) release(segments []string) error {
+	var result error
+	for _, hash := range segments {
+		references, err := store.references(hash)
+		if err == nil && references > 0 {
+			err = store.setReferences(hash, references-1)
+		}
+		result = errors.Join(result, err)
+	}
+	return result
+}
+
+func (store *segmentStore) dropPending(segments []string) {
+	for _, hash := range segments {
+		store.pending[hash]--
+		if store.pending[hash] <= 0 {
+			delete(store.pending, hash)
+		}
+	}
+}
+
+// Put splits the object into the segments and writes its manifest, the
+// previous manifest of the object is replaced.
+func (store *segmentStore) Put(bucketName string, objectKey string, reader io.Reader, segmentSize int64) (*segmentManifest, error) {
+	manifest := &segmentManifest{
+		SegmentSize: segmentSize,
+		Segments:    make([]string, 0),
+	}
+	objectHash := md5.New()
+	segment := make([]byte, segmentSize)
+	for {
+		size, err := io.ReadFull(reader, segment)
+		if size > 0 {
+			hash := sha256.Sum256(segment[:size])
+			err := store.addSegment(hex.EncodeToString(hash[:]), segment[:size])
+			if err != nil {
+				store.lock.Lock()
+				store.release(manifest.Segments)
+				store.dropPending(manifest.Segments)
+				store.lock.Unlock()
+				return nil, err
+			}
+			manifest.Segments = append(manifest.Segments, hex.EncodeToString(hash[:]))
+			manifest.Size += int64(size)
+			objectHash.Write(segment[:size])
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			store.lock.Lock()
+			store.release(manifest.Segments)
+			store.dropPending(manifest.Segments)
+			store.lock.Unlock()
+			return nil, err
+		}
+	}
+	manifest.ETag = hex.EncodeToString(objectHash.Sum(nil))
+	manifest.ModTime = time.Now().UTC()
+
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	defer store.dropPending(manifest.Segments)
+
+	previous, err := store.manifest(bucketName, objectKey)
+	if err != nil {
+		store.release(manifest.Segments)
+		return nil, err
+	}
+	data, err := json.Marshal(manifest)
+	if err == nil {
+		err = writeFileAtomically(store.manifestPath(bucketName, objectKey), data, OBJECT_FILE_MODE)
+	}
+	if err != nil {
+		store.release(manifest.Segments)
+		return nil, err
+	}
+	if previous != nil {
+		if err := store.release(previous.Segments); err != nil {
+			fmt.Printf("[dedup] the segments of %s/%s are not released: %s\n", bucketName, objectKey, err)
+		}
+	}
+	return manifest, nil
+}
+
+func (store *segmentStore) manifest(bucketName string, objectKey string) (*segmentManifest, error) {
+	data, err := os.ReadFile(store.manifestPath(bucketName, objectKey))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	manifest := segmentManifest{}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, err
+	}
+	return &manifest, nil
+}
+
+// Manifest returns nil for the object which is not deduplicated.
+func (store *segmentStore) Manifest(bucketName string, objectKey string) (*segmentManifest, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	return store.manifest(bucketName, objectKey)
+}
+
+// Delete removes the manifest of the object and releases its segments, it
+// returns false when the object is not deduplicated.
+func (store *segmentStore) Delete(bucketName string, objectKey string) (bool, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	manifest, err := store.manifest(bucketName, objectKey)
+	if err != nil || manifest == nil {
+		return false, err
+	}
+	err = removeWithEmptyParents(store.manifestPath(bucketName, objectKey), filepath.Join(store.folder, MANIFESTS_FOLDER))
+	if err != nil {
+		return false, err
+	}
+	return true, store.release(manifest.Segments)
+}
+
+// Keys returns the keys of the deduplicated objects of the bucket.
+func (store *segmentStore) Keys(bucketName string) ([]string, error) {
+	manifestsPath := store.manifestsPath(bucketName)
+	keys := make([]string, 0)
+	err := filepath.WalkDir(manifestsPath, func(path string, entry fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && path == manifestsPath {
+			return filepath.SkipDir
+		}
+		if err != nil {
+			return err
+		}
+		// The manifests being written end with .tmp, the dot files are the
+		// manifests of the keys like dir/.env
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			return nil
+		}
+		relativePath, err := filepath.Rel(manifestsPath, path)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, strings.TrimSuffix(filepath.ToSlash(relativePath), ".json"))
+		return nil
+	})
+	return keys, err
+}
+
+// Open returns the reader of the object content.
+func (store *segmentStore) Open(manifest *segmentManifest) io.ReadSeekCloser {
+	return &segmentReader{
+		store:    store,
+		manifest: manifest,
+		index:    -1,
+	}
+}
+
+// walkSegments calls the function for every stored segment.
+func (store *segmentStore) walkSegments(walk func(hash string, size int64) error) error {
+	segmentsPath := filepath.Join(store.folder, SEGMENTS_FOLDER)
+	return filepath.WalkDir(segmentsPath, func(path string, entry fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && path == segmentsPath {
+			return filepath.SkipDir
+		}
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") || strings.HasSuffix(entry.Name(), SEGMENT_REFERENCES_SUFFIX) {
+			return nil
+		}
+		fileInfo, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		return walk(entry.Name(), fileInfo.Size())
+	})
+}
+
+// Collect recounts the references of the segments from the manifests and
+// removes the unreferenced segments, it returns the count of the removed
+// segments and their size.
+func (store *segmentStore) Collect() (int64, int64, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	counted := map[string]int64{}
+	for hash, references := range store.pending {
+		counted[hash] += references
+	}
+	manifestsPath := filepath.Join(store.folder, MANIFESTS_FOLDER)
+	err := filepath.WalkDir(manifestsPath, func(path string, entry fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && path == manifestsPath {
+			return filepath.SkipDir
+		}
+		if err != nil {
+			return err
+		}
+		// The manifests being written end with .tmp, the dot files are the
+		// manifests of the keys like dir/.env
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		manifest := segmentManifest{}
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			return fmt.Errorf("manifest %s: %w", path, err)
+		}
+		for _, hash := range manifest.Segments {
+			counted[hash]++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	removed, reclaimed := int64(0), int64(0)
+	err = store.walkSegments(func(hash string, size int64) error {
+		references, err := store.references(hash)
+		if err != nil {
+			fmt.Printf("[dedup] the references of the segment %s are recounted: %s\n", hash, err)
+		}
+		if counted[hash] == 0 {
+			err = os.Remove(store.segmentPath(hash))
+			if err == nil || errors.Is(err, fs.ErrNotExist) {
+				err = os.Remove(store.segmentPath(hash) + SEGMENT_REFERENCES_SUFFIX)
+			}
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+			os.Remove(filepath.Dir(store.segmentPath(hash)))
+			removed++
+			reclaimed += size
+			return nil
+		}
+		if references != counted[hash] {
+			return store.setReferences(hash, counted[hash])
+		}
+		return nil
+	})
+	return removed, reclaimed, err
+}
+
+// Statistics reports the stored segments, the unreferenced segments are
+// counted until they are collected.
+func (store *segmentStore) Statistics() (*models.DedupStatistics, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	statistics := &models.DedupStatistics{}
+	err := store.walkSegments(func(hash string, size int64) error {
+		references, err := store.references(hash)
+		if err != nil {
+			return err
+		}
+		statistics.Segments++
+		statistics.StoredBytes += size
+		statistics.References += references
+		statistics.LogicalBytes += references * size
+		if references == 0 {
+			statistics.UnreferencedBytes += size
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	statistics.DedupRatio = dedupRatio(statistics)
+	return statistics, nil
+}
+
+func dedupRatio(statistics *models.DedupStatistics) float64 {
+	if statistics.StoredBytes == 0 {
+		return 0
+	}
+	return float64(statistics.LogicalBytes) / float64(statistics.StoredBytes)
+}
+
+// segmentReader reads the object from its segments, the segment files are
+// opened while they are read.
+type segmentReader struct {
+	store    *segmentStore
+	manifest *segmentManifest
+	offset   int64
+	index    int
+	segment  *os.File
+}
+
+func (reader *segmentReader) Read(buffer []byte) (int, error) {
+	if reader.offset >= reader.manifest.Size {
+		return 0, io.EOF
+	}
+	index := int(reader.offset / reader.manifest.SegmentSize)
+	if reader.segment == nil || index != reader.index {
+		if reader.segment != nil {
+			reader.segment.Close()
+			reader.segment = nil
+		}
+		segment, err := os.Open(reader.store.segmentPath(reader.manifest.Segments[index]))
+		if err != nil {
+			return 0, err
+		}
+		reader.segment = segment
+		reader.index = index
+	}
+
+	count, err := reader.segment.ReadAt(buffer, reader.offset-int64(index)*reader.manifest.SegmentSize)
+	reader.offset += int64(count)
+	if errors.Is(err, io.EOF) && count > 0 {
+		err = nil
+	}
+	return count, err
+}
+
+func (reader *segmentReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += reader.offset
+	case io.SeekEnd:
+		offset += reader.manifest.Size
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("negative position %d", offset)
+	}
+	reader.offset = offset
+	return offset, nil
+}
+
+func (reader *segmentReader) Close() error {
+	if reader.segment != nil {
+		return reader.segment.Close()
+	}
+	return nil
+}
@@ -6,10 +6,13 @@
 package services
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"strings"
+	"time"
 )
 
 type ServiceContext struct {
@@ -21,8 +24,40 @@ const KeyServerAddr ServiceContextKey = "serverAddr"
 const KeyDataFolder ServiceContextKey = "dataFolder"
 const KeyUrlContext ServiceContextKey = "urlContext"
 const KeyStatisticsApplicationFolder ServiceContextKey = "statisticsApplicationFolder"
+const KeyGovernanceBypassAccessKeys ServiceContextKey = "governanceBypassAccessKeys"
+const KeyMasterKeyFile ServiceContextKey = "masterKeyFile"
+const KeyGenerateMasterKey ServiceContextKey = "generateMasterKey"
+const KeyBackend ServiceContextKey = "backend"
+const KeyRequestId ServiceContextKey = "requestId"
+const KeyHostId ServiceContextKey = "hostId"
+const KeyAccessLogFormat ServiceContextKey = "accessLogFormat"
+const KeyAdminToken ServiceContextKey = "adminToken"
+const KeyNotificationSignal ServiceContextKey = "notificationSignal"
+const KeyAccessKeyCache ServiceContextKey = "accessKeyCache"
 
+// ApiRouter routes the S3 requests, accounts them in the metrics and the
+// statistics and writes their access log.
 func ApiRouter(writer http.ResponseWriter, request *http.Request) {
+	start := time.Now()
+	recorder := &responseRecorder{ResponseWriter: writer, status: http.StatusOK}
+	body := &countingReader{Reader: request.Body}
+	request.Body = body
+	requestId := newRequestId()
+	hostId := hostIdOf(request, requestId)
+	ctx := context.WithValue(request.Context(), KeyRequestId, requestId)
+	request = request.WithContext(context.WithValue(ctx, KeyHostId, hostId))
+	writer.Header().Set("x-amz-request-id", requestId)
+	writer.Header().Set("x-amz-id-2", hostId)
+
+	routeRequest(recorder, request)
+
+	entry := accessLogEntryOf(request, start, recorder, body.count)
+	observeRequest(entry.operation, entry.bucketName, entry.status, entry.duration, entry.bytesReceived, entry.bytesSent)
+	observeStatistics(entry)
+	logAccess(request, entry)
+}
+
+func routeRequest(writer http.ResponseWriter, request *http.Request) {
 
 	parsedQuery, err := url.ParseQuery(request.URL.RawQuery)
 
@@ -31,17 +66,140 @@ func ApiRouter(writer http.ResponseWriter, request *http.Request) {
 		return
 	}
 
+	// The buckets are created by the valid names only, see CreateBucket
+	bucketName, objectKey := bucketNameAndObjectKey(request.URL.Path, request.Context().Value(KeyUrlContext).(string))
+	if bucketName != "" && !ValidBucketName(bucketName) && !(request.Method == "PUT" && objectKey == "") {
+		writeError(writer, http.StatusNotFound, CodeNoSuchBucket, "the bucket name is not valid")
+		return
+	}
+	if !validObjectKey(objectKey) {
+		writeError(writer, http.StatusBadRequest, CodeInvalidArgument, "the object key is not valid")
+		return
+	}
+
+	if request.Method != "OPTIONS" {
+		applyCors(writer, request)
+
+		// The admin token is authorized to all the actions as by the browser
+		storage := storageOf(request)
+		if !hasAdminToken(request) {
+			if err := authorize(request, &storage, bucketName, objectKey, policyActionOf(operationOf(request))); err != nil {
+				writeAuthorizationError(writer, err)
+				return
+			}
+		}
+	}
+
+	if request.Method == "PUT" || request.Method == "POST" || request.Method == "DELETE" {
+		if err := backendOf(request).CheckWritable(bucketName); err != nil {
+			writeError(writer, http.StatusForbidden, CodeAccessDenied, err.Error())
+			return
+		}
+	}
+
 	switch request.Method {
 
+	case "OPTIONS":
+		Preflight(writer, request)
+		return
+
 	case "GET":
-		listType, exists := parsedQuery["list-type"]
+		_, exists := parsedQuery["cors"]
+		if exists {
+			Cors(writer, request)
+			return
+		}
+		_, exists = parsedQuery["notification"]
+		if exists {
+			Notification(writer, request)
+			return
+		}
+		_, exists = parsedQuery["encryption"]
+		if exists {
+			Encryption(writer, request)
+			return
+		}
+		_, exists = parsedQuery["compression"]
+		if exists {
+			Compression(writer, request)
+			return
+		}
+		_, exists = parsedQuery["pack"]
+		if exists {
+			Pack(writer, request)
+			return
+		}
+		_, exists = parsedQuery["dedup"]
+		if exists {
+			Dedup(writer, request)
+			return
+		}
+		_, exists = parsedQuery["dedup-stats"]
+		if exists {
+			DedupStats(writer, request)
+			return
+		}
+		_, exists = parsedQuery["policy"]
+		if exists {
+			Policy(writer, request)
+			return
+		}
+		_, exists = parsedQuery["logging"]
+		if exists {
+			Logging(writer, request)
+			return
+		}
+		_, exists = parsedQuery["disk-status"]
+		if exists {
+			DiskStats(writer, request)
+			return
+		}
+		_, exists = parsedQuery["object-lock"]
+		if exists {
+			ObjectLock(writer, request)
+			return
+		}
+		_, exists = parsedQuery["retention"]
+		if exists {
+			Retention(writer, request)
+			return
+		}
+		_, exists = parsedQuery["legal-hold"]
+		if exists {
+			LegalHold(writer, request)
+			return
+		}
+		_, exists = parsedQuery["tagging"]
+		if exists {
+			Tagging(writer, request)
+			return
+		}
+		_, exists = parsedQuery["lifecycle"]
+		if exists {
+			Lifecycle(writer, request)
+			return
+		}
+		_, exists = parsedQuery["versions"]
 		if exists {
+			ListVersions(writer, request)
+			return
+		}
+		// The bucket without the key is listed by ListObjects (V1 unless
+		// list-type is given) whatever its listing parameters are
+		listType, exists := parsedQuery["list-type"]
+		if exists || (bucketName != "" && objectKey == "") {
 			List(writer, request, listType)
 			return
 		}
 		Get(writer, request)
 		return
 
+	case "HEAD":
+		if !hasSubresource(parsedQuery) {
+			Get(writer, request)
+			return
+		}
+
 	case "POST":
 		_, exists := parsedQuery["uploads"]
 		if exists {
@@ -56,17 +214,176 @@ func ApiRouter(writer http.ResponseWriter, request *http.Request) {
 		}
 
 	case "PUT":
-		_, exists := parsedQuery["uploadId"]
+		_, exists := parsedQuery["cors"]
+		if exists {
+			Cors(writer, request)
+			return
+		}
+
+		_, exists = parsedQuery["notification"]
+		if exists {
+			Notification(writer, request)
+			return
+		}
+
+		_, exists = parsedQuery["encryption"]
+		if exists {
+			Encryption(writer, request)
+			return
+		}
+
+		_, exists = parsedQuery["compression"]
+		if exists {
+			Compression(writer, request)
+			return
+		}
+
+		_, exists = parsedQuery["pack"]
+		if exists {
+			Pack(writer, request)
+			return
+		}
+
+		_, exists = parsedQuery["dedup"]
+		if exists {
+			Dedup(writer, request)
+			return
+		}
+
+		_, exists = parsedQuery["policy"]
+		if exists {
+			Policy(writer, request)
+			return
+		}
+
+		_, exists = parsedQuery["logging"]
+		if exists {
+			Logging(writer, request)
+			return
+		}
+
+		_, exists = parsedQuery["object-lock"]
+		if exists {
+			ObjectLock(writer, request)
+			return
+		}
+
+		_, exists = parsedQuery["retention"]
+		if exists {
+			Retention(writer, request)
+			return
+		}
+
+		_, exists = parsedQuery["legal-hold"]
+		if exists {
+			LegalHold(writer, request)
+			return
+		}
+
+		_, exists = parsedQuery["tagging"]
+		if exists {
+			Tagging(writer, request)
+			return
+		}
+
+		_, exists = parsedQuery["lifecycle"]
+		if exists {
+			Lifecycle(writer, request)
+			return
+		}
+
+		_, exists = parsedQuery["uploadId"]
+		if exists {
+			Upload(writer, request)
+			return
+		}
+
+		if !hasSubresource(parsedQuery) {
+			_, objectKey := bucketNameAndObjectKey(request.URL.Path, request.Context().Value(KeyUrlContext).(string))
+			if objectKey == "" {
+				CreateBucket(writer, request)
+				return
+			}
+			Put(writer, request)
+			return
+		}
+
+	case "DELETE":
+		_, exists := parsedQuery["cors"]
+		if exists {
+			Cors(writer, request)
+			return
+		}
+
+		_, exists = parsedQuery["encryption"]
+		if exists {
+			Encryption(writer, request)
+			return
+		}
+
+		_, exists = parsedQuery["compression"]
+		if exists {
+			Compression(writer, request)
+			return
+		}
+
+		_, exists = parsedQuery["pack"]
+		if exists {
+			Pack(writer, request)
+			return
+		}
+
+		_, exists = parsedQuery["dedup"]
+		if exists {
+			Dedup(writer, request)
+			return
+		}
+
+		_, exists = parsedQuery["policy"]
+		if exists {
+			Policy(writer, request)
+			return
+		}
+
+		_, exists = parsedQuery["tagging"]
+		if exists {
+			Tagging(writer, request)
+			return
+		}
+
+		_, exists = parsedQuery["lifecycle"]
+		if exists {
+			Lifecycle(writer, request)
+			return
+		}
+
+		_, exists = parsedQuery["uploadId"]
 		if exists {
 			Upload(writer, request)
 			return
 		}
 
+		if !hasSubresource(parsedQuery) {
+			Delete(writer, request)
+			return
+		}
+
 	}
 
 	fmt.Printf("%s: [%s] %s request not processed\n", request.Context().Value(KeyServerAddr), request.Method, request.URL.Path)
 }
 
+// hasSubresource checks the query for parameters other than the ones of the
+// presigned requests (X-Amz-Signature etc.)
+func hasSubresource(parsedQuery url.Values) bool {
+	for parameter := range parsedQuery {
+		if !strings.HasPrefix(strings.ToLower(parameter), "x-amz-") {
+			return true
+		}
+	}
+	return false
+}
+
 func GetHello(writer http.ResponseWriter, request *http.Request) {
 	ctx := request.Context()
 
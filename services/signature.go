@@ -0,0 +1,392 @@
+/**
+ * Author: Vanya Usalko <ivict@rambler.ru>
+ * File: signature.go
+ */
+
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/usalko/s2d3/utils"
+)
+
+const SIGNATURE_V4_ALGORITHM = "AWS4-HMAC-SHA256"
+const SIGNATURE_V4_DATE_FORMAT = "20060102T150405Z"
+const UNSIGNED_PAYLOAD = "UNSIGNED-PAYLOAD"
+
+// SIGNATURE_MAX_SKEW limits the difference of the request date and the server
+// time, the signed requests are not replayed later.
+const SIGNATURE_MAX_SKEW = 15 * time.Minute
+
+// SIGNATURE_V4_MAX_EXPIRES is the longest validity of the presigned url.
+const SIGNATURE_V4_MAX_EXPIRES = 7 * 24 * time.Hour
+
+// SIGNATURE_V2_SUBRESOURCES are the query parameters signed as the part of
+// the resource by the signature version 2.
+var SIGNATURE_V2_SUBRESOURCES = []string{
+	"accelerate", "acl", "analytics", "cors", "defaultObjectAcl", "delete", "inventory", "lifecycle",
+	"location", "logging", "metrics", "notification", "object-lock", "partNumber", "policy",
+	"replication", "requestPayment", "response-cache-control", "response-content-disposition",
+	"response-content-encoding", "response-content-language", "response-content-type",
+	"response-expires", "restore", "select", "select-type", "storageClass", "tagging", "torrent",
+	"uploadId", "uploads", "versionId", "versioning", "versions", "website",
+}
+
+const CodeSignatureDoesNotMatch = "SignatureDoesNotMatch"
+const CodeAuthorizationHeaderMalformed = "AuthorizationHeaderMalformed"
+const CodeRequestTimeTooSkewed = "RequestTimeTooSkewed"
+const CodeXAmzContentSHA256Mismatch = "XAmzContentSHA256Mismatch"
+
+var ErrSignatureDoesNotMatch = errors.New("the request signature does not match the signature calculated by the secret access key")
+var ErrMalformedAuthorization = errors.New("the authorization of the request is malformed")
+var ErrRequestTimeTooSkewed = errors.New("the difference between the request time and the server time is too large")
+var ErrRequestExpired = errors.New("the presigned request has expired")
+var ErrContentSHA256Mismatch = errors.New("the provided x-amz-content-sha256 header does not match what was computed")
+
+// verifySignature checks the signature version 2 or 4 of the request, given
+// by the authorization header or by the presigned url, by the secret of its
+// access key.
+func verifySignature(request *http.Request, secretAccessKey string, now time.Time) error {
+	authorization := request.Header.Get("Authorization")
+	switch {
+	case strings.HasPrefix(authorization, SIGNATURE_V4_ALGORITHM+" "), authorization == "" && request.URL.Query().Has("X-Amz-Signature"):
+		return verifySignatureV4(request, secretAccessKey, now)
+	case strings.HasPrefix(authorization, "AWS "), authorization == "" && request.URL.Query().Has("Signature"):
+		return verifySignatureV2(request, secretAccessKey, now)
+	}
+	return ErrMalformedAuthorization
+}
+
+// signatureV4 is the signature version 4 of the authorization header or of
+// the presigned url.
+type signatureV4 struct {
+	accessKeyId   string
+	scope         []string
+	signedHeaders []string
+	signature     string
+	date          time.Time
+	payloadHash   string
+	// expires is the validity of the presigned url, it is zero for the
+	// authorization header
+	expires time.Duration
+}
+
+func signatureV4Of(request *http.Request) (*signatureV4, error) {
+	signature := &signatureV4{}
+	var credential, signedHeaders, date string
+	if authorization, found := strings.CutPrefix(request.Header.Get("Authorization"), SIGNATURE_V4_ALGORITHM+" "); found {
+		for _, field := range strings.Split(authorization, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(field), "=")
+			switch name {
+			case "Credential":
+				credential = value
+			case "SignedHeaders":
+				signedHeaders = value
+			case "Signature":
+				signature.signature = value
+			}
+		}
+		date = request.Header.Get("x-amz-date")
+		if date == "" {
+			headerDate, err := http.ParseTime(request.Header.Get("Date"))
+			if err != nil {
+				return nil, ErrMalformedAuthorization
+			}
+			date = headerDate.UTC().Format(SIGNATURE_V4_DATE_FORMAT)
+		}
+		signature.payloadHash = request.Header.Get("x-amz-content-sha256")
+	} else {
+		query := request.URL.Query()
+		if query.Get("X-Amz-Algorithm") != SIGNATURE_V4_ALGORITHM {
+			return nil, ErrMalformedAuthorization
+		}
+		credential = query.Get("X-Amz-Credential")
+		signedHeaders = query.Get("X-Amz-SignedHeaders")
+		signature.signature = query.Get("X-Amz-Signature")
+		date = query.Get("X-Amz-Date")
+		expires, err := strconv.Atoi(query.Get("X-Amz-Expires"))
+		signature.expires = time.Duration(expires) * time.Second
+		if err != nil || expires < 1 || signature.expires > SIGNATURE_V4_MAX_EXPIRES {
+			return nil, ErrMalformedAuthorization
+		}
+		signature.payloadHash = query.Get("X-Amz-Content-Sha256")
+	}
+	if signature.payloadHash == "" {
+		signature.payloadHash = UNSIGNED_PAYLOAD
+	}
+
+	var err error
+	signature.date, err = time.Parse(SIGNATURE_V4_DATE_FORMAT, date)
+	if err != nil {
+		return nil, ErrMalformedAuthorization
+	}
+	// The credential is the access key id with the scope of the signing key:
+	// id/yyyymmdd/region/service/aws4_request
+	scope := strings.Split(credential, "/")
+	if len(scope) != 5 || scope[1] != signature.date.Format("20060102") || scope[4] != "aws4_request" ||
+		signedHeaders == "" || signature.signature == "" {
+		return nil, ErrMalformedAuthorization
+	}
+	signature.accessKeyId, signature.scope = scope[0], scope[1:]
+	signature.signedHeaders = strings.Split(signedHeaders, ";")
+	// The signature of the request is bound to the host it is sent to
+	if !slices.Contains(signature.signedHeaders, "host") {
+		return nil, ErrMalformedAuthorization
+	}
+	return signature, nil
+}
+
+// checkSignatureDate refuses the request signed out of SIGNATURE_MAX_SKEW, the
+// presigned url is refused once it expires.
+func checkSignatureDate(date time.Time, expires time.Duration, now time.Time) error {
+	if expires > 0 && now.After(date.Add(expires)) {
+		return ErrRequestExpired
+	}
+	if date.After(now.Add(SIGNATURE_MAX_SKEW)) || (expires == 0 && date.Before(now.Add(-SIGNATURE_MAX_SKEW))) {
+		return ErrRequestTimeTooSkewed
+	}
+	return nil
+}
+
+func verifySignatureV4(request *http.Request, secretAccessKey string, now time.Time) error {
+	signature, err := signatureV4Of(request)
+	if err != nil {
+		return err
+	}
+	if err := checkSignatureDate(signature.date, signature.expires, now); err != nil {
+		return err
+	}
+
+	canonicalHeaders := make([]string, len(signature.signedHeaders))
+	for i, name := range signature.signedHeaders {
+		canonicalHeaders[i] = name + ":" + canonicalHeaderOf(request, name) + "\n"
+	}
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		canonicalUriOf(request.URL.Path),
+		canonicalQueryOf(request.URL.RawQuery, signature.expires > 0),
+		strings.Join(canonicalHeaders, ""),
+		strings.Join(signature.signedHeaders, ";"),
+		signature.payloadHash,
+	}, "\n")
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		SIGNATURE_V4_ALGORITHM,
+		signature.date.Format(SIGNATURE_V4_DATE_FORMAT),
+		strings.Join(signature.scope, "/"),
+		hex.EncodeToString(canonicalHash[:]),
+	}, "\n")
+
+	signingKey := []byte("AWS4" + secretAccessKey)
+	for _, part := range signature.scope {
+		signingKey = utils.Mac256(signingKey, []byte(part))
+	}
+	expected := hex.EncodeToString(utils.Mac256(signingKey, []byte(stringToSign)))
+	if !hmac.Equal([]byte(expected), []byte(strings.ToLower(signature.signature))) {
+		return ErrSignatureDoesNotMatch
+	}
+
+	// The signed payload hash binds the body to the signature, the body is
+	// verified while it is read (the streamed payloads are not supported)
+	if signature.payloadHash == UNSIGNED_PAYLOAD {
+		return nil
+	}
+	payloadHash, err := hex.DecodeString(signature.payloadHash)
+	if err != nil || len(payloadHash) != sha256.Size {
+		return ErrMalformedAuthorization
+	}
+	request.Body = &payloadVerifier{ReadCloser: request.Body, hash: sha256.New(), expected: payloadHash}
+	return nil
+}
+
+// payloadVerifier fails the read of the body which hash differs from the
+// signed x-amz-content-sha256, the hash is compared once the body is read to
+// the end, so the object is not stored.
+type payloadVerifier struct {
+	io.ReadCloser
+	hash     hash.Hash
+	expected []byte
+}
+
+func (verifier *payloadVerifier) Read(buffer []byte) (int, error) {
+	count, err := verifier.ReadCloser.Read(buffer)
+	verifier.hash.Write(buffer[:count])
+	if err == io.EOF && !hmac.Equal(verifier.hash.Sum(nil), verifier.expected) {
+		return count, ErrContentSHA256Mismatch
+	}
+	return count, err
+}
+
+func canonicalUriOf(path string) string {
+	if path == "" {
+		return "/"
+	}
+	return utils.UriEncode(path, false)
+}
+
+// canonicalQueryOf returns the sorted and encoded query parameters, the
+// signature of the presigned url is not signed.
+func canonicalQueryOf(rawQuery string, presigned bool) string {
+	type queryParam struct {
+		key   string
+		value string
+	}
+	queryParams := make([]queryParam, 0)
+	for _, rawParam := range strings.Split(rawQuery, "&") {
+		if rawParam == "" {
+			continue
+		}
+		rawKey, rawValue, _ := strings.Cut(rawParam, "=")
+		key, err := url.QueryUnescape(rawKey)
+		if err != nil {
+			key = rawKey
+		}
+		value, err := url.QueryUnescape(rawValue)
+		if err != nil {
+			value = rawValue
+		}
+		if presigned && key == "X-Amz-Signature" {
+			continue
+		}
+		queryParams = append(queryParams, queryParam{key: utils.UriEncode(key, true), value: utils.UriEncode(value, true)})
+	}
+	sort.Slice(queryParams, func(i, j int) bool {
+		if queryParams[i].key != queryParams[j].key {
+			return queryParams[i].key < queryParams[j].key
+		}
+		return queryParams[i].value < queryParams[j].value
+	})
+
+	canonical := make([]string, len(queryParams))
+	for i, queryParam := range queryParams {
+		canonical[i] = queryParam.key + "=" + queryParam.value
+	}
+	return strings.Join(canonical, "&")
+}
+
+// canonicalHeaderOf returns the values of the header joined by commas with
+// their spaces collapsed, the host is taken from the request.
+func canonicalHeaderOf(request *http.Request, name string) string {
+	values := slices.Clone(request.Header.Values(name))
+	switch {
+	case name == "host":
+		values = []string{request.Host}
+	case name == "content-length" && len(values) == 0 && request.ContentLength >= 0:
+		values = []string{strconv.FormatInt(request.ContentLength, 10)}
+	}
+	for i, value := range values {
+		values[i] = strings.Join(strings.Fields(value), " ")
+	}
+	return strings.Join(values, ",")
+}
+
+func verifySignatureV2(request *http.Request, secretAccessKey string, now time.Time) error {
+	var signature, date string
+	if authorization, found := strings.CutPrefix(request.Header.Get("Authorization"), "AWS "); found {
+		_, signature, _ = strings.Cut(authorization, ":")
+		// The date is not signed when it is given by x-amz-date, the
+		// x-amz-date header is signed with the other x-amz- headers
+		requestDate := request.Header.Get("x-amz-date")
+		if requestDate == "" {
+			date = request.Header.Get("Date")
+			requestDate = date
+		}
+		parsedDate, err := http.ParseTime(requestDate)
+		if err != nil {
+			parsedDate, err = time.Parse(SIGNATURE_V4_DATE_FORMAT, requestDate)
+		}
+		if err != nil {
+			return ErrMalformedAuthorization
+		}
+		if err := checkSignatureDate(parsedDate, 0, now); err != nil {
+			return err
+		}
+	} else {
+		query := request.URL.Query()
+		signature, date = query.Get("Signature"), query.Get("Expires")
+		expires, err := strconv.ParseInt(date, 10, 64)
+		if err != nil {
+			return ErrMalformedAuthorization
+		}
+		if now.Unix() > expires {
+			return ErrRequestExpired
+		}
+	}
+	if signature == "" {
+		return ErrMalformedAuthorization
+	}
+
+	stringToSign := request.Method + "\n" +
+		request.Header.Get("Content-MD5") + "\n" +
+		request.Header.Get("Content-Type") + "\n" +
+		date + "\n" +
+		canonicalAmzHeadersOf(request) +
+		canonicalResourceOf(request)
+	mac := hmac.New(sha1.New, []byte(secretAccessKey))
+	mac.Write([]byte(stringToSign))
+	expected := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return ErrSignatureDoesNotMatch
+	}
+	return nil
+}
+
+// canonicalAmzHeadersOf returns the x-amz- headers signed by the signature
+// version 2, one header per line sorted by the names.
+func canonicalAmzHeadersOf(request *http.Request) string {
+	names := make([]string, 0)
+	for name := range request.Header {
+		if strings.HasPrefix(strings.ToLower(name), "x-amz-") {
+			names = append(names, name)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return strings.ToLower(names[i]) < strings.ToLower(names[j])
+	})
+
+	var builder strings.Builder
+	for _, name := range names {
+		values := make([]string, 0)
+		for _, value := range request.Header.Values(name) {
+			values = append(values, strings.TrimSpace(value))
+		}
+		builder.WriteString(strings.ToLower(name) + ":" + strings.Join(values, ",") + "\n")
+	}
+	return builder.String()
+}
+
+// canonicalResourceOf returns the path of the request with the subresources
+// signed by the signature version 2.
+func canonicalResourceOf(request *http.Request) string {
+	query := request.URL.Query()
+	subresources := make([]string, 0)
+	for _, name := range SIGNATURE_V2_SUBRESOURCES {
+		if !query.Has(name) {
+			continue
+		}
+		if value := query.Get(name); value != "" {
+			subresources = append(subresources, name+"="+value)
+		} else {
+			subresources = append(subresources, name)
+		}
+	}
+	if len(subresources) == 0 {
+		return request.URL.EscapedPath()
+	}
+	return request.URL.EscapedPath() + "?" + strings.Join(subresources, "&")
+}
@@ -0,0 +1,279 @@
+/**
+ * Author: Vanya Usalko <ivict@rambler.ru>
+ * File: statistics.go
+ */
+
+package services
+
+import (
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/usalko/s2d3/models"
+)
+
+// STATISTICS_API_PATH is the path of the statistics api in the admin
+// namespace.
+const STATISTICS_API_PATH = ADMIN_PATH + "api/"
+
+// The request rates are counted per STATISTICS_RATE_INTERVAL for the last
+// STATISTICS_RATE_SAMPLES intervals.
+const STATISTICS_RATE_INTERVAL = time.Minute
+const STATISTICS_RATE_SAMPLES = 60
+
+// The access keys are active when they are seen within
+// STATISTICS_ACTIVE_PERIOD.
+const STATISTICS_ACTIVE_PERIOD = time.Hour
+
+// The keys beyond MAX_STATISTICS_KEYS and the access keys beyond
+// MAX_STATISTICS_ACCESS_KEYS are not counted until the service is restarted.
+const MAX_STATISTICS_KEYS = 10000
+const MAX_STATISTICS_ACCESS_KEYS = 1000
+const DEFAULT_STATISTICS_TOP_KEYS = 10
+
+type keyCounter struct {
+	requests   int64
+	sentBytes  int64
+	lastAccess time.Time
+}
+
+type accessKeyCounter struct {
+	requests  int64
+	errors    int64
+	firstSeen time.Time
+	lastSeen  time.Time
+}
+
+// The statistics of the requests are kept in memory since the service start.
+var rateSamples = []models.RequestRateSample{}
+var keyCounters = map[[2]string]*keyCounter{}
+var accessKeyCounters = map[string]*accessKeyCounter{}
+var statisticsLock sync.Mutex
+
+// observeStatistics accounts the served request in the request rates, the
+// keys and the access keys.
+func observeStatistics(entry *accessLogEntry) {
+	statisticsLock.Lock()
+	defer statisticsLock.Unlock()
+
+	failed := entry.status >= http.StatusBadRequest
+	interval := entry.time.Truncate(STATISTICS_RATE_INTERVAL)
+	if len(rateSamples) == 0 || rateSamples[len(rateSamples)-1].Time.Before(interval) {
+		rateSamples = append(rateSamples, models.RequestRateSample{Time: interval})
+		if len(rateSamples) > STATISTICS_RATE_SAMPLES {
+			rateSamples = rateSamples[len(rateSamples)-STATISTICS_RATE_SAMPLES:]
+		}
+	}
+	sample := &rateSamples[len(rateSamples)-1]
+	sample.Requests++
+	if failed {
+		sample.Errors++
+	}
+	sample.ReceivedBytes += entry.bytesReceived
+	sample.SentBytes += entry.bytesSent
+
+	if entry.bucketName != "" && entry.objectKey != "" && !failed {
+		key := [2]string{entry.bucketName, entry.objectKey}
+		counter, exists := keyCounters[key]
+		if !exists && len(keyCounters) < MAX_STATISTICS_KEYS {
+			counter = &keyCounter{}
+			keyCounters[key] = counter
+		}
+		if counter != nil {
+			counter.requests++
+			counter.sentBytes += entry.bytesSent
+			counter.lastAccess = entry.time
+		}
+	}
+
+	if entry.accessKey != "" {
+		counter, exists := accessKeyCounters[entry.accessKey]
+		if !exists && len(accessKeyCounters) < MAX_STATISTICS_ACCESS_KEYS {
+			counter = &accessKeyCounter{firstSeen: entry.time}
+			accessKeyCounters[entry.accessKey] = counter
+		}
+		if counter != nil {
+			counter.requests++
+			if failed {
+				counter.errors++
+			}
+			counter.lastSeen = entry.time
+		}
+	}
+}
+
+// requestRates returns the samples of the last STATISTICS_RATE_SAMPLES
+// intervals up to now, the intervals without requests are zero.
+func requestRates(now time.Time) models.RequestRates {
+	statisticsLock.Lock()
+	defer statisticsLock.Unlock()
+
+	rates := models.RequestRates{
+		IntervalSeconds: int64(STATISTICS_RATE_INTERVAL / time.Second),
+		Samples:         make([]models.RequestRateSample, 0, STATISTICS_RATE_SAMPLES),
+	}
+	interval := now.Truncate(STATISTICS_RATE_INTERVAL).Add(-(STATISTICS_RATE_SAMPLES - 1) * STATISTICS_RATE_INTERVAL)
+	index := 0
+	for i := 0; i < STATISTICS_RATE_SAMPLES; i++ {
+		for index < len(rateSamples) && rateSamples[index].Time.Before(interval) {
+			index++
+		}
+		if index < len(rateSamples) && rateSamples[index].Time.Equal(interval) {
+			rates.Samples = append(rates.Samples, rateSamples[index])
+		} else {
+			rates.Samples = append(rates.Samples, models.RequestRateSample{Time: interval})
+		}
+		interval = interval.Add(STATISTICS_RATE_INTERVAL)
+	}
+	return rates
+}
+
+// topKeys returns the most requested keys, the keys of the same count are
+// ordered by bucket and key.
+func topKeys(count int) []models.KeyStatistics {
+	statisticsLock.Lock()
+	defer statisticsLock.Unlock()
+
+	keys := make([]models.KeyStatistics, 0, len(keyCounters))
+	for key, counter := range keyCounters {
+		keys = append(keys, models.KeyStatistics{
+			Bucket:     key[0],
+			Key:        key[1],
+			Requests:   counter.requests,
+			SentBytes:  counter.sentBytes,
+			LastAccess: counter.lastAccess,
+		})
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Requests != keys[j].Requests {
+			return keys[i].Requests > keys[j].Requests
+		}
+		if keys[i].Bucket != keys[j].Bucket {
+			return keys[i].Bucket < keys[j].Bucket
+		}
+		return keys[i].Key < keys[j].Key
+	})
+	return keys[:min(count, len(keys))]
+}
+
+// activeAccessKeys returns the access keys seen within
+// STATISTICS_ACTIVE_PERIOD, the recently seen first.
+func activeAccessKeys(now time.Time) []models.AccessKeyStatistics {
+	statisticsLock.Lock()
+	defer statisticsLock.Unlock()
+
+	accessKeys := make([]models.AccessKeyStatistics, 0, len(accessKeyCounters))
+	for accessKey, counter := range accessKeyCounters {
+		if now.Sub(counter.lastSeen) > STATISTICS_ACTIVE_PERIOD {
+			continue
+		}
+		accessKeys = append(accessKeys, models.AccessKeyStatistics{
+			AccessKey: accessKey,
+			Requests:  counter.requests,
+			Errors:    counter.errors,
+			FirstSeen: counter.firstSeen,
+			LastSeen:  counter.lastSeen,
+		})
+	}
+	sort.Slice(accessKeys, func(i, j int) bool {
+		if !accessKeys[i].LastSeen.Equal(accessKeys[j].LastSeen) {
+			return accessKeys[i].LastSeen.After(accessKeys[j].LastSeen)
+		}
+		return accessKeys[i].AccessKey < accessKeys[j].AccessKey
+	})
+	return accessKeys
+}
+
+// bucketStatistics returns the usage, the traffic and the in-flight multipart
+// uploads of the buckets.
+func bucketStatistics(storage *Storage) ([]models.BucketStatistics, error) {
+	buckets, err := storage.ListBuckets()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]models.BucketStatistics, 0, len(buckets))
+	for _, bucket := range buckets {
+		usage, err := storage.GetQuotaUsage(bucket.Name)
+		if err != nil {
+			return nil, err
+		}
+		uploads, err := storage.ListUploads(bucket.Name)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, models.BucketStatistics{
+			Name:         bucket.Name,
+			CreationDate: bucket.CreationDate,
+			Objects:      usage.Objects,
+			Bytes:        usage.Bytes,
+			Uploads:      len(uploads),
+		})
+	}
+
+	metricsLock.Lock()
+	defer metricsLock.Unlock()
+	for i := range result {
+		result[i].ReceivedBytes = receivedBytes[result[i].Name]
+		result[i].SentBytes = sentBytes[result[i].Name]
+	}
+	return result, nil
+}
+
+// StatisticsApi serves the statistics of the service as json documents:
+// buckets, requests, keys (the top keys, ?top=N) and access-keys (the active
+// access keys), the api path itself serves all of them. The statistics are
+// served by the admin token as the admin api is.
+func StatisticsApi(writer http.ResponseWriter, request *http.Request) {
+	if !checkAdminToken(writer, request) {
+		return
+	}
+	if request.Method != "GET" && request.Method != "HEAD" {
+		writeJsonError(writer, http.StatusMethodNotAllowed, "the statistics are read only")
+		return
+	}
+	top := DEFAULT_STATISTICS_TOP_KEYS
+	if value := request.URL.Query().Get("top"); value != "" {
+		var err error
+		top, err = strconv.Atoi(value)
+		if err != nil || top < 0 {
+			writeJsonError(writer, http.StatusBadRequest, "invalid top: "+value)
+			return
+		}
+	}
+	storage := storageOf(request)
+	now := time.Now()
+
+	var result any
+	var err error
+	switch name := path.Base(strings.TrimSuffix(request.URL.Path, "/")); name {
+	case "buckets":
+		result, err = bucketStatistics(&storage)
+	case "requests":
+		result = requestRates(now)
+	case "keys":
+		result = topKeys(top)
+	case "access-keys":
+		result = activeAccessKeys(now)
+	case path.Base(strings.TrimSuffix(STATISTICS_API_PATH, "/")):
+		statistics := &models.Statistics{
+			Requests:   requestRates(now),
+			TopKeys:    topKeys(top),
+			AccessKeys: activeAccessKeys(now),
+		}
+		statistics.Buckets, err = bucketStatistics(&storage)
+		result = statistics
+	default:
+		writeJsonError(writer, http.StatusNotFound, "no such statistics: "+name)
+		return
+	}
+	if err != nil {
+		writeJsonError(writer, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJson(writer, http.StatusOK, result)
+}
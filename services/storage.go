@@ -4,135 +4,136 @@ import (
 	"fmt"
 	"io"
 	"io/fs"
-	"os"
-	"strings"
+	"time"
 
-	"github.com/usalko/s2d3/utils"
+	"github.com/usalko/s2d3/models"
 )
 
-var BREAKPOINTS = [...]utils.SizeInBytes{
-	4096,
-	16384,
-	65536,
-	4194304,
-	16777216,
-	4294967296,
-}
-var BREAKPOINTS_DELTA = [...]utils.SizeInBytes{
-	0,
-	4096,
-	16384,
-	65536,
-	4194304,
-	16777216,
-}
-
-type Storage struct {
-	RootFolder string
-}
-
-func findBreakpoint(dataSize int) (int, int) {
-	breakpointIndex := 0
-	countOfSegments := 1
-	for index, breakpoint := range BREAKPOINTS {
-		if int(breakpoint) > dataSize {
-			if index > 0 {
-				breakpointIndex = index - 1
-				countOfSegments = dataSize / int(BREAKPOINTS[breakpointIndex])
-			}
-			break
-		}
-		if int(breakpoint+BREAKPOINTS_DELTA[index]) > dataSize {
-			breakpointIndex = index
-			countOfSegments = 1
-			break
-		}
-	}
-	return breakpointIndex, countOfSegments
-}
+// SYSTEM_FOLDER is the folder inside RootFolder reserved for the service data,
+// it is never listed as a bucket or as an object.
+const SYSTEM_FOLDER = ".s2d3"
 
-func (storage *Storage) Init() {
-	os.Mkdir(storage.RootFolder, fs.ModeDir|0775)
-}
-
-func (storage *Storage) CheckUpload(bucketName string, objectKey string, suffix string, uploadDone UploadDone) error {
-	file, err := os.Open(strings.Join([]string{
-		storage.RootFolder,
-		bucketName,
-		objectKey,
-	}, "/"))
-	defer file.Close()
+// TEMPORARY_FILE_SUFFIX ends the names of the dot files being written in place
+// of the objects, they are never listed as the objects.
+const TEMPORARY_FILE_SUFFIX = ".tmp"
 
-	if err != nil {
-		return err
-	}
-	return nil
-}
+// RECORD_FILE_MODE is the mode of the records and the metadata files, they
+// keep the keys (the master key, the wrapped data keys, the access keys) and
+// are readable by the server user only.
+const RECORD_FILE_MODE = 0600
 
-func (storage *Storage) PushData(bucketName string, objectKey string, suffix string, reader io.ReadCloser) error {
-	content, err := io.ReadAll(reader)
-	if err != nil {
-		return err
-	}
+// KEY_RECORDS are the records keeping the keys of the server.
+var KEY_RECORDS = []string{MASTER_KEY_FILE, ACCESS_KEYS_RECORD}
 
-	breakpointIndex, countOfSegments := findBreakpoint(len(content))
-	segmentSize := BREAKPOINTS[breakpointIndex]
-	segmentDelta := BREAKPOINTS_DELTA[breakpointIndex]
+// OBJECT_FILE_MODE is the mode of the object files.
+const OBJECT_FILE_MODE = 0644
 
-	objectParentPath := strings.Join([]string{
-		storage.RootFolder,
-		bucketName,
-	}, "/")
-	err = os.MkdirAll(objectParentPath, fs.ModeDir|0775)
-	if err != nil {
-		return err
-	}
+const NULL_VERSION_ID = "null"
 
-	if countOfSegments == 1 {
-		// Save object as single file
+// Storage implements the S3 features (object lock, encryption, compression,
+// notifications, ...) on top of the backend keeping the data.
+type Storage struct {
+	Backend
+	// The master key of the server side encryption, the key record of the
+	// backend is used by default
+	MasterKeyFile string
+	// GenerateMasterKey allows to generate the missing key record of the
+	// backend, the SSE-S3 is refused without the master key otherwise
+	GenerateMasterKey bool
+	// NotificationSignal wakes up the notification worker when an event is
+	// queued, the worker waits for the next interval without it
+	NotificationSignal chan struct{}
+	// AccessKeys keeps the access keys record between the requests, the
+	// record is read by every check without it
+	AccessKeys *AccessKeyCache
+}
 
-		err = os.WriteFile(strings.Join([]string{
-			objectParentPath,
-			objectKey,
-		}, "/"), content, 0644)
-		if err != nil {
-			return err
-		}
+// NewStorage returns the storage keeping the data in the root folder, as the
+// storage of the previous versions did.
+func NewStorage(rootFolder string) *Storage {
+	return &Storage{Backend: &FileSystemBackend{RootFolder: rootFolder}}
+}
 
-	} else if countOfSegments > 1 {
-		println(segmentSize)
-		println(segmentDelta)
-		return fmt.Errorf("not implemented for countOfSegments %d", countOfSegments)
-	} else {
-		return fmt.Errorf("invalid count of segments for content length %d", len(content))
+// DeleteObjectVersion removes one version of the object. Only the "null"
+// version exists for objects stored without history.
+func (storage *Storage) DeleteObjectVersion(bucketName string, objectKey string, versionId string) error {
+	if versionId != NULL_VERSION_ID {
+		return fs.ErrNotExist
 	}
-	return nil
+	return storage.Delete(bucketName, objectKey)
 }
 
+// GetData returns the object data as it is stored.
 func (storage *Storage) GetData(bucketName string, objectKey string, suffix string) ([]byte, error) {
-	file, err := os.Open(strings.Join([]string{
-		storage.RootFolder,
-		bucketName,
-		objectKey,
-	}, "/"))
+	reader, _, err := storage.Get(bucketName, objectKey)
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
+	defer reader.Close()
+
+	return io.ReadAll(reader)
+}
+
+// ObjectReader reads the object content, the encrypted objects are decrypted
+// and the compressed ones are inflated.
+type ObjectReader struct {
+	io.ReadSeekCloser
+	Metadata     *models.ObjectMetadata
+	ETag         string
+	LastModified time.Time
+}
 
-	fileInfo, err := file.Stat()
+func (storage *Storage) OpenObject(bucketName string, objectKey string, sse *ServerSideEncryption) (*ObjectReader, error) {
+	reader, info, err := storage.Get(bucketName, objectKey)
 	if err != nil {
 		return nil, err
 	}
+	metadata := info.Metadata
 
-	if fileInfo.IsDir() {
-		return nil, fmt.Errorf("can't open object %s/%s cause not implemented", bucketName, objectKey)
+	objectReader := &ObjectReader{
+		ReadSeekCloser: reader,
+		Metadata:       metadata,
+		ETag:           info.ETag,
+		LastModified:   info.LastModified,
+	}
+	if metadata.Encryption != nil {
+		dataKey, err := storage.dataKeyOf(metadata.Encryption, sse)
+		if err != nil {
+			reader.Close()
+			return nil, err
+		}
+		objectReader.ReadSeekCloser, err = newDecryptingReader(reader, dataKey)
+		if err != nil {
+			reader.Close()
+			return nil, err
+		}
+	}
+	if metadata.Compression != "" {
+		if metadata.Compression != models.CompressionDeflate {
+			reader.Close()
+			return nil, fmt.Errorf("the compression %s is not supported", metadata.Compression)
+		}
+		objectReader.ReadSeekCloser, err = newDecompressingReader(objectReader.ReadSeekCloser)
+		if err != nil {
+			reader.Close()
+			return nil, err
+		}
 	}
+	return objectReader, nil
+}
 
-	data, err := io.ReadAll(file)
+// ListObjects returns the latest versions of the bucket objects.
+func (storage *Storage) ListObjects(bucketName string) ([]models.ObjectVersion, error) {
+	versions, err := storage.List(bucketName)
 	if err != nil {
 		return nil, err
 	}
 
-	return data, nil
+	objects := make([]models.ObjectVersion, 0, len(versions))
+	for _, version := range versions {
+		if version.IsLatest && !version.IsDeleteMarker {
+			objects = append(objects, version)
+		}
+	}
+	return objects, nil
 }
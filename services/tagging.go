@@ -0,0 +1,180 @@
+/**
+ * Author: Vanya Usalko <ivict@rambler.ru>
+ * File: tagging.go
+ */
+
+package services
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/usalko/s2d3/models"
+)
+
+const TAGGING_CONFIG = "tagging"
+const TAGGING_HEADER = "x-amz-tagging"
+const TAGGING_COUNT_HEADER = "x-amz-tagging-count"
+
+const CodeNoSuchTagSet = "NoSuchTagSet"
+const CodeInvalidTag = "InvalidTag"
+
+func tagsOf(tagSet []models.Tag, maxTags int) (map[string]string, error) {
+	if len(tagSet) > maxTags {
+		return nil, fmt.Errorf("the tag set can't have more than %d tags", maxTags)
+	}
+
+	tags := make(map[string]string, len(tagSet))
+	for _, tag := range tagSet {
+		if tag.Key == "" || utf8.RuneCountInString(tag.Key) > 128 {
+			return nil, fmt.Errorf("the tag key must be from 1 to 128 characters long")
+		}
+		if utf8.RuneCountInString(tag.Value) > 256 {
+			return nil, fmt.Errorf("the tag value of %s is longer than 256 characters", tag.Key)
+		}
+		if _, exists := tags[tag.Key]; exists {
+			return nil, fmt.Errorf("the tag key %s is not unique", tag.Key)
+		}
+		tags[tag.Key] = tag.Value
+	}
+	return tags, nil
+}
+
+// tagsOfHeader parses the url encoded x-amz-tagging header.
+func tagsOfHeader(request *http.Request) (map[string]string, error) {
+	header := request.Header.Get(TAGGING_HEADER)
+	if header == "" {
+		return nil, nil
+	}
+
+	parsedHeader, err := url.ParseQuery(header)
+	if err != nil {
+		return nil, fmt.Errorf("the %s header is not url encoded: %s", TAGGING_HEADER, err)
+	}
+	tagSet := make([]models.Tag, 0, len(parsedHeader))
+	for key, values := range parsedHeader {
+		if len(values) > 1 {
+			return nil, fmt.Errorf("the tag key %s is not unique", key)
+		}
+		tagSet = append(tagSet, models.Tag{Key: key, Value: values[0]})
+	}
+	return tagsOf(tagSet, models.MaxObjectTags)
+}
+
+func taggingOf(tags map[string]string) *models.Tagging {
+	tagging := &models.Tagging{
+		TagSet: make([]models.Tag, 0, len(tags)),
+	}
+	for key, value := range tags {
+		tagging.TagSet = append(tagging.TagSet, models.Tag{Key: key, Value: value})
+	}
+	sort.Slice(tagging.TagSet, func(i, j int) bool {
+		return tagging.TagSet[i].Key < tagging.TagSet[j].Key
+	})
+	return tagging
+}
+
+func setTaggingCount(writer http.ResponseWriter, tags map[string]string) {
+	if len(tags) > 0 {
+		writer.Header().Set(TAGGING_COUNT_HEADER, strconv.Itoa(len(tags)))
+	}
+}
+
+func writeTaggingError(writer http.ResponseWriter, err error) {
+	if errors.Is(err, ErrNoSuchKey) {
+		writeError(writer, http.StatusNotFound, CodeNoSuchKey, err.Error())
+		return
+	}
+	writeBucketConfigError(writer, err, CodeNoSuchTagSet)
+}
+
+// Tagging implements PUT, GET and DELETE of the object tags and, for the
+// requests without an object key, of the bucket tags.
+func Tagging(writer http.ResponseWriter, request *http.Request) {
+	bucketName, objectKey := bucketNameAndObjectKey(request.URL.Path, request.Context().Value(KeyUrlContext).(string))
+	storage := storageOf(request)
+
+	maxTags := models.MaxObjectTags
+	if objectKey == "" {
+		maxTags = models.MaxBucketTags
+	}
+
+	switch request.Method {
+
+	case "GET":
+		if objectKey == "" {
+			data, err := storage.GetBucketConfig(bucketName, TAGGING_CONFIG)
+			if err != nil {
+				writeTaggingError(writer, err)
+				return
+			}
+			writer.Header().Set("Content-Type", "application/xml")
+			writer.Write(data)
+			return
+		}
+
+		tags, err := storage.GetObjectTags(bucketName, objectKey)
+		if err != nil {
+			writeTaggingError(writer, err)
+			return
+		}
+		setTaggingCount(writer, tags)
+		writeXml(writer, taggingOf(tags))
+
+	case "PUT":
+		body, err := io.ReadAll(request.Body)
+		if err != nil {
+			writeError(writer, http.StatusBadRequest, CodeInvalidArgument, err.Error())
+			return
+		}
+		tagging := models.Tagging{}
+		if err := xml.Unmarshal(body, &tagging); err != nil {
+			writeError(writer, http.StatusBadRequest, CodeMalformedXML, err.Error())
+			return
+		}
+		tags, err := tagsOf(tagging.TagSet, maxTags)
+		if err != nil {
+			writeError(writer, http.StatusBadRequest, CodeInvalidTag, err.Error())
+			return
+		}
+
+		if objectKey == "" {
+			data, err := xml.Marshal(taggingOf(tags))
+			if err == nil {
+				err = storage.PutBucketConfig(bucketName, TAGGING_CONFIG, data)
+			}
+			if err != nil {
+				writeTaggingError(writer, err)
+				return
+			}
+			writer.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		if err := storage.PutObjectTags(bucketName, objectKey, tags); err != nil {
+			writeTaggingError(writer, err)
+			return
+		}
+
+	case "DELETE":
+		var err error
+		if objectKey == "" {
+			err = storage.DeleteBucketConfig(bucketName, TAGGING_CONFIG)
+		} else {
+			err = storage.PutObjectTags(bucketName, objectKey, nil)
+		}
+		if err != nil {
+			writeTaggingError(writer, err)
+			return
+		}
+		writer.WriteHeader(http.StatusNoContent)
+
+	}
+}
@@ -9,11 +9,13 @@ import (
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
 	"math/big"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
@@ -36,6 +38,9 @@ type UploadDone struct {
 	Parts   []models.XmlPart `xml:"Part"`
 }
 
+// bucketNameAndObjectKey splits the request path (without the url context)
+// into the bucket name and the object key. The path without the key (e.g.
+// /bucket or /bucket?versions) is the bucket path, its key is empty.
 func bucketNameAndObjectKey(path string, urlContext string) (string, string) {
 	bucketName, objectKey, exists := strings.Cut(strings.TrimPrefix(
 		strings.TrimPrefix(
@@ -46,7 +51,52 @@ func bucketNameAndObjectKey(path string, urlContext string) (string, string) {
 	if exists {
 		return bucketName, objectKey
 	}
-	return "", path
+	return bucketName, ""
+}
+
+// newUploadSuffix returns the suffix of the new multipart upload, the upload
+// id is the path of the object with the suffix.
+func newUploadSuffix() string {
+	return hex.EncodeToString(new(big.Int).SetInt64(time.Now().UnixMicro()).Bytes())
+}
+
+type UploadResult struct {
+	XMLName  xml.Name `xml:"CompleteMultipartUploadResult"`
+	Location string   `xml:"Location"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+}
+
+func writeUploadError(writer http.ResponseWriter, err error) {
+	if errors.Is(err, ErrNoSuchUpload) {
+		writeError(writer, http.StatusNotFound, CodeNoSuchUpload, err.Error())
+		return
+	}
+	if errors.Is(err, ErrInvalidPart) {
+		writeError(writer, http.StatusBadRequest, CodeInvalidPart, err.Error())
+		return
+	}
+	if errors.Is(err, ErrMissingParts) {
+		writeError(writer, http.StatusBadRequest, CodeMalformedXML, err.Error())
+		return
+	}
+	if errors.Is(err, ErrCustomerKeyRequired) || errors.Is(err, ErrInvalidEncryptionKey) || errors.Is(err, ErrMasterKeyRequired) {
+		writeEncryptionError(writer, err)
+		return
+	}
+	if errors.Is(err, ErrQuotaExceeded) {
+		writeError(writer, http.StatusForbidden, CodeQuotaExceeded, err.Error())
+		return
+	}
+	if errors.Is(err, ErrContentSHA256Mismatch) {
+		writeError(writer, http.StatusBadRequest, CodeXAmzContentSHA256Mismatch, err.Error())
+		return
+	}
+	if errors.Is(err, ErrInsufficientStorage) {
+		writeDiskSpaceError(writer, err)
+		return
+	}
+	writeError(writer, http.StatusInternalServerError, CodeInternalError, err.Error())
 }
 
 func Upload(writer http.ResponseWriter, request *http.Request) error {
@@ -64,17 +114,22 @@ func Upload(writer http.ResponseWriter, request *http.Request) error {
 			for _, encodedUploadId := range uploadIds {
 				uploadId, err := base64.StdEncoding.DecodeString(encodedUploadId)
 				if err != nil {
+					writeUploadError(writer, ErrNoSuchUpload)
 					return err
 				}
 
 				path, suffix, found := strings.Cut(string(uploadId), ":")
 				if !found {
-					return err
+					writeUploadError(writer, ErrNoSuchUpload)
+					return ErrNoSuchUpload
 				}
 				bucketName, objectName := bucketNameAndObjectKey(path, request.Context().Value(KeyUrlContext).(string))
 
-				storage := Storage{
-					RootFolder: request.Context().Value(KeyDataFolder).(string),
+				storage := storageOf(request)
+				sse, err := serverSideEncryptionOf(request)
+				if err != nil {
+					writeError(writer, http.StatusBadRequest, CodeInvalidArgument, err.Error())
+					return err
 				}
 
 				body, err := io.ReadAll(request.Body)
@@ -83,16 +138,35 @@ func Upload(writer http.ResponseWriter, request *http.Request) error {
 				}
 
 				payload := UploadDone{}
-				err = xml.Unmarshal(body, &payload)
+				if len(body) > 0 {
+					err = xml.Unmarshal(body, &payload)
+					if err != nil {
+						writeError(writer, http.StatusBadRequest, CodeMalformedXML, err.Error())
+						return err
+					}
+				}
+
+				err = storage.CheckObjectLock(bucketName, objectName, bypassGovernance(request), time.Now())
 				if err != nil {
+					writeObjectLockError(writer, err)
 					return err
 				}
 
-				err = storage.CheckUpload(bucketName, objectName, suffix, payload)
+				err = storage.CompleteUpload(bucketName, objectName, suffix, payload, sse)
 				if err != nil {
+					writeUploadError(writer, err)
 					return err
 				}
+				if metadata, err := storage.GetMetadata(bucketName, objectName); err == nil {
+					setEncryptionHeaders(writer, metadata.Encryption)
+				}
 
+				notify(request, &storage, bucketName, objectName, EventObjectCreatedCompleteMultipartUpload)
+				writeXml(writer, &UploadResult{
+					Location: path,
+					Bucket:   bucketName,
+					Key:      objectName,
+				})
 			}
 		} else {
 			path, err := url.QueryUnescape(request.URL.Path)
@@ -101,7 +175,36 @@ func Upload(writer http.ResponseWriter, request *http.Request) error {
 			}
 			bucketName, objectKey := bucketNameAndObjectKey(path, request.Context().Value(KeyUrlContext).(string))
 
-			uploadId := strings.Join([]string{path, hex.EncodeToString(new(big.Int).SetInt64(time.Now().UnixMicro()).Bytes())}, ":")
+			suffix := newUploadSuffix()
+			uploadId := strings.Join([]string{path, suffix}, ":")
+
+			tags, err := tagsOfHeader(request)
+			if err != nil {
+				writeError(writer, http.StatusBadRequest, CodeInvalidTag, err.Error())
+				return err
+			}
+
+			sse, err := serverSideEncryptionOf(request)
+			if err != nil {
+				writeError(writer, http.StatusBadRequest, CodeInvalidArgument, err.Error())
+				return err
+			}
+
+			storage := storageOf(request)
+			metadata := &models.ObjectMetadata{
+				Tags:        tags,
+				ContentType: request.Header.Get("Content-Type"),
+			}
+			if err := storage.objectLockOfHeaders(request, bucketName, metadata, time.Now()); err != nil {
+				writeObjectLockHeadersError(writer, err)
+				return err
+			}
+			err = storage.CreateUpload(bucketName, objectKey, suffix, metadata, sse)
+			if err != nil {
+				writeUploadError(writer, err)
+				return err
+			}
+			setEncryptionHeaders(writer, metadata.Encryption)
 
 			response := &UploadStart{
 				Bucket:   bucketName,
@@ -126,34 +229,72 @@ func Upload(writer http.ResponseWriter, request *http.Request) error {
 		uploadIds, exists := parsedQuery["uploadId"]
 
 		if exists {
+			partNumber := 1
+			if parsedQuery.Has("partNumber") {
+				partNumber, err = strconv.Atoi(parsedQuery.Get("partNumber"))
+				if err != nil || partNumber < 1 || partNumber > 10000 {
+					writeError(writer, http.StatusBadRequest, CodeInvalidArgument, "part number must be an integer between 1 and 10000")
+					return err
+				}
+			}
+
 			for _, encodedUploadId := range uploadIds {
 				uploadId, err := base64.StdEncoding.DecodeString(encodedUploadId)
 				if err != nil {
+					writeUploadError(writer, ErrNoSuchUpload)
 					return err
 				}
 
 				path, suffix, found := strings.Cut(string(uploadId), ":")
 				if !found {
-					return err
+					writeUploadError(writer, ErrNoSuchUpload)
+					return ErrNoSuchUpload
 				}
 				bucketName, objectName := bucketNameAndObjectKey(path, request.Context().Value(KeyUrlContext).(string))
 
-				storage := Storage{
-					RootFolder: request.Context().Value(KeyDataFolder).(string),
+				storage := storageOf(request)
+				sse, err := serverSideEncryptionOf(request)
+				if err != nil {
+					writeError(writer, http.StatusBadRequest, CodeInvalidArgument, err.Error())
+					return err
 				}
-				err = storage.PushData(bucketName, objectName, suffix, request.Body)
+				etag, err := storage.PushPart(bucketName, objectName, suffix, partNumber, declaredBodyOf(request), sse)
 				if err != nil {
+					writeUploadError(writer, err)
 					return err
 				}
+				writer.Header().Set("ETag", quotedETag(etag))
+			}
+		}
+
+	case "DELETE":
+		parsedQuery, err := url.ParseQuery(request.URL.RawQuery)
+		if err != nil {
+			return err
+		}
+
+		for _, encodedUploadId := range parsedQuery["uploadId"] {
+			uploadId, err := base64.StdEncoding.DecodeString(encodedUploadId)
+			if err != nil {
+				writeUploadError(writer, ErrNoSuchUpload)
+				return err
+			}
 
-				// payload := UploadPart{}
-				// err = xml.Unmarshal(body, &payload)
-				// if err != nil {
-				// 	fmt.Printf("%s", err)
-				// 	return
-				// }
+			path, suffix, found := strings.Cut(string(uploadId), ":")
+			if !found {
+				writeUploadError(writer, ErrNoSuchUpload)
+				return ErrNoSuchUpload
+			}
+			bucketName, objectName := bucketNameAndObjectKey(path, request.Context().Value(KeyUrlContext).(string))
+
+			storage := storageOf(request)
+			err = storage.DeleteUpload(bucketName, objectName, suffix)
+			if err != nil {
+				writeUploadError(writer, err)
+				return err
 			}
 		}
+		writer.WriteHeader(http.StatusNoContent)
 
 	}
 	fmt.Printf("%s: [%s] %s request\n", request.Context().Value(KeyServerAddr), request.Method, request.URL.Path)
@@ -1,35 +1,48 @@
 package utils
 
 import (
-	"bytes"
 	"net/url"
 	"sort"
 	"strings"
 )
 
+// V4QueryString returns the canonical query string of the signature version
+// 4: the parameters are encoded by UriEncode and sorted by their names.
 func V4QueryString(queryString string) []byte {
-	//TODO: please use standard library
 	if queryString == "" {
 		return []byte{}
 	}
 
-	queryParams := strings.Split(queryString, "&")
-	sort.Strings(queryParams)
-
-	queryParamsLengths := make([][]byte, len(queryParams))
-	for i := range queryParams {
-		keyValue := strings.SplitN(queryParams[i], "=", 2)
-		key := url.QueryEscape(keyValue[0])
-		var value []byte
-		if len(keyValue) == 2 {
-			value = []byte(url.QueryEscape(keyValue[1]))
+	type queryParam struct {
+		key   string
+		value string
+	}
+	queryParams := make([]queryParam, 0)
+	for _, rawParam := range strings.Split(queryString, "&") {
+		if rawParam == "" {
+			continue
 		}
-
-		queryParamsLengths[i] = make([]byte, len(key)+1+len(value))
-		copy(queryParamsLengths[i], key)
-		queryParamsLengths[i][len(key)] = 0x3d
-		copy(queryParamsLengths[i][1+len(key):], value)
+		rawKey, rawValue, _ := strings.Cut(rawParam, "=")
+		key, err := url.PathUnescape(rawKey)
+		if err != nil {
+			key = rawKey
+		}
+		value, err := url.PathUnescape(rawValue)
+		if err != nil {
+			value = rawValue
+		}
+		queryParams = append(queryParams, queryParam{key: UriEncode(key, true), value: UriEncode(value, true)})
 	}
+	sort.Slice(queryParams, func(i, j int) bool {
+		if queryParams[i].key != queryParams[j].key {
+			return queryParams[i].key < queryParams[j].key
+		}
+		return queryParams[i].value < queryParams[j].value
+	})
 
-	return bytes.Join(queryParamsLengths, []byte{0x26})
+	canonical := make([]string, len(queryParams))
+	for i, queryParam := range queryParams {
+		canonical[i] = queryParam.key + "=" + queryParam.value
+	}
+	return []byte(strings.Join(canonical, "&"))
 }
@@ -2,6 +2,9 @@ package utils
 
 import (
 	"fmt"
+	"math"
+	"strconv"
+	"strings"
 )
 
 type SizeInBytes int64
@@ -55,3 +58,31 @@ func (sizeInBytes SizeInBytes) Petabytes() string {
 func (sizeInBytes SizeInBytes) Exabytes() string {
 	return fmt.Sprintf("%dx", sizeInBytes/(1<<60))
 }
+
+var sizeSuffixes = map[byte]SizeInBytes{
+	'b': 1,
+	'k': 1 << 10,
+	'm': 1 << 20,
+	'g': 1 << 30,
+	't': 1 << 40,
+	'p': 1 << 50,
+	'x': 1 << 60,
+}
+
+// ParseSizeInBytes parses the size written as String() does, e.g. 512m or
+// 10g, the size without suffix is in bytes.
+func ParseSizeInBytes(size string) (SizeInBytes, error) {
+	digits := strings.ToLower(strings.TrimSpace(size))
+	multiplier := SizeInBytes(1)
+	if digits != "" {
+		if suffixMultiplier, exists := sizeSuffixes[digits[len(digits)-1]]; exists {
+			multiplier = suffixMultiplier
+			digits = digits[:len(digits)-1]
+		}
+	}
+	value, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil || value < 0 || SizeInBytes(value) > math.MaxInt64/multiplier {
+		return 0, fmt.Errorf("invalid size %q", size)
+	}
+	return SizeInBytes(value) * multiplier, nil
+}
@@ -0,0 +1,24 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+)
+
+// UriEncode encodes the value as the signature version 4 does: the unreserved
+// characters are kept, the slash is kept unless encodeSlash is set and the
+// rest is percent encoded.
+func UriEncode(value string, encodeSlash bool) string {
+	var builder strings.Builder
+	for i := 0; i < len(value); i++ {
+		character := value[i]
+		switch {
+		case 'A' <= character && character <= 'Z', 'a' <= character && character <= 'z', '0' <= character && character <= '9',
+			character == '-', character == '_', character == '.', character == '~', character == '/' && !encodeSlash:
+			builder.WriteByte(character)
+		default:
+			fmt.Fprintf(&builder, "%%%02X", character)
+		}
+	}
+	return builder.String()
+}